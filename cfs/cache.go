@@ -0,0 +1,230 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	pb "github.com/creiht/formic/proto"
+	"github.com/getcfs/fuse"
+)
+
+// Most entries kept at once, so that listing a huge directory doesn't fill up
+// memory
+const maxCachedEntries = 100000
+
+// Longest wait before watching again after losing touch with formicd
+const maxWatchBackoff = 30 * time.Second
+
+// attrCache keeps the attributes of the entries of directories that have just
+// been listed, so that the lookups that follow a listing, as with ls -l, don't
+// each need a call to formicd. The kernel would have kept them for as long had
+// they come from lookups, so they are no more out of date than they were.
+// Anything that this client changes is dropped straight away, as is anything
+// that formicd says other clients have changed.
+type attrCache struct {
+	sync.Mutex
+	times     cacheTimes
+	attrs     map[uint64]cachedAttr // By inode
+	entries   map[dirName]cachedEntry
+	nextPrune time.Time
+}
+
+type cachedAttr struct {
+	attr    *pb.Attr
+	expires time.Time
+}
+
+type dirName struct {
+	parent uint64
+	name   string
+}
+
+type cachedEntry struct {
+	inode   uint64
+	expires time.Time
+}
+
+func newAttrCache(times cacheTimes) *attrCache {
+	return &attrCache{
+		times:   times,
+		attrs:   make(map[uint64]cachedAttr),
+		entries: make(map[dirName]cachedEntry),
+	}
+}
+
+// add caches the entry d of parent, if it came with its attributes.
+func (c *attrCache) add(parent uint64, d *pb.DirEnt) {
+	if d.Attr == nil || c.times.attr <= 0 || c.times.entry <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxCachedEntries {
+		// Only look for expired entries now and then, as a directory that is
+		// being listed will fill the cache back up straight away
+		if now.Before(c.nextPrune) {
+			return
+		}
+		c.prune(now)
+		c.nextPrune = now.Add(time.Second)
+		if len(c.entries) >= maxCachedEntries {
+			return
+		}
+	}
+	c.attrs[d.Inode] = cachedAttr{attr: d.Attr, expires: now.Add(c.times.attr)}
+	c.entries[dirName{parent: parent, name: d.Name}] = cachedEntry{inode: d.Inode, expires: now.Add(c.times.entry)}
+}
+
+// prune drops everything that has expired. It must be called with the cache
+// locked.
+func (c *attrCache) prune(now time.Time) {
+	for inode, a := range c.attrs {
+		if now.After(a.expires) {
+			delete(c.attrs, inode)
+		}
+	}
+	for n, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, n)
+		}
+	}
+}
+
+// getAttr returns the cached attributes of inode and how much longer they are
+// good for.
+func (c *attrCache) getAttr(inode uint64) (*pb.Attr, time.Duration, bool) {
+	c.Lock()
+	defer c.Unlock()
+	a, ok := c.attrs[inode]
+	if !ok {
+		return nil, 0, false
+	}
+	valid := a.expires.Sub(time.Now())
+	if valid <= 0 {
+		delete(c.attrs, inode)
+		return nil, 0, false
+	}
+	return a.attr, valid, true
+}
+
+// lookup returns the cached attributes of name in parent and how much longer
+// they and the entry are good for.
+func (c *attrCache) lookup(parent uint64, name string) (*pb.Attr, time.Duration, time.Duration, bool) {
+	n := dirName{parent: parent, name: name}
+	c.Lock()
+	e, ok := c.entries[n]
+	entryValid := e.expires.Sub(time.Now())
+	if ok && entryValid <= 0 {
+		delete(c.entries, n)
+		ok = false
+	}
+	c.Unlock()
+	if !ok {
+		return nil, 0, 0, false
+	}
+	attr, attrValid, ok := c.getAttr(e.inode)
+	return attr, attrValid, entryValid, ok
+}
+
+// forget drops the attributes of inode.
+func (c *attrCache) forget(inode uint64) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.attrs, inode)
+}
+
+// forgetEntry drops name in parent, along with the attributes of what it was
+// and of parent.
+func (c *attrCache) forgetEntry(parent uint64, name string) {
+	n := dirName{parent: parent, name: name}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[n]; ok {
+		delete(c.attrs, e.inode)
+		delete(c.entries, n)
+	}
+	delete(c.attrs, parent)
+}
+
+// reset drops everything, for when there may have been changes that formicd
+// couldn't say what they were.
+func (c *attrCache) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.attrs = make(map[uint64]cachedAttr)
+	c.entries = make(map[dirName]cachedEntry)
+}
+
+// watch listens for what other clients change and drops it from both the
+// kernel's cache and ours. If the watch is lost, or formicd says that some
+// changes went by without it, our cache is dropped; the kernel keeps what it
+// has until it expires, as there is no telling it to drop everything.
+func (f *fs) watch() {
+	backoff := time.Second
+	for {
+		err := f.watchOnce(func() { backoff = time.Second })
+		f.attrs.reset()
+		log.Printf("Watch failed, retrying in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxWatchBackoff {
+			backoff = maxWatchBackoff
+		}
+	}
+}
+
+// watchOnce watches until the stream fails, calling ok whenever a response
+// arrives.
+func (f *fs) watchOnce(ok func()) error {
+	ctx, cancel := context.WithCancel(f.withMetadata(context.Background(), nil))
+	defer cancel()
+	stream, err := f.rpc.api.Watch(ctx, &pb.WatchRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		ok()
+		if resp.Missed {
+			f.attrs.reset()
+		}
+		for _, inv := range resp.Invalidations {
+			f.invalidate(inv)
+		}
+	}
+}
+
+// invalidate drops what inv says has changed.
+func (f *fs) invalidate(inv *pb.Invalidation) {
+	var err error
+	if inv.Name != "" {
+		f.attrs.forgetEntry(inv.Parent, inv.Name)
+		err = f.conn.InvalidateEntry(fuse.NodeID(inv.Parent), inv.Name)
+		if err != nil && err != fuse.ErrNotCached {
+			log.Printf("InvalidateEntry(%d, %s) failed: %s", inv.Parent, inv.Name, err)
+		}
+		// The parent's attributes change along with its entries
+		err = f.conn.InvalidateNode(fuse.NodeID(inv.Parent), 0, 0)
+		if err != nil && err != fuse.ErrNotCached {
+			log.Printf("InvalidateNode(%d) failed: %s", inv.Parent, err)
+		}
+	}
+	if inv.Inode != 0 {
+		f.attrs.forget(inv.Inode)
+		var size int64
+		if inv.Data {
+			size = -1
+		}
+		err = f.conn.InvalidateNode(fuse.NodeID(inv.Inode), 0, size)
+		if err != nil && err != fuse.ErrNotCached {
+			log.Printf("InvalidateNode(%d) failed: %s", inv.Inode, err)
+		}
+	}
+}
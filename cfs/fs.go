@@ -1,39 +1,60 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/satori/go.uuid"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 
 	"golang.org/x/net/context"
 
+	"github.com/creiht/formic"
 	pb "github.com/creiht/formic/proto"
 
 	"github.com/getcfs/fuse"
-	"github.com/getcfs/fuse/fuseutil"
 )
 
-const (
-	attrValidTime  = 5 * time.Second
-	entryValidTime = 5 * time.Second
-)
+// cacheTimes are how long the kernel and cfs may keep what they have been told
+// about the file system, as set with the attr_timeout, entry_timeout and
+// negative_timeout mount options.
+type cacheTimes struct {
+	attr     time.Duration
+	entry    time.Duration
+	negative time.Duration // Missing entries aren't kept if this is 0
+}
+
+var defaultCacheTimes = cacheTimes{
+	attr:  5 * time.Second,
+	entry: 5 * time.Second,
+}
 
 type fs struct {
 	conn    *fuse.Conn
 	rpc     *rpc
 	handles *fileHandles
+	times   cacheTimes
+	attrs   *attrCache
 	fsid    string
+	client  string // Sent with each request so that formicd can tell who made a change
 }
 
-func newfs(c *fuse.Conn, r *rpc, fsid string) *fs {
+func newfs(c *fuse.Conn, r *rpc, fsid string, times cacheTimes) *fs {
 	fs := &fs{
 		conn:    c,
 		rpc:     r,
 		handles: newFileHandles(),
+		times:   times,
+		attrs:   newAttrCache(times),
 		fsid:    fsid,
+		client:  uuid.NewV4().String(),
 	}
 	return fs
 }
@@ -111,6 +132,18 @@ func (f *fs) handle(r fuse.Request) {
 	case *fuse.StatfsRequest:
 		f.handleStatfs(r)
 
+	case *fuse.LinkRequest:
+		f.handleLink(r)
+
+	case *fuse.FallocateRequest:
+		f.handleFallocate(r)
+
+	case *fuse.LseekRequest:
+		f.handleLseek(r)
+
+	case *fuse.FsyncRequest:
+		f.handleFsync(r)
+
 		/*
 			case *fuse.InitRequest:
 				f.handleInit(r)
@@ -118,22 +151,21 @@ func (f *fs) handle(r fuse.Request) {
 			case *fuse.MknodRequest:
 				f.handleMknod(r)
 
-
-			case *fuse.LinkRequest:
-				f.handleLink(r)
-
 			case *fuse.DestroyRequest:
 				f.handleDestroy(r)
-
-			case *fuse.FsyncRequest:
-				f.handleFsync(r)
 		*/
 	}
 }
 
 type fileHandle struct {
-	inode     fuse.NodeID
-	readCache []byte
+	inode fuse.NodeID
+	// Used to spot sequential I/O and stream it, protected by the mutex
+	sync.Mutex
+	readEnd  int64
+	writeEnd int64
+	reader   *streamReader
+	writer   *streamWriter
+	dir      *dirReader
 }
 
 type fileHandles struct {
@@ -165,18 +197,10 @@ func (f *fileHandles) removeFileHandle(h fuse.HandleID) {
 	delete(f.handles, h)
 }
 
-func (f *fileHandles) cacheRead(h fuse.HandleID, data []byte) {
-	f.Lock()
-	defer f.Unlock()
-	// TODO: Need to add error handling
-	f.handles[h].readCache = data
-}
-
-func (f *fileHandles) getReadCache(h fuse.HandleID) []byte {
+func (f *fileHandles) get(h fuse.HandleID) *fileHandle {
 	f.RLock()
 	defer f.RUnlock()
-	// TODO: Need to add error handling
-	return f.handles[h].readCache
+	return f.handles[h]
 }
 
 func copyAttr(dst *fuse.Attr, src *pb.Attr) {
@@ -189,22 +213,65 @@ func copyAttr(dst *fuse.Attr, src *pb.Attr) {
 	dst.Crtime = time.Unix(src.Crtime, 0)
 	dst.Uid = src.Uid
 	dst.Gid = src.Gid
+	dst.Nlink = src.Nlink
+	if dst.Nlink == 0 {
+		// Entries created before link counts were tracked
+		dst.Nlink = 1
+	}
 }
 
-// Get a context that includes fsid
-func (f *fs) getContext() context.Context {
+// fuseErr returns the errno to send to the kernel for an error from the Api,
+// given the trailer that came back with it. Errors from streams already have
+// theirs.
+func fuseErr(err error, trailer metadata.MD) fuse.Errno {
+	return fuse.Errno(formic.Errno(formic.TrailerError(err, trailer)))
+}
+
+// Get a context that includes fsid and, when h is set, the credentials of the
+// process that made the request so that formicd can check permissions
+func (f *fs) getContext(h *fuse.Header) context.Context {
 	// TODO: Make timeout configurable
 	c, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	return f.withMetadata(c, h)
+}
+
+// withMetadata adds what getContext sends to c.
+func (f *fs) withMetadata(c context.Context, h *fuse.Header) context.Context {
+	md := []string{"fsid", f.fsid, "client", f.client}
+	if h != nil {
+		md = append(md, "uid", strconv.FormatUint(uint64(h.Uid), 10), "gid", strconv.FormatUint(uint64(h.Gid), 10))
+		for _, gid := range groups(h.Pid) {
+			md = append(md, "gids", gid)
+		}
+	}
 	c = metadata.NewContext(
 		c,
-		metadata.Pairs("fsid", f.fsid),
+		metadata.Pairs(md...),
 	)
 	return c
 }
 
+// groups returns the supplementary groups of the process. Fuse only sends the
+// primary group, so these have to come from /proc.
+func groups(pid uint32) []string {
+	if pid == 0 {
+		return nil
+	}
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "Groups:") {
+			return strings.Fields(line[len("Groups:"):])
+		}
+	}
+	return nil
+}
+
 func (f *fs) InitFs() error {
 	log.Println("Inside InitFs")
-	_, err := f.rpc.api.InitFs(f.getContext(), &pb.InitFsRequest{})
+	_, err := f.rpc.api.InitFs(f.getContext(nil), &pb.InitFsRequest{})
 	return err
 }
 
@@ -212,16 +279,23 @@ func (f *fs) handleGetattr(r *fuse.GetattrRequest) {
 	log.Println("Inside handleGetattr")
 	log.Println(r)
 	resp := &fuse.GetattrResponse{}
+	if attr, valid, ok := f.attrs.getAttr(uint64(r.Node)); ok {
+		copyAttr(&resp.Attr, attr)
+		resp.Attr.Valid = valid
+		r.Respond(resp)
+		return
+	}
 
-	a, err := f.rpc.api.GetAttr(f.getContext(), &pb.GetAttrRequest{Inode: uint64(r.Node)})
+	var trailer metadata.MD
+	a, err := f.rpc.api.GetAttr(f.getContext(r.Hdr()), &pb.GetAttrRequest{Inode: uint64(r.Node)}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("GetAttr fail: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	copyAttr(&resp.Attr, a.Attr)
 	// TODO: should we make these configurable?
-	resp.Attr.Valid = attrValidTime
+	resp.Attr.Valid = f.times.attr
 
 	log.Println(resp)
 	r.Respond(resp)
@@ -232,25 +306,40 @@ func (f *fs) handleLookup(r *fuse.LookupRequest) {
 	log.Printf("Running Lookup for %s", r.Name)
 	log.Println(r)
 	resp := &fuse.LookupResponse{}
+	if attr, attrValid, entryValid, ok := f.attrs.lookup(uint64(r.Node), r.Name); ok {
+		resp.Node = fuse.NodeID(attr.Inode)
+		copyAttr(&resp.Attr, attr)
+		resp.Attr.Valid = attrValid
+		resp.EntryValid = entryValid
+		r.Respond(resp)
+		return
+	}
 
-	l, err := f.rpc.api.Lookup(f.getContext(), &pb.LookupRequest{Name: r.Name, Parent: uint64(r.Node)})
+	var trailer metadata.MD
+	l, err := f.rpc.api.Lookup(f.getContext(r.Hdr()), &pb.LookupRequest{Name: r.Name, Parent: uint64(r.Node)}, grpc.Trailer(&trailer))
 
-	if err != nil {
+	if err != nil && fuseErr(err, trailer) != fuse.ENOENT {
 		log.Printf("Lookup failed(%s): %s", r.Name, err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	// If there is no name then it wasn't found
-	if l.Name != r.Name {
+	if err != nil || l.Name != r.Name {
 		log.Printf("ENOENT Lookup(%s)", r.Name)
+		if f.times.negative > 0 {
+			// An entry without a node tells the kernel to remember that the
+			// name is missing, until formicd says otherwise
+			resp.EntryValid = f.times.negative
+			r.Respond(resp)
+			return
+		}
 		r.RespondError(fuse.ENOENT)
 		return
 	}
 	resp.Node = fuse.NodeID(l.Attr.Inode)
 	copyAttr(&resp.Attr, l.Attr)
-	// TODO: should we make these configureable?
-	resp.Attr.Valid = attrValidTime
-	resp.EntryValid = entryValidTime
+	resp.Attr.Valid = f.times.attr
+	resp.EntryValid = f.times.entry
 
 	log.Println(resp)
 	r.Respond(resp)
@@ -260,11 +349,13 @@ func (f *fs) handleMkdir(r *fuse.MkdirRequest) {
 	log.Println("Inside handleMkdir")
 	log.Println(r)
 	resp := &fuse.MkdirResponse{}
+	f.attrs.forgetEntry(uint64(r.Node), r.Name)
 
-	m, err := f.rpc.api.MkDir(f.getContext(), &pb.MkDirRequest{Name: r.Name, Parent: uint64(r.Node), Attr: &pb.Attr{Uid: r.Uid, Gid: r.Gid, Mode: uint32(r.Mode)}})
+	var trailer metadata.MD
+	m, err := f.rpc.api.MkDir(f.getContext(r.Hdr()), &pb.MkDirRequest{Name: r.Name, Parent: uint64(r.Node), Attr: &pb.Attr{Uid: r.Uid, Gid: r.Gid, Mode: uint32(r.Mode)}}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Mkdir failed(%s): %s", r.Name, err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	// If the name is empty, then the dir already exists
@@ -275,8 +366,8 @@ func (f *fs) handleMkdir(r *fuse.MkdirRequest) {
 	}
 	resp.Node = fuse.NodeID(m.Attr.Inode)
 	copyAttr(&resp.Attr, m.Attr)
-	resp.Attr.Valid = attrValidTime
-	resp.EntryValid = entryValidTime
+	resp.Attr.Valid = f.times.attr
+	resp.EntryValid = f.times.entry
 
 	log.Println(resp)
 	r.Respond(resp)
@@ -286,6 +377,18 @@ func (f *fs) handleOpen(r *fuse.OpenRequest) {
 	log.Println("Inside handleOpen")
 	log.Println(r)
 	resp := &fuse.OpenResponse{}
+	// Permissions are checked once here; the reads and writes through the
+	// handle aren't checked again
+	var trailer metadata.MD
+	_, err := f.rpc.api.Open(f.getContext(r.Hdr()), &pb.OpenRequest{
+		Inode: uint64(r.Node),
+		Flags: uint32(r.Flags),
+	}, grpc.Trailer(&trailer))
+	if err != nil {
+		log.Printf("Open failed: %s", err)
+		r.RespondError(fuseErr(err, trailer))
+		return
+	}
 	// For now use the inode as the file handle
 	resp.Handle = f.handles.newFileHandle(r.Node)
 	resp.Flags |= fuse.OpenKeepCache
@@ -299,48 +402,37 @@ func (f *fs) handleRead(r *fuse.ReadRequest) {
 	resp := &fuse.ReadResponse{Data: make([]byte, r.Size)}
 	if r.Dir {
 		// handle directory listing
-		data := f.handles.getReadCache(r.Handle)
-		if data == nil {
-			d, err := f.rpc.api.ReadDirAll(f.getContext(), &pb.ReadDirAllRequest{Inode: uint64(r.Node)})
-			if err != nil {
-				log.Printf("Read on dir failed: %s", err)
-				r.RespondError(fuse.EIO)
-				return
-			}
-			data = fuse.AppendDirent(data, fuse.Dirent{
-				Name:  ".",
-				Inode: uint64(r.Node),
-				Type:  fuse.DT_Dir,
-			})
-			data = fuse.AppendDirent(data, fuse.Dirent{
-				Name: "..",
-				Type: fuse.DT_Dir,
-			})
-			for _, de := range d.DirEntries {
-				if de == nil || de.Name == "" {
-					continue
-				}
-				data = fuse.AppendDirent(data, fuse.Dirent{
-					Name:  de.Name,
-					Inode: 1, // TODO: seems to work fine with any non-zero inode.  why?
-					Type:  fuse.DirentType(de.Type),
-				})
-			}
-			f.handles.cacheRead(r.Handle, data)
+		data, err := f.readDir(r)
+		if err != nil {
+			log.Printf("Read on dir failed: %s", err)
+			r.RespondError(fuseErr(err, nil))
+			return
 		}
-		fuseutil.HandleRead(r, resp, data)
+		resp.Data = data
 		r.Respond(resp)
 		return
 	} else {
 		// handle file read
-		data, err := f.rpc.api.Read(f.getContext(), &pb.ReadRequest{
+		streamed, ok, err := f.streamRead(r)
+		if err != nil {
+			log.Printf("Read on file failed: %s", err)
+			r.RespondError(fuseErr(err, nil))
+			return
+		}
+		if ok {
+			resp.Data = streamed
+			r.Respond(resp)
+			return
+		}
+		var trailer metadata.MD
+		data, err := f.rpc.api.Read(f.getContext(r.Hdr()), &pb.ReadRequest{
 			Inode:  uint64(r.Node),
 			Offset: int64(r.Offset),
 			Size:   int64(r.Size),
-		})
+		}, grpc.Trailer(&trailer))
 		if err != nil {
 			log.Printf("Read on file failed: %s", err)
-			r.RespondError(fuse.EIO)
+			r.RespondError(fuseErr(err, trailer))
 			return
 		}
 		copy(resp.Data, data.Payload)
@@ -355,10 +447,23 @@ func (f *fs) handleWrite(r *fuse.WriteRequest) {
 	// TODO: Implement write
 	// Currently this is stupid simple and doesn't handle all the possibilities
 	resp := &fuse.WriteResponse{}
-	w, err := f.rpc.api.Write(f.getContext(), &pb.WriteRequest{Inode: uint64(r.Node), Offset: r.Offset, Payload: r.Data})
+	f.attrs.forget(uint64(r.Node))
+	ok, err := f.streamWrite(r)
+	if err != nil {
+		log.Printf("Write to file failed: %s", err)
+		r.RespondError(fuseErr(err, nil))
+		return
+	}
+	if ok {
+		resp.Size = len(r.Data)
+		r.Respond(resp)
+		return
+	}
+	var trailer metadata.MD
+	w, err := f.rpc.api.Write(f.getContext(r.Hdr()), &pb.WriteRequest{Inode: uint64(r.Node), Offset: r.Offset, Payload: r.Data}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Write to file failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	if w.Status != 0 {
@@ -372,19 +477,21 @@ func (f *fs) handleCreate(r *fuse.CreateRequest) {
 	log.Println("Inside handleCreate")
 	log.Println(r)
 	resp := &fuse.CreateResponse{}
-	c, err := f.rpc.api.Create(f.getContext(), &pb.CreateRequest{Parent: uint64(r.Node), Name: r.Name, Attr: &pb.Attr{Uid: r.Uid, Gid: r.Gid, Mode: uint32(r.Mode)}})
+	f.attrs.forgetEntry(uint64(r.Node), r.Name)
+	var trailer metadata.MD
+	c, err := f.rpc.api.Create(f.getContext(r.Hdr()), &pb.CreateRequest{Parent: uint64(r.Node), Name: r.Name, Attr: &pb.Attr{Uid: r.Uid, Gid: r.Gid, Mode: uint32(r.Mode)}}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Failed to create file: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	resp.Node = fuse.NodeID(c.Attr.Inode)
 	copyAttr(&resp.Attr, c.Attr)
-	resp.EntryValid = entryValidTime
-	resp.Attr.Valid = attrValidTime
+	resp.EntryValid = f.times.entry
+	resp.Attr.Valid = f.times.attr
 	copyAttr(&resp.LookupResponse.Attr, c.Attr)
-	resp.LookupResponse.EntryValid = entryValidTime
-	resp.LookupResponse.Attr.Valid = attrValidTime
+	resp.LookupResponse.EntryValid = f.times.entry
+	resp.LookupResponse.Attr.Valid = f.times.attr
 	r.Respond(resp)
 }
 
@@ -417,25 +524,35 @@ func (f *fs) handleSetattr(r *fuse.SetattrRequest) {
 	if r.Valid.Gid() {
 		a.Gid = r.Gid
 	}
-	setAttrResp, err := f.rpc.api.SetAttr(f.getContext(), &pb.SetAttrRequest{Attr: a, Valid: uint32(r.Valid)})
+	f.attrs.forget(uint64(r.Node))
+	var trailer metadata.MD
+	setAttrResp, err := f.rpc.api.SetAttr(f.getContext(r.Hdr()), &pb.SetAttrRequest{Attr: a, Valid: uint32(r.Valid)}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Setattr failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	copyAttr(&resp.Attr, setAttrResp.Attr)
-	resp.Attr.Valid = attrValidTime
+	resp.Attr.Valid = f.times.attr
 	log.Println(resp)
 	r.Respond(resp)
 }
 
 func (f *fs) handleFlush(r *fuse.FlushRequest) {
 	log.Println("Inside handleFlush")
+	if err := f.closeStreams(r.Handle); err != nil {
+		log.Printf("Flush failed: %s", err)
+		r.RespondError(fuseErr(err, nil))
+		return
+	}
 	r.Respond()
 }
 
 func (f *fs) handleRelease(r *fuse.ReleaseRequest) {
 	log.Println("Inside handleRelease")
+	if err := f.closeStreams(r.Handle); err != nil {
+		log.Printf("Release failed: %s", err)
+	}
 	f.handles.removeFileHandle(r.Handle)
 	r.Respond()
 }
@@ -453,13 +570,14 @@ func (f *fs) handleForget(r *fuse.ForgetRequest) {
 }
 
 func (f *fs) handleRemove(r *fuse.RemoveRequest) {
-	// TODO: Handle dir deletions correctly
 	log.Println("Inside handleRemove")
 	log.Println(r)
-	_, err := f.rpc.api.Remove(f.getContext(), &pb.RemoveRequest{Parent: uint64(r.Node), Name: r.Name})
+	f.attrs.forgetEntry(uint64(r.Node), r.Name)
+	var trailer metadata.MD
+	_, err := f.rpc.api.Remove(f.getContext(r.Hdr()), &pb.RemoveRequest{Parent: uint64(r.Node), Name: r.Name, Dir: r.Dir}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Failed to delete file: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	r.Respond()
@@ -467,7 +585,9 @@ func (f *fs) handleRemove(r *fuse.RemoveRequest) {
 
 func (f *fs) handleAccess(r *fuse.AccessRequest) {
 	log.Println("Inside handleAccess")
-	// TODO: Add real access support, for now allows everything
+	// NOTE: formicd checks permissions on each request, so this only
+	//       affects what access(2) reports
+	// TODO: Check the mode bits here too
 	r.Respond()
 }
 
@@ -490,10 +610,11 @@ func (f *fs) handleInit(r *fuse.InitRequest) {
 func (f *fs) handleStatfs(r *fuse.StatfsRequest) {
 	log.Println("Inside handleStatfs")
 	log.Println(r)
-	resp, err := f.rpc.api.Statfs(f.getContext(), &pb.StatfsRequest{})
+	var trailer metadata.MD
+	resp, err := f.rpc.api.Statfs(f.getContext(r.Hdr()), &pb.StatfsRequest{}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Failed to Statfs : %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	fuse_resp := &fuse.StatfsResponse{
@@ -513,16 +634,18 @@ func (f *fs) handleSymlink(r *fuse.SymlinkRequest) {
 	log.Println("Inside handleSymlink")
 	log.Println(r)
 	resp := &fuse.SymlinkResponse{}
-	symlink, err := f.rpc.api.Symlink(f.getContext(), &pb.SymlinkRequest{Parent: uint64(r.Node), Name: r.NewName, Target: r.Target, Uid: r.Uid, Gid: r.Gid})
+	f.attrs.forgetEntry(uint64(r.Node), r.NewName)
+	var trailer metadata.MD
+	symlink, err := f.rpc.api.Symlink(f.getContext(r.Hdr()), &pb.SymlinkRequest{Parent: uint64(r.Node), Name: r.NewName, Target: r.Target, Uid: r.Uid, Gid: r.Gid}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Symlink failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	resp.Node = fuse.NodeID(symlink.Attr.Inode)
 	copyAttr(&resp.Attr, symlink.Attr)
-	resp.Attr.Valid = attrValidTime
-	resp.EntryValid = entryValidTime
+	resp.Attr.Valid = f.times.attr
+	resp.EntryValid = f.times.entry
 	log.Println(resp)
 	r.Respond(resp)
 }
@@ -530,10 +653,11 @@ func (f *fs) handleSymlink(r *fuse.SymlinkRequest) {
 func (f *fs) handleReadlink(r *fuse.ReadlinkRequest) {
 	log.Println("Inside handleReadlink")
 	log.Println(r)
-	resp, err := f.rpc.api.Readlink(f.getContext(), &pb.ReadlinkRequest{Inode: uint64(r.Node)})
+	var trailer metadata.MD
+	resp, err := f.rpc.api.Readlink(f.getContext(r.Hdr()), &pb.ReadlinkRequest{Inode: uint64(r.Node)}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Readlink failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	log.Println(resp)
@@ -542,7 +666,29 @@ func (f *fs) handleReadlink(r *fuse.ReadlinkRequest) {
 
 func (f *fs) handleLink(r *fuse.LinkRequest) {
 	log.Println("Inside handleLink")
-	r.RespondError(fuse.ENOSYS)
+	log.Println(r)
+	resp := &fuse.LookupResponse{}
+	f.attrs.forget(uint64(r.OldNode))
+	f.attrs.forgetEntry(uint64(r.Node), r.NewName)
+	var trailer metadata.MD
+	l, err := f.rpc.api.Link(f.getContext(r.Hdr()), &pb.LinkRequest{Parent: uint64(r.Node), Name: r.NewName, Inode: uint64(r.OldNode)}, grpc.Trailer(&trailer))
+	if err != nil {
+		log.Printf("Link failed(%s): %s", r.NewName, err)
+		r.RespondError(fuseErr(err, trailer))
+		return
+	}
+	// If the name is empty, then the entry already exists
+	if l.Name != r.NewName {
+		log.Printf("EEXIST Link(%s)", r.NewName)
+		r.RespondError(fuse.EEXIST)
+		return
+	}
+	resp.Node = fuse.NodeID(l.Attr.Inode)
+	copyAttr(&resp.Attr, l.Attr)
+	resp.Attr.Valid = f.times.attr
+	resp.EntryValid = f.times.entry
+	log.Println(resp)
+	r.Respond(resp)
 }
 
 func (f *fs) handleGetxattr(r *fuse.GetxattrRequest) {
@@ -560,10 +706,11 @@ func (f *fs) handleGetxattr(r *fuse.GetxattrRequest) {
 		Size:     r.Size,
 		Position: r.Position,
 	}
-	resp, err := f.rpc.api.Getxattr(f.getContext(), req)
+	var trailer metadata.MD
+	resp, err := f.rpc.api.Getxattr(f.getContext(r.Hdr()), req, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Getxattr failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	fuse_resp := &fuse.GetxattrResponse{Xattr: resp.Xattr}
@@ -579,10 +726,11 @@ func (f *fs) handleListxattr(r *fuse.ListxattrRequest) {
 		Size:     r.Size,
 		Position: r.Position,
 	}
-	resp, err := f.rpc.api.Listxattr(f.getContext(), req)
+	var trailer metadata.MD
+	resp, err := f.rpc.api.Listxattr(f.getContext(r.Hdr()), req, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Listxattr failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	fuse_resp := &fuse.ListxattrResponse{Xattr: resp.Xattr}
@@ -600,10 +748,12 @@ func (f *fs) handleSetxattr(r *fuse.SetxattrRequest) {
 		Position: r.Position,
 		Flags:    r.Flags,
 	}
-	_, err := f.rpc.api.Setxattr(f.getContext(), req)
+	f.attrs.forget(uint64(r.Node))
+	var trailer metadata.MD
+	_, err := f.rpc.api.Setxattr(f.getContext(r.Hdr()), req, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Setxattr failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	r.Respond()
@@ -616,10 +766,12 @@ func (f *fs) handleRemovexattr(r *fuse.RemovexattrRequest) {
 		Inode: uint64(r.Node),
 		Name:  r.Name,
 	}
-	_, err := f.rpc.api.Removexattr(f.getContext(), req)
+	f.attrs.forget(uint64(r.Node))
+	var trailer metadata.MD
+	_, err := f.rpc.api.Removexattr(f.getContext(r.Hdr()), req, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Removexattr failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	r.Respond()
@@ -633,16 +785,68 @@ func (f *fs) handleDestroy(r *fuse.DestroyRequest) {
 func (f *fs) handleRename(r *fuse.RenameRequest) {
 	log.Println("Inside handleRename")
 	log.Println(r)
-	_, err := f.rpc.api.Rename(f.getContext(), &pb.RenameRequest{OldParent: uint64(r.Node), NewParent: uint64(r.NewDir), OldName: r.OldName, NewName: r.NewName})
+	// NOTE: The fuse library doesn't support rename2 yet, so there are never
+	//       any flags to pass along
+	f.attrs.forgetEntry(uint64(r.Node), r.OldName)
+	f.attrs.forgetEntry(uint64(r.NewDir), r.NewName)
+	var trailer metadata.MD
+	_, err := f.rpc.api.Rename(f.getContext(r.Hdr()), &pb.RenameRequest{OldParent: uint64(r.Node), NewParent: uint64(r.NewDir), OldName: r.OldName, NewName: r.NewName}, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Printf("Rename failed: %s", err)
-		r.RespondError(fuse.EIO)
+		r.RespondError(fuseErr(err, trailer))
+		return
+	}
+	r.Respond()
+}
+
+func (f *fs) handleFallocate(r *fuse.FallocateRequest) {
+	log.Println("Inside handleFallocate")
+	log.Println(r)
+	// Streamed writes have to land first, and anything read ahead may be
+	// about to be zeroed
+	if err := f.closeStreams(r.Handle); err != nil {
+		log.Printf("Fallocate failed: %s", err)
+		r.RespondError(fuseErr(err, nil))
+		return
+	}
+	f.attrs.forget(uint64(r.Node))
+	var trailer metadata.MD
+	_, err := f.rpc.api.Fallocate(f.getContext(r.Hdr()), &pb.FallocateRequest{Inode: uint64(r.Node), Mode: r.Mode, Offset: r.Offset, Length: r.Length}, grpc.Trailer(&trailer))
+	if err != nil {
+		log.Printf("Fallocate failed: %s", err)
+		r.RespondError(fuseErr(err, trailer))
 		return
 	}
 	r.Respond()
 }
 
+func (f *fs) handleLseek(r *fuse.LseekRequest) {
+	log.Println("Inside handleLseek")
+	log.Println(r)
+	// Data is found from the blocks that have been stored
+	if err := f.syncStreams(r.Handle); err != nil {
+		log.Printf("Lseek failed: %s", err)
+		r.RespondError(fuseErr(err, nil))
+		return
+	}
+	var trailer metadata.MD
+	l, err := f.rpc.api.Lseek(f.getContext(r.Hdr()), &pb.LseekRequest{Inode: uint64(r.Node), Offset: r.Offset, Whence: uint32(r.Whence)}, grpc.Trailer(&trailer))
+	if err != nil {
+		log.Printf("Lseek failed: %s", err)
+		r.RespondError(fuseErr(err, trailer))
+		return
+	}
+	r.Respond(&fuse.LseekResponse{Offset: l.Offset})
+}
+
 func (f *fs) handleFsync(r *fuse.FsyncRequest) {
 	log.Println("Inside handleFsync")
-	r.RespondError(fuse.ENOSYS)
+	// Unary writes are stored by the time they return, so only streamed
+	// writes need waiting for
+	if err := f.syncStreams(r.Handle); err != nil {
+		log.Printf("Fsync failed: %s", err)
+		r.RespondError(fuseErr(err, nil))
+		return
+	}
+	r.Respond()
 }
@@ -2,14 +2,17 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -22,6 +25,13 @@ import (
 	"gopkg.in/urfave/cli.v2"
 )
 
+// How often fsck asks after a running check, and how long it waits for an
+// answer each time.
+const (
+	fsckPollInterval   = 10 * time.Second
+	fsckRequestTimeout = 30 * time.Second
+)
+
 var regions = map[string]string{
 	"aio": "127.0.0.1",
 	"iad": "api.ea.iad.rackfs.com",
@@ -95,6 +105,9 @@ func main() {
 	var fsName string
 	var addrValue string
 	var fsRegion string
+	var fsBlockSize int64
+	var fsckRepair bool
+	var fsckStatus bool
 	var ok bool
 
 	app := cli.NewApp()
@@ -145,7 +158,7 @@ func main() {
 		{
 			Name:      "create",
 			Usage:     "Create a File Systems",
-			ArgsUsage: "[R|region] [aio|iad]  [N|name] <file system name>",
+			ArgsUsage: "[R|region] [aio|iad]  [N|name] <file system name> [B|blocksize] <bytes>",
 			Flags: []cli.Flag{
 				&cli.StringFlag{
 					Name:        "name",
@@ -161,6 +174,13 @@ func main() {
 					Usage:       "Target region",
 					Destination: &fsRegion,
 				},
+				&cli.Int64Flag{
+					Name:        "blocksize",
+					Aliases:     []string{"B"},
+					Value:       0,
+					Usage:       "Size of the file blocks in bytes, 0 for the default",
+					Destination: &fsBlockSize,
+				},
 			},
 			Action: func(c *cli.Context) error {
 				if gtoken == "" {
@@ -179,7 +199,7 @@ func main() {
 				}
 				conn := setupWS(serverAddr)
 				ws := pb.NewFileSystemAPIClient(conn)
-				result, err := ws.CreateFS(context.Background(), &pb.CreateFSRequest{Token: gtoken, FSName: fsName})
+				result, err := ws.CreateFS(context.Background(), &pb.CreateFSRequest{Token: gtoken, FSName: fsName, BlockSize: fsBlockSize})
 				if err != nil {
 					log.Fatalf("Bad Request: %v", err)
 					conn.Close()
@@ -390,6 +410,102 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:      "scrub",
+			Usage:     "Show the report from the last scrub of a File System",
+			ArgsUsage: "<region>://<file system uuid>",
+			Action: func(c *cli.Context) error {
+				if !c.Args().Present() {
+					fmt.Println("Invalid syntax for scrub.")
+					os.Exit(1)
+				}
+				if gtoken == "" {
+					fmt.Println("Token is required")
+					os.Exit(1)
+				}
+				serverAddr, fsNum = parseurl(c.Args().Get(0), "8445")
+				if fsNum == "" {
+					fmt.Println("Missing file system id")
+					os.Exit(1)
+				}
+				conn := setupWS(serverAddr)
+				ws := pb.NewFileSystemAPIClient(conn)
+				result, err := ws.ScrubReportFS(context.Background(), &pb.ScrubReportFSRequest{Token: gtoken, FSid: fsNum})
+				if err != nil {
+					log.Fatalf("Bad Request: %v", err)
+					conn.Close()
+					os.Exit(1)
+				}
+				conn.Close()
+				log.Printf("SCRUB Report: %s", result.Data)
+				return nil
+			},
+		},
+		{
+			Name:      "fsck",
+			Usage:     "Check a File System for damage",
+			ArgsUsage: "[--repair | --status] <region>://<file system uuid>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "repair",
+					Usage:       "Repair what is found rather than just reporting it",
+					Destination: &fsckRepair,
+				},
+				&cli.BoolFlag{
+					Name:        "status",
+					Usage:       "Follow the running check, or show the last one, rather than starting one",
+					Destination: &fsckStatus,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if !c.Args().Present() {
+					fmt.Println("Invalid syntax for fsck.")
+					os.Exit(1)
+				}
+				if gtoken == "" {
+					fmt.Println("Token is required")
+					os.Exit(1)
+				}
+				serverAddr, fsNum = parseurl(c.Args().Get(0), "8445")
+				if fsNum == "" {
+					fmt.Println("Missing file system id")
+					os.Exit(1)
+				}
+				conn := setupWS(serverAddr)
+				ws := pb.NewFileSystemAPIClient(conn)
+				// The check runs in the background on the server, so it is
+				// started and then asked after until it is done
+				req := &pb.FsckFSRequest{Token: gtoken, FSid: fsNum, Repair: fsckRepair, Status: fsckStatus}
+				for {
+					ctx, cancel := context.WithTimeout(context.Background(), fsckRequestTimeout)
+					result, err := ws.FsckFS(ctx, req)
+					cancel()
+					if err != nil {
+						log.Fatalf("Bad Request: %v", err)
+						conn.Close()
+						os.Exit(1)
+					}
+					var report struct {
+						State  string         `json:"state"`
+						Inodes uint64         `json:"inodes"`
+						Counts map[string]int `json:"counts"`
+					}
+					if err = json.Unmarshal([]byte(result.Data), &report); err != nil {
+						log.Fatalf("Bad Response: %v", err)
+						conn.Close()
+						os.Exit(1)
+					}
+					if report.State != "running" {
+						conn.Close()
+						log.Printf("FSCK Results: %s", result.Data)
+						return nil
+					}
+					log.Printf("FSCK Running: %d inodes checked, problems %v", report.Inodes, report.Counts)
+					req.Status = true
+					time.Sleep(fsckPollInterval)
+				}
+			},
+		},
 		{
 			Name:      "mount",
 			Usage:     "mount a file system",
@@ -420,6 +536,7 @@ func main() {
 				fusermountPath()
 				// process file system options
 				allowOther := false
+				times := defaultCacheTimes
 				if c.String("o") != "" {
 					clargs := getArgs(c.String("o"))
 					// crapy debug log handling :)
@@ -433,6 +550,7 @@ func main() {
 						log.SetOutput(ioutil.Discard)
 					}
 					_, allowOther = clargs["allow_other"]
+					times = getCacheTimes(clargs, times)
 				}
 				// Setup grpc
 				var opts []grpc.DialOption
@@ -480,11 +598,12 @@ func main() {
 				defer cfs.Close()
 
 				rpc := newrpc(conn)
-				fs := newfs(cfs, rpc, fsnum.String())
+				fs := newfs(cfs, rpc, fsnum.String(), times)
 				err = fs.InitFs()
 				if err != nil {
 					log.Fatal(err)
 				}
+				go fs.watch()
 				srv := newserver(fs)
 
 				if err := srv.serve(); err != nil {
@@ -539,6 +658,28 @@ func getArgs(args string) map[string]string {
 	return clargs
 }
 
+// getCacheTimes returns times with any of the attr_timeout, entry_timeout and
+// negative_timeout options, given in seconds, set.
+func getCacheTimes(clargs map[string]string, times cacheTimes) cacheTimes {
+	for opt, t := range map[string]*time.Duration{
+		"attr_timeout":     &times.attr,
+		"entry_timeout":    &times.entry,
+		"negative_timeout": &times.negative,
+	} {
+		v, ok := clargs[opt]
+		if !ok {
+			continue
+		}
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs < 0 {
+			fmt.Printf("Invalid option %s, %s is not a number of seconds\n\n", opt, v)
+			os.Exit(1)
+		}
+		*t = time.Duration(secs * float64(time.Second))
+	}
+	return times
+}
+
 func fusermountPath() {
 	// Grab the current path
 	currentPath := os.Getenv("PATH")
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"unsafe"
+
+	"golang.org/x/net/context"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+
+	"github.com/getcfs/fuse"
+)
+
+// How far ahead of the kernel a ReadStream is asked to read. Streams are only
+// used once a handle has been read or written sequentially, so that random
+// access still goes through the unary calls.
+const streamReadAhead = 16 * 1024 * 1024
+
+// streamReader serves sequential reads on a handle from a ReadStream.
+type streamReader struct {
+	stream pb.Api_ReadStreamClient
+	cancel context.CancelFunc
+	offset int64 // Where buf starts in the file
+	end    int64 // Where the stream was asked to stop
+	buf    []byte
+	done   bool
+}
+
+// streamContext returns the context for a stream on a handle. Streams can
+// stay open for as long as the handle does, so rather than the timeout of
+// getContext they are cancelled when they are closed, at the latest when the
+// handle is released.
+func (f *fs) streamContext(h *fuse.Header) (context.Context, context.CancelFunc) {
+	return context.WithCancel(f.withMetadata(context.Background(), h))
+}
+
+func (f *fs) newStreamReader(h *fuse.Header, inode uint64, offset int64) (*streamReader, error) {
+	ctx, cancel := f.streamContext(h)
+	stream, err := f.rpc.api.ReadStream(ctx, &pb.ReadRequest{Inode: inode, Offset: offset, Size: streamReadAhead})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &streamReader{stream: stream, cancel: cancel, offset: offset, end: offset + streamReadAhead}, nil
+}
+
+// read returns up to size bytes from the current offset. It returns less at
+// the end of the file, or at the end of the range the stream asked for, in
+// which case exhausted will return true.
+func (s *streamReader) read(size int) ([]byte, error) {
+	for len(s.buf) < size && !s.done {
+		resp, err := s.stream.Recv()
+		if err == io.EOF {
+			s.done = true
+			break
+		}
+		if err != nil {
+			return nil, formic.TrailerError(err, s.stream.Trailer())
+		}
+		s.buf = append(s.buf, resp.Payload...)
+	}
+	if size > len(s.buf) {
+		size = len(s.buf)
+	}
+	data := s.buf[:size]
+	s.buf = s.buf[size:]
+	s.offset += int64(size)
+	return data, nil
+}
+
+// exhausted returns true if the stream ended because it reached the end of
+// the range it asked for rather than the end of the file.
+func (s *streamReader) exhausted() bool {
+	return s.done && len(s.buf) == 0 && s.offset >= s.end
+}
+
+func (s *streamReader) close() {
+	s.cancel()
+}
+
+// streamWriter sends sequential writes on a handle through a WriteStream.
+// Errors from the server only show up when the stream is closed, so they are
+// returned from the next fsync, flush, release or non-sequential write.
+type streamWriter struct {
+	stream pb.Api_WriteStreamClient
+	cancel context.CancelFunc
+	next   int64 // Where the next sequential write would start
+}
+
+func (f *fs) newStreamWriter(h *fuse.Header) (*streamWriter, error) {
+	ctx, cancel := f.streamContext(h)
+	stream, err := f.rpc.api.WriteStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &streamWriter{stream: stream, cancel: cancel}, nil
+}
+
+func (s *streamWriter) write(r *pb.WriteRequest) error {
+	err := s.stream.Send(r)
+	if err == io.EOF {
+		// The server ended the stream, and the reason comes from closing it
+		return s.close()
+	}
+	s.next = r.Offset + int64(len(r.Payload))
+	return err
+}
+
+// close waits for the server to say that everything sent has been stored.
+func (s *streamWriter) close() error {
+	w, err := s.stream.CloseAndRecv()
+	s.cancel()
+	if err != nil {
+		return formic.TrailerError(err, s.stream.Trailer())
+	}
+	if w.Status != 0 {
+		log.Printf("WriteStream status non zero(%d)\n", w.Status)
+	}
+	return nil
+}
+
+// closeWriter finishes any WriteStream on the handle. It must be called with
+// the handle locked.
+func (h *fileHandle) closeWriter() error {
+	if h.writer == nil {
+		return nil
+	}
+	err := h.writer.close()
+	h.writer = nil
+	return err
+}
+
+// closeReader stops any ReadStream on the handle. It must be called with the
+// handle locked.
+func (h *fileHandle) closeReader() {
+	if h.reader != nil {
+		h.reader.close()
+		h.reader = nil
+	}
+}
+
+// dirReader serves the listing of a directory handle from a ReadDirPlus,
+// taking entries from the stream only as the kernel asks for them.
+type dirReader struct {
+	stream  pb.Api_ReadDirPlusClient
+	cancel  context.CancelFunc
+	cookie  uint64       // Of the last entry taken, where a new stream would carry on
+	cookieB uint64       // The rest of the cookie, which the kernel doesn't keep
+	ents    []*pb.DirEnt // Received but not yet taken
+	done    bool
+}
+
+func (f *fs) newDirReader(h *fuse.Header, inode, cookie, cookieB uint64) (*dirReader, error) {
+	ctx, cancel := f.streamContext(h)
+	stream, err := f.rpc.api.ReadDirPlus(ctx, &pb.ReadDirRequest{Inode: inode, Cookie: cookie, CookieB: cookieB})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &dirReader{stream: stream, cancel: cancel, cookie: cookie, cookieB: cookieB}, nil
+}
+
+// peek returns the next entry without taking it, or nil at the end of the
+// directory.
+func (d *dirReader) peek() (*pb.DirEnt, error) {
+	for len(d.ents) == 0 && !d.done {
+		resp, err := d.stream.Recv()
+		if err == io.EOF {
+			d.done = true
+			break
+		}
+		if err != nil {
+			return nil, formic.TrailerError(err, d.stream.Trailer())
+		}
+		d.ents = resp.DirEntries
+	}
+	if len(d.ents) == 0 {
+		return nil, nil
+	}
+	return d.ents[0], nil
+}
+
+// take moves past the entry returned by peek.
+func (d *dirReader) take() {
+	d.cookie = d.ents[0].Cookie
+	d.cookieB = d.ents[0].CookieB
+	d.ents = d.ents[1:]
+}
+
+func (d *dirReader) close() {
+	d.cancel()
+}
+
+// closeDir stops any ReadDir on the handle. It must be called with the handle
+// locked.
+func (h *fileHandle) closeDir() {
+	if h.dir != nil {
+		h.dir.close()
+		h.dir = nil
+	}
+}
+
+// The offsets of . and .., which come before the first entry's cookie. An
+// entry whose cookie is one of these would be listed again, but the cookies
+// are hashes so that is too unlikely to matter. The same goes for entries
+// whose offsets clash, as only the first half of an entry's cookie fits in its
+// offset; a listing carried on by the same handle uses the whole cookie, but
+// one carried on from just the offset skips the entries that share it.
+const (
+	dotOffset    = 1
+	dotDotOffset = 2
+)
+
+// dirent is how fuse lays out a directory entry.
+type dirent struct {
+	Ino     uint64
+	Off     uint64
+	Namelen uint32
+	Type    uint32
+}
+
+const direntSize = 8 + 8 + 4 + 4
+
+// appendDirent is fuse.AppendDirent with the offset of the entry given.
+// fuse.AppendDirent uses where the entry ends in data, which only works when
+// every read is served from the whole listing.
+func appendDirent(data []byte, d fuse.Dirent, off uint64) []byte {
+	de := dirent{
+		Ino:     d.Inode,
+		Off:     off,
+		Namelen: uint32(len(d.Name)),
+		Type:    uint32(d.Type),
+	}
+	data = append(data, (*[direntSize]byte)(unsafe.Pointer(&de))[:]...)
+	data = append(data, d.Name...)
+	if n := direntSize + len(d.Name); n%8 != 0 {
+		var pad [8]byte
+		data = append(data, pad[:8-n%8]...)
+	}
+	return data
+}
+
+// direntLen is how much of a read an entry named name takes.
+func direntLen(name string) int {
+	return (direntSize + len(name) + 7) &^ 7
+}
+
+// readDir lists the directory from the offset of the read, which is the
+// offset of the last entry the kernel has taken. The offset of each entry is
+// its cookie, so a read from anywhere in the listing can carry on with a new
+// ReadDir. Reads that follow on from the last one use the same stream. The
+// attributes that come with the entries are cached for the lookups that are
+// likely to follow.
+func (f *fs) readDir(r *fuse.ReadRequest) ([]byte, error) {
+	h := f.handles.get(r.Handle)
+	if h == nil {
+		return nil, errors.New("Unknown handle")
+	}
+	h.Lock()
+	defer h.Unlock()
+	var data []byte
+	cookie := uint64(r.Offset)
+	switch cookie {
+	case 0:
+		data = appendDirent(data, fuse.Dirent{Name: ".", Inode: uint64(r.Node), Type: fuse.DT_Dir}, dotOffset)
+		fallthrough
+	case dotOffset:
+		data = appendDirent(data, fuse.Dirent{Name: "..", Type: fuse.DT_Dir}, dotDotOffset)
+		fallthrough
+	case dotDotOffset:
+		cookie = 0
+	}
+	cookieB := uint64(0)
+	if cookie != 0 {
+		cookieB = math.MaxUint64
+	}
+	if h.dir != nil && h.dir.cookie != cookie {
+		h.closeDir()
+	}
+	retried := false
+	for {
+		if h.dir == nil {
+			dir, err := f.newDirReader(r.Hdr(), uint64(r.Node), cookie, cookieB)
+			if err != nil {
+				return nil, err
+			}
+			h.dir = dir
+		}
+		de, err := h.dir.peek()
+		if err != nil {
+			// The stream may have just lost its connection, so try once to
+			// carry on from the last entry with a new one
+			cookieB = h.dir.cookieB
+			h.closeDir()
+			if retried {
+				return nil, err
+			}
+			retried = true
+			continue
+		}
+		if de == nil || len(data)+direntLen(de.Name) > r.Size {
+			return data, nil
+		}
+		data = appendDirent(data, fuse.Dirent{
+			Name:  de.Name,
+			Inode: de.Inode,
+			Type:  fuse.DirentType(de.Type),
+		}, de.Cookie)
+		f.attrs.add(uint64(r.Node), de)
+		h.dir.take()
+		cookie = h.dir.cookie
+	}
+}
+
+// streamRead reads from the handle with a ReadStream if the reads have been
+// sequential, and returns false if the caller should use a unary Read.
+func (f *fs) streamRead(r *fuse.ReadRequest) ([]byte, bool, error) {
+	h := f.handles.get(r.Handle)
+	if h == nil {
+		return nil, false, nil
+	}
+	h.Lock()
+	defer h.Unlock()
+	// Anything streamed so far has to be stored before it can be read back
+	if err := h.closeWriter(); err != nil {
+		return nil, false, err
+	}
+	if h.reader != nil && (h.reader.offset != r.Offset || h.reader.exhausted()) {
+		h.closeReader()
+	}
+	if h.reader == nil {
+		if r.Offset == 0 || r.Offset != h.readEnd {
+			h.readEnd = r.Offset + int64(r.Size)
+			return nil, false, nil
+		}
+		reader, err := f.newStreamReader(r.Hdr(), uint64(r.Node), r.Offset)
+		if err != nil {
+			return nil, false, err
+		}
+		h.reader = reader
+	}
+	data, err := h.reader.read(r.Size)
+	if err == nil && len(data) < r.Size && h.reader.exhausted() {
+		// The read straddles the end of the stream, so carry on with a new one
+		h.closeReader()
+		var reader *streamReader
+		reader, err = f.newStreamReader(r.Hdr(), uint64(r.Node), r.Offset+int64(len(data)))
+		if err == nil {
+			h.reader = reader
+			var more []byte
+			more, err = reader.read(r.Size - len(data))
+			data = append(data, more...)
+		}
+	}
+	if err != nil {
+		h.closeReader()
+		return nil, false, err
+	}
+	h.readEnd = r.Offset + int64(len(data))
+	return data, true, nil
+}
+
+// streamWrite writes to the handle with a WriteStream if the writes have been
+// sequential, and returns false if the caller should use a unary Write.
+func (f *fs) streamWrite(r *fuse.WriteRequest) (bool, error) {
+	h := f.handles.get(r.Handle)
+	if h == nil {
+		return false, nil
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.closeReader()
+	if h.writer != nil && h.writer.next != r.Offset {
+		if err := h.closeWriter(); err != nil {
+			return false, err
+		}
+	}
+	if h.writer == nil {
+		if r.Offset == 0 || r.Offset != h.writeEnd {
+			h.writeEnd = r.Offset + int64(len(r.Data))
+			return false, nil
+		}
+		writer, err := f.newStreamWriter(r.Hdr())
+		if err != nil {
+			return false, err
+		}
+		h.writer = writer
+	}
+	err := h.writer.write(&pb.WriteRequest{Inode: uint64(r.Node), Offset: r.Offset, Payload: r.Data})
+	if err != nil {
+		h.writer.cancel()
+		h.writer = nil
+		return false, err
+	}
+	h.writeEnd = h.writer.next
+	return true, nil
+}
+
+// syncStreams waits for any writes streamed on the handle to be stored,
+// returning the error from storing them.
+func (f *fs) syncStreams(handle fuse.HandleID) error {
+	h := f.handles.get(handle)
+	if h == nil {
+		return nil
+	}
+	h.Lock()
+	defer h.Unlock()
+	return h.closeWriter()
+}
+
+// closeStreams finishes any streams on the handle, returning the error from
+// storing any streamed writes.
+func (f *fs) closeStreams(handle fuse.HandleID) error {
+	h := f.handles.get(handle)
+	if h == nil {
+		return nil
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.closeReader()
+	h.closeDir()
+	return h.closeWriter()
+}
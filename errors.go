@@ -0,0 +1,135 @@
+package formic
+
+import (
+	"strconv"
+	"syscall"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// Errors sent back by the Api service carry the gRPC code for the general
+// class of the error, and the POSIX errno is sent in the "errno" trailer so
+// that clients can give applications the exact error. The description starts
+// with the name of the errno for people reading it, and for older clients.
+
+// ErrnoTrailer is the trailer the Api service sends the errno of an error in.
+const ErrnoTrailer = "errno"
+
+type errnoInfo struct {
+	name string
+	code codes.Code
+}
+
+var errnos = map[syscall.Errno]errnoInfo{
+	syscall.ENOENT:       {"ENOENT", codes.NotFound},
+	syscall.ENODATA:      {"ENODATA", codes.NotFound},
+	syscall.EEXIST:       {"EEXIST", codes.AlreadyExists},
+	syscall.ENOTEMPTY:    {"ENOTEMPTY", codes.FailedPrecondition},
+	syscall.EACCES:       {"EACCES", codes.PermissionDenied},
+	syscall.EPERM:        {"EPERM", codes.PermissionDenied},
+	syscall.EINVAL:       {"EINVAL", codes.InvalidArgument},
+	syscall.ENAMETOOLONG: {"ENAMETOOLONG", codes.InvalidArgument},
+	syscall.EISDIR:       {"EISDIR", codes.InvalidArgument},
+	syscall.ENOTDIR:      {"ENOTDIR", codes.InvalidArgument},
+	syscall.ENOSPC:       {"ENOSPC", codes.ResourceExhausted},
+	syscall.ENOSYS:       {"ENOSYS", codes.Unimplemented},
+	syscall.ENXIO:        {"ENXIO", codes.OutOfRange},
+	syscall.EOPNOTSUPP:   {"EOPNOTSUPP", codes.Unimplemented},
+	syscall.EIO:          {"EIO", codes.DataLoss},
+	syscall.EAGAIN:       {"EAGAIN", codes.Aborted},
+	syscall.EXDEV:        {"EXDEV", codes.FailedPrecondition},
+}
+
+// Used when no errno was sent, such as for errors from older servers or from
+// grpc itself. FailedPrecondition covers too many different errors to guess
+// at.
+var codeErrnos = map[codes.Code]syscall.Errno{
+	codes.NotFound:          syscall.ENOENT,
+	codes.AlreadyExists:     syscall.EEXIST,
+	codes.PermissionDenied:  syscall.EACCES,
+	codes.Unauthenticated:   syscall.EACCES,
+	codes.InvalidArgument:   syscall.EINVAL,
+	codes.ResourceExhausted: syscall.ENOSPC,
+	codes.Unimplemented:     syscall.ENOSYS,
+	codes.OutOfRange:        syscall.ENXIO,
+	codes.DataLoss:          syscall.EIO,
+	codes.Aborted:           syscall.EAGAIN,
+}
+
+// errnoError is an error from the Api service along with its errno.
+type errnoError struct {
+	errno syscall.Errno
+	err   error // The gRPC error
+}
+
+func (e *errnoError) Error() string {
+	return e.err.Error()
+}
+
+// Error returns the error the Api service should send for errno. The service
+// has to be run with the interceptors below for the errno to be sent.
+func Error(errno syscall.Errno) error {
+	e, ok := errnos[errno]
+	if !ok {
+		return &errnoError{errno, grpc.Errorf(codes.Unknown, "%s", errno.Error())}
+	}
+	return &errnoError{errno, grpc.Errorf(e.code, "%s: %s", e.name, errno.Error())}
+}
+
+// UnaryServerInterceptor sends the errno of an error from Error in the trailer.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if e, ok := err.(*errnoError); ok {
+		grpc.SetTrailer(ctx, errnoTrailer(e.errno))
+		err = e.err
+	}
+	return resp, err
+}
+
+// StreamServerInterceptor sends the errno of an error from Error in the
+// trailer.
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	if e, ok := err.(*errnoError); ok {
+		ss.SetTrailer(errnoTrailer(e.errno))
+		err = e.err
+	}
+	return err
+}
+
+func errnoTrailer(errno syscall.Errno) metadata.MD {
+	return metadata.Pairs(ErrnoTrailer, strconv.FormatUint(uint64(errno), 10))
+}
+
+// TrailerError returns err along with the errno in the trailer that came back
+// with it, for Errno to find.
+func TrailerError(err error, trailer metadata.MD) error {
+	if err == nil || len(trailer[ErrnoTrailer]) == 0 {
+		return err
+	}
+	errno, perr := strconv.ParseUint(trailer[ErrnoTrailer][0], 10, 32)
+	if perr != nil || errno == 0 {
+		return err
+	}
+	return &errnoError{syscall.Errno(errno), err}
+}
+
+// Errno returns the POSIX errno for an error returned by the Api service,
+// once the errno sent with it has been added by TrailerError. Without one, the
+// errno is guessed from the gRPC code, and anything that can't be mapped is
+// an EIO.
+func Errno(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*errnoError); ok {
+		return e.errno
+	}
+	if errno, ok := codeErrnos[grpc.Code(err)]; ok {
+		return errno
+	}
+	return syscall.EIO
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+
+	pb "github.com/creiht/formic/proto"
+)
+
+// Names of the xattrs that hold POSIX ACLs
+const (
+	ACLAccessXattr  = "system.posix_acl_access"
+	ACLDefaultXattr = "system.posix_acl_default"
+)
+
+// The xattr format used by Linux, which is a version header followed by
+// little endian entries of a tag, the permissions and an id.
+const (
+	ACLVersion   = 2
+	aclEntrySize = 8
+
+	ACLUserObj  = 0x01
+	ACLUser     = 0x02
+	ACLGroupObj = 0x04
+	ACLGroup    = 0x08
+	ACLMask     = 0x10
+	ACLOther    = 0x20
+
+	ACLUndefinedID = 0xffffffff
+)
+
+type ACLEntry struct {
+	Tag  uint16
+	Perm uint16
+	Id   uint32
+}
+
+type ACL []ACLEntry
+
+func (a ACL) Len() int {
+	return len(a)
+}
+
+func (a ACL) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func (a ACL) Less(i, j int) bool {
+	if a[i].Tag != a[j].Tag {
+		return a[i].Tag < a[j].Tag
+	}
+	return a[i].Id < a[j].Id
+}
+
+// ParseACL decodes and validates an ACL xattr. Entries may be in any order
+// but there must be exactly one of each of the owner, group and other entries,
+// and a mask if there are any named users or groups.
+func ParseACL(b []byte) (ACL, error) {
+	if len(b) < 4 || (len(b)-4)%aclEntrySize != 0 {
+		return nil, ErrInvalid
+	}
+	if binary.LittleEndian.Uint32(b) != ACLVersion {
+		return nil, ErrInvalid
+	}
+	a := make(ACL, 0, (len(b)-4)/aclEntrySize)
+	for i := 4; i < len(b); i += aclEntrySize {
+		a = append(a, ACLEntry{
+			Tag:  binary.LittleEndian.Uint16(b[i:]),
+			Perm: binary.LittleEndian.Uint16(b[i+2:]),
+			Id:   binary.LittleEndian.Uint32(b[i+4:]),
+		})
+	}
+	sort.Sort(a)
+	counts := make(map[uint16]int)
+	for i, e := range a {
+		if e.Perm&^7 != 0 {
+			return nil, ErrInvalid
+		}
+		switch e.Tag {
+		case ACLUserObj, ACLGroupObj, ACLMask, ACLOther:
+			a[i].Id = ACLUndefinedID
+		case ACLUser, ACLGroup:
+			if i > 0 && a[i-1].Tag == e.Tag && a[i-1].Id == e.Id {
+				return nil, ErrInvalid
+			}
+		default:
+			return nil, ErrInvalid
+		}
+		counts[e.Tag]++
+	}
+	if counts[ACLUserObj] != 1 || counts[ACLGroupObj] != 1 || counts[ACLOther] != 1 || counts[ACLMask] > 1 {
+		return nil, ErrInvalid
+	}
+	if counts[ACLMask] == 0 && counts[ACLUser]+counts[ACLGroup] > 0 {
+		return nil, ErrInvalid
+	}
+	return a, nil
+}
+
+// Bytes returns the ACL in the xattr format.
+func (a ACL) Bytes() []byte {
+	b := make([]byte, 4+len(a)*aclEntrySize)
+	binary.LittleEndian.PutUint32(b, ACLVersion)
+	for i, e := range a {
+		o := 4 + i*aclEntrySize
+		binary.LittleEndian.PutUint16(b[o:], e.Tag)
+		binary.LittleEndian.PutUint16(b[o+2:], e.Perm)
+		binary.LittleEndian.PutUint32(b[o+4:], e.Id)
+	}
+	return b
+}
+
+// find returns the first entry with tag, or nil if there isn't one.
+func (a ACL) find(tag uint16) *ACLEntry {
+	for i := range a {
+		if a[i].Tag == tag {
+			return &a[i]
+		}
+	}
+	return nil
+}
+
+// groupClass returns the entry that the group bits of the mode map to, which
+// is the mask when there is one.
+func (a ACL) groupClass() *ACLEntry {
+	if m := a.find(ACLMask); m != nil {
+		return m
+	}
+	return a.find(ACLGroupObj)
+}
+
+// Minimal returns true if the ACL says nothing more than the mode bits.
+func (a ACL) Minimal() bool {
+	return len(a) == 3
+}
+
+// Mode returns mode with the permission bits replaced by those in the ACL.
+func (a ACL) Mode(mode uint32) uint32 {
+	mode &^= 0777
+	mode |= uint32(a.find(ACLUserObj).Perm) << 6
+	mode |= uint32(a.groupClass().Perm) << 3
+	mode |= uint32(a.find(ACLOther).Perm)
+	return mode
+}
+
+// SetMode updates the ACL to match the permission bits of mode, as done by a
+// chmod.
+func (a ACL) SetMode(mode uint32) {
+	a.find(ACLUserObj).Perm = uint16(mode>>6) & 7
+	a.groupClass().Perm = uint16(mode>>3) & 7
+	a.find(ACLOther).Perm = uint16(mode) & 7
+}
+
+// Inherit returns the access ACL for a new entry created with mode in a
+// directory with the default ACL a, along with the new entry's mode.
+func (a ACL) Inherit(mode uint32) (ACL, uint32) {
+	n := make(ACL, len(a))
+	copy(n, a)
+	n.find(ACLUserObj).Perm &= uint16(mode>>6) & 7
+	n.groupClass().Perm &= uint16(mode>>3) & 7
+	n.find(ACLOther).Perm &= uint16(mode) & 7
+	return n, n.Mode(mode)
+}
+
+// allows returns true if the ACL grants the caller all of the permissions in
+// want, following the POSIX access check algorithm. The owner and root are
+// handled by the caller using the mode bits.
+func (a ACL) allows(c *Creds, attr *pb.Attr, want uint32) bool {
+	mask := uint32(7)
+	if m := a.find(ACLMask); m != nil {
+		mask = uint32(m.Perm)
+	}
+	for _, e := range a {
+		if e.Tag == ACLUser && e.Id == c.Uid {
+			return uint32(e.Perm)&mask&want == want
+		}
+	}
+	matched := false
+	for _, e := range a {
+		if (e.Tag == ACLGroupObj && c.inGroup(attr.Gid)) || (e.Tag == ACLGroup && c.inGroup(e.Id)) {
+			if uint32(e.Perm)&mask&want == want {
+				return true
+			}
+			matched = true
+		}
+	}
+	if matched {
+		return false
+	}
+	return uint32(a.find(ACLOther).Perm)&want == want
+}
+
+// setACL validates and stores one of the ACL xattrs on the inode. The mode
+// bits are updated to match an access ACL, and one that says nothing more than
+// the mode bits isn't kept at all.
+func setACL(n *pb.InodeEntry, name string, value []byte) error {
+	acl, err := ParseACL(value)
+	if err != nil {
+		return err
+	}
+	if name == ACLDefaultXattr {
+		if !n.IsDir {
+			return ErrAccess
+		}
+		n.Xattr[name] = acl.Bytes()
+		return nil
+	}
+	n.Attr.Mode = acl.Mode(n.Attr.Mode)
+	if acl.Minimal() {
+		delete(n.Xattr, name)
+		return nil
+	}
+	n.Xattr[name] = acl.Bytes()
+	return nil
+}
+
+// inheritACLs returns the xattrs for a new entry in a directory with the
+// default ACL def, and updates the mode in attr to match. New directories
+// also get def as their default ACL.
+// The kernel will have already applied the umask to the mode, which POSIX
+// says should be skipped when there is a default ACL.
+func inheritACLs(def ACL, attr *pb.Attr, isdir bool) map[string][]byte {
+	xattr := make(map[string][]byte)
+	acl, mode := def.Inherit(attr.Mode)
+	attr.Mode = mode
+	if !acl.Minimal() {
+		xattr[ACLAccessXattr] = acl.Bytes()
+	}
+	if isdir {
+		xattr[ACLDefaultXattr] = def.Bytes()
+	}
+	return xattr
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/getcfs/fuse"
+)
+
+func TestParseACL(t *testing.T) {
+	good := ACL{
+		{Tag: ACLOther, Perm: 4},
+		{Tag: ACLUser, Perm: 6, Id: 2000},
+		{Tag: ACLUserObj, Perm: 6},
+		{Tag: ACLMask, Perm: 6},
+		{Tag: ACLGroupObj, Perm: 4},
+	}
+	a, err := ParseACL(good.Bytes())
+	if err != nil {
+		t.Fatal("ParseACL failed: ", err)
+	}
+	if a[0].Tag != ACLUserObj || a[len(a)-1].Tag != ACLOther {
+		t.Errorf("Expected entries to be sorted, got: %v", a)
+	}
+	if a.Mode(0) != 0664 {
+		t.Errorf("Expected mode 0664, got %o", a.Mode(0))
+	}
+	for _, bad := range []ACL{
+		// No mask with a named user
+		{{Tag: ACLUserObj, Perm: 6}, {Tag: ACLUser, Perm: 6, Id: 1}, {Tag: ACLGroupObj}, {Tag: ACLOther}},
+		// Missing other
+		{{Tag: ACLUserObj, Perm: 6}, {Tag: ACLGroupObj}},
+		// Duplicate named user
+		{{Tag: ACLUserObj}, {Tag: ACLUser, Id: 1}, {Tag: ACLUser, Id: 1}, {Tag: ACLGroupObj}, {Tag: ACLMask}, {Tag: ACLOther}},
+		// Bad permissions
+		{{Tag: ACLUserObj, Perm: 8}, {Tag: ACLGroupObj}, {Tag: ACLOther}},
+	} {
+		if _, err = ParseACL(bad.Bytes()); err != ErrInvalid {
+			t.Errorf("Expected ErrInvalid for %v, got: %v", bad, err)
+		}
+	}
+	if _, err = ParseACL([]byte{1, 0, 0, 0}); err != ErrInvalid {
+		t.Error("Expected ErrInvalid for a bad version, got: ", err)
+	}
+}
+
+func TestACL_Allows(t *testing.T) {
+	a := ACL{
+		{Tag: ACLUserObj, Perm: 7},
+		{Tag: ACLUser, Perm: 7, Id: 2000},
+		{Tag: ACLGroupObj, Perm: 4},
+		{Tag: ACLGroup, Perm: 2, Id: 30},
+		{Tag: ACLMask, Perm: 6},
+		{Tag: ACLOther},
+	}
+	attr := &pb.Attr{Mode: a.Mode(0), Uid: 10, Gid: 20}
+	for _, test := range []struct {
+		c    *Creds
+		want uint32
+		ok   bool
+	}{
+		{&Creds{Uid: 2000, Gid: 99}, MayRead | MayWrite, true},
+		// Limited by the mask
+		{&Creds{Uid: 2000, Gid: 99}, MayExec, false},
+		{&Creds{Uid: 11, Gid: 20}, MayRead, true},
+		{&Creds{Uid: 11, Gid: 20}, MayWrite, false},
+		{&Creds{Uid: 11, Gid: 20, Gids: []uint32{30}}, MayWrite, true},
+		// No single group entry grants both
+		{&Creds{Uid: 11, Gid: 20, Gids: []uint32{30}}, MayRead | MayWrite, false},
+		{&Creds{Uid: 11, Gid: 99}, MayRead, false},
+	} {
+		if ok := test.c.canAccessACL(attr, a, test.want); ok != test.ok {
+			t.Errorf("canAccessACL(%v, %d) returned %v", test.c, test.want, ok)
+		}
+	}
+}
+
+func TestOortFS_ACL(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	other := asUser(ctx, 2000, 2000)
+	m, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "shared", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	def := ACL{
+		{Tag: ACLUserObj, Perm: 7},
+		{Tag: ACLUser, Perm: 7, Id: 2000},
+		{Tag: ACLGroupObj, Perm: 5},
+		{Tag: ACLMask, Perm: 7},
+		{Tag: ACLOther, Perm: 5},
+	}
+	_, err = api.Setxattr(other, &pb.SetxattrRequest{Inode: m.Attr.Inode, Name: ACLDefaultXattr, Value: def.Bytes()})
+	if formic.Errno(err) != syscall.EPERM {
+		t.Fatal("Expected EPERM setting an ACL on another user's directory, got: ", err)
+	}
+	_, err = api.Setxattr(ctx, &pb.SetxattrRequest{Inode: m.Attr.Inode, Name: ACLDefaultXattr, Value: []byte("junk")})
+	if formic.Errno(err) != syscall.EINVAL {
+		t.Fatal("Expected EINVAL for a bad ACL, got: ", err)
+	}
+	if _, err = api.Setxattr(ctx, &pb.SetxattrRequest{Inode: m.Attr.Inode, Name: ACLDefaultXattr, Value: def.Bytes()}); err != nil {
+		t.Fatal("Setxattr failed: ", err)
+	}
+	// The directory itself doesn't have an access ACL yet
+	_, err = api.Create(other, &pb.CreateRequest{Parent: m.Attr.Inode, Name: "denied", Attr: &pb.Attr{Mode: 0644}})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES, got: ", err)
+	}
+	if _, err = api.Setxattr(ctx, &pb.SetxattrRequest{Inode: m.Attr.Inode, Name: ACLAccessXattr, Value: def.Bytes()}); err != nil {
+		t.Fatal("Setxattr failed: ", err)
+	}
+	a, _ := api.GetAttr(ctx, &pb.GetAttrRequest{Inode: m.Attr.Inode})
+	if a.Attr.Mode&0777 != 0775 {
+		t.Fatalf("Expected the mode to follow the ACL, got %o", a.Attr.Mode&0777)
+	}
+	sub, err := api.MkDir(other, &pb.MkDirRequest{Parent: m.Attr.Inode, Name: "sub", Attr: &pb.Attr{Mode: 0750}})
+	if err != nil {
+		t.Fatal("MkDir with an ACL grant failed: ", err)
+	}
+	if sub.Attr.Mode&0777 != 0750 {
+		t.Fatalf("Expected inherited mode 0750, got %o", sub.Attr.Mode&0777)
+	}
+	c := createFile(t, api, ctx, m.Attr.Inode, "file")
+	if c.Mode&0777 != 0644 {
+		t.Fatalf("Expected inherited mode 0644, got %o", c.Mode&0777)
+	}
+	x, err := api.Getxattr(ctx, &pb.GetxattrRequest{Inode: sub.Attr.Inode, Name: ACLDefaultXattr})
+	sorted, _ := ParseACL(def.Bytes())
+	if err != nil || !bytes.Equal(x.Xattr, sorted.Bytes()) {
+		t.Fatal("Expected the default ACL to be inherited by the directory: ", err)
+	}
+	// The mask from the inherited ACL stops the named user from writing
+	_, err = api.Open(other, &pb.OpenRequest{Inode: c.Inode, Flags: syscall.O_WRONLY})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES, got: ", err)
+	}
+	// chmod g+w opens up the mask
+	_, err = api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: c.Inode, Mode: 0664}, Valid: uint32(fuse.SetattrMode)})
+	if err != nil {
+		t.Fatal("SetAttr failed: ", err)
+	}
+	x, err = api.Getxattr(ctx, &pb.GetxattrRequest{Inode: c.Inode, Name: ACLAccessXattr})
+	if err != nil {
+		t.Fatal("Getxattr failed: ", err)
+	}
+	acl, err := ParseACL(x.Xattr)
+	if err != nil || acl.find(ACLMask).Perm != 6 {
+		t.Fatalf("Expected the mask to follow the mode, got %v: %v", acl, err)
+	}
+	if _, err = api.Open(other, &pb.OpenRequest{Inode: c.Inode, Flags: syscall.O_WRONLY}); err != nil {
+		t.Fatal("Open with an ACL grant failed: ", err)
+	}
+}
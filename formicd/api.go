@@ -3,17 +3,23 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 
+	"github.com/getcfs/fuse"
+
 	"github.com/creiht/formic"
 	"github.com/creiht/formic/flother"
 	pb "github.com/creiht/formic/proto"
@@ -25,14 +31,63 @@ import (
 
 var ErrUnauthorized = errors.New("Unknown or unauthorized filesystem")
 
+// MaxNameLen is the longest name allowed for a directory entry, as NAME_MAX
+const MaxNameLen = 255
+
+// DefaultBlockConcurrency is how many block operations a single request may
+// have in flight at once unless set by FORMICD_CONCURRENT_REQUESTS_PER_STORE.
+const DefaultBlockConcurrency = 8
+
+// errnos maps errors from the FileService to the errno sent to the client
+var errnos = map[error]syscall.Errno{
+	ErrNotFound:     syscall.ENOENT,
+	ErrExists:       syscall.EEXIST,
+	ErrNotEmpty:     syscall.ENOTEMPTY,
+	ErrIsDir:        syscall.EISDIR,
+	ErrNotDir:       syscall.ENOTDIR,
+	ErrInvalid:      syscall.EINVAL,
+	ErrLinkDir:      syscall.EPERM,
+	ErrNameTooLong:  syscall.ENAMETOOLONG,
+	ErrNoAttr:       syscall.ENODATA,
+	ErrUnauthorized: syscall.EACCES,
+	ErrAccess:       syscall.EACCES,
+	ErrPerm:         syscall.EPERM,
+	ErrNoData:       syscall.ENXIO,
+	ErrNotSupported: syscall.EOPNOTSUPP,
+	ErrCorrupt:      syscall.EIO,
+	ErrConflict:     syscall.EAGAIN,
+	// Tools such as mv copy instead when a rename can't be done in place
+	ErrUnknownParent: syscall.EXDEV,
+}
+
+// apiError converts err into an error that the client can map to an errno.
+// Anything unexpected is passed through and will end up as an EIO.
+func apiError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errno, ok := errnos[err]; ok {
+		return formic.Error(errno)
+	}
+	if store.IsDisabled(err) {
+		// The store disables writes when it runs out of space
+		return formic.Error(syscall.ENOSPC)
+	}
+	return err
+}
+
 type apiServer struct {
 	sync.RWMutex
-	fs         FileService
-	fl         *flother.Flother
-	blocksize  int64
-	updateChan chan *UpdateItem
-	comms      *StoreComms
-	validIPs   map[string]map[string]bool
+	fs        FileService
+	fl        *flother.Flother
+	blocksize int64
+	// How many block operations a single request may have in flight at once
+	blockConcurrency int
+	updates          *Updatinator
+	invalidator      *Invalidator
+	comms            *StoreComms
+	validIPs         map[string]map[string]bool
+	blocksizes       map[string]int64 // Block size of each fs by fsid
 }
 
 func NewApiServer(fs FileService, nodeId int, comms *StoreComms) *apiServer {
@@ -40,12 +95,15 @@ func NewApiServer(fs FileService, nodeId int, comms *StoreComms) *apiServer {
 	s.fs = fs
 	s.comms = comms
 	s.validIPs = make(map[string]map[string]bool)
+	s.blocksizes = make(map[string]int64)
 	log.Println("NodeID: ", nodeId)
 	s.fl = flother.NewFlother(time.Time{}, uint64(nodeId))
 	s.blocksize = int64(1024 * 64) // Default Block Size (64K)
-	s.updateChan = make(chan *UpdateItem, 1000)
-	updates := newUpdatinator(s.updateChan, fs)
-	go updates.run()
+	s.blockConcurrency = DefaultBlockConcurrency
+	s.updates = newUpdatinator(fs, comms, DefaultUpdateWorkers)
+	s.updates.start()
+	s.invalidator = newInvalidator(comms, fmt.Sprint(nodeId))
+	s.invalidator.start()
 	return s
 }
 
@@ -97,13 +155,10 @@ func (s *apiServer) validateIP(ctx context.Context) error {
 		return err
 	}
 	// First check the cache
-	ips, ok := s.validIPs[fsid]
-	if !ok {
-		ips = make(map[string]bool)
-		s.validIPs[fsid] = ips
-	}
-	valid, ok := ips[ip]
-	if ok && valid {
+	s.RLock()
+	valid := s.validIPs[fsid][ip]
+	s.RUnlock()
+	if valid {
 		return nil
 	}
 	_, err = s.comms.ReadGroupItem(ctx, []byte(fmt.Sprintf("/fs/%s/addr", fsid)), []byte(ip))
@@ -116,44 +171,280 @@ func (s *apiServer) validateIP(ctx context.Context) error {
 		return err
 	}
 	// Cache the valid ip
-	s.validIPs[fsid][ip] = true
+	s.Lock()
+	ips, ok := s.validIPs[fsid]
+	if !ok {
+		ips = make(map[string]bool)
+		s.validIPs[fsid] = ips
+	}
+	ips[ip] = true
+	s.Unlock()
+	return nil
+}
+
+// access returns the attributes of the inode if the caller has all of the
+// permissions in mask on it, either from the mode bits or its ACL.
+func (s *apiServer) access(ctx context.Context, c *Creds, id []byte, mask uint32) (*pb.Attr, error) {
+	n, err := s.accessInode(ctx, c, id, mask)
+	if err != nil {
+		return nil, err
+	}
+	return n.Attr, nil
+}
+
+// accessInode is access for when the whole inode is needed.
+func (s *apiServer) accessInode(ctx context.Context, c *Creds, id []byte, mask uint32) (*pb.InodeEntry, error) {
+	n, err := s.fs.GetInode(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !c.canAccessACL(n.Attr, inodeACL(n), mask) {
+		return nil, ErrAccess
+	}
+	return n, nil
+}
+
+// accessHandle is accessInode for the requests made through an open file.
+// There are no handles here to remember what Open allowed, so as on an NFS
+// server every request is checked again, and as there the owner is always let
+// through. That way a file created without write permission can still be
+// written through the handle it was created with, and the owner could give
+// themselves the permission anyway.
+func (s *apiServer) accessHandle(ctx context.Context, id []byte, mask uint32) (*pb.InodeEntry, error) {
+	n, err := s.fs.GetInode(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c := GetCreds(ctx)
+	if c.Uid != n.Attr.Uid && !c.canAccessACL(n.Attr, inodeACL(n), mask) {
+		return nil, ErrAccess
+	}
+	return n, nil
+}
+
+// blockSize returns the size of the blocks of the file n. Files that have been
+// written record the block size that they were written with, so that they
+// stay readable when the default changes.
+func (s *apiServer) blockSize(ctx context.Context, fsid uuid.UUID, n *pb.InodeEntry) (int64, error) {
+	if n.BlockSize > 0 {
+		return int64(n.BlockSize), nil
+	}
+	return s.fsBlockSize(ctx, fsid)
+}
+
+// fsBlockSize returns the block size that the file system was created with,
+// which is only stored when it isn't the default.
+func (s *apiServer) fsBlockSize(ctx context.Context, fsid uuid.UUID) (int64, error) {
+	if s.comms == nil {
+		// Assume that it is a unit test
+		return s.blocksize, nil
+	}
+	key := fsid.String()
+	s.RLock()
+	bs, ok := s.blocksizes[key]
+	s.RUnlock()
+	if !ok {
+		b, err := s.comms.ReadGroupItem(ctx, []byte(fmt.Sprintf("/fs/%s", key)), []byte("blocksize"))
+		if err == nil {
+			attr := &FileSysAttr{}
+			err = json.Unmarshal(b, attr)
+			if err == nil {
+				bs, err = strconv.ParseInt(attr.Value, 10, 64)
+			}
+		} else if store.IsNotFound(err) {
+			err = nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		// 0 is cached for file systems that use the default
+		s.Lock()
+		s.blocksizes[key] = bs
+		s.Unlock()
+	}
+	if bs == 0 {
+		return s.blocksize, nil
+	}
+	return bs, nil
+}
+
+// canUnlink checks that the caller may remove name from parent, which needs
+// write and search permission on parent along with the sticky bit rules.
+func (s *apiServer) canUnlink(ctx context.Context, c *Creds, parent []byte, name string) error {
+	dir, err := s.access(ctx, c, parent, MayWrite|MayExec)
+	if err != nil {
+		return err
+	}
+	_, attr, err := s.fs.Lookup(ctx, parent, name)
+	if err != nil {
+		return err
+	}
+	if !c.canUnlink(dir, attr) {
+		return ErrPerm
+	}
+	return nil
+}
+
+// checkXattr makes sure that the caller may read (MayRead) or change
+// (MayWrite) the xattr name of n. Anyone may read the ACLs but only the owner
+// may change them, trusted xattrs are only for root, as are changes to
+// security xattrs, and everything else follows the permissions of the inode.
+func checkXattr(c *Creds, n *pb.InodeEntry, name string, mask uint32) error {
+	switch {
+	case name == ACLAccessXattr || name == ACLDefaultXattr:
+		if mask&MayWrite != 0 && !c.owns(n.Attr) {
+			return ErrPerm
+		}
+		return nil
+	case strings.HasPrefix(name, "trusted."):
+		if c.Uid != 0 {
+			return ErrPerm
+		}
+		return nil
+	case strings.HasPrefix(name, "security."):
+		if mask&MayWrite != 0 && c.Uid != 0 {
+			return ErrPerm
+		}
+		return nil
+	}
+	if !c.canAccessACL(n.Attr, inodeACL(n), mask) {
+		return ErrAccess
+	}
+	return nil
+}
+
+// accessXattr returns an error unless the caller may read or change the
+// xattr name of the inode, as checkXattr.
+func (s *apiServer) accessXattr(ctx context.Context, id []byte, name string, mask uint32) error {
+	n, err := s.fs.GetInode(ctx, id)
+	if err != nil {
+		return err
+	}
+	return checkXattr(GetCreds(ctx), n, name, mask)
+}
+
+// openMask returns the permissions needed to open a file with flags.
+func openMask(flags uint32) uint32 {
+	var mask uint32
+	switch flags & syscall.O_ACCMODE {
+	case syscall.O_RDONLY:
+		mask = MayRead
+	case syscall.O_WRONLY:
+		mask = MayWrite
+	default:
+		mask = MayRead | MayWrite
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		mask |= MayWrite
+	}
+	return mask
+}
+
+// setOwner makes the caller the owner of a new entry in dir. Entries in a
+// setgid directory get the directory's group instead of the caller's, and new
+// directories there keep the setgid bit.
+func setOwner(attr, dir *pb.Attr, c *Creds) {
+	attr.Uid = c.Uid
+	attr.Gid = c.Gid
+	if dir.Mode&uint32(os.ModeSetgid) != 0 {
+		attr.Gid = dir.Gid
+		if os.FileMode(attr.Mode).IsDir() {
+			attr.Mode |= uint32(os.ModeSetgid)
+		}
+	}
+}
+
+// checkSetAttr makes sure the caller is allowed to make the changes to attr
+// that are flagged in valid.
+func checkSetAttr(c *Creds, attr *pb.Attr, acl ACL, changes *pb.Attr, valid fuse.SetattrValid) error {
+	if valid.Uid() && changes.Uid != attr.Uid && c.Uid != 0 {
+		return ErrPerm
+	}
+	if valid.Gid() && changes.Gid != attr.Gid {
+		if !c.owns(attr) || (c.Uid != 0 && !c.inGroup(changes.Gid)) {
+			return ErrPerm
+		}
+	}
+	if valid.Mode() {
+		if !c.owns(attr) {
+			return ErrPerm
+		}
+		if c.Uid != 0 && !c.inGroup(attr.Gid) {
+			// Only members of the group can give out its permissions
+			changes.Mode &^= uint32(os.ModeSetgid)
+		}
+	}
+	if valid.Size() && !c.canAccessACL(attr, acl, MayWrite) {
+		return ErrAccess
+	}
+	// Setting the times to now only needs write permission, any other time
+	// needs ownership
+	if (valid.Atime() && !valid.AtimeNow()) || (valid.Mtime() && !valid.MtimeNow()) {
+		if !c.owns(attr) {
+			return ErrPerm
+		}
+	}
+	if (valid.AtimeNow() || valid.MtimeNow()) && !c.owns(attr) && !c.canAccessACL(attr, acl, MayWrite) {
+		return ErrAccess
+	}
 	return nil
 }
 
 func (s *apiServer) GetAttr(ctx context.Context, r *pb.GetAttrRequest) (*pb.GetAttrResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	attr, err := s.fs.GetAttr(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0))
-	return &pb.GetAttrResponse{Attr: attr}, err
+	return &pb.GetAttrResponse{Attr: attr}, apiError(err)
 }
 
 func (s *apiServer) SetAttr(ctx context.Context, r *pb.SetAttrRequest) (*pb.SetAttrResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
-	attr, err := s.fs.SetAttr(ctx, formic.GetID(fsid.Bytes(), r.Attr.Inode, 0), r.Attr, r.Valid)
-	return &pb.SetAttrResponse{Attr: attr}, err
+	id := formic.GetID(fsid.Bytes(), r.Attr.Inode, 0)
+	n, err := s.fs.GetInode(ctx, id)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	err = checkSetAttr(GetCreds(ctx), n.Attr, inodeACL(n), r.Attr, fuse.SetattrValid(r.Valid))
+	if err != nil {
+		return nil, apiError(err)
+	}
+	attr, err := s.fs.SetAttr(ctx, fsid.Bytes(), id, r.Attr, r.Valid)
+	if err == nil {
+		s.changed(ctx, fsid, &pb.Invalidation{Inode: r.Attr.Inode, Data: fuse.SetattrValid(r.Valid).Size()})
+	}
+	return &pb.SetAttrResponse{Attr: attr}, apiError(err)
 }
 
 func (s *apiServer) Create(ctx context.Context, r *pb.CreateRequest) (*pb.CreateResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	if len(r.Name) > MaxNameLen {
+		return nil, apiError(ErrNameTooLong)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	c := GetCreds(ctx)
+	parent := formic.GetID(fsid.Bytes(), r.Parent, 0)
+	dir, err := s.access(ctx, c, parent, MayWrite|MayExec)
+	if err != nil {
+		return nil, apiError(err)
 	}
 	ts := time.Now().Unix()
 	inode := s.fl.GetID()
@@ -164,24 +455,34 @@ func (s *apiServer) Create(ctx context.Context, r *pb.CreateRequest) (*pb.Create
 		Ctime:  ts,
 		Crtime: ts,
 		Mode:   r.Attr.Mode,
-		Uid:    r.Attr.Uid,
-		Gid:    r.Attr.Gid,
+		Nlink:  1,
 	}
-	rname, rattr, err := s.fs.Create(ctx, formic.GetID(fsid.Bytes(), r.Parent, 0), formic.GetID(fsid.Bytes(), inode, 0), inode, r.Name, attr, false)
+	setOwner(attr, dir, c)
+	rname, rattr, err := s.fs.Create(ctx, fsid.Bytes(), parent, formic.GetID(fsid.Bytes(), inode, 0), inode, r.Name, attr, false)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
-	return &pb.CreateResponse{Name: rname, Attr: rattr}, err
+	s.changed(ctx, fsid, &pb.Invalidation{Parent: r.Parent, Name: r.Name})
+	return &pb.CreateResponse{Name: rname, Attr: rattr}, apiError(err)
 }
 
 func (s *apiServer) MkDir(ctx context.Context, r *pb.MkDirRequest) (*pb.MkDirResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	if len(r.Name) > MaxNameLen {
+		return nil, apiError(ErrNameTooLong)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	c := GetCreds(ctx)
+	parent := formic.GetID(fsid.Bytes(), r.Parent, 0)
+	dir, err := s.access(ctx, c, parent, MayWrite|MayExec)
+	if err != nil {
+		return nil, apiError(err)
 	}
 	ts := time.Now().Unix()
 	inode := s.fl.GetID()
@@ -192,56 +493,105 @@ func (s *apiServer) MkDir(ctx context.Context, r *pb.MkDirRequest) (*pb.MkDirRes
 		Ctime:  ts,
 		Crtime: ts,
 		Mode:   uint32(os.ModeDir) | r.Attr.Mode,
-		Uid:    r.Attr.Uid,
-		Gid:    r.Attr.Gid,
+		Nlink:  1,
 	}
-	rname, rattr, err := s.fs.Create(ctx, formic.GetID(fsid.Bytes(), r.Parent, 0), formic.GetID(fsid.Bytes(), inode, 0), inode, r.Name, attr, true)
-	return &pb.MkDirResponse{Name: rname, Attr: rattr}, err
+	setOwner(attr, dir, c)
+	rname, rattr, err := s.fs.Create(ctx, fsid.Bytes(), parent, formic.GetID(fsid.Bytes(), inode, 0), inode, r.Name, attr, true)
+	if err == nil {
+		s.changed(ctx, fsid, &pb.Invalidation{Parent: r.Parent, Name: r.Name})
+	}
+	return &pb.MkDirResponse{Name: rname, Attr: rattr}, apiError(err)
+}
+
+// Open checks that the caller may open the inode as flags ask. As with the
+// kernel, reads and writes through an open file need no permissions of their
+// own, so a file that was created read only, or whose mode has changed since
+// it was opened, can still be written.
+func (s *apiServer) Open(ctx context.Context, r *pb.OpenRequest) (*pb.OpenResponse, error) {
+	err := s.validateIP(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	fsid, err := GetFsId(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	_, err = s.access(ctx, GetCreds(ctx), formic.GetID(fsid.Bytes(), r.Inode, 0), openMask(r.Flags))
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return &pb.OpenResponse{}, nil
 }
 
 func (s *apiServer) Read(ctx context.Context, r *pb.ReadRequest) (*pb.ReadResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	n, err := s.accessHandle(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0), MayRead)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	bs, err := s.blockSize(ctx, fsid, n)
+	if err != nil {
+		return nil, apiError(err)
 	}
 	log.Printf("READ: Inode: %d Offset: %d Size: %d", r.Inode, r.Offset, r.Size)
-	block := uint64(r.Offset / s.blocksize)
+	block := uint64(r.Offset / bs)
+	firstOffset := r.Offset % bs
+	count := int((firstOffset + r.Size + bs - 1) / bs)
+	chunks := make([][]byte, count)
+	errs := s.forBlocks(count, func(i int) error {
+		if !hasData(n, block+uint64(i)) {
+			return nil
+		}
+		var err error
+		chunks[i], err = s.fs.GetChunk(ctx, formic.GetID(fsid.Bytes(), r.Inode, block+uint64(i)+1)) // block 0 is for inode data
+		return err
+	})
 	data := make([]byte, r.Size)
-	firstOffset := int64(0)
-	if r.Offset%s.blocksize != 0 {
-		// Handle non-aligned offset
-		firstOffset = r.Offset - int64(block)*s.blocksize
-	}
 	cur := int64(0)
-	for cur < r.Size {
-		id := formic.GetID(fsid.Bytes(), r.Inode, block+1) // block 0 is for inode data
-		chunk, err := s.fs.GetChunk(ctx, id)
-		if err != nil {
-			log.Print("Err: Failed to read block: ", err)
-			// NOTE: This returns basically 0's to the client.for this block in this case
-			//       It is totally valid for a fs to request an invalid block
-			// TODO: Do we need to differentiate between real errors and bad requests?
-			return &pb.ReadResponse{}, nil
+	for i, chunk := range chunks {
+		if errs[i] != nil && errs[i] != ErrNotFound {
+			log.Printf("Err: Failed to read block %d: %s", block+uint64(i), errs[i])
+			if cur == 0 {
+				return nil, apiError(errs[i])
+			}
+			// Return what could be read before the failed block as a short read
+			break
 		}
-		if len(chunk) == 0 {
+		// It is totally valid for a fs to request a block past the end
+		chunk = fillHole(chunk, int64(block+uint64(i))*bs, bs, n.Attr.Size)
+		if int64(len(chunk)) <= firstOffset {
 			break
 		}
-		count := copy(data[cur:], chunk[firstOffset:])
+		cur += int64(copy(data[cur:], chunk[firstOffset:]))
 		firstOffset = 0
-		block += 1
-		cur += int64(count)
-		if int64(len(chunk)) < s.blocksize {
+		if int64(len(chunk)) < bs {
 			break
 		}
 	}
-	f := &pb.ReadResponse{Inode: r.Inode, Payload: data}
+	f := &pb.ReadResponse{Inode: r.Inode, Payload: data[:cur]}
 	return f, nil
 }
 
+// fillHole pads what was read of the block starting at start out to the end
+// of the block or of the file, whichever comes first. Parts of the file that
+// were never written, or were cut off by a truncate, read back as zeros.
+func fillHole(chunk []byte, start, blocksize int64, size uint64) []byte {
+	end := min(start+blocksize, int64(size))
+	if int64(len(chunk)) >= end-start {
+		return chunk
+	}
+	filled := make([]byte, end-start)
+	copy(filled, chunk)
+	return filled
+}
+
 func min(a, b int64) int64 {
 	if a < b {
 		return a
@@ -253,109 +603,216 @@ func min(a, b int64) int64 {
 func (s *apiServer) Write(ctx context.Context, r *pb.WriteRequest) (*pb.WriteResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
-	log.Printf("WRITE: Inode %d Offset: %d Size: %d", r.Inode, r.Offset, len(r.Payload))
-	block := uint64(r.Offset / s.blocksize)
-	firstOffset := int64(0)
-	if r.Offset%s.blocksize != 0 {
-		// Handle non-aligned offset
-		firstOffset = r.Offset - int64(block)*s.blocksize
+	n, err := s.accessHandle(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0), MayWrite)
+	if err != nil {
+		return nil, apiError(err)
 	}
-	cur := int64(0)
-	for cur < int64(len(r.Payload)) {
-		sendSize := min(s.blocksize, int64(len(r.Payload))-cur)
-		if sendSize+firstOffset > s.blocksize {
-			sendSize = s.blocksize - firstOffset
+	bs, err := s.blockSize(ctx, fsid, n)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	log.Printf("WRITE: Inode %d Offset: %d Size: %d", r.Inode, r.Offset, len(r.Payload))
+	block := uint64(r.Offset / bs)
+	firstOffset := r.Offset % bs
+	count := int((firstOffset + int64(len(r.Payload)) + bs - 1) / bs)
+	errs := s.forBlocks(count, func(i int) error {
+		// Only the first block can start part way in
+		start := int64(0)
+		first := firstOffset
+		if i > 0 {
+			start = int64(i)*bs - firstOffset
+			first = 0
 		}
-		payload := r.Payload[cur : cur+sendSize]
-		id := formic.GetID(fsid.Bytes(), r.Inode, block+1) // 0 block is for inode data
-		if firstOffset > 0 || sendSize < s.blocksize {
-			// need to get the block and update
-			chunk := make([]byte, firstOffset+int64(len(payload)))
-			data, err := s.fs.GetChunk(ctx, id)
-			if firstOffset > 0 && err != nil {
-				// TODO: How do we differentiate a block that hasn't been created yet, and a block that is truely missing?
-				log.Printf("WARN: couldn't get block id %d", id)
-			} else {
-				if len(data) > len(chunk) {
-					chunk = data
-				} else {
-					copy(chunk, data)
-				}
+		end := min(int64(i+1)*bs-firstOffset, int64(len(r.Payload)))
+		return s.writeBlock(ctx, fsid.Bytes(), r.Inode, bs, block+uint64(i), first, r.Payload[start:end])
+	})
+	// Even a failed write may have changed some of the blocks
+	s.changed(ctx, fsid, &pb.Invalidation{Inode: r.Inode, Data: true})
+	var failed []uint64
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, block+uint64(i))
+		}
+	}
+	if len(failed) > 0 {
+		// The other blocks were still stored, so the file may now be partially
+		// written.
+		log.Printf("Err: Failed to write %d of %d blocks of inode %d: %v", len(failed), count, r.Inode, failed)
+		for _, err := range errs {
+			if err != nil {
+				return &pb.WriteResponse{Status: 1}, apiError(err)
 			}
-			copy(chunk[firstOffset:], payload)
-			payload = chunk
-			firstOffset = 0
 		}
-		err := s.fs.WriteChunk(ctx, id, payload)
-		// TODO: Need better error handling for failing with multiple chunks
+	}
+	return &pb.WriteResponse{Status: 0}, nil
+}
+
+// forBlocks calls fn for each of count blocks, with up to blockConcurrency of
+// the calls running at once, and returns the error from each call in block
+// order.
+func (s *apiServer) forBlocks(count int, fn func(i int) error) []error {
+	errs := make([]error, count)
+	if count == 1 {
+		errs[0] = fn(0)
+		return errs
+	}
+	sem := make(chan struct{}, s.blockConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// writeBlock stores payload at firstOffset in the block of a file with the
+// given block size, and waits for the inode's size to be updated.
+func (s *apiServer) writeBlock(ctx context.Context, fsid []byte, inode uint64, blocksize int64, block uint64, firstOffset int64, payload []byte) error {
+	size, err := s.storeBlock(ctx, fsid, inode, blocksize, block, firstOffset, payload)
+	if err != nil {
+		return err
+	}
+	return s.updates.update(ctx, blockUpdate(fsid, inode, blocksize, block, size))
+}
+
+// storeBlock stores payload at firstOffset in the block of a file with the
+// given block size, merging it with what is already there if it doesn't cover
+// the whole block, and returns how long the block now is. The inode isn't
+// updated.
+func (s *apiServer) storeBlock(ctx context.Context, fsid []byte, inode uint64, blocksize int64, block uint64, firstOffset int64, payload []byte) (int, error) {
+	id := formic.GetID(fsid, inode, block+1) // 0 block is for inode data
+	if firstOffset > 0 || int64(len(payload)) < blocksize {
+		// need to get the block and update
+		chunk := make([]byte, firstOffset+int64(len(payload)))
+		n, err := s.fs.GetInode(ctx, formic.GetID(fsid, inode, 0))
 		if err != nil {
-			return &pb.WriteResponse{Status: 1}, err
+			return 0, err
 		}
-		s.updateChan <- &UpdateItem{
-			id:        formic.GetID(fsid.Bytes(), r.Inode, 0),
-			block:     block,
-			blocksize: uint64(s.blocksize),
-			size:      uint64(len(payload)),
-			mtime:     time.Now().Unix(),
+		var data []byte
+		if hasData(n, block) {
+			data, err = s.fs.GetChunk(ctx, id)
 		}
-		cur += sendSize
-		block += 1
+		if firstOffset > 0 && err != nil {
+			// TODO: How do we differentiate a block that hasn't been created yet, and a block that is truely missing?
+			log.Printf("WARN: couldn't get block id %d", id)
+		} else {
+			if len(data) > len(chunk) {
+				chunk = data
+			} else {
+				copy(chunk, data)
+			}
+		}
+		copy(chunk[firstOffset:], payload)
+		payload = chunk
+	}
+	err := s.fs.WriteChunk(ctx, id, payload)
+	if err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// blockUpdate returns the update for a block of a file that is now size long.
+func blockUpdate(fsid []byte, inode uint64, blocksize int64, block uint64, size int) *UpdateItem {
+	return &UpdateItem{
+		fsid:      fsid,
+		inode:     inode,
+		blocks:    []uint64{block},
+		blocksize: uint64(blocksize),
+		size:      uint64(blocksize)*block + uint64(size),
+		mtime:     time.Now().Unix(),
 	}
-	return &pb.WriteResponse{Status: 0}, nil
 }
 
 func (s *apiServer) Lookup(ctx context.Context, r *pb.LookupRequest) (*pb.LookupResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
-	name, attr, err := s.fs.Lookup(ctx, formic.GetID(fsid.Bytes(), r.Parent, 0), r.Name)
-	return &pb.LookupResponse{Name: name, Attr: attr}, err
+	parent := formic.GetID(fsid.Bytes(), r.Parent, 0)
+	_, err = s.access(ctx, GetCreds(ctx), parent, MayExec)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	name, attr, err := s.fs.Lookup(ctx, parent, r.Name)
+	return &pb.LookupResponse{Name: name, Attr: attr}, apiError(err)
 }
 
 func (s *apiServer) ReadDirAll(ctx context.Context, n *pb.ReadDirAllRequest) (*pb.ReadDirAllResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
-	return s.fs.ReadDirAll(ctx, formic.GetID(fsid.Bytes(), n.Inode, 0))
+	id := formic.GetID(fsid.Bytes(), n.Inode, 0)
+	_, err = s.access(ctx, GetCreds(ctx), id, MayRead)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	resp, err := s.fs.ReadDirAll(ctx, id)
+	return resp, apiError(err)
 }
 
 func (s *apiServer) Remove(ctx context.Context, r *pb.RemoveRequest) (*pb.RemoveResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
-	status, err := s.fs.Remove(ctx, formic.GetID(fsid.Bytes(), r.Parent, 0), r.Name)
-	return &pb.RemoveResponse{Status: status}, err
+	c := GetCreds(ctx)
+	parent := formic.GetID(fsid.Bytes(), r.Parent, 0)
+	err = s.canUnlink(ctx, c, parent, r.Name)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	status, err := s.fs.Remove(ctx, fsid.Bytes(), parent, r.Name, r.Dir)
+	if err == nil {
+		s.changed(ctx, fsid, &pb.Invalidation{Parent: r.Parent, Name: r.Name})
+	}
+	return &pb.RemoveResponse{Status: status}, apiError(err)
 }
 
 func (s *apiServer) Symlink(ctx context.Context, r *pb.SymlinkRequest) (*pb.SymlinkResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	if len(r.Name) > MaxNameLen {
+		return nil, apiError(ErrNameTooLong)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	c := GetCreds(ctx)
+	parent := formic.GetID(fsid.Bytes(), r.Parent, 0)
+	dir, err := s.access(ctx, c, parent, MayWrite|MayExec)
+	if err != nil {
+		return nil, apiError(err)
 	}
 	ts := time.Now().Unix()
 	inode := s.fl.GetID()
@@ -367,88 +824,174 @@ func (s *apiServer) Symlink(ctx context.Context, r *pb.SymlinkRequest) (*pb.Syml
 		Crtime: ts,
 		Mode:   uint32(os.ModeSymlink | 0755),
 		Size:   uint64(len(r.Target)),
-		Uid:    r.Uid,
-		Gid:    r.Gid,
+		Nlink:  1,
 	}
-	return s.fs.Symlink(ctx, formic.GetID(fsid.Bytes(), r.Parent, 0), formic.GetID(fsid.Bytes(), inode, 0), r.Name, r.Target, attr, inode)
+	setOwner(attr, dir, c)
+	resp, err := s.fs.Symlink(ctx, fsid.Bytes(), parent, formic.GetID(fsid.Bytes(), inode, 0), r.Name, r.Target, attr, inode)
+	if err == nil {
+		s.changed(ctx, fsid, &pb.Invalidation{Parent: r.Parent, Name: r.Name})
+	}
+	return resp, apiError(err)
 }
 
 func (s *apiServer) Readlink(ctx context.Context, r *pb.ReadlinkRequest) (*pb.ReadlinkResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	id := formic.GetID(fsid.Bytes(), r.Inode, 0)
+	_, err = s.access(ctx, GetCreds(ctx), id, MayRead)
+	if err != nil {
+		return nil, apiError(err)
 	}
-	return s.fs.Readlink(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0))
+	resp, err := s.fs.Readlink(ctx, id)
+	return resp, apiError(err)
 }
 
 func (s *apiServer) Getxattr(ctx context.Context, r *pb.GetxattrRequest) (*pb.GetxattrResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	id := formic.GetID(fsid.Bytes(), r.Inode, 0)
+	err = s.accessXattr(ctx, id, r.Name, MayRead)
+	if err != nil {
+		return nil, apiError(err)
 	}
-	return s.fs.Getxattr(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0), r.Name)
+	resp, err := s.fs.Getxattr(ctx, id, r.Name)
+	return resp, apiError(err)
 }
 
 func (s *apiServer) Setxattr(ctx context.Context, r *pb.SetxattrRequest) (*pb.SetxattrResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	id := formic.GetID(fsid.Bytes(), r.Inode, 0)
+	err = s.accessXattr(ctx, id, r.Name, MayWrite)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	resp, err := s.fs.Setxattr(ctx, id, r.Name, r.Value)
+	if err == nil {
+		s.changed(ctx, fsid, &pb.Invalidation{Inode: r.Inode})
 	}
-	return s.fs.Setxattr(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0), r.Name, r.Value)
+	return resp, apiError(err)
 }
 
 func (s *apiServer) Listxattr(ctx context.Context, r *pb.ListxattrRequest) (*pb.ListxattrResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
-	return s.fs.Listxattr(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0))
+	id := formic.GetID(fsid.Bytes(), r.Inode, 0)
+	n, err := s.fs.GetInode(ctx, id)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	resp, err := s.fs.Listxattr(ctx, id)
+	if err != nil {
+		return resp, apiError(err)
+	}
+	// Only the names that the caller could read are listed
+	c := GetCreds(ctx)
+	var names []byte
+	for _, name := range bytes.SplitAfter(resp.Xattr, []byte{0}) {
+		if len(name) > 1 && checkXattr(c, n, string(name[:len(name)-1]), MayRead) == nil {
+			names = append(names, name...)
+		}
+	}
+	resp.Xattr = names
+	return resp, nil
 }
 
 func (s *apiServer) Removexattr(ctx context.Context, r *pb.RemovexattrRequest) (*pb.RemovexattrResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	id := formic.GetID(fsid.Bytes(), r.Inode, 0)
+	err = s.accessXattr(ctx, id, r.Name, MayWrite)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	resp, err := s.fs.Removexattr(ctx, id, r.Name)
+	if err == nil {
+		s.changed(ctx, fsid, &pb.Invalidation{Inode: r.Inode})
 	}
-	return s.fs.Removexattr(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0), r.Name)
+	return resp, apiError(err)
 }
 
 func (s *apiServer) Rename(ctx context.Context, r *pb.RenameRequest) (*pb.RenameResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	if len(r.NewName) > MaxNameLen {
+		return nil, apiError(ErrNameTooLong)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	c := GetCreds(ctx)
+	oldParent := formic.GetID(fsid.Bytes(), r.OldParent, 0)
+	newParent := formic.GetID(fsid.Bytes(), r.NewParent, 0)
+	err = s.canUnlink(ctx, c, oldParent, r.OldName)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	err = s.canUnlink(ctx, c, newParent, r.NewName)
+	if err != nil && err != ErrNotFound {
+		return nil, apiError(err)
+	}
+	if r.OldParent != r.NewParent {
+		// Moving a directory changes its parent, which needs write
+		// permission on the directory itself
+		_, attr, err := s.fs.Lookup(ctx, oldParent, r.OldName)
+		if err != nil {
+			return nil, apiError(err)
+		}
+		if os.FileMode(attr.Mode).IsDir() {
+			_, err = s.access(ctx, c, formic.GetID(fsid.Bytes(), attr.Inode, 0), MayWrite)
+			if err != nil {
+				return nil, apiError(err)
+			}
+		}
+	}
+	resp, err := s.fs.Rename(ctx, fsid.Bytes(), r.OldParent, r.NewParent, r.OldName, r.NewName, r.Flags)
+	if err == nil {
+		s.changed(ctx, fsid,
+			&pb.Invalidation{Parent: r.OldParent, Name: r.OldName},
+			&pb.Invalidation{Parent: r.NewParent, Name: r.NewName})
 	}
-	return s.fs.Rename(ctx, formic.GetID(fsid.Bytes(), r.OldParent, 0), formic.GetID(fsid.Bytes(), r.NewParent, 0), r.OldName, r.NewName)
+	return resp, apiError(err)
 }
 
 func (s *apiServer) Statfs(ctx context.Context, r *pb.StatfsRequest) (*pb.StatfsResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	resp := &pb.StatfsResponse{
 		Blocks:  281474976710656, // 1 exabyte (asuming 4K block size)
@@ -457,7 +1000,7 @@ func (s *apiServer) Statfs(ctx context.Context, r *pb.StatfsRequest) (*pb.Statfs
 		Files:   1000000000000, // 1 trillion inodes
 		Ffree:   1000000000000,
 		Bsize:   4096, // it looked like ext4 used 4KB blocks
-		Namelen: 256,
+		Namelen: MaxNameLen,
 		Frsize:  4096, // this should probably match Bsize so we don't allow fragmented blocks
 	}
 	return resp, nil
@@ -466,11 +1009,36 @@ func (s *apiServer) Statfs(ctx context.Context, r *pb.StatfsRequest) (*pb.Statfs
 func (s *apiServer) InitFs(ctx context.Context, r *pb.InitFsRequest) (*pb.InitFsResponse, error) {
 	err := s.validateIP(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
 	}
 	fsid, err := GetFsId(ctx)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err)
+	}
+	return &pb.InitFsResponse{}, apiError(s.fs.InitFs(ctx, fsid.Bytes()))
+}
+
+func (s *apiServer) Link(ctx context.Context, r *pb.LinkRequest) (*pb.LinkResponse, error) {
+	err := s.validateIP(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if len(r.Name) > MaxNameLen {
+		return nil, apiError(ErrNameTooLong)
+	}
+	fsid, err := GetFsId(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	_, err = s.access(ctx, GetCreds(ctx), formic.GetID(fsid.Bytes(), r.Parent, 0), MayWrite|MayExec)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	resp, err := s.fs.Link(ctx, formic.GetID(fsid.Bytes(), r.Parent, 0), formic.GetID(fsid.Bytes(), r.Inode, 0), r.Name)
+	if err == nil {
+		s.changed(ctx, fsid,
+			&pb.Invalidation{Parent: r.Parent, Name: r.Name},
+			&pb.Invalidation{Inode: r.Inode})
 	}
-	return &pb.InitFsResponse{}, s.fs.InitFs(ctx, fsid.Bytes())
+	return resp, apiError(err)
 }
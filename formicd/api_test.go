@@ -2,10 +2,17 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
+	"os"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 
@@ -19,6 +26,7 @@ import (
 
 // Minimal FileService for testing
 type TestFS struct {
+	sync.Mutex
 	writes [][]byte
 	reads  [][]byte
 }
@@ -39,10 +47,17 @@ func (fs *TestFS) GetDirent(ctx context.Context, parent []byte, name string) (*p
 }
 
 func (fs *TestFS) GetInode(ctx context.Context, id []byte) (*pb.InodeEntry, error) {
-	return nil, nil
+	// An inode from before extents were tracked, so every block has data
+	return &pb.InodeEntry{IsDir: true, Attr: &pb.Attr{Mode: uint32(os.ModeDir | 0777)}, Blocks: math.MaxUint32}, nil
+}
+
+func (fs *TestFS) UpdateInode(ctx context.Context, id []byte, fn func(n *pb.InodeEntry) error) (*pb.InodeEntry, error) {
+	return &pb.InodeEntry{}, nil
 }
 
 func (fs *TestFS) GetChunk(ctx context.Context, id []byte) ([]byte, error) {
+	fs.Lock()
+	defer fs.Unlock()
 	if len(fs.reads) > 0 {
 		chunk := fs.reads[0]
 		fs.reads = fs.reads[1:]
@@ -53,6 +68,8 @@ func (fs *TestFS) GetChunk(ctx context.Context, id []byte) ([]byte, error) {
 }
 
 func (fs *TestFS) WriteChunk(ctx context.Context, id, data []byte) error {
+	fs.Lock()
+	defer fs.Unlock()
 	fs.writes = append(fs.writes, data)
 	return nil
 }
@@ -74,14 +91,14 @@ func (fs *TestFS) addread(d []byte) {
 }
 
 func (ds *TestFS) GetAttr(ctx context.Context, id []byte) (*pb.Attr, error) {
-	return &pb.Attr{}, nil
+	return &pb.Attr{Mode: uint32(os.ModeDir | 0777)}, nil
 }
 
-func (ds *TestFS) SetAttr(ctx context.Context, id []byte, attr *pb.Attr, valid uint32) (*pb.Attr, error) {
+func (ds *TestFS) SetAttr(ctx context.Context, fsid, id []byte, attr *pb.Attr, valid uint32) (*pb.Attr, error) {
 	return &pb.Attr{}, nil
 }
 
-func (ds *TestFS) Create(ctx context.Context, parent, id []byte, inode uint64, name string, attr *pb.Attr, isdir bool) (string, *pb.Attr, error) {
+func (ds *TestFS) Create(ctx context.Context, fsid, parent, id []byte, inode uint64, name string, attr *pb.Attr, isdir bool) (string, *pb.Attr, error) {
 	return name, attr, nil
 }
 
@@ -93,15 +110,23 @@ func (ds *TestFS) ReadDirAll(ctx context.Context, id []byte) (*pb.ReadDirAllResp
 	return &pb.ReadDirAllResponse{}, nil
 }
 
-func (ds *TestFS) Remove(ctx context.Context, parent []byte, name string) (int32, error) {
+func (ds *TestFS) ReadDir(ctx context.Context, id []byte, cookie, cookieB uint64, fn func(*pb.DirEnt) error) error {
+	return nil
+}
+
+func (ds *TestFS) Remove(ctx context.Context, fsid, parent []byte, name string, isdir bool) (int32, error) {
 	return 1, nil
 }
 
-func (ds *TestFS) Update(ctx context.Context, id []byte, block, blocksize, size uint64, mtime int64) error {
+func (ds *TestFS) Unlink(ctx context.Context, fsid, parent []byte, name string) error {
 	return nil
 }
 
-func (ds *TestFS) Symlink(ctx context.Context, parent, id []byte, name string, target string, attr *pb.Attr, inode uint64) (*pb.SymlinkResponse, error) {
+func (ds *TestFS) Update(ctx context.Context, id []byte, blocks []uint64, blocksize, size uint64, mtime int64) error {
+	return nil
+}
+
+func (ds *TestFS) Symlink(ctx context.Context, fsid, parent, id []byte, name string, target string, attr *pb.Attr, inode uint64) (*pb.SymlinkResponse, error) {
 	return &pb.SymlinkResponse{}, nil
 }
 
@@ -125,10 +150,18 @@ func (ds *TestFS) Removexattr(ctx context.Context, id []byte, name string) (*pb.
 	return &pb.RemovexattrResponse{}, nil
 }
 
-func (ds *TestFS) Rename(ctx context.Context, oldParent, newParent []byte, oldName, newName string) (*pb.RenameResponse, error) {
+func (ds *TestFS) Rename(ctx context.Context, fsid []byte, oldParent, newParent uint64, oldName, newName string, flags uint32) (*pb.RenameResponse, error) {
 	return &pb.RenameResponse{}, nil
 }
 
+func (ds *TestFS) Link(ctx context.Context, parent, id []byte, name string) (*pb.LinkResponse, error) {
+	return &pb.LinkResponse{}, nil
+}
+
+func (ds *TestFS) Fallocate(ctx context.Context, fsid, id []byte, mode uint32, offset, length uint64) (*pb.Attr, error) {
+	return &pb.Attr{}, nil
+}
+
 type fakePeerAddr struct {
 }
 
@@ -145,7 +178,7 @@ func getContext() context.Context {
 	c, _ := context.WithTimeout(context.Background(), 5*time.Second)
 	c = metadata.NewContext(
 		c,
-		metadata.Pairs("fsid", fsid.String()),
+		metadata.Pairs("fsid", fsid.String(), "uid", "0", "gid", "0"),
 	)
 	p := &peer.Peer{
 		Addr: fakePeerAddr{},
@@ -215,6 +248,8 @@ func TestWrite_Chunk(t *testing.T) {
 	fs := NewTestFS()
 	api := NewApiServer(fs, 1, nil)
 	api.blocksize = 5
+	// TestFS hands out the blocks in the order they are asked for
+	api.blockConcurrency = 1
 	chunk := pb.WriteRequest{
 		Inode:   0,
 		Offset:  0,
@@ -333,6 +368,8 @@ func TestRead_Chunk(t *testing.T) {
 	fs := NewTestFS()
 	api := NewApiServer(fs, 1, nil)
 	api.blocksize = 10
+	// TestFS hands out the blocks in the order they are asked for
+	api.blockConcurrency = 1
 	write1 := []byte("0123456789")
 	write2 := []byte("9876543210")
 	fs.addread(write1)
@@ -358,3 +395,38 @@ func TestProtoWriteSize(t *testing.T) {
 	b, _ := proto.Marshal(block)
 	fmt.Printf("Storing 64K and checksum in protobufs takes %d bytes.", len(b))
 }
+
+// trailerStream keeps the trailer set on it
+type trailerStream struct {
+	grpc.ServerStream
+	trailer metadata.MD
+}
+
+func (s *trailerStream) SetTrailer(md metadata.MD) {
+	s.trailer = md
+}
+
+func TestApiError_Trailer(t *testing.T) {
+	ss := &trailerStream{}
+	err := formic.StreamServerInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		return apiError(ErrNotEmpty)
+	})
+	if grpc.Code(err) != codes.FailedPrecondition {
+		t.Fatal("Expected FailedPrecondition, got: ", err)
+	}
+	// The errno is only taken from the trailer, never the description
+	if formic.Errno(err) != syscall.EIO {
+		t.Error("Expected EIO without the trailer, got: ", formic.Errno(err))
+	}
+	if errno := formic.Errno(formic.TrailerError(err, ss.trailer)); errno != syscall.ENOTEMPTY {
+		t.Error("Expected ENOTEMPTY, got: ", errno)
+	}
+	// Errors that aren't from the Api service are passed on as they are
+	other := errors.New("other")
+	err = formic.StreamServerInterceptor(nil, &trailerStream{}, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		return other
+	})
+	if err != other {
+		t.Error("Expected the error to be passed on, got: ", err)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
 type config struct {
-	path string
-	port int
+	path    string
+	port    int
+	backend string
 	//	fsPort                     int
 	oortValueSyndicate         string
 	oortGroupSyndicate         string
@@ -18,6 +20,7 @@ type config struct {
 	metricsAddr                string
 	metricsCollectors          string
 	concurrentRequestsPerStore int
+	scrubInterval              time.Duration
 	debug                      bool
 }
 
@@ -40,6 +43,12 @@ func resolveConfig(c *config) *config {
 	if cfg.port == 0 {
 		cfg.port = 8445
 	}
+	if env := os.Getenv("FORMICD_BACKEND"); env != "" {
+		cfg.backend = env
+	}
+	if cfg.backend == "" {
+		cfg.backend = "oort"
+	}
 	if env := os.Getenv("FORMICD_OORT_VALUE_SYNDICATE"); env != "" {
 		log.Println("Value: ", env)
 		cfg.oortValueSyndicate = env
@@ -73,6 +82,12 @@ func resolveConfig(c *config) *config {
 			cfg.concurrentRequestsPerStore = val
 		}
 	}
+	cfg.scrubInterval = 24 * time.Hour
+	if env := os.Getenv("FORMICD_SCRUB_INTERVAL"); env != "" {
+		if val, err := time.ParseDuration(env); err == nil {
+			cfg.scrubInterval = val
+		}
+	}
 	if env := os.Getenv("FORMICD_DEBUG"); env == "true" {
 		cfg.debug = true
 	}
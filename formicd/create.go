@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+	"github.com/gholt/store"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+const CreateJournalVersion = 1
+
+// Creates that are in progress are recorded in this group of their file
+// system so that any that were interrupted can be finished when formicd starts
+// back up.
+func createJournalKey(fsid []byte) []byte {
+	return []byte(fmt.Sprintf("/fs/%s/creates", uuid.FromBytesOrNil(fsid)))
+}
+
+func createJournalChild(j *pb.CreateJournal) []byte {
+	return []byte(fmt.Sprintf("%d/%d", j.Inode.Inode, j.Tsm))
+}
+
+// createEntry adds the new inode n to parent as d. The create is journaled
+// first and both are written with the journal's timestamp, so a create that
+// was interrupted can simply be applied again. The inode is written before the
+// entry so that the entry never points at nothing.
+func (o *OortFS) createEntry(ctx context.Context, fsid, parent []byte, n *pb.InodeEntry, d *pb.DirEntry) error {
+	j := &pb.CreateJournal{
+		Version: CreateJournalVersion,
+		FsId:    fsid,
+		Parent:  parent,
+		Tsm:     brimtime.TimeToUnixMicro(time.Now()),
+		Inode:   n,
+		Dirent:  d,
+	}
+	b, err := formic.Marshal(j)
+	if err != nil {
+		return err
+	}
+	err = o.comms.WriteGroupTS(ctx, createJournalKey(fsid), createJournalChild(j), b, j.Tsm)
+	if err != nil {
+		return err
+	}
+	// Record the inode before anything points at it, so that fsck can find
+	// it if the create doesn't finish
+	err = o.addInodeRecord(ctx, fsid, n.Inode)
+	if err != nil {
+		return err
+	}
+	err = o.applyCreate(ctx, j)
+	if err == ErrExists {
+		// Another create got the name first. Nothing can have seen the inode,
+		// so it goes too.
+		err = o.undoCreate(ctx, j)
+		if err != nil {
+			return err
+		}
+		err = ErrExists
+	}
+	if err != nil && err != ErrExists {
+		// The journal entry is left in place so the create will be finished
+		// when formicd restarts
+		return err
+	}
+	derr := o.comms.DeleteGroupItemTS(ctx, createJournalKey(j.FsId), createJournalChild(j), j.Tsm+1)
+	if derr != nil && derr != ErrStoreHasNewerValue {
+		log.Println("Couldn't remove create journal entry: ", derr)
+	}
+	return err
+}
+
+// applyCreate writes the inode and then claims the name recorded in the
+// journal entry.
+func (o *OortFS) applyCreate(ctx context.Context, j *pb.CreateJournal) error {
+	b, err := formic.Marshal(j.Inode)
+	if err != nil {
+		return err
+	}
+	err = o.writeChunkTS(ctx, j.Dirent.Id, b, j.Tsm)
+	if err != nil && err != ErrStoreHasNewerValue {
+		return err
+	}
+	return o.claimName(ctx, j.Parent, j.Dirent)
+}
+
+// How far in the future a tombstone can be before claimName warns about it.
+// A claim has to be written after the tombstone, so it goes into the future
+// along with it.
+const maxClaimSkew = time.Minute
+
+// claimNow is the clock that claims are written with.
+var claimNow = time.Now
+
+// claimName adds d to parent if its name is free, and returns ErrExists if
+// the name is already taken, including by a concurrent create on another
+// formicd. The entry is written with the current time and read back, and
+// whatever the store kept decides which create got the name. Of two entries
+// written in the same microsecond the store keeps the first, and the one
+// that wrote it can't know about the other, so the other gives up rather
+// than writing again. A name that already has d is treated as claimed, so a
+// create can be applied again.
+func (o *OortFS) claimName(ctx context.Context, parent []byte, d *pb.DirEntry) error {
+	b, err := formic.Marshal(d)
+	if err != nil {
+		return err
+	}
+	for i := 0; i <= maxInodeRetries; i++ {
+		cur, ts, err := o.readClaim(ctx, parent, d.Name)
+		if err != nil {
+			return err
+		}
+		if cur != nil {
+			if !bytes.Equal(cur.Id, d.Id) {
+				return ErrExists
+			}
+			return nil
+		}
+		tsm := brimtime.TimeToUnixMicro(claimNow())
+		if tsm <= ts {
+			if ts-tsm > int64(maxClaimSkew/time.Microsecond) {
+				log.Printf("Claiming %s after a tombstone %s in the future", d.Name, time.Duration(ts-tsm)*time.Microsecond)
+			}
+			// Newer than the tombstone, whatever the clock says
+			tsm = ts + 1
+		}
+		err = o.comms.WriteGroupTS(ctx, parent, []byte(d.Name), b, tsm)
+		if err != nil && err != ErrStoreHasNewerValue {
+			return err
+		}
+		cur, _, err = o.readClaim(ctx, parent, d.Name)
+		if err != nil {
+			return err
+		}
+		if cur == nil {
+			// Removed already, so try again
+			continue
+		}
+		if bytes.Equal(cur.Id, d.Id) {
+			return nil
+		}
+		return ErrExists
+	}
+	return ErrConflict
+}
+
+// readClaim returns the live entry for name in parent, if there is one, and
+// the timestamp of what is stored for it.
+func (o *OortFS) readClaim(ctx context.Context, parent []byte, name string) (*pb.DirEntry, int64, error) {
+	b, ts, err := o.comms.ReadGroupItemTS(ctx, parent, []byte(name))
+	if store.IsNotFound(err) {
+		return nil, ts, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	d := &pb.DirEntry{}
+	err = formic.Unmarshal(b, d)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.Tombstone != nil {
+		return nil, ts, nil
+	}
+	return d, ts, nil
+}
+
+// RecoverCreates finishes any creates that were interrupted, for instance by
+// formicd dying between writing the inode and the directory entry. A create
+// whose directory has since been removed can't be finished, and is undone
+// instead. Applying a create is idempotent, so this is safe to run even when
+// another formicd is still working on one of the creates.
+func (o *OortFS) RecoverCreates(ctx context.Context) error {
+	fsids, err := listFSIDs(ctx, o.comms)
+	if err != nil {
+		return err
+	}
+	for _, fsid := range fsids {
+		if err = o.recoverCreates(ctx, fsid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverCreates finishes the interrupted creates of a file system.
+func (o *OortFS) recoverCreates(ctx context.Context, fsid []byte) error {
+	items, err := o.comms.ReadGroup(ctx, createJournalKey(fsid))
+	if err != nil && !store.IsNotFound(err) {
+		return err
+	}
+	for _, item := range items {
+		j := &pb.CreateJournal{}
+		err = formic.Unmarshal(item.Value, j)
+		if err != nil || j.Inode == nil || j.Dirent == nil {
+			log.Println("Skipping bad create journal entry: ", err)
+			continue
+		}
+		_, err = o.GetInode(ctx, j.Parent)
+		switch err {
+		case nil:
+			log.Printf("Finishing create of %s", j.Dirent.Name)
+			err = o.applyCreate(ctx, j)
+			if err == ErrExists {
+				// The create may have finished, and the file been renamed
+				// since, so this is left to fsck
+				log.Printf("Name %s of an unfinished create is taken, leaving inode %d", j.Dirent.Name, j.Inode.Inode)
+				err = nil
+			}
+		case ErrNotFound:
+			log.Printf("Undoing create of %s", j.Dirent.Name)
+			err = o.undoCreate(ctx, j)
+		}
+		if err != nil {
+			return err
+		}
+		err = o.comms.DeleteGroupItemTS(ctx, createJournalKey(fsid), createJournalChild(j), j.Tsm+1)
+		if err != nil && err != ErrStoreHasNewerValue {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoCreate removes the inode of a create that can't be finished. An inode
+// that has been changed since it was created is left for fsck to sort out.
+func (o *OortFS) undoCreate(ctx context.Context, j *pb.CreateJournal) error {
+	err := o.DeleteChunk(ctx, j.Dirent.Id, j.Tsm+1)
+	if err == ErrStoreHasNewerValue {
+		log.Printf("Inode %d of an unfinished create has changed, leaving it", j.Inode.Inode)
+		return nil
+	}
+	if err != nil && !store.IsNotFound(err) {
+		return err
+	}
+	tsm := brimtime.TimeToUnixMicro(time.Now())
+	err = o.DeleteListing(ctx, inodeRecordsKey(j.FsId, j.Inode.Inode), inodeRecordName(j.Inode.Inode), tsm)
+	if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+		return err
+	}
+	return nil
+}
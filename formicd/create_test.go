@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+)
+
+func TestOortFS_RecoverCreates(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	root := formic.GetID(fsid.Bytes(), 1, 0)
+	m, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "dir", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	// Simulate formicd dying after writing the inode but before the entry,
+	// once in the root and once in a directory that is then removed
+	journal := func(parent []byte, inode uint64, name string) *pb.CreateJournal {
+		id := formic.GetID(fsid.Bytes(), inode, 0)
+		j := &pb.CreateJournal{
+			Version: CreateJournalVersion,
+			FsId:    fsid.Bytes(),
+			Parent:  parent,
+			Tsm:     brimtime.TimeToUnixMicro(time.Now()),
+			Inode:   &pb.InodeEntry{Version: InodeEntryVersion, Inode: inode, Attr: &pb.Attr{Inode: inode, Mode: 0644}},
+			Dirent:  &pb.DirEntry{Version: DirEntryVersion, Name: name, Id: id},
+		}
+		b, err := formic.Marshal(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = o.comms.WriteGroupTS(ctx, createJournalKey(fsid.Bytes()), createJournalChild(j), b, j.Tsm); err != nil {
+			t.Fatal(err)
+		}
+		b, _ = formic.Marshal(j.Inode)
+		if err = o.writeChunkTS(ctx, id, b, j.Tsm); err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+	journal(root, 1000, "finished")
+	undone := journal(formic.GetID(fsid.Bytes(), m.Attr.Inode, 0), 1001, "undone")
+	if _, err = api.Remove(ctx, &pb.RemoveRequest{Parent: 1, Name: "dir", Dir: true}); err != nil {
+		t.Fatal("Remove failed: ", err)
+	}
+	// Rather than waiting on the Deletinator
+	if err = api.comms.DeleteValue(ctx, formic.GetID(fsid.Bytes(), m.Attr.Inode, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = o.RecoverCreates(ctx); err != nil {
+		t.Fatal("RecoverCreates failed: ", err)
+	}
+	if i := lookupInode(t, api, ctx, 1, "finished"); i != 1000 {
+		t.Fatalf("Expected finished to be inode 1000, got %d", i)
+	}
+	if _, err = o.GetInode(ctx, undone.Dirent.Id); err != ErrNotFound {
+		t.Error("Expected the undone inode to be gone, got: ", err)
+	}
+	items, err := o.comms.ReadGroup(ctx, createJournalKey(fsid.Bytes()))
+	if err != nil || len(items) != 0 {
+		t.Fatalf("Expected the journal to be empty, got %d items: %v", len(items), err)
+	}
+}
+
+func TestOortFS_HideMissingInodes(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	fsid, _ := GetFsId(ctx)
+	createFile(t, api, ctx, 1, "here")
+	gone := createFile(t, api, ctx, 1, "gone")
+	if err := api.comms.DeleteValue(ctx, formic.GetID(fsid.Bytes(), gone.Inode, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if i := lookupInode(t, api, ctx, 1, "gone"); i != 0 {
+		t.Error("Expected gone to be hidden, got inode: ", i)
+	}
+	r, err := api.ReadDirAll(ctx, &pb.ReadDirAllRequest{Inode: 1})
+	if err != nil {
+		t.Fatal("ReadDirAll failed: ", err)
+	}
+	if len(r.DirEntries) != 1 || r.DirEntries[0].Name != "here" {
+		t.Errorf("Expected just here, got: %v", r.DirEntries)
+	}
+}
+
+func TestOortFS_ExclusiveCreate(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	// A second formicd on the same store
+	other := NewApiServer(NewOortFS(api.comms), 2, api.comms)
+	fsid, _ := GetFsId(ctx)
+	for round := 0; round < 20; round++ {
+		name := fmt.Sprintf("file%d", round)
+		var lock sync.Mutex
+		var won []uint64
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(s *apiServer) {
+				defer wg.Done()
+				c, err := s.Create(ctx, &pb.CreateRequest{Parent: 1, Name: name, Attr: &pb.Attr{Mode: 0644}})
+				if formic.Errno(err) == syscall.EEXIST {
+					return
+				}
+				if err != nil {
+					t.Error("Create failed: ", err)
+					return
+				}
+				lock.Lock()
+				won = append(won, c.Attr.Inode)
+				lock.Unlock()
+			}([]*apiServer{api, other}[i%2])
+		}
+		wg.Wait()
+		if len(won) != 1 {
+			t.Fatalf("Expected exactly one create of %s to succeed, got %v", name, won)
+		}
+		if i := lookupInode(t, api, ctx, 1, name); i != won[0] {
+			t.Fatalf("Expected %s to be inode %d, got %d", name, won[0], i)
+		}
+	}
+	// The losers cleaned up after themselves
+	items, err := api.comms.ReadGroup(ctx, createJournalKey(fsid.Bytes()))
+	if err != nil || len(items) != 0 {
+		t.Fatalf("Expected the journal to be empty, got %d items: %v", len(items), err)
+	}
+	r, err := api.fs.(*OortFS).Fsck(ctx, fsid.Bytes(), false, 0, api.fl)
+	if err != nil {
+		t.Fatal("Fsck failed: ", err)
+	}
+	if len(r.Problems) != 0 {
+		t.Errorf("Unexpected problems: %v", r.Problems)
+	}
+}
+
+func TestOortFS_ExclusiveLink(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	a := createFile(t, api, ctx, 1, "a")
+	b := createFile(t, api, ctx, 1, "b")
+	// Linking two files to the same name at once
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, inode := range []uint64{a.Inode, b.Inode} {
+		wg.Add(1)
+		go func(i int, inode uint64) {
+			defer wg.Done()
+			_, errs[i] = api.Link(ctx, &pb.LinkRequest{Parent: 1, Name: "c", Inode: inode})
+		}(i, inode)
+	}
+	wg.Wait()
+	c := lookupInode(t, api, ctx, 1, "c")
+	for i, inode := range []uint64{a.Inode, b.Inode} {
+		g, err := api.GetAttr(ctx, &pb.GetAttrRequest{Inode: inode})
+		if err != nil {
+			t.Fatal("GetAttr failed: ", err)
+		}
+		switch {
+		case inode == c && errs[i] == nil:
+			if g.Attr.Nlink != 2 {
+				t.Errorf("Expected the linked inode to have 2 links, got %d", g.Attr.Nlink)
+			}
+		case inode != c && formic.Errno(errs[i]) == syscall.EEXIST:
+			if Nlink(g.Attr) != 1 {
+				t.Errorf("Expected the other inode to have 1 link, got %d", g.Attr.Nlink)
+			}
+		default:
+			t.Errorf("Unexpected result linking inode %d as inode %d: %v", inode, c, errs[i])
+		}
+	}
+}
+
+func TestOortFS_CreateOverFutureTombstone(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	fsid, _ := GetFsId(ctx)
+	// A tombstone from a formicd whose clock is ahead
+	d := &pb.DirEntry{Version: DirEntryVersion, Name: "file", Tombstone: &pb.Tombstone{}}
+	b, err := formic.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := brimtime.TimeToUnixMicro(time.Now().Add(time.Hour))
+	if err = api.comms.WriteGroupTS(ctx, formic.GetID(fsid.Bytes(), 1, 0), []byte("file"), b, future); err != nil {
+		t.Fatal(err)
+	}
+	c := createFile(t, api, ctx, 1, "file")
+	if i := lookupInode(t, api, ctx, 1, "file"); i != c.Inode {
+		t.Fatalf("Expected file to be inode %d, got %d", c.Inode, i)
+	}
+}
+
+func TestOortFS_ClaimSameTime(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	root := formic.GetID(fsid.Bytes(), 1, 0)
+	// Both claims are written in the same microsecond, once both have found
+	// the name free
+	fixed := time.Now()
+	var both sync.WaitGroup
+	claimNow = func() time.Time {
+		both.Done()
+		both.Wait()
+		return fixed
+	}
+	defer func() { claimNow = time.Now }()
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("tie%d", i)
+		errs := make([]error, 2)
+		both.Add(len(errs))
+		var wg sync.WaitGroup
+		for j := range errs {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				inode := uint64(1000 + 2*i + j)
+				d := &pb.DirEntry{Version: DirEntryVersion, Name: name, Id: formic.GetID(fsid.Bytes(), inode, 0), Inode: inode}
+				errs[j] = o.claimName(ctx, root, d)
+			}(j)
+		}
+		wg.Wait()
+		won := 0
+		for _, err := range errs {
+			switch err {
+			case nil:
+				won++
+			case ErrExists:
+			default:
+				t.Fatal("Claim failed: ", err)
+			}
+		}
+		if won != 1 {
+			t.Fatalf("Expected exactly one claim of %s to succeed, got %v", name, errs)
+		}
+	}
+}
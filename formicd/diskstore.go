@@ -0,0 +1,736 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gholt/store"
+	"golang.org/x/net/context"
+)
+
+// Single node, durable implementations of store.ValueStore and
+// store.GroupStore, following the same timestamp rules as the in memory
+// stores. Every change that takes effect is appended to a log file, and only
+// an index of where the latest record for each key is in the log is kept in
+// memory; values are read back from the log. A change is acknowledged once the
+// log has been synced past it, and writers that are waiting at the same time
+// share a sync. The log is replayed on Startup to rebuild the index, and is
+// compacted in the background once most of it is records that have been
+// replaced, so that it only holds the latest record of each key (tombstones
+// included).
+//
+// Log record layout (big endian):
+//   crc32   uint32 // of everything after the length
+//   length  uint32 // of everything after this field
+//   op      uint8
+//   keys    uint64 * number of keys (2 for values, 4 for groups)
+//   tsm     int64
+//   value   []byte
+
+const (
+	diskOpWrite  = 1
+	diskOpDelete = 2
+)
+
+// Longest record replay believes, well past the largest block or inode that a
+// file system writes. Anything longer is taken to be a damaged length.
+const maxDiskRecordLength = 64 << 20
+
+// The log is compacted once at least this much of it, and more than half of
+// it, is records that have been replaced.
+var diskCompactGarbage int64 = 64 << 20
+
+var ErrDiskLogCorrupt = errors.New("Disk store log record is corrupt")
+
+var ErrDiskValueTooLong = errors.New("Value is too long for the disk store")
+
+var errDiskNotStarted = errors.New("Disk store not started")
+
+// diskKey is the key of an item; values only use the first two.
+type diskKey [4]uint64
+
+// diskItem is where the latest record for a key is in the log.
+type diskItem struct {
+	timestampMicro int64
+	deleted        bool
+	offset         int64 // Of the value
+	length         uint32
+}
+
+type diskLog struct {
+	path string
+	keys int
+	// syncLock is held while syncing, so that writers waiting on their
+	// records share the sync of whoever got there first, and while the file
+	// is swapped for a compacted one.
+	syncLock sync.Mutex
+	// The lock guards the rest. Appends and swapping the file also hold the
+	// store's write lock, so reads only need the store's read lock.
+	sync.Mutex
+	f      *os.File
+	size   int64 // Where the next record goes
+	synced int64 // How much of the file is known to be on disk
+}
+
+func newDiskLog(path string, keys int) *diskLog {
+	return &diskLog{
+		path: path,
+		keys: keys,
+	}
+}
+
+// headerLength is how far into a record its value starts.
+func (l *diskLog) headerLength() int64 {
+	return int64(8 + 1 + 8*l.keys + 8)
+}
+
+func (l *diskLog) recordLength(length uint32) int64 {
+	return l.headerLength() + int64(length)
+}
+
+func (l *diskLog) open() error {
+	if err := os.MkdirAll(path.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	return nil
+}
+
+// replay reads every record in the log calling fn for each with where its
+// value is. A torn record at the end of the log (from a crash in the middle of
+// an append) is cut off. Records that fail their checksum are skipped, and if
+// any were, or the log can't be read to the end, an error is returned so that
+// the log can be kept aside as is.
+func (l *diskLog) replay(fn func(op byte, keys []uint64, tsm int64, offset int64, length uint32)) error {
+	r := bufio.NewReader(io.NewSectionReader(l.f, 0, math.MaxInt64))
+	header := make([]byte, 8)
+	var err error
+	var pos int64
+	records := 0
+	skipped := 0
+	for {
+		if _, err = io.ReadFull(r, header); err != nil {
+			break
+		}
+		sum := binary.BigEndian.Uint32(header[0:4])
+		length := binary.BigEndian.Uint32(header[4:8])
+		if length < uint32(1+8*l.keys+8) || length > maxDiskRecordLength {
+			err = ErrDiskLogCorrupt
+			break
+		}
+		rec := make([]byte, length)
+		if _, err = io.ReadFull(r, rec); err != nil {
+			break
+		}
+		start := pos
+		pos += int64(8 + length)
+		if crc32.ChecksumIEEE(rec) != sum || (rec[0] != diskOpWrite && rec[0] != diskOpDelete) {
+			// The length held up, so the records that follow can still be
+			// found
+			skipped++
+			continue
+		}
+		keys := make([]uint64, l.keys)
+		for i := range keys {
+			keys[i] = binary.BigEndian.Uint64(rec[1+8*i:])
+		}
+		tsm := int64(binary.BigEndian.Uint64(rec[1+8*l.keys:]))
+		fn(rec[0], keys, tsm, start+l.headerLength(), length-uint32(1+8*l.keys+8))
+		records++
+	}
+	l.size = pos
+	l.synced = pos
+	if err == io.EOF && skipped == 0 {
+		return nil
+	}
+	if err == io.ErrUnexpectedEOF && skipped == 0 {
+		log.Printf("Discarding a torn record at the end of %s after %d records", l.path, records)
+		return l.f.Truncate(pos)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrDiskLogCorrupt
+	}
+	log.Printf("Read %d records from %s, skipping %d and then stopping with %v", records, l.path, skipped, err)
+	return err
+}
+
+func (l *diskLog) encode(op byte, keys []uint64, tsm int64, value []byte) []byte {
+	length := 1 + 8*len(keys) + 8 + len(value)
+	b := make([]byte, 8+length)
+	rec := b[8:]
+	rec[0] = op
+	for i, k := range keys {
+		binary.BigEndian.PutUint64(rec[1+8*i:], k)
+	}
+	binary.BigEndian.PutUint64(rec[1+8*len(keys):], uint64(tsm))
+	copy(rec[1+8*len(keys)+8:], value)
+	binary.BigEndian.PutUint32(b[0:4], crc32.ChecksumIEEE(rec))
+	binary.BigEndian.PutUint32(b[4:8], uint32(length))
+	return b
+}
+
+// read appends the value of item in f to value, checking the record it is in
+// hasn't been damaged since it was written.
+func (l *diskLog) read(f *os.File, item *diskItem, value []byte) ([]byte, error) {
+	b := make([]byte, l.recordLength(item.length))
+	if _, err := f.ReadAt(b, item.offset-l.headerLength()); err != nil {
+		return value, err
+	}
+	if crc32.ChecksumIEEE(b[8:]) != binary.BigEndian.Uint32(b[0:4]) {
+		log.Printf("Err: Record at %d in %s is corrupt", item.offset-l.headerLength(), l.path)
+		return value, ErrDiskLogCorrupt
+	}
+	return append(value, b[l.headerLength():]...), nil
+}
+
+// append adds a record to the log, returning where its value is and where the
+// log now ends. The store's write lock must be held.
+func (l *diskLog) append(op byte, keys []uint64, tsm int64, value []byte) (int64, int64, error) {
+	l.Lock()
+	defer l.Unlock()
+	if l.f == nil {
+		return 0, 0, errDiskNotStarted
+	}
+	if _, err := l.f.Write(l.encode(op, keys, tsm, value)); err != nil {
+		// Don't leave part of a record for the next one to follow
+		if terr := l.f.Truncate(l.size); terr != nil {
+			log.Printf("Err: Couldn't cut a partial record off %s: %s", l.path, terr)
+		}
+		return 0, 0, err
+	}
+	offset := l.size + l.headerLength()
+	l.size += l.recordLength(uint32(len(value)))
+	return offset, l.size, nil
+}
+
+// waitSynced returns once the log is on disk up to end.
+func (l *diskLog) waitSynced(end int64) error {
+	l.syncLock.Lock()
+	defer l.syncLock.Unlock()
+	l.Lock()
+	f, size, synced := l.f, l.size, l.synced
+	l.Unlock()
+	if synced >= end {
+		// Synced by whoever held the lock before
+		return nil
+	}
+	if f == nil {
+		return errDiskNotStarted
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	l.Lock()
+	if size > l.synced {
+		l.synced = size
+	}
+	l.Unlock()
+	return nil
+}
+
+// close syncs and closes the log. The sync lock and the store's write lock
+// must be held.
+func (l *diskLog) close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+func diskOp(deleted bool) byte {
+	if deleted {
+		return diskOpDelete
+	}
+	return diskOpWrite
+}
+
+// diskEntry is a key and a copy of its item, for compacting.
+type diskEntry struct {
+	key  diskKey
+	item diskItem
+}
+
+// diskEntries sorts entries into the order they are in the log.
+type diskEntries []diskEntry
+
+func (e diskEntries) Len() int           { return len(e) }
+func (e diskEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e diskEntries) Less(i, j int) bool { return e[i].item.offset < e[j].item.offset }
+
+// diskStore is what the disk value and group stores have in common: the log
+// and the index of it.
+type diskStore struct {
+	sync.RWMutex
+	log   *diskLog
+	items map[diskKey]*diskItem
+	live  int64 // How much of the log is records in the index
+	// added is told about keys that are new to the index
+	added func(k diskKey, item *diskItem)
+	// compactLock keeps to one compaction at a time
+	compactLock sync.Mutex
+	compacts    chan struct{}
+	done        chan struct{}
+}
+
+func newDiskStore(path string, keys int) diskStore {
+	return diskStore{
+		log:   newDiskLog(path, keys),
+		items: make(map[diskKey]*diskItem),
+	}
+}
+
+func (s *diskStore) startup() error {
+	if err := s.log.open(); err != nil {
+		return err
+	}
+	s.Lock()
+	err := s.log.replay(func(op byte, keys []uint64, tsm int64, offset int64, length uint32) {
+		var k diskKey
+		copy(k[:], keys)
+		s.index(k, tsm, op == diskOpDelete, offset, length)
+	})
+	s.Unlock()
+	if err != nil {
+		kept := fmt.Sprintf("%s.damaged-%d", s.log.path, time.Now().Unix())
+		log.Printf("Keeping %s as %s", s.log.path, kept)
+		if err = os.Rename(s.log.path, kept); err != nil {
+			return err
+		}
+		// What could be read is read from the kept log until it has been
+		// rewritten
+		if err = s.compact(); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	s.compacts = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	go s.compactor(s.compacts, s.done)
+	s.maybeCompact()
+	s.Unlock()
+	return nil
+}
+
+func (s *diskStore) shutdown() error {
+	s.Lock()
+	compacts := s.compacts
+	s.compacts = nil
+	s.Unlock()
+	if compacts != nil {
+		close(compacts)
+		<-s.done
+	}
+	s.log.syncLock.Lock()
+	defer s.log.syncLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	return s.log.close()
+}
+
+func (s *diskStore) flush() error {
+	s.RLock()
+	end := s.log.size
+	s.RUnlock()
+	return s.log.waitSynced(end)
+}
+
+func (s *diskStore) compactor(compacts chan struct{}, done chan struct{}) {
+	for range compacts {
+		if err := s.compact(); err != nil {
+			log.Printf("Err: Couldn't compact %s: %s", s.log.path, err)
+		}
+	}
+	close(done)
+}
+
+// maybeCompact has the log compacted if enough of it has been replaced. The
+// write lock must be held.
+func (s *diskStore) maybeCompact() {
+	garbage := s.log.size - s.live
+	if garbage < diskCompactGarbage || garbage <= s.live {
+		return
+	}
+	select {
+	case s.compacts <- struct{}{}:
+	default:
+	}
+}
+
+// index records where the latest record for k is, if it is newer than what
+// the index has. It returns the timestamp of what the index had. The write
+// lock must be held.
+func (s *diskStore) index(k diskKey, tsm int64, deleted bool, offset int64, length uint32) int64 {
+	item, ok := s.items[k]
+	if ok {
+		if item.timestampMicro >= tsm {
+			return item.timestampMicro
+		}
+		s.live -= s.log.recordLength(item.length)
+	} else {
+		item = &diskItem{}
+		s.items[k] = item
+		if s.added != nil {
+			s.added(k, item)
+		}
+	}
+	old := item.timestampMicro
+	*item = diskItem{timestampMicro: tsm, deleted: deleted, offset: offset, length: length}
+	s.live += s.log.recordLength(length)
+	return old
+}
+
+func (s *diskStore) lookup(k diskKey) (int64, uint32, error) {
+	s.RLock()
+	defer s.RUnlock()
+	item, ok := s.items[k]
+	if !ok {
+		return 0, 0, errMemNotFoundValue
+	}
+	if item.deleted {
+		return item.timestampMicro, 0, errMemNotFoundValue
+	}
+	return item.timestampMicro, item.length, nil
+}
+
+func (s *diskStore) read(k diskKey, value []byte) (int64, []byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	item, ok := s.items[k]
+	if !ok {
+		return 0, value, errMemNotFoundValue
+	}
+	if item.deleted {
+		return item.timestampMicro, value, errMemNotFoundValue
+	}
+	value, err := s.log.read(s.log.f, item, value)
+	if err != nil {
+		return 0, value, err
+	}
+	return item.timestampMicro, value, nil
+}
+
+func (s *diskStore) write(k diskKey, timestampMicro int64, value []byte, deleted bool) (int64, error) {
+	if s.log.recordLength(uint32(len(value)))-8 > maxDiskRecordLength {
+		return 0, ErrDiskValueTooLong
+	}
+	s.Lock()
+	if item, ok := s.items[k]; ok && item.timestampMicro >= timestampMicro {
+		s.Unlock()
+		return item.timestampMicro, nil
+	}
+	offset, end, err := s.log.append(diskOp(deleted), k[:s.log.keys], timestampMicro, value)
+	if err != nil {
+		s.Unlock()
+		return 0, err
+	}
+	old := s.index(k, timestampMicro, deleted, offset, uint32(len(value)))
+	s.maybeCompact()
+	s.Unlock()
+	// The change can be read before it is on disk, but isn't acknowledged
+	// until it is
+	if err = s.log.waitSynced(end); err != nil {
+		return 0, err
+	}
+	return old, nil
+}
+
+// compact rewrites the log with only the records in the index. Those are
+// copied without holding up the store, and only the records appended in the
+// meantime are copied with it locked, just before the logs are swapped.
+func (s *diskStore) compact() error {
+	s.compactLock.Lock()
+	defer s.compactLock.Unlock()
+	l := s.log
+	s.RLock()
+	entries := make(diskEntries, 0, len(s.items))
+	for k, item := range s.items {
+		entries = append(entries, diskEntry{key: k, item: *item})
+	}
+	old, cut := l.f, l.size
+	s.RUnlock()
+	if old == nil {
+		return errDiskNotStarted
+	}
+	// So that the old log is read from start to end
+	sort.Sort(entries)
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	w := bufio.NewWriter(f)
+	offsets := make(map[diskKey]int64, len(entries))
+	var size int64
+	var value []byte
+	for _, e := range entries {
+		value, err = l.read(old, &e.item, value[:0])
+		if err != nil {
+			return fail(err)
+		}
+		if _, err = w.Write(l.encode(diskOp(e.item.deleted), e.key[:l.keys], e.item.timestampMicro, value)); err != nil {
+			return fail(err)
+		}
+		offsets[e.key] = size + l.headerLength()
+		size += l.recordLength(e.item.length)
+	}
+	if err = w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err = f.Sync(); err != nil {
+		return fail(err)
+	}
+	l.syncLock.Lock()
+	defer l.syncLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	l.Lock()
+	defer l.Unlock()
+	if _, err = io.Copy(f, io.NewSectionReader(old, cut, l.size-cut)); err != nil {
+		return fail(err)
+	}
+	if err = f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err = os.Rename(tmp, l.path); err != nil {
+		return fail(err)
+	}
+	if err = syncDir(path.Dir(l.path)); err != nil {
+		log.Printf("Err: Couldn't sync the directory of %s: %s", l.path, err)
+	}
+	// Records that were appended since moved along with the rest of the tail
+	delta := size - cut
+	for k, item := range s.items {
+		if item.offset > cut {
+			item.offset += delta
+		} else {
+			item.offset = offsets[k]
+		}
+	}
+	old.Close()
+	log.Printf("Compacted %s from %d to %d bytes", l.path, l.size, l.size+delta)
+	l.f = f
+	l.size += delta
+	l.synced = l.size
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *diskStore) stats() *memStats {
+	s.RLock()
+	defer s.RUnlock()
+	st := &memStats{}
+	for _, item := range s.items {
+		if item.deleted {
+			st.tombstones++
+		} else {
+			st.values++
+		}
+	}
+	return st
+}
+
+type diskValueStore struct {
+	diskStore
+}
+
+func NewDiskValueStore(path string) *diskValueStore {
+	return &diskValueStore{diskStore: newDiskStore(path, 2)}
+}
+
+func (s *diskValueStore) Startup(ctx context.Context) error {
+	return s.startup()
+}
+
+func (s *diskValueStore) Shutdown(ctx context.Context) error {
+	return s.shutdown()
+}
+
+func (s *diskValueStore) EnableWrites(ctx context.Context) error {
+	return nil
+}
+
+func (s *diskValueStore) DisableWrites(ctx context.Context) error {
+	return nil
+}
+
+func (s *diskValueStore) Flush(ctx context.Context) error {
+	return s.flush()
+}
+
+func (s *diskValueStore) AuditPass(ctx context.Context) error {
+	return nil
+}
+
+func (s *diskValueStore) Stats(ctx context.Context, debug bool) (fmt.Stringer, error) {
+	return s.stats(), nil
+}
+
+func (s *diskValueStore) ValueCap(ctx context.Context) (uint32, error) {
+	return maxDiskRecordLength - uint32(s.log.headerLength()), nil
+}
+
+func (s *diskValueStore) Lookup(ctx context.Context, keyA, keyB uint64) (int64, uint32, error) {
+	return s.lookup(diskKey{keyA, keyB})
+}
+
+func (s *diskValueStore) Read(ctx context.Context, keyA, keyB uint64, value []byte) (int64, []byte, error) {
+	return s.read(diskKey{keyA, keyB}, value)
+}
+
+func (s *diskValueStore) Write(ctx context.Context, keyA, keyB uint64, timestampMicro int64, value []byte) (int64, error) {
+	return s.write(diskKey{keyA, keyB}, timestampMicro, value, false)
+}
+
+func (s *diskValueStore) Delete(ctx context.Context, keyA, keyB uint64, timestampMicro int64) (int64, error) {
+	return s.write(diskKey{keyA, keyB}, timestampMicro, nil, true)
+}
+
+type diskGroupStore struct {
+	diskStore
+	groups map[memKey]map[memKey]*diskItem
+}
+
+func NewDiskGroupStore(path string) *diskGroupStore {
+	s := &diskGroupStore{
+		diskStore: newDiskStore(path, 4),
+		groups:    make(map[memKey]map[memKey]*diskItem),
+	}
+	s.added = func(k diskKey, item *diskItem) {
+		p := memKey{k[0], k[1]}
+		g := s.groups[p]
+		if g == nil {
+			g = make(map[memKey]*diskItem)
+			s.groups[p] = g
+		}
+		g[memKey{k[2], k[3]}] = item
+	}
+	return s
+}
+
+func (s *diskGroupStore) Startup(ctx context.Context) error {
+	return s.startup()
+}
+
+func (s *diskGroupStore) Shutdown(ctx context.Context) error {
+	return s.shutdown()
+}
+
+func (s *diskGroupStore) EnableWrites(ctx context.Context) error {
+	return nil
+}
+
+func (s *diskGroupStore) DisableWrites(ctx context.Context) error {
+	return nil
+}
+
+func (s *diskGroupStore) Flush(ctx context.Context) error {
+	return s.flush()
+}
+
+func (s *diskGroupStore) AuditPass(ctx context.Context) error {
+	return nil
+}
+
+func (s *diskGroupStore) Stats(ctx context.Context, debug bool) (fmt.Stringer, error) {
+	return s.stats(), nil
+}
+
+func (s *diskGroupStore) ValueCap(ctx context.Context) (uint32, error) {
+	return maxDiskRecordLength - uint32(s.log.headerLength()), nil
+}
+
+func (s *diskGroupStore) Lookup(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64) (int64, uint32, error) {
+	return s.lookup(diskKey{parentKeyA, parentKeyB, childKeyA, childKeyB})
+}
+
+func (s *diskGroupStore) Read(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64, value []byte) (int64, []byte, error) {
+	return s.read(diskKey{parentKeyA, parentKeyB, childKeyA, childKeyB}, value)
+}
+
+func (s *diskGroupStore) Write(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64, timestampMicro int64, value []byte) (int64, error) {
+	return s.write(diskKey{parentKeyA, parentKeyB, childKeyA, childKeyB}, timestampMicro, value, false)
+}
+
+func (s *diskGroupStore) Delete(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64, timestampMicro int64) (int64, error) {
+	return s.write(diskKey{parentKeyA, parentKeyB, childKeyA, childKeyB}, timestampMicro, nil, true)
+}
+
+func (s *diskGroupStore) LookupGroup(ctx context.Context, parentKeyA, parentKeyB uint64) ([]store.LookupGroupItem, error) {
+	s.RLock()
+	defer s.RUnlock()
+	g := s.groups[memKey{parentKeyA, parentKeyB}]
+	items := make([]store.LookupGroupItem, 0, len(g))
+	for k, item := range g {
+		if item.deleted {
+			continue
+		}
+		items = append(items, store.LookupGroupItem{
+			ChildKeyA:      k.keyA,
+			ChildKeyB:      k.keyB,
+			TimestampMicro: item.timestampMicro,
+			Length:         item.length,
+		})
+	}
+	return items, nil
+}
+
+func (s *diskGroupStore) ReadGroup(ctx context.Context, parentKeyA, parentKeyB uint64) ([]store.ReadGroupItem, error) {
+	s.RLock()
+	defer s.RUnlock()
+	g := s.groups[memKey{parentKeyA, parentKeyB}]
+	items := make([]store.ReadGroupItem, 0, len(g))
+	for k, item := range g {
+		if item.deleted {
+			continue
+		}
+		v, err := s.log.read(s.log.f, item, nil)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, store.ReadGroupItem{
+			ChildKeyA:      k.keyA,
+			ChildKeyB:      k.keyB,
+			TimestampMicro: item.timestampMicro,
+			Value:          v,
+		})
+	}
+	return items, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gholt/store"
+	"golang.org/x/net/context"
+)
+
+func TestDiskValueStore_Restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formicd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	p := path.Join(dir, "data/valuestore.log")
+	s := NewDiskValueStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Write(ctx, 1, 1, 10, []byte("one"))
+	s.Write(ctx, 2, 2, 10, []byte("two"))
+	s.Write(ctx, 2, 2, 20, []byte("two again"))
+	s.Delete(ctx, 1, 1, 30)
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash in the middle of an append
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	s = NewDiskValueStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ts, v, err := s.Read(ctx, 2, 2, nil)
+	if err != nil || ts != 20 || !bytes.Equal(v, []byte("two again")) {
+		t.Fatalf("Read returned %d, %q, %v", ts, v, err)
+	}
+	ts, _, err = s.Read(ctx, 1, 1, nil)
+	if !store.IsNotFound(err) || ts != 30 {
+		t.Fatalf("Read of deleted value returned %d, %v", ts, err)
+	}
+	if old, _ := s.Write(ctx, 1, 1, 25, []byte("older")); old != 30 {
+		t.Fatal("Expected tombstone to survive restart, got: ", old)
+	}
+	s.Shutdown(ctx)
+}
+
+func TestDiskGroupStore_Restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formicd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	p := path.Join(dir, "data/groupstore.log")
+	s := NewDiskGroupStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Write(ctx, 1, 1, 1, 1, 10, []byte("a"))
+	s.Write(ctx, 1, 1, 2, 2, 10, []byte("b"))
+	s.Delete(ctx, 1, 1, 2, 2, 20)
+	s.Shutdown(ctx)
+
+	s = NewDiskGroupStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(ctx)
+	items, err := s.ReadGroup(ctx, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !bytes.Equal(items[0].Value, []byte("a")) {
+		t.Fatalf("Unexpected group items after restart: %v", items)
+	}
+}
+
+func TestDiskValueStore_DamagedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formicd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	p := path.Join(dir, "data/valuestore.log")
+	s := NewDiskValueStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Write(ctx, 1, 1, 10, []byte("one"))
+	s.Write(ctx, 2, 2, 10, []byte("two"))
+	s.Write(ctx, 3, 3, 10, []byte("three"))
+	s.Shutdown(ctx)
+	// Flip the last byte of the second record's value
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := bytes.Index(b, []byte("two"))
+	b[second+2] ^= 0xff
+	if err = ioutil.WriteFile(p, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s = NewDiskValueStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(ctx)
+	// The records after the damaged one are still there
+	if _, v, err := s.Read(ctx, 3, 3, nil); err != nil || !bytes.Equal(v, []byte("three")) {
+		t.Fatalf("Read returned %q, %v", v, err)
+	}
+	if _, _, err := s.Read(ctx, 2, 2, nil); !store.IsNotFound(err) {
+		t.Fatal("Expected the damaged record to be skipped, got: ", err)
+	}
+	// And the damaged log was kept
+	kept, _ := filepath.Glob(p + ".damaged-*")
+	if len(kept) != 1 {
+		t.Fatalf("Expected the damaged log to be kept, found %v", kept)
+	}
+	if k, _ := ioutil.ReadFile(kept[0]); !bytes.Equal(k, b) {
+		t.Fatal("Expected the damaged log to be kept as it was")
+	}
+}
+
+func TestDiskValueStore_DamagedLength(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formicd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	p := path.Join(dir, "data/valuestore.log")
+	s := NewDiskValueStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Write(ctx, 1, 1, 10, []byte("one"))
+	s.Write(ctx, 2, 2, 10, []byte("two"))
+	s.Shutdown(ctx)
+	// Give the second record an absurd length
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := len(b) / 2
+	binary.BigEndian.PutUint32(b[second+4:], 0xffffffff)
+	if err = ioutil.WriteFile(p, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s = NewDiskValueStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(ctx)
+	if _, v, err := s.Read(ctx, 1, 1, nil); err != nil || !bytes.Equal(v, []byte("one")) {
+		t.Fatalf("Read returned %q, %v", v, err)
+	}
+	if kept, _ := filepath.Glob(p + ".damaged-*"); len(kept) != 1 {
+		t.Fatalf("Expected the damaged log to be kept, found %v", kept)
+	}
+}
+
+func TestDiskValueStore_Compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formicd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(g int64) { diskCompactGarbage = g }(diskCompactGarbage)
+	diskCompactGarbage = 4096
+	ctx := context.Background()
+	p := path.Join(dir, "data/valuestore.log")
+	s := NewDiskValueStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("v"), 100)
+	s.Write(ctx, 1, 1, 1, []byte("kept"))
+	s.Delete(ctx, 2, 2, 1)
+	// Writers keep going while the log is compacted under them
+	var wg sync.WaitGroup
+	for w := uint64(10); w < 14; w++ {
+		wg.Add(1)
+		go func(k uint64) {
+			defer wg.Done()
+			for i := int64(1); i <= 200; i++ {
+				if _, err := s.Write(ctx, k, k, i, append(value, byte(i))); err != nil {
+					t.Error("Write failed: ", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	check := func() {
+		if _, v, err := s.Read(ctx, 1, 1, nil); err != nil || !bytes.Equal(v, []byte("kept")) {
+			t.Fatalf("Read returned %q, %v", v, err)
+		}
+		if ts, _, err := s.Read(ctx, 2, 2, nil); !store.IsNotFound(err) || ts != 1 {
+			t.Fatalf("Read of deleted value returned %d, %v", ts, err)
+		}
+		for k := uint64(10); k < 14; k++ {
+			ts, v, err := s.Read(ctx, k, k, nil)
+			if err != nil || ts != 200 || !bytes.Equal(v, append(value, 200)) {
+				t.Fatalf("Read of %d returned %d, %q, %v", k, ts, v, err)
+			}
+		}
+	}
+	check()
+	// Well over 800 records went in, and the log is kept to a few of each
+	for i := 0; ; i++ {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() < 2*diskCompactGarbage {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("Expected the log to be compacted, it is %d bytes", fi.Size())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	check()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s = NewDiskValueStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(ctx)
+	check()
+}
+
+func TestDiskGroupStore_Compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formicd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	p := path.Join(dir, "data/groupstore.log")
+	s := NewDiskGroupStore(p)
+	if err = s.Startup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(ctx)
+	for i := int64(1); i <= 10; i++ {
+		s.Write(ctx, 1, 1, 1, 1, i, []byte(fmt.Sprint("a", i)))
+		s.Write(ctx, 1, 1, 2, 2, i, []byte(fmt.Sprint("b", i)))
+	}
+	s.Delete(ctx, 1, 1, 2, 2, 20)
+	if err = s.compact(); err != nil {
+		t.Fatal("Compact failed: ", err)
+	}
+	s.Write(ctx, 1, 1, 3, 3, 1, []byte("c"))
+	items, err := s.ReadGroup(ctx, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, item := range items {
+		found[string(item.Value)] = true
+	}
+	if len(items) != 2 || !found["a10"] || !found["c"] {
+		t.Fatalf("Unexpected group items after compacting: %v", items)
+	}
+	if ts, _, err := s.Lookup(ctx, 1, 1, 2, 2); !store.IsNotFound(err) || ts != 20 {
+		t.Fatalf("Lookup of deleted item returned %d, %v", ts, err)
+	}
+}
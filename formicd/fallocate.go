@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+	"github.com/gholt/store"
+	"golang.org/x/net/context"
+)
+
+// Fallocate modes, these match the values used by fallocate(2)
+const (
+	FallocKeepSize  = 0x01
+	FallocPunchHole = 0x02
+	FallocZeroRange = 0x10
+)
+
+var ErrNotSupported = errors.New("Operation not supported")
+
+func (s *apiServer) Fallocate(ctx context.Context, r *pb.FallocateRequest) (*pb.FallocateResponse, error) {
+	err := s.validateIP(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	fsid, err := GetFsId(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if r.Offset < 0 || r.Length <= 0 {
+		return nil, apiError(ErrInvalid)
+	}
+	switch r.Mode {
+	case 0, FallocKeepSize, FallocZeroRange, FallocZeroRange | FallocKeepSize:
+	case FallocPunchHole | FallocKeepSize:
+		// Punching a hole never changes the size
+	default:
+		return nil, apiError(ErrNotSupported)
+	}
+	id := formic.GetID(fsid.Bytes(), r.Inode, 0)
+	if _, err = s.accessHandle(ctx, id, MayWrite); err != nil {
+		return nil, apiError(err)
+	}
+	log.Printf("FALLOCATE: Inode: %d Mode: %#x Offset: %d Length: %d", r.Inode, r.Mode, r.Offset, r.Length)
+	attr, err := s.fs.Fallocate(ctx, fsid.Bytes(), id, r.Mode, uint64(r.Offset), uint64(r.Length))
+	if err == nil {
+		s.changed(ctx, fsid, &pb.Invalidation{Inode: r.Inode, Data: true})
+	}
+	return &pb.FallocateResponse{Attr: attr}, apiError(err)
+}
+
+// Fallocate preallocates or zeroes part of a file. Blocks are only stored
+// when written, so there is nothing to reserve and preallocating just extends
+// the file. Punching a hole and zeroing a range both drop the whole blocks in
+// the range and zero the parts of the blocks at either end, and only differ in
+// that zeroing may also extend the file.
+func (o *OortFS) Fallocate(ctx context.Context, fsid, id []byte, mode uint32, offset, length uint64) (*pb.Attr, error) {
+	// The blocks are only changed once the inode is stored, as the update may
+	// be tried again or lose out to another
+	var z *zeroing
+	n, err := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+		if n.IsDir {
+			return ErrIsDir
+		}
+		upgradeExtents(n)
+		end := offset + length
+		z = nil
+		if mode&(FallocPunchHole|FallocZeroRange) != 0 && n.BlockSize > 0 {
+			// Past the end of the file is already zeros
+			zend := end
+			if zend > n.Attr.Size {
+				zend = n.Attr.Size
+			}
+			if offset < zend {
+				z = zeroExtents(n, offset, zend)
+			}
+		}
+		if mode&FallocKeepSize == 0 && end > n.Attr.Size {
+			setSize(n, end)
+		} else if z == nil {
+			return errNoChange
+		}
+		ts := time.Now().Unix()
+		n.Attr.Mtime = ts
+		n.Attr.Ctime = ts
+		return nil
+	})
+	if err != nil {
+		return &pb.Attr{}, err
+	}
+	if z != nil {
+		err = o.zeroRange(ctx, fsid, n.Inode, z)
+		if err != nil {
+			return &pb.Attr{}, err
+		}
+	}
+	return n.Attr, nil
+}
+
+// zeroing is a range of a file to be zeroed, from start up to end, of which
+// the blocks from first up to last are dropped entirely.
+type zeroing struct {
+	start, end  uint64
+	first, last uint64
+	blocksize   uint64
+	extents     []*pb.Extent // Of the blocks that had data
+	tsm         int64        // When the blocks were dropped
+}
+
+// zeroExtents drops the extents of the whole blocks of n from start up to end,
+// along with the block that end is in when the range runs to the end of the
+// file, and returns what zeroRange then has to do to the blocks.
+func zeroExtents(n *pb.InodeEntry, start, end uint64) *zeroing {
+	bs := n.BlockSize
+	z := &zeroing{
+		start:     start,
+		end:       end,
+		first:     (start + bs - 1) / bs,
+		last:      end / bs,
+		blocksize: bs,
+		extents:   dataExtents(n),
+		tsm:       brimtime.TimeToUnixMicro(time.Now()),
+	}
+	if end == n.Attr.Size {
+		z.last = (end + bs - 1) / bs
+	}
+	if z.first < z.last {
+		removeExtents(n, z.first, z.last)
+	}
+	return z
+}
+
+// zeroRange deletes the blocks of the inode that z drops and zeroes the parts
+// of the blocks on either side.
+func (o *OortFS) zeroRange(ctx context.Context, fsid []byte, inode uint64, z *zeroing) error {
+	bs := z.blocksize
+	head := z.start / bs
+	if z.start%bs != 0 {
+		to := bs
+		if z.end/bs == head {
+			to = z.end % bs
+		}
+		err := o.zeroPart(ctx, fsid, inode, head, z.start%bs, to)
+		if err != nil {
+			return err
+		}
+	}
+	if tail := z.end / bs; z.end%bs != 0 && tail >= z.last && (z.start%bs == 0 || tail != head) {
+		err := o.zeroPart(ctx, fsid, inode, tail, 0, z.end%bs)
+		if err != nil {
+			return err
+		}
+	}
+	// Only the blocks that were written need to be deleted, and anything
+	// written after the inode was updated wins over the delete
+	for _, e := range z.extents {
+		for b := e.Start; b < e.Start+e.Count; b++ {
+			if b < z.first || b >= z.last {
+				continue
+			}
+			err := o.DeleteChunk(ctx, formic.GetID(fsid, inode, b+1), z.tsm) // block 0 is for inode data
+			if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// zeroPart zeroes the block from the offset from up to to. Zeroing to the end
+// of what is stored just cuts the block short, as reads fill in the rest.
+func (o *OortFS) zeroPart(ctx context.Context, fsid []byte, inode, block, from, to uint64) error {
+	id := formic.GetID(fsid, inode, block+1) // block 0 is for inode data
+	chunk, err := o.GetChunk(ctx, id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if from >= uint64(len(chunk)) {
+		return nil
+	}
+	if to >= uint64(len(chunk)) {
+		to = uint64(len(chunk))
+	}
+	zeroed := make([]byte, len(chunk))
+	copy(zeroed, chunk)
+	for i := from; i < to; i++ {
+		zeroed[i] = 0
+	}
+	if to == uint64(len(chunk)) {
+		zeroed = zeroed[:from]
+	}
+	if len(zeroed) == 0 {
+		// The block stays in the extents, but reads of a missing block are
+		// zeros all the same
+		err = o.DeleteChunk(ctx, id, brimtime.TimeToUnixMicro(time.Now()))
+		if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+			return err
+		}
+		return nil
+	}
+	return o.WriteChunk(ctx, id, zeroed)
+}
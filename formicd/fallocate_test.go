@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+)
+
+func TestApiServer_Fallocate(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	fsid, _ := GetFsId(ctx)
+	c := createFile(t, api, ctx, 1, "fallocate")
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHI")
+	if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: data}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	waitForSize(t, api, ctx, c.Inode, uint64(len(data)))
+	time.Sleep(20 * time.Millisecond)
+	fallocate := func(mode uint32, offset, length int64) (*pb.Attr, error) {
+		f, err := api.Fallocate(ctx, &pb.FallocateRequest{Inode: c.Inode, Mode: mode, Offset: offset, Length: length})
+		if err != nil {
+			return nil, err
+		}
+		return f.Attr, nil
+	}
+	for _, bad := range []struct {
+		mode           uint32
+		offset, length int64
+		errno          syscall.Errno
+	}{
+		{FallocPunchHole, 0, 10, syscall.EOPNOTSUPP},
+		{0x08, 0, 10, syscall.EOPNOTSUPP}, // FALLOC_FL_COLLAPSE_RANGE
+		{0, 0, 0, syscall.EINVAL},
+		{0, -1, 10, syscall.EINVAL},
+	} {
+		if _, err := fallocate(bad.mode, bad.offset, bad.length); formic.Errno(err) != bad.errno {
+			t.Errorf("Expected %v for %v, got: %v", bad.errno, bad, err)
+		}
+	}
+	// Preallocating extends the file unless asked not to
+	attr, err := fallocate(0, 40, 20)
+	if err != nil || attr.Size != 60 {
+		t.Fatalf("Expected size 60, got %v: %v", attr, err)
+	}
+	attr, err = fallocate(FallocKeepSize, 100, 20)
+	if err != nil || attr.Size != 60 {
+		t.Fatalf("Expected size 60, got %v: %v", attr, err)
+	}
+	// Punching a hole drops block 1 and zeroes the blocks on either side
+	if _, err = fallocate(FallocPunchHole|FallocKeepSize, 5, 22); err != nil {
+		t.Fatal("Punching a hole failed: ", err)
+	}
+	if _, err = api.fs.GetChunk(ctx, formic.GetID(fsid.Bytes(), c.Inode, 2)); err != ErrNotFound {
+		t.Fatal("Expected block 1 to be deleted, got: ", err)
+	}
+	want := make([]byte, 60)
+	copy(want, data)
+	for i := 5; i < 27; i++ {
+		want[i] = 0
+	}
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: 100})
+	if err != nil || !bytes.Equal(r.Payload, want) {
+		t.Fatalf("Expected read: %v received: %v: %v", want, r, err)
+	}
+	l, err := api.Lseek(ctx, &pb.LseekRequest{Inode: c.Inode, Whence: SeekHole})
+	if err != nil || l.Offset != 10 {
+		t.Fatalf("Expected a hole at 10, got %v: %v", l, err)
+	}
+	// Zeroing a range can extend the file too
+	attr, err = fallocate(FallocZeroRange, 42, 23)
+	if err != nil || attr.Size != 65 {
+		t.Fatalf("Expected size 65, got %v: %v", attr, err)
+	}
+	want = append(want[:42], make([]byte, 23)...)
+	r, err = api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: 100})
+	if err != nil || !bytes.Equal(r.Payload, want) {
+		t.Fatalf("Expected read: %v received: %v: %v", want, r, err)
+	}
+}
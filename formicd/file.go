@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/getcfs/fuse"
@@ -15,42 +16,95 @@ import (
 	pb "github.com/creiht/formic/proto"
 	"github.com/gholt/brimtime"
 	"github.com/gholt/store"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spaolacci/murmur3"
 	"golang.org/x/net/context"
 )
 
 const (
-	InodeEntryVersion = 1
-	DirEntryVersion   = 1
+	InodeEntryVersion = 2 // Version 2 tracks which blocks have been written
+	DirEntryVersion   = 2 // Version 2 records the inode number
 	FileBlockVersion  = 1
 )
 
 type FileService interface {
 	InitFs(ctx context.Context, fsid []byte) error
 	GetAttr(ctx context.Context, id []byte) (*pb.Attr, error)
-	SetAttr(ctx context.Context, id []byte, attr *pb.Attr, valid uint32) (*pb.Attr, error)
-	Create(ctx context.Context, parent, id []byte, inode uint64, name string, attr *pb.Attr, isdir bool) (string, *pb.Attr, error)
-	Update(ctx context.Context, id []byte, block, size, blocksize uint64, mtime int64) error
+	SetAttr(ctx context.Context, fsid, id []byte, attr *pb.Attr, valid uint32) (*pb.Attr, error)
+	Create(ctx context.Context, fsid, parent, id []byte, inode uint64, name string, attr *pb.Attr, isdir bool) (string, *pb.Attr, error)
+	Update(ctx context.Context, id []byte, blocks []uint64, blocksize, size uint64, mtime int64) error
 	Lookup(ctx context.Context, parent []byte, name string) (string, *pb.Attr, error)
 	ReadDirAll(ctx context.Context, id []byte) (*pb.ReadDirAllResponse, error)
-	Remove(ctx context.Context, parent []byte, name string) (int32, error)
-	Symlink(ctx context.Context, parent, id []byte, name string, target string, attr *pb.Attr, inode uint64) (*pb.SymlinkResponse, error)
+	ReadDir(ctx context.Context, id []byte, cookie, cookieB uint64, fn func(*pb.DirEnt) error) error
+	Remove(ctx context.Context, fsid, parent []byte, name string, isdir bool) (int32, error)
+	Unlink(ctx context.Context, fsid, parent []byte, name string) error
+	Symlink(ctx context.Context, fsid, parent, id []byte, name string, target string, attr *pb.Attr, inode uint64) (*pb.SymlinkResponse, error)
 	Readlink(ctx context.Context, id []byte) (*pb.ReadlinkResponse, error)
 	Getxattr(ctx context.Context, id []byte, name string) (*pb.GetxattrResponse, error)
 	Setxattr(ctx context.Context, id []byte, name string, value []byte) (*pb.SetxattrResponse, error)
 	Listxattr(ctx context.Context, id []byte) (*pb.ListxattrResponse, error)
 	Removexattr(ctx context.Context, id []byte, name string) (*pb.RemovexattrResponse, error)
-	Rename(ctx context.Context, oldParent, newParent []byte, oldName, newName string) (*pb.RenameResponse, error)
+	Rename(ctx context.Context, fsid []byte, oldParent, newParent uint64, oldName, newName string, flags uint32) (*pb.RenameResponse, error)
+	Link(ctx context.Context, parent, id []byte, name string) (*pb.LinkResponse, error)
+	Fallocate(ctx context.Context, fsid, id []byte, mode uint32, offset, length uint64) (*pb.Attr, error)
 	GetChunk(ctx context.Context, id []byte) ([]byte, error)
 	WriteChunk(ctx context.Context, id, data []byte) error
 	DeleteChunk(ctx context.Context, id []byte, tsm int64) error
 	DeleteListing(ctx context.Context, parent []byte, name string, tsm int64) error
 	GetInode(ctx context.Context, id []byte) (*pb.InodeEntry, error)
+	UpdateInode(ctx context.Context, id []byte, fn func(n *pb.InodeEntry) error) (*pb.InodeEntry, error)
 	GetDirent(ctx context.Context, parent []byte, name string) (*pb.DirEntry, error)
 }
 
 var ErrStoreHasNewerValue = errors.New("Error store already has newer value")
 var ErrNotFound = errors.New("Not found")
+var ErrLinkDir = errors.New("Hard links to directories are not allowed")
+var ErrNotEmpty = errors.New("Directory not empty")
+var ErrIsDir = errors.New("Is a directory")
+var ErrNotDir = errors.New("Not a directory")
+var ErrExists = errors.New("File exists")
+var ErrInvalid = errors.New("Invalid argument")
+var ErrNameTooLong = errors.New("File name too long")
+var ErrNoAttr = errors.New("No such attribute")
+var ErrCorrupt = errors.New("Block failed checksum")
+var ErrConflict = errors.New("Too many concurrent updates")
+var ErrUnknownParent = errors.New("Directory predates parent tracking")
+
+// errNoChange is returned by the functions given to UpdateInode when there is
+// nothing to store.
+var errNoChange = errors.New("No change")
+
+// corruptChunks counts the chunks read from the store that failed their
+// checksum, including those that were good when read again.
+var corruptChunks = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "formicd",
+	Name:      "corrupt_chunks_total",
+	Help:      "Chunks read from the store that failed their checksum.",
+})
+
+// How many times a chunk that fails its checksum is read again before giving up
+const corruptRereads = 1
+
+// inodeConflicts counts the inode updates that had to be made again because
+// the inode was changed by something else meanwhile.
+var inodeConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "formicd",
+	Name:      "inode_conflicts_total",
+	Help:      "Inode updates retried because of a concurrent update.",
+})
+
+// How many times an inode update is retried after conflicting with others.
+// Each conflict means another update got through.
+const maxInodeRetries = 20
+
+// Nlink returns the link count for attr. Entries written before link counts
+// were tracked have a count of 0, which really means a single link.
+func Nlink(attr *pb.Attr) uint32 {
+	if attr == nil || attr.Nlink == 0 {
+		return 1
+	}
+	return attr.Nlink
+}
 
 type StoreComms struct {
 	vstore store.ValueStore
@@ -66,26 +120,43 @@ func NewStoreComms(vstore store.ValueStore, gstore store.GroupStore) (*StoreComm
 
 // Helper methods to get data from value and group store
 func (o *StoreComms) ReadValue(ctx context.Context, id []byte) ([]byte, error) {
+	v, _, err := o.ReadValueTS(ctx, id)
+	return v, err
+}
+
+// ReadValueTS returns the value stored for id along with its timestamp.
+func (o *StoreComms) ReadValueTS(ctx context.Context, id []byte) ([]byte, int64, error) {
 	// TODO: You might want to make this whole area pass in reusable []byte to
 	// lessen gc pressure.
 	keyA, keyB := murmur3.Sum128(id)
-	_, v, err := o.vstore.Read(ctx, keyA, keyB, nil)
-	return v, err
+	ts, v, err := o.vstore.Read(ctx, keyA, keyB, nil)
+	return v, ts, err
 }
 
 func (o *StoreComms) WriteValue(ctx context.Context, id, data []byte) error {
-	keyA, keyB := murmur3.Sum128(id)
 	timestampMicro := brimtime.TimeToUnixMicro(time.Now())
-	oldTimestampMicro, err := o.vstore.Write(ctx, keyA, keyB, timestampMicro, data)
+	return o.WriteValueTS(ctx, id, data, timestampMicro)
+}
+
+func (o *StoreComms) WriteValueTS(ctx context.Context, id, data []byte, tsm int64) error {
+	keyA, keyB := murmur3.Sum128(id)
+	oldTimestampMicro, err := o.vstore.Write(ctx, keyA, keyB, tsm, data)
 	if err != nil {
 		return err
 	}
-	if oldTimestampMicro >= timestampMicro {
+	if oldTimestampMicro >= tsm {
 		return ErrStoreHasNewerValue
 	}
 	return nil
 }
 
+// LookupValue returns the timestamp of the value stored for id.
+func (o *StoreComms) LookupValue(ctx context.Context, id []byte) (int64, error) {
+	keyA, keyB := murmur3.Sum128(id)
+	ts, _, err := o.vstore.Lookup(ctx, keyA, keyB)
+	return ts, err
+}
+
 func (o *StoreComms) DeleteValue(ctx context.Context, id []byte) error {
 	timestampMicro := brimtime.TimeToUnixMicro(time.Now())
 	return o.DeleteValueTS(ctx, id, timestampMicro)
@@ -110,7 +181,7 @@ func (o *StoreComms) WriteGroupTS(ctx context.Context, key, childKey, value []by
 	childKeyA, childKeyB := murmur3.Sum128(childKey)
 	oldTimestampMicro, err := o.gstore.Write(ctx, keyA, keyB, childKeyA, childKeyB, tsm, value)
 	if err != nil {
-		return nil
+		return err
 	}
 	if oldTimestampMicro >= tsm {
 		return ErrStoreHasNewerValue
@@ -129,6 +200,15 @@ func (o *StoreComms) ReadGroupItemByKey(ctx context.Context, key []byte, childKe
 	return v, err
 }
 
+// ReadGroupItemTS returns the item along with its timestamp. The timestamp of
+// an item that has been deleted is returned along with the not found error.
+func (o *StoreComms) ReadGroupItemTS(ctx context.Context, key, childKey []byte) ([]byte, int64, error) {
+	keyA, keyB := murmur3.Sum128(key)
+	childKeyA, childKeyB := murmur3.Sum128(childKey)
+	ts, v, err := o.gstore.Read(ctx, keyA, keyB, childKeyA, childKeyB, nil)
+	return v, ts, err
+}
+
 func (o *StoreComms) DeleteGroupItem(ctx context.Context, key, childKey []byte) error {
 	timestampMicro := brimtime.TimeToUnixMicro(time.Now())
 	return o.DeleteGroupItemTS(ctx, key, childKey, timestampMicro)
@@ -166,9 +246,10 @@ func (o *StoreComms) ReadGroup(ctx context.Context, key []byte) ([]store.ReadGro
 }
 
 type OortFS struct {
-	hasher     func() hash.Hash32
-	comms      *StoreComms
-	deleteChan chan *DeleteItem
+	hasher   func() hash.Hash32
+	comms    *StoreComms
+	deletes  *Deletinator
+	listings dirListings
 }
 
 func NewOortFS(comms *StoreComms) *OortFS {
@@ -176,10 +257,8 @@ func NewOortFS(comms *StoreComms) *OortFS {
 		hasher: crc32.NewIEEE,
 		comms:  comms,
 	}
-	// TODO: How big should the chan be, or should we have another in memory queue that feeds the chan?
-	o.deleteChan = make(chan *DeleteItem, 1000)
-	deletes := newDeletinator(o.deleteChan, o)
-	go deletes.run()
+	o.deletes = newDeletinator(o, comms, DefaultDeleteWorkers)
+	o.deletes.start()
 	return o
 }
 
@@ -205,6 +284,7 @@ func (o *OortFS) InitFs(ctx context.Context, fsid []byte) error {
 			Mode:   uint32(os.ModeDir | 0775),
 			Uid:    1001, // TODO: need to config default user/group id
 			Gid:    1001,
+			Nlink:  1,
 		}
 		b, err := formic.Marshal(r)
 		if err != nil {
@@ -231,52 +311,115 @@ func (o *OortFS) GetAttr(ctx context.Context, id []byte) (*pb.Attr, error) {
 	return n.Attr, nil
 }
 
-func (o *OortFS) SetAttr(ctx context.Context, id []byte, attr *pb.Attr, v uint32) (*pb.Attr, error) {
+func (o *OortFS) SetAttr(ctx context.Context, fsid, id []byte, attr *pb.Attr, v uint32) (*pb.Attr, error) {
 	valid := fuse.SetattrValid(v)
-	b, err := o.GetChunk(ctx, id)
-	if err != nil {
-		return &pb.Attr{}, err
-	}
-	n := &pb.InodeEntry{}
-	err = formic.Unmarshal(b, n)
+	// The blocks are only cut back once the smaller size is stored, as the
+	// update may be tried again or lose out to another
+	truncated := false
+	var blocks uint64
+	n, err := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+		truncated = false
+		if valid.Mode() {
+			n.Attr.Mode = attr.Mode
+			// The ACL has to be kept in sync with the mode bits
+			if acl := inodeACL(n); acl != nil {
+				acl.SetMode(n.Attr.Mode)
+				n.Xattr[ACLAccessXattr] = acl.Bytes()
+			}
+		}
+		if valid.Size() && attr.Size != n.Attr.Size {
+			upgradeExtents(n)
+			if attr.Size < n.Attr.Size {
+				truncated = true
+				blocks = n.Blocks
+				// So that journaled writes from before aren't replayed
+				n.Truncates++
+			}
+			setSize(n, attr.Size)
+		}
+		if valid.Mtime() {
+			n.Attr.Mtime = attr.Mtime
+		}
+		if valid.Atime() {
+			n.Attr.Atime = attr.Atime
+		}
+		if valid.Uid() {
+			n.Attr.Uid = attr.Uid
+		}
+		if valid.Gid() {
+			n.Attr.Gid = attr.Gid
+		}
+		return nil
+	})
 	if err != nil {
 		return &pb.Attr{}, err
 	}
-	if valid.Mode() {
-		n.Attr.Mode = attr.Mode
-	}
-	if valid.Size() {
-		if n.Attr.Size == 0 {
-			n.Blocks = 0
-			n.LastBlock = 0
+	if truncated {
+		err = o.truncate(ctx, fsid, n, blocks)
+		if err != nil {
+			return &pb.Attr{}, err
 		}
-		n.Attr.Size = attr.Size
-	}
-	if valid.Mtime() {
-		n.Attr.Mtime = attr.Mtime
 	}
-	if valid.Atime() {
-		n.Attr.Atime = attr.Atime
-	}
-	if valid.Uid() {
-		n.Attr.Uid = attr.Uid
+	return n.Attr, nil
+}
+
+// truncate frees the blocks of n past its size, of the blocks that it had
+// before, and cuts the new last block off at the size, so that the old data
+// doesn't come back if the file is extended again. n must be the inode as
+// stored with the new size. Freeing the blocks is left to the Deletinator.
+func (o *OortFS) truncate(ctx context.Context, fsid []byte, n *pb.InodeEntry, blocks uint64) error {
+	size := n.Attr.Size
+	if n.BlockSize == 0 {
+		// Nothing has been written
+		return nil
 	}
-	if valid.Gid() {
-		n.Attr.Gid = attr.Gid
+	keep := (size + n.BlockSize - 1) / n.BlockSize
+	if tail := size % n.BlockSize; tail != 0 {
+		id := formic.GetID(fsid, n.Inode, keep) // The last kept block, as block 0 is for inode data
+		chunk, err := o.GetChunk(ctx, id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if uint64(len(chunk)) > tail {
+			err = o.WriteChunk(ctx, id, chunk[:tail])
+			if err != nil {
+				return err
+			}
+		}
 	}
-	b, err = formic.Marshal(n)
-	if err != nil {
-		return &pb.Attr{}, err
-	}
-	err = o.WriteChunk(ctx, id, b)
-	if err != nil {
-		return &pb.Attr{}, err
+	if keep < blocks {
+		tsm := brimtime.TimeToUnixMicro(time.Now())
+		o.deletes.queue(ctx, &DeleteItem{
+			ts: &pb.Tombstone{
+				Dtime:  tsm,
+				Qtime:  tsm,
+				FsId:   fsid,
+				Inode:  n.Inode,
+				Blocks: blocks,
+			},
+			firstBlock: keep,
+			truncate:   true,
+		})
 	}
+	return nil
+}
 
-	return n.Attr, nil
+// setSize sets the size of the file along with the block counts that follow
+// from it. Files that haven't been written yet have no blocks.
+func setSize(n *pb.InodeEntry, size uint64) {
+	n.Attr.Size = size
+	n.Blocks = 0
+	n.LastBlock = 0
+	if n.BlockSize == 0 || size == 0 {
+		n.Extents = nil
+		return
+	}
+	n.Blocks = (size + n.BlockSize - 1) / n.BlockSize
+	n.LastBlock = size - (n.Blocks-1)*n.BlockSize
+	clipExtents(n, n.Blocks)
 }
 
-func (o *OortFS) Create(ctx context.Context, parent, id []byte, inode uint64, name string, attr *pb.Attr, isdir bool) (string, *pb.Attr, error) {
+func (o *OortFS) Create(ctx context.Context, fsid, parent, id []byte, inode uint64, name string, attr *pb.Attr, isdir bool) (string, *pb.Attr, error) {
 	// Check to see if the name already exists
 	b, err := o.comms.ReadGroupItem(ctx, parent, []byte(name))
 	if err != nil && !store.IsNotFound(err) {
@@ -291,8 +434,26 @@ func (o *OortFS) Create(ctx context.Context, parent, id []byte, inode uint64, na
 		}
 		// Return an error if entry already exists and is not a tombstone
 		if p.Tombstone == nil {
-			return "", &pb.Attr{}, nil
+			return "", &pb.Attr{}, ErrExists
+		}
+	}
+	p, err := o.GetInode(ctx, parent)
+	if err != nil {
+		return "", &pb.Attr{}, err
+	}
+	// Directories track their parent so that rename can tell when a directory
+	// would be moved inside of itself
+	var parentInode uint64
+	if isdir {
+		parentInode = p.Inode
+	}
+	var xattr map[string][]byte
+	if b, ok := p.Xattr[ACLDefaultXattr]; ok {
+		def, err := ParseACL(b)
+		if err != nil {
+			return "", &pb.Attr{}, err
 		}
+		xattr = inheritACLs(def, attr, isdir)
 	}
 	var direntType fuse.DirentType
 	if isdir {
@@ -300,34 +461,23 @@ func (o *OortFS) Create(ctx context.Context, parent, id []byte, inode uint64, na
 	} else {
 		direntType = fuse.DT_File
 	}
-	// Add the name to the group
-	d := &pb.DirEntry{
-		Version: DirEntryVersion,
-		Name:    name,
-		Id:      id,
-		Type:    uint32(direntType),
-	}
-	b, err = formic.Marshal(d)
-	if err != nil {
-		return "", &pb.Attr{}, err
-	}
-	err = o.comms.WriteGroup(ctx, parent, []byte(name), b)
-	if err != nil {
-		return "", &pb.Attr{}, err
-	}
-	// Add the inode entry
 	n := &pb.InodeEntry{
 		Version: InodeEntryVersion,
 		Inode:   inode,
 		IsDir:   isdir,
 		Attr:    attr,
 		Blocks:  0,
+		Parent:  parentInode,
+		Xattr:   xattr,
 	}
-	b, err = formic.Marshal(n)
-	if err != nil {
-		return "", &pb.Attr{}, err
+	d := &pb.DirEntry{
+		Version: DirEntryVersion,
+		Name:    name,
+		Id:      id,
+		Type:    uint32(direntType),
+		Inode:   inode,
 	}
-	err = o.WriteChunk(ctx, id, b)
+	err = o.createEntry(ctx, fsid, parent, n, d)
 	if err != nil {
 		return "", &pb.Attr{}, err
 	}
@@ -338,7 +488,7 @@ func (o *OortFS) Lookup(ctx context.Context, parent []byte, name string) (string
 	// Get the id
 	b, err := o.comms.ReadGroupItem(ctx, parent, []byte(name))
 	if store.IsNotFound(err) {
-		return "", &pb.Attr{}, nil
+		return "", &pb.Attr{}, ErrNotFound
 	} else if err != nil {
 		return "", &pb.Attr{}, err
 	}
@@ -348,15 +498,14 @@ func (o *OortFS) Lookup(ctx context.Context, parent []byte, name string) (string
 		return "", &pb.Attr{}, err
 	}
 	if d.Tombstone != nil {
-		return "", &pb.Attr{}, nil
+		return "", &pb.Attr{}, ErrNotFound
 	}
-	// Get the Inode entry
-	b, err = o.GetChunk(ctx, d.Id)
-	if err != nil {
-		return "", &pb.Attr{}, err
+	// Get the Inode entry. An entry whose inode is missing is hidden, as
+	// there is nothing that could be done with it.
+	n, err := o.GetInode(ctx, d.Id)
+	if err == ErrNotFound {
+		log.Printf("Hiding entry %s whose inode %x is missing", name, d.Id)
 	}
-	n := &pb.InodeEntry{}
-	err = formic.Unmarshal(b, n)
 	if err != nil {
 		return "", &pb.Attr{}, err
 	}
@@ -388,101 +537,391 @@ func (o *OortFS) ReadDirAll(ctx context.Context, id []byte) (*pb.ReadDirAllRespo
 	}
 	// Iterate over each item, getting the ID then the Inode Entry
 	e := &pb.ReadDirAllResponse{}
-	dirent := &pb.DirEntry{}
 	for _, item := range items {
+		dirent := &pb.DirEntry{}
 		err = formic.Unmarshal(item.Value, dirent)
 		if err != nil {
 			return &pb.ReadDirAllResponse{}, err
 		}
-		if dirent.Tombstone != nil {
-			// Skip deleted entries
-			continue
+		de, err := o.dirEnt(ctx, dirent)
+		if err != nil {
+			return &pb.ReadDirAllResponse{}, err
+		}
+		if de != nil {
+			e.DirEntries = append(e.DirEntries, de)
 		}
-		e.DirEntries = append(e.DirEntries, &pb.DirEnt{Name: dirent.Name, Type: dirent.Type})
 	}
 	sort.Sort(ByDirent(e.DirEntries))
 	return e, nil
 }
 
-func (o *OortFS) Remove(ctx context.Context, parent []byte, name string) (int32, error) {
+// byKey sorts the keys of a directory's entries into the order they are
+// listed in, which stays the same so that a listing can be carried on.
+type byKey []store.LookupGroupItem
+
+func (k byKey) Len() int {
+	return len(k)
+}
+
+func (k byKey) Swap(i, j int) {
+	k[i], k[j] = k[j], k[i]
+}
+
+func (k byKey) Less(i, j int) bool {
+	return keyAfter(k[j].ChildKeyA, k[j].ChildKeyB, k[i].ChildKeyA, k[i].ChildKeyB)
+}
+
+// keyAfter returns true if the key a, b comes after the key ca, cb.
+func keyAfter(a, b, ca, cb uint64) bool {
+	return a > ca || a == ca && b > cb
+}
+
+// How long the sorted keys of a directory are kept for listings to carry on
+// from, and for how many directories at most.
+const (
+	dirListingTTL  = time.Minute
+	maxDirListings = 128
+)
+
+// How many entries of a directory are read at once while listing it.
+const dirEntBatch = 32
+
+type dirListing struct {
+	keys []store.LookupGroupItem
+	read time.Time
+}
+
+// dirListings keeps the sorted keys of the directories being listed, so that
+// a listing that is carried on from a cookie doesn't read and sort all of the
+// keys again. The pages that follow miss entries added in the meantime, which
+// readdir allows.
+type dirListings struct {
+	sync.Mutex
+	listings map[string]*dirListing
+}
+
+func (d *dirListings) get(id []byte) []store.LookupGroupItem {
+	d.Lock()
+	defer d.Unlock()
+	l := d.listings[string(id)]
+	if l == nil || time.Since(l.read) > dirListingTTL {
+		return nil
+	}
+	return l.keys
+}
+
+func (d *dirListings) put(id []byte, keys []store.LookupGroupItem) {
+	d.Lock()
+	defer d.Unlock()
+	if d.listings == nil {
+		d.listings = make(map[string]*dirListing)
+	}
+	now := time.Now()
+	var oldest string
+	for k, l := range d.listings {
+		if now.Sub(l.read) > dirListingTTL {
+			delete(d.listings, k)
+		} else if oldest == "" || l.read.Before(d.listings[oldest].read) {
+			oldest = k
+		}
+	}
+	if _, ok := d.listings[string(id)]; !ok && len(d.listings) >= maxDirListings {
+		delete(d.listings, oldest)
+	}
+	d.listings[string(id)] = &dirListing{keys: keys, read: now}
+}
+
+// ReadDir calls fn with each entry in the directory after the cookie, stopping
+// at the first error from fn, which is returned. Entries are listed in the
+// order of the hashes of their names and the cookie of an entry is its hash,
+// so a listing can be carried on from any entry.
+//
+// The store can only list all of the keys of a group, so the sorted keys are
+// kept for a while for the listings carried on from a cookie, and are only
+// read again by listings from the start or once they have expired. The
+// entries themselves are read a batch at a time as they are reached.
+func (o *OortFS) ReadDir(ctx context.Context, id []byte, cookie, cookieB uint64, fn func(*pb.DirEnt) error) error {
+	var keys []store.LookupGroupItem
+	if cookie != 0 || cookieB != 0 {
+		keys = o.listings.get(id)
+	}
+	if keys == nil {
+		items, err := o.comms.LookupGroup(ctx, id)
+		if err != nil {
+			return err
+		}
+		sort.Sort(byKey(items))
+		o.listings.put(id, items)
+		keys = items
+	}
+	keys = keys[sort.Search(len(keys), func(i int) bool {
+		return keyAfter(keys[i].ChildKeyA, keys[i].ChildKeyB, cookie, cookieB)
+	}):]
+	for len(keys) > 0 {
+		n := dirEntBatch
+		if n > len(keys) {
+			n = len(keys)
+		}
+		ents, err := o.dirEnts(ctx, id, keys[:n])
+		if err != nil {
+			return err
+		}
+		for _, de := range ents {
+			err = fn(de)
+			if err != nil {
+				return err
+			}
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// dirEnts reads the entries with keys all at once, leaving out those that
+// shouldn't be listed.
+func (o *OortFS) dirEnts(ctx context.Context, id []byte, keys []store.LookupGroupItem) ([]*pb.DirEnt, error) {
+	ents := make([]*pb.DirEnt, len(keys))
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ents[i], errs[i] = o.readDirEnt(ctx, id, keys[i])
+		}(i)
+	}
+	wg.Wait()
+	listed := ents[:0]
+	for i, de := range ents {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if de != nil {
+			listed = append(listed, de)
+		}
+	}
+	return listed, nil
+}
+
+func (o *OortFS) readDirEnt(ctx context.Context, id []byte, key store.LookupGroupItem) (*pb.DirEnt, error) {
+	b, err := o.comms.ReadGroupItemByKey(ctx, id, key.ChildKeyA, key.ChildKeyB)
+	if store.IsNotFound(err) {
+		// Removed since the keys were read
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dirent := &pb.DirEntry{}
+	err = formic.Unmarshal(b, dirent)
+	if err != nil {
+		return nil, err
+	}
+	de, err := o.dirEnt(ctx, dirent)
+	if de != nil {
+		de.Cookie = key.ChildKeyA
+		de.CookieB = key.ChildKeyB
+	}
+	return de, err
+}
+
+// dirEnt returns the listing of d, or nil if d shouldn't be listed because it
+// has been deleted or its inode is missing, as Lookup would hide it anyway.
+func (o *OortFS) dirEnt(ctx context.Context, d *pb.DirEntry) (*pb.DirEnt, error) {
+	if d.Tombstone != nil {
+		return nil, nil
+	}
+	inode := d.Inode
+	var err error
+	if inode == 0 {
+		// Entries from before version 2 only have the inode's ID
+		var n *pb.InodeEntry
+		n, err = o.GetInode(ctx, d.Id)
+		if n != nil {
+			inode = n.Inode
+		}
+	} else {
+		_, err = o.comms.LookupValue(ctx, d.Id)
+		if store.IsNotFound(err) {
+			err = ErrNotFound
+		}
+	}
+	if err == ErrNotFound {
+		log.Printf("Hiding entry %s whose inode %x is missing", d.Name, d.Id)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DirEnt{Name: d.Name, Type: d.Type, Inode: inode}, nil
+}
+
+func (o *OortFS) Remove(ctx context.Context, fsid, parent []byte, name string, isdir bool) (int32, error) {
+	d, inode, err := o.getEntry(ctx, parent, name)
+	if err != nil {
+		return 1, err
+	}
+	if inode.IsDir != isdir {
+		if isdir {
+			return 1, ErrNotDir
+		}
+		return 1, ErrIsDir
+	}
+	if isdir {
+		// Only empty directories can be removed, otherwise the children would
+		// be orphaned in the directory's group
+		empty, err := o.isEmpty(ctx, d.Id)
+		if err != nil {
+			return 1, err
+		}
+		if !empty {
+			return 1, ErrNotEmpty
+		}
+	}
+	err = o.unlink(ctx, fsid, parent, name, d, inode)
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// Unlink removes name from parent whatever it is, without checking that a
+// directory is empty. The Deletinator uses it for entries that were created in
+// a directory after it was removed, whose children then go the same way.
+func (o *OortFS) Unlink(ctx context.Context, fsid, parent []byte, name string) error {
+	d, inode, err := o.getEntry(ctx, parent, name)
+	if err != nil {
+		return err
+	}
+	return o.unlink(ctx, fsid, parent, name, d, inode)
+}
+
+// getEntry returns the live entry name in parent along with its inode.
+func (o *OortFS) getEntry(ctx context.Context, parent []byte, name string) (*pb.DirEntry, *pb.InodeEntry, error) {
 	// Get the ID from the group list
 	b, err := o.comms.ReadGroupItem(ctx, parent, []byte(name))
 	if store.IsNotFound(err) {
-		return 1, nil
+		return nil, nil, ErrNotFound
 	} else if err != nil {
-		return 1, err
+		return nil, nil, err
 	}
 	d := &pb.DirEntry{}
 	err = formic.Unmarshal(b, d)
 	if err != nil {
-		return 1, err
+		return nil, nil, err
+	}
+	if d.Tombstone != nil {
+		// Already removed
+		return nil, nil, ErrNotFound
 	}
 	// TODO: More error handling needed
 	// TODO: Handle possible race conditions where user writes and deletes the same file over and over
+	inode, err := o.GetInode(ctx, d.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, inode, nil
+}
+
+// unlink drops a link to the inode of d, queueing the inode to be deleted if
+// it was the last.
+func (o *OortFS) unlink(ctx context.Context, fsid, parent []byte, name string, d *pb.DirEntry, inode *pb.InodeEntry) error {
+	var err error
+	linked := false
+	if Nlink(inode.Attr) > 1 {
+		// Other names still point at the inode, so drop the link count and
+		// remove just this listing. The data stays until the last link goes.
+		inode, err = o.UpdateInode(ctx, d.Id, func(n *pb.InodeEntry) error {
+			// The other names may have gone meanwhile
+			linked = Nlink(n.Attr) > 1
+			if !linked {
+				return errNoChange
+			}
+			n.Attr.Nlink = Nlink(n.Attr) - 1
+			n.Attr.Ctime = time.Now().Unix()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if linked {
+		return o.comms.DeleteGroupItem(ctx, parent, []byte(name))
+	}
 	// Mark the item deleted in the group
 	t := &pb.Tombstone{}
 	tsm := brimtime.TimeToUnixMicro(time.Now())
 	t.Dtime = tsm
 	t.Qtime = tsm
-	t.FsId = []byte("1") // TODO: Make sure this gets set when we are tracking fsids
-	inode, err := o.GetInode(ctx, d.Id)
-	if err != nil {
-		return 1, err
-	}
+	t.FsId = fsid
 	t.Blocks = inode.Blocks
 	t.Inode = inode.Inode
 	d.Tombstone = t
-	b, err = formic.Marshal(d)
+	b, err := formic.Marshal(d)
 	if err != nil {
-		return 1, err
+		return err
 	}
 	// NOTE: The tsm-1 is kind of a hack because the timestamp needs to be updated on this write, but if we choose tsm, once the actual delete comes through, it will not work because it is going to try to delete with a timestamp of tsm.
 	err = o.comms.WriteGroupTS(ctx, parent, []byte(name), b, tsm-1)
 	if err != nil {
-		return 1, err // Not really sure what should be done here to try to recover from err
+		return err // Not really sure what should be done here to try to recover from err
 	}
-	o.deleteChan <- &DeleteItem{
+	o.deletes.queue(ctx, &DeleteItem{
+		fsid:   fsid,
 		parent: parent,
 		name:   name,
-	}
-	return 0, nil
+	})
+	return nil
 }
 
-func (o *OortFS) Update(ctx context.Context, id []byte, block, blocksize, size uint64, mtime int64) error {
-	b, err := o.GetChunk(ctx, id)
-	if err != nil {
-		return err
-	}
-	n := &pb.InodeEntry{}
-	err = formic.Unmarshal(b, n)
-	if err != nil {
-		return err
-	}
-	blocks := n.Blocks
-	if block >= blocks {
-		n.Blocks = block + 1
-		n.LastBlock = size
-		n.BlockSize = blocksize
-		n.Attr.Size = blocksize*block + size
-	} else if block == (blocks - 1) {
-		n.LastBlock = size
-		n.Attr.Size = blocksize*block + size
-	}
-	if mtime > n.Attr.Mtime {
-		n.Attr.Mtime = mtime
-	}
-	b, err = formic.Marshal(n)
+// isEmpty returns true if the directory's group has no live entries. Entries
+// that have been tombstoned but not yet cleaned up by the Deletinator don't
+// count.
+func (o *OortFS) isEmpty(ctx context.Context, id []byte) (bool, error) {
+	items, err := o.comms.ReadGroup(ctx, id)
 	if err != nil {
-		return err
+		return false, err
 	}
-	err = o.WriteChunk(ctx, id, b)
-	if err != nil {
-		return err
+	d := &pb.DirEntry{}
+	for _, item := range items {
+		err = formic.Unmarshal(item.Value, d)
+		if err != nil {
+			return false, err
+		}
+		if d.Tombstone == nil {
+			return false, nil
+		}
 	}
-	return nil
+	return true, nil
+}
+
+// Update records that blocks of the file have been written, and grows the file
+// to size if it is smaller.
+func (o *OortFS) Update(ctx context.Context, id []byte, blocks []uint64, blocksize, size uint64, mtime int64) error {
+	_, err := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+		if n.BlockSize == 0 {
+			n.BlockSize = blocksize
+		}
+		upgradeExtents(n)
+		for _, block := range blocks {
+			addExtent(n, block)
+		}
+		// Writes only ever grow the file, as it may have already been extended
+		// past the blocks by a truncate or another write
+		end := size
+		if end < n.Attr.Size {
+			end = n.Attr.Size
+		}
+		setSize(n, end)
+		if mtime > n.Attr.Mtime {
+			n.Attr.Mtime = mtime
+		}
+		return nil
+	})
+	return err
 }
 
-func (o *OortFS) Symlink(ctx context.Context, parent, id []byte, name string, target string, attr *pb.Attr, inode uint64) (*pb.SymlinkResponse, error) {
+func (o *OortFS) Symlink(ctx context.Context, fsid, parent, id []byte, name string, target string, attr *pb.Attr, inode uint64) (*pb.SymlinkResponse, error) {
 	// Check to see if the name exists
 	val, err := o.comms.ReadGroupItem(ctx, parent, []byte(name))
 	if err != nil && !store.IsNotFound(err) {
@@ -490,7 +929,14 @@ func (o *OortFS) Symlink(ctx context.Context, parent, id []byte, name string, ta
 		return &pb.SymlinkResponse{}, err
 	}
 	if len(val) > 1 { // Exists already
-		return &pb.SymlinkResponse{}, nil
+		d := &pb.DirEntry{}
+		err = formic.Unmarshal(val, d)
+		if err != nil {
+			return &pb.SymlinkResponse{}, err
+		}
+		if d.Tombstone == nil {
+			return &pb.SymlinkResponse{}, ErrExists
+		}
 	}
 	n := &pb.InodeEntry{
 		Version: InodeEntryVersion,
@@ -500,26 +946,14 @@ func (o *OortFS) Symlink(ctx context.Context, parent, id []byte, name string, ta
 		Target:  target,
 		Attr:    attr,
 	}
-	b, err := formic.Marshal(n)
-	if err != nil {
-		return &pb.SymlinkResponse{}, err
-	}
-	err = o.WriteChunk(ctx, id, b)
-	if err != nil {
-		return &pb.SymlinkResponse{}, err
-	}
-	// Add the name to the group
 	d := &pb.DirEntry{
 		Version: DirEntryVersion,
 		Name:    name,
 		Id:      id,
-		Type:    uint32(fuse.DT_File),
-	}
-	b, err = formic.Marshal(d)
-	if err != nil {
-		return &pb.SymlinkResponse{}, err
+		Type:    uint32(fuse.DT_Link),
+		Inode:   inode,
 	}
-	err = o.comms.WriteGroup(ctx, parent, []byte(name), b)
+	err = o.createEntry(ctx, fsid, parent, n, d)
 	if err != nil {
 		return &pb.SymlinkResponse{}, err
 	}
@@ -552,28 +986,20 @@ func (o *OortFS) Getxattr(ctx context.Context, id []byte, name string) (*pb.Getx
 	if xattr, ok := n.Xattr[name]; ok {
 		return &pb.GetxattrResponse{Xattr: xattr}, nil
 	}
-	return &pb.GetxattrResponse{}, nil
+	return &pb.GetxattrResponse{}, ErrNoAttr
 }
 
 func (o *OortFS) Setxattr(ctx context.Context, id []byte, name string, value []byte) (*pb.SetxattrResponse, error) {
-	b, err := o.GetChunk(ctx, id)
-	if err != nil {
-		return &pb.SetxattrResponse{}, err
-	}
-	n := &pb.InodeEntry{}
-	err = formic.Unmarshal(b, n)
-	if err != nil {
-		return &pb.SetxattrResponse{}, err
-	}
-	if n.Xattr == nil {
-		n.Xattr = make(map[string][]byte)
-	}
-	n.Xattr[name] = value
-	b, err = formic.Marshal(n)
-	if err != nil {
-		return &pb.SetxattrResponse{}, err
-	}
-	err = o.WriteChunk(ctx, id, b)
+	_, err := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+		if n.Xattr == nil {
+			n.Xattr = make(map[string][]byte)
+		}
+		if name == ACLAccessXattr || name == ACLDefaultXattr {
+			return setACL(n, name, value)
+		}
+		n.Xattr[name] = value
+		return nil
+	})
 	if err != nil {
 		return &pb.SetxattrResponse{}, err
 	}
@@ -601,77 +1027,120 @@ func (o *OortFS) Listxattr(ctx context.Context, id []byte) (*pb.ListxattrRespons
 }
 
 func (o *OortFS) Removexattr(ctx context.Context, id []byte, name string) (*pb.RemovexattrResponse, error) {
-	b, err := o.GetChunk(ctx, id)
-	if err != nil {
-		return &pb.RemovexattrResponse{}, err
-	}
-	n := &pb.InodeEntry{}
-	err = formic.Unmarshal(b, n)
-	if err != nil {
-		return &pb.RemovexattrResponse{}, err
-	}
-	delete(n.Xattr, name)
-	b, err = formic.Marshal(n)
-	if err != nil {
-		return &pb.RemovexattrResponse{}, err
-	}
-	err = o.WriteChunk(ctx, id, b)
+	_, err := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+		if _, ok := n.Xattr[name]; !ok {
+			return errNoChange
+		}
+		delete(n.Xattr, name)
+		return nil
+	})
 	if err != nil {
 		return &pb.RemovexattrResponse{}, err
 	}
 	return &pb.RemovexattrResponse{}, nil
 }
 
-func (o *OortFS) Rename(ctx context.Context, oldParent, newParent []byte, oldName, newName string) (*pb.RenameResponse, error) {
-	// Get the ID from the group list
-	b, err := o.comms.ReadGroupItem(ctx, oldParent, []byte(oldName))
-	if store.IsNotFound(err) {
-		return &pb.RenameResponse{}, nil
+func (o *OortFS) Link(ctx context.Context, parent, id []byte, name string) (*pb.LinkResponse, error) {
+	// Check to see if the name already exists
+	b, err := o.comms.ReadGroupItem(ctx, parent, []byte(name))
+	if err != nil && !store.IsNotFound(err) {
+		// TODO: Needs beter error handling
+		return &pb.LinkResponse{}, err
 	}
-	if err != nil {
-		return &pb.RenameResponse{}, err
+	if len(b) > 0 {
+		p := &pb.DirEntry{}
+		err = formic.Unmarshal(b, p)
+		if err != nil {
+			return &pb.LinkResponse{}, err
+		}
+		// Return an error if entry already exists and is not a tombstone
+		if p.Tombstone == nil {
+			return &pb.LinkResponse{}, ErrExists
+		}
 	}
-	d := &pb.DirEntry{}
-	err = formic.Unmarshal(b, d)
+	// Bump the link count before adding the name so that a failure in between
+	// leaves the count too high (leaking the inode) rather than too low (which
+	// would let a remove of the other name reclaim blocks that are still in use)
+	n, err := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+		if n.IsDir {
+			return ErrLinkDir
+		}
+		n.Attr.Nlink = Nlink(n.Attr) + 1
+		n.Attr.Ctime = time.Now().Unix()
+		return nil
+	})
 	if err != nil {
-		return &pb.RenameResponse{}, err
+		return &pb.LinkResponse{}, err
 	}
-	// TODO: Handle orphaned data from overwrites
-	// Create new entry
-	d.Name = newName
-	b, err = formic.Marshal(d)
-	err = o.comms.WriteGroup(ctx, newParent, []byte(newName), b)
-	if err != nil {
-		return &pb.RenameResponse{}, err
+	direntType := fuse.DT_File
+	if n.IsLink {
+		direntType = fuse.DT_Link
+	}
+	d := &pb.DirEntry{
+		Version: DirEntryVersion,
+		Name:    name,
+		Id:      id,
+		Type:    uint32(direntType),
+		Inode:   n.Inode,
+	}
+	err = o.claimName(ctx, parent, d)
+	if err == ErrExists {
+		// Someone else got the name first
+		_, uerr := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+			n.Attr.Nlink = Nlink(n.Attr) - 1
+			return nil
+		})
+		if uerr != nil {
+			log.Printf("Couldn't drop link count of inode %x: %s", id, uerr)
+		}
 	}
-	// Delete old entry
-	err = o.comms.DeleteGroupItem(ctx, oldParent, []byte(oldName))
 	if err != nil {
-		// TODO: Handle errors
-		// If we fail here then we will have two entries
-		return &pb.RenameResponse{}, err
+		return &pb.LinkResponse{}, err
 	}
-	return &pb.RenameResponse{}, nil
+	return &pb.LinkResponse{Name: name, Attr: n.Attr}, nil
 }
 
+// GetChunk returns the data stored for id, or ErrCorrupt if it fails its
+// checksum. A corrupt chunk is read again first, as a replicated store may
+// answer from a replica with a good copy.
 func (o *OortFS) GetChunk(ctx context.Context, id []byte) ([]byte, error) {
-	b, err := o.comms.ReadValue(ctx, id)
-	if store.IsNotFound(err) {
-		return nil, ErrNotFound
-	}
-	if err != nil {
-		return nil, err
-	}
-	fb := &pb.FileBlock{}
-	err = formic.Unmarshal(b, fb)
-	if err != nil {
-		return nil, err
+	data, _, err := o.getChunkTS(ctx, id)
+	return data, err
+}
+
+// getChunkTS is GetChunk that also returns the timestamp of the chunk.
+func (o *OortFS) getChunkTS(ctx context.Context, id []byte) ([]byte, int64, error) {
+	for i := 0; ; i++ {
+		b, ts, err := o.comms.ReadValueTS(ctx, id)
+		if store.IsNotFound(err) {
+			return nil, 0, ErrNotFound
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		fb := &pb.FileBlock{}
+		err = formic.Unmarshal(b, fb)
+		if err == nil {
+			crc := o.hasher()
+			crc.Write(fb.Data)
+			if crc.Sum32() == fb.Checksum {
+				return fb.Data, ts, nil
+			}
+		}
+		corruptChunks.Inc()
+		if i >= corruptRereads {
+			log.Printf("Err: Chunk %x is corrupt", id)
+			return nil, 0, ErrCorrupt
+		}
+		log.Printf("Chunk %x is corrupt, reading it again", id)
 	}
-	// TODO: Validate checksum and handle errors
-	return fb.Data, nil
 }
 
 func (o *OortFS) WriteChunk(ctx context.Context, id, data []byte) error {
+	return o.writeChunkTS(ctx, id, data, brimtime.TimeToUnixMicro(time.Now()))
+}
+
+func (o *OortFS) writeChunkTS(ctx context.Context, id, data []byte, tsm int64) error {
 	crc := o.hasher()
 	crc.Write(data)
 	fb := &pb.FileBlock{
@@ -683,7 +1152,7 @@ func (o *OortFS) WriteChunk(ctx context.Context, id, data []byte) error {
 	if err != nil {
 		return err
 	}
-	return o.comms.WriteValue(ctx, id, b)
+	return o.comms.WriteValueTS(ctx, id, b, tsm)
 }
 
 func (o *OortFS) DeleteChunk(ctx context.Context, id []byte, tsm int64) error {
@@ -703,11 +1172,56 @@ func (o *OortFS) GetInode(ctx context.Context, id []byte) (*pb.InodeEntry, error
 	n := &pb.InodeEntry{}
 	err = formic.Unmarshal(b, n)
 	if err != nil {
-		return nil, err
+		log.Printf("Err: Inode %x doesn't decode: %s", id, err)
+		return nil, ErrCorrupt
 	}
 	return n, nil
 }
 
+// UpdateInode applies fn to the inode and stores the result, as long as the
+// inode hasn't changed since it was read. Otherwise it is read again and fn is
+// applied again, so fn has to be safe to repeat. fn returns errNoChange to
+// leave the inode as it is.
+//
+// The store keeps whichever value has the newest timestamp, so the update is
+// written one microsecond after the version it was made from. Of two updates
+// made from the same version only the first is stored, and the other is
+// rejected as the store already has a value that new.
+func (o *OortFS) UpdateInode(ctx context.Context, id []byte, fn func(n *pb.InodeEntry) error) (*pb.InodeEntry, error) {
+	for i := 0; ; i++ {
+		b, ts, err := o.getChunkTS(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		n := &pb.InodeEntry{}
+		err = formic.Unmarshal(b, n)
+		if err != nil {
+			log.Printf("Err: Inode %x doesn't decode: %s", id, err)
+			return nil, ErrCorrupt
+		}
+		err = fn(n)
+		if err == errNoChange {
+			return n, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err = formic.Marshal(n)
+		if err != nil {
+			return nil, err
+		}
+		err = o.writeChunkTS(ctx, id, b, ts+1)
+		if err != ErrStoreHasNewerValue {
+			return n, err
+		}
+		inodeConflicts.Inc()
+		if i >= maxInodeRetries {
+			log.Printf("Err: Gave up updating inode %x after %d conflicts", id, i+1)
+			return nil, ErrConflict
+		}
+	}
+}
+
 func (o *OortFS) GetDirent(ctx context.Context, parent []byte, name string) (*pb.DirEntry, error) {
 	// Get the Dir Entry
 	b, err := o.comms.ReadGroupItem(ctx, parent, []byte(name))
@@ -17,8 +17,10 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"strconv"
 	"time"
 
+	"github.com/creiht/formic/flother"
 	pb "github.com/creiht/formic/proto"
 	"github.com/gholt/brimtime"
 	"github.com/gholt/store"
@@ -69,11 +71,12 @@ type AddrRef struct {
 
 // FileSysMeta ...
 type FileSysMeta struct {
-	ID     string   `json:"id"`
-	AcctID string   `json:"acctid"`
-	Name   string   `json:"name"`
-	Status string   `json:"status"`
-	Addr   []string `json:"addrs"`
+	ID        string   `json:"id"`
+	AcctID    string   `json:"acctid"`
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	Addr      []string `json:"addrs"`
+	BlockSize int64    `json:"blocksize,omitempty"`
 }
 
 func clear(v interface{}) {
@@ -84,15 +87,25 @@ func clear(v interface{}) {
 // FileSystemAPIServer is used to implement oohhc
 type FileSystemAPIServer struct {
 	gstore store.GroupStore
+	fs     *OortFS
+	fl     *flother.Flother // Shared with the ApiServer so inodes don't clash
 }
 
 // FSAttrList ...
-var FSAttrList = []string{"name"}
+var FSAttrList = []string{"name", "blocksize"}
+
+// Limits on the block size a file system can be created with
+const (
+	MinBlockSize = 4 * 1024
+	MaxBlockSize = 1024 * 1024
+)
 
 // NewFileSystemAPIServer ...
-func NewFileSystemAPIServer(store store.GroupStore) *FileSystemAPIServer {
+func NewFileSystemAPIServer(store store.GroupStore, fs *OortFS, fl *flother.Flother) *FileSystemAPIServer {
 	s := new(FileSystemAPIServer)
 	s.gstore = store
+	s.fs = fs
+	s.fl = fl
 	return s
 }
 
@@ -119,6 +132,11 @@ func (s *FileSystemAPIServer) CreateFS(ctx context.Context, r *pb.CreateFSReques
 		return nil, errf(codes.PermissionDenied, "%v", "Invalid Token")
 	}
 
+	if r.BlockSize != 0 && (r.BlockSize < MinBlockSize || r.BlockSize > MaxBlockSize) {
+		log.Printf("%s CREATE FAILED BLOCKSIZE %d\n", srcAddr, r.BlockSize)
+		return nil, errf(codes.InvalidArgument, "Block size must be between %d and %d", MinBlockSize, MaxBlockSize)
+	}
+
 	fsID := uuid.NewV4().String()
 	timestampMicro := brimtime.TimeToUnixMicro(time.Now())
 	// Write file system reference entries.
@@ -164,6 +182,22 @@ func (s *FileSystemAPIServer) CreateFS(ctx context.Context, r *pb.CreateFSReques
 		log.Printf("%s CREATE FAILED %v\n", srcAddr, err)
 		return nil, errf(codes.Internal, "%v", err)
 	}
+	if r.BlockSize != 0 {
+		// write /fs/FSID					blocksize					FileSysAttr
+		cKeyA, cKeyB = murmur3.Sum128([]byte("blocksize"))
+		fsSysAttr.Attr = "blocksize"
+		fsSysAttr.Value = strconv.FormatInt(r.BlockSize, 10)
+		fsSysAttrByte, err = json.Marshal(fsSysAttr)
+		if err != nil {
+			log.Printf("%s  CREATE FAILED %v\n", srcAddr, err)
+			return nil, errf(codes.Internal, "%v", err)
+		}
+		_, err = s.gstore.Write(context.Background(), pKeyA, pKeyB, cKeyA, cKeyB, timestampMicro, fsSysAttrByte)
+		if err != nil {
+			log.Printf("%s CREATE FAILED %v\n", srcAddr, err)
+			return nil, errf(codes.Internal, "%v", err)
+		}
+	}
 
 	// Return File System UUID
 	// Log Operation
@@ -246,6 +280,24 @@ func (s *FileSystemAPIServer) ShowFS(ctx context.Context, r *pb.ShowFSRequest) (
 	}
 	fs.Name = fsAttrData.Value
 
+	// The block size is only stored when it isn't the default
+	cKeyA, cKeyB = murmur3.Sum128([]byte("blocksize"))
+	_, value, err = s.gstore.Read(context.Background(), pKeyA, pKeyB, cKeyA, cKeyB, nil)
+	if err != nil && !store.IsNotFound(err) {
+		log.Printf("%s SHOW FAILED %v\n", srcAddr, err)
+		return nil, errf(codes.Internal, "%v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(value, &fsAttrData)
+		if err == nil {
+			fs.BlockSize, err = strconv.ParseInt(fsAttrData.Value, 10, 64)
+		}
+		if err != nil {
+			log.Printf("%s SHOW FAILED %v\n", srcAddr, err)
+			return nil, errf(codes.Internal, "%v", err)
+		}
+	}
+
 	// Read list of granted ip addresses
 	// group-lookup printf("/fs/%s/addr", FSID)
 	pKey = fmt.Sprintf("/fs/%s/addr", fs.ID)
@@ -557,6 +609,146 @@ func (s *FileSystemAPIServer) RevokeAddrFS(ctx context.Context, r *pb.RevokeAddr
 	return &pb.RevokeAddrFSResponse{Data: r.FSid}, nil
 }
 
+// ScrubReportFS ...
+func (s *FileSystemAPIServer) ScrubReportFS(ctx context.Context, r *pb.ScrubReportFSRequest) (*pb.ScrubReportFSResponse, error) {
+	var err error
+	var acctID string
+	var value []byte
+	var fsRef FileSysRef
+	srcAddr := ""
+
+	// Get incomming ip
+	pr, ok := peer.FromContext(ctx)
+	if ok {
+		srcAddr = pr.Addr.String()
+	}
+	// Validate Token
+	acctID, err = s.validateToken(r.Token)
+	if err != nil {
+		log.Printf("%s SCRUBREPORT FAILED %s\n", srcAddr, "PermissionDenied")
+		return nil, errf(codes.PermissionDenied, "%v", "Invalid Token")
+	}
+	// Validate Token/Account owns this file system
+	// Read FileSysRef entry to determine if it exists
+	pKey := fmt.Sprintf("/fs")
+	pKeyA, pKeyB := murmur3.Sum128([]byte(pKey))
+	cKeyA, cKeyB := murmur3.Sum128([]byte(r.FSid))
+	_, value, err = s.gstore.Read(context.Background(), pKeyA, pKeyB, cKeyA, cKeyB, nil)
+	if store.IsNotFound(err) {
+		log.Printf("%s SCRUBREPORT FAILED %s NOTFOUND", srcAddr, r.FSid)
+		return nil, errf(codes.NotFound, "%v", "Not Found")
+	}
+	if err != nil {
+		log.Printf("%s SCRUBREPORT FAILED %v\n", srcAddr, err)
+		return nil, errf(codes.Internal, "%v", err)
+	}
+	err = json.Unmarshal(value, &fsRef)
+	if err != nil {
+		log.Printf("%s SCRUBREPORT FAILED %v\n", srcAddr, err)
+		return nil, errf(codes.Internal, "%v", err)
+	}
+	if fsRef.AcctID != acctID {
+		log.Printf("%s SCRUBREPORT FAILED %v ACCOUNT MISMATCH", srcAddr, r.FSid)
+		return nil, errf(codes.FailedPrecondition, "%v", "Account Mismatch")
+	}
+
+	// Read the report from the last scrub
+	// read /fs/FSID						scrub						ScrubReport
+	pKey = fmt.Sprintf("/fs/%s", r.FSid)
+	pKeyA, pKeyB = murmur3.Sum128([]byte(pKey))
+	cKeyA, cKeyB = murmur3.Sum128([]byte("scrub"))
+	_, value, err = s.gstore.Read(context.Background(), pKeyA, pKeyB, cKeyA, cKeyB, nil)
+	if store.IsNotFound(err) {
+		log.Printf("%s SCRUBREPORT FAILED %s NOTSCRUBBED", srcAddr, r.FSid)
+		return nil, errf(codes.NotFound, "%v", "File System Not Scrubbed Yet")
+	}
+	if err != nil {
+		log.Printf("%s SCRUBREPORT FAILED %v\n", srcAddr, err)
+		return nil, errf(codes.Internal, "%v", err)
+	}
+
+	// Log Operation
+	log.Printf("%s SCRUBREPORT SUCCESS %s\n", srcAddr, r.FSid)
+	return &pb.ScrubReportFSResponse{Data: string(value)}, nil
+}
+
+// FsckFS ...
+func (s *FileSystemAPIServer) FsckFS(ctx context.Context, r *pb.FsckFSRequest) (*pb.FsckFSResponse, error) {
+	var err error
+	var acctID string
+	var value []byte
+	var fsRef FileSysRef
+	srcAddr := ""
+
+	// Get incomming ip
+	pr, ok := peer.FromContext(ctx)
+	if ok {
+		srcAddr = pr.Addr.String()
+	}
+	// Validate Token
+	acctID, err = s.validateToken(r.Token)
+	if err != nil {
+		log.Printf("%s FSCK FAILED %s\n", srcAddr, "PermissionDenied")
+		return nil, errf(codes.PermissionDenied, "%v", "Invalid Token")
+	}
+	// Validate Token/Account owns this file system
+	// Read FileSysRef entry to determine if it exists
+	pKey := fmt.Sprintf("/fs")
+	pKeyA, pKeyB := murmur3.Sum128([]byte(pKey))
+	cKeyA, cKeyB := murmur3.Sum128([]byte(r.FSid))
+	_, value, err = s.gstore.Read(context.Background(), pKeyA, pKeyB, cKeyA, cKeyB, nil)
+	if store.IsNotFound(err) {
+		log.Printf("%s FSCK FAILED %s NOTFOUND", srcAddr, r.FSid)
+		return nil, errf(codes.NotFound, "%v", "Not Found")
+	}
+	if err != nil {
+		log.Printf("%s FSCK FAILED %v\n", srcAddr, err)
+		return nil, errf(codes.Internal, "%v", err)
+	}
+	err = json.Unmarshal(value, &fsRef)
+	if err != nil {
+		log.Printf("%s FSCK FAILED %v\n", srcAddr, err)
+		return nil, errf(codes.Internal, "%v", err)
+	}
+	if fsRef.AcctID != acctID {
+		log.Printf("%s FSCK FAILED %v ACCOUNT MISMATCH", srcAddr, r.FSid)
+		return nil, errf(codes.FailedPrecondition, "%v", "Account Mismatch")
+	}
+	fsid, err := uuid.FromString(r.FSid)
+	if err != nil {
+		log.Printf("%s FSCK FAILED %v\n", srcAddr, err)
+		return nil, errf(codes.InvalidArgument, "%v", err)
+	}
+
+	// Report on the check, or start one in the background
+	var report *FsckReport
+	if r.Status {
+		report, err = s.fs.FsckStatus(ctx, fsid.Bytes())
+	} else {
+		report, err = s.fs.StartFsck(ctx, fsid.Bytes(), r.Repair, FsckGrace, s.fl)
+	}
+	if err == ErrNotFound {
+		log.Printf("%s FSCK FAILED %s NOTCHECKED", srcAddr, r.FSid)
+		return nil, errf(codes.NotFound, "%v", "File System Not Checked Yet")
+	}
+	if err == ErrExists {
+		log.Printf("%s FSCK FAILED %s RUNNING", srcAddr, r.FSid)
+		return nil, errf(codes.AlreadyExists, "%v", "File System Already Being Checked")
+	}
+	if err != nil {
+		log.Printf("%s FSCK FAILED %v\n", srcAddr, err)
+		return nil, errf(codes.Internal, "%v", err)
+	}
+	reportJSON, jerr := json.Marshal(report)
+	if jerr != nil {
+		return nil, errf(codes.Internal, "%s", jerr)
+	}
+
+	// Log Operation
+	log.Printf("%s FSCK SUCCESS %s REPAIR %t STATUS %t\n", srcAddr, r.FSid, r.Repair, r.Status)
+	return &pb.FsckFSResponse{Data: string(reportJSON)}, nil
+}
+
 // validateToken ...
 func (s *FileSystemAPIServer) validateToken(t string) (string, error) {
 	var tData TokenRef
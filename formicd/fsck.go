@@ -0,0 +1,575 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/getcfs/fuse"
+
+	"github.com/creiht/formic"
+	"github.com/creiht/formic/flother"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+	"github.com/gholt/store"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+// Kinds of problems found by fsck
+const (
+	FsckDanglingEntry    = "dangling-entry"
+	FsckUnreachableInode = "unreachable-inode"
+	FsckStaleTombstone   = "stale-tombstone"
+	FsckLeakedBlock      = "leaked-block"
+	FsckStaleRecord      = "stale-record"
+)
+
+// States of an fsck
+const (
+	FsckRunning = "running"
+	FsckDone    = "done"
+	FsckFailed  = "failed"
+)
+
+// How often an fsck running in the background stores its report. One whose
+// report hasn't been stored for fsckStale is taken to have died along with its
+// formicd.
+const (
+	fsckProgressInterval = 10 * time.Second
+	fsckStale            = 6 * fsckProgressInterval
+)
+
+// FsckGrace is how old something has to be before fsck will treat it as a
+// problem, as anything newer may belong to an operation still in progress.
+const FsckGrace = 10 * time.Minute
+
+// How many missing blocks in a row past the end of a file fsck looks through
+// for leaked blocks. Blocks only go past the end of a file when a truncate or
+// remove didn't finish deleting them, and those are usually contiguous.
+const fsckBlockProbe = 16
+
+// How many problems are listed in a report. The counts include all of them.
+const maxFsckProblems = 1000
+
+// LostFoundName is the directory in the root where fsck links the inodes that
+// can't be reached from the root. If the name is already taken by something
+// other than a directory only root can get into, lost+found.1, lost+found.2
+// and so on are tried instead.
+const LostFoundName = "lost+found"
+
+// How many names fsck tries for lost+found before giving up.
+const maxLostFoundNames = 100
+
+// The inode records of a file system are spread across this many groups so
+// that fsck never has to read them all at once.
+const inodeRecordShards = 256
+
+// inodeRecordsKey is the group that the inode is recorded in when it is
+// created, so that fsck can find the inodes that can no longer be reached. The
+// root, and inodes from before the records were kept, aren't recorded.
+func inodeRecordsKey(fsid []byte, inode uint64) []byte {
+	return []byte(fmt.Sprintf("/fs/%s/inodes/%d", uuid.FromBytesOrNil(fsid), inode%inodeRecordShards))
+}
+
+func inodeRecordName(inode uint64) string {
+	return strconv.FormatUint(inode, 10)
+}
+
+func (o *OortFS) addInodeRecord(ctx context.Context, fsid []byte, inode uint64) error {
+	name := inodeRecordName(inode)
+	return o.comms.WriteGroup(ctx, inodeRecordsKey(fsid, inode), []byte(name), []byte(name))
+}
+
+// FsckProblem is something wrong that fsck found, and whether it was
+// repaired.
+type FsckProblem struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path,omitempty"`
+	Inode    uint64 `json:"inode,omitempty"`
+	Block    uint64 `json:"block,omitempty"`
+	Repaired bool   `json:"repaired"`
+	Err      string `json:"err,omitempty"` // Why the repair failed
+}
+
+// FsckReport is the result of checking a file system. The report of an fsck
+// running in the background is stored as the "fsck" item of /fs/FSID in the
+// group store, and is updated as it goes.
+type FsckReport struct {
+	FSID     string         `json:"fsid"`
+	Repair   bool           `json:"repair"`
+	State    string         `json:"state"`
+	Start    int64          `json:"start"`
+	Updated  int64          `json:"updated"`
+	Finish   int64          `json:"finish,omitempty"`
+	Err      string         `json:"err,omitempty"` // Why the fsck failed
+	Inodes   uint64         `json:"inodes"`
+	Counts   map[string]int `json:"counts"`
+	Problems []FsckProblem  `json:"problems"`
+}
+
+type fsck struct {
+	o       *OortFS
+	fl      *flother.Flother
+	fsid    []byte
+	repair  bool
+	before  int64 // Only things older than this are problems
+	seen    map[string]bool
+	report  *FsckReport
+	lf      []byte // The id of lost+found, once it is found or created
+	lfInode uint64
+	lfName  string
+	store   bool      // Whether the report is stored as the fsck goes
+	stored  time.Time // When the report was last stored
+}
+
+// fsckItem is a directory entry to be checked.
+type fsckItem struct {
+	parent []byte
+	name   string
+	id     []byte
+	path   string
+	tsm    int64
+}
+
+// Fsck checks a file system for directory entries that point at missing
+// inodes, inodes that can't be reached from the root, tombstones that were
+// never cleaned up and blocks left past the end of files. With repair, the
+// entries and blocks are deleted, the tombstones are queued to be deleted
+// again, and the unreachable inodes are linked into /lost+found rather than
+// deleted in case they were being moved while fsck walked the tree. Anything
+// changed within grace is left alone. If lost+found has to be created, its
+// inode comes from fl.
+func (o *OortFS) Fsck(ctx context.Context, fsid []byte, repair bool, grace time.Duration, fl *flother.Flother) (*FsckReport, error) {
+	f := o.newFsck(fsid, repair, grace, fl)
+	if err := f.run(ctx); err != nil {
+		return nil, err
+	}
+	return f.report, nil
+}
+
+// StartFsck starts an Fsck of the file system in the background and returns
+// the report it starts with. The report is stored as the fsck goes, so any
+// formicd can tell how it is getting on with FsckStatus. ErrExists is returned
+// if the file system is already being checked.
+func (o *OortFS) StartFsck(ctx context.Context, fsid []byte, repair bool, grace time.Duration, fl *flother.Flother) (*FsckReport, error) {
+	r, err := o.FsckStatus(ctx, fsid)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	if err == nil && r.State == FsckRunning {
+		return nil, ErrExists
+	}
+	f := o.newFsck(fsid, repair, grace, fl)
+	f.store = true
+	if err = f.storeReport(ctx); err != nil {
+		return nil, err
+	}
+	// The report belongs to the fsck from here on
+	started := *f.report
+	started.Counts, started.Problems = map[string]int{}, []FsckProblem{}
+	go func() {
+		// The fsck outlives the request that started it
+		ctx := context.Background()
+		err := f.run(ctx)
+		if err != nil {
+			log.Printf("FSCK %s failed: %s", f.report.FSID, err)
+			f.report.State, f.report.Err = FsckFailed, err.Error()
+		}
+		if err = f.storeReport(ctx); err != nil {
+			log.Printf("FSCK %s couldn't store its report: %s", f.report.FSID, err)
+		}
+	}()
+	return &started, nil
+}
+
+// FsckStatus returns the stored report of the fsck running on the file
+// system, or of the last one to finish. An fsck whose report has stopped
+// being updated is reported as failed.
+func (o *OortFS) FsckStatus(ctx context.Context, fsid []byte) (*FsckReport, error) {
+	b, err := o.comms.ReadGroupItem(ctx, fsckReportKey(fsid), []byte("fsck"))
+	if store.IsNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &FsckReport{}
+	if err = json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	if r.State == FsckRunning && r.Updated < brimtime.TimeToUnixMicro(time.Now().Add(-fsckStale)) {
+		r.State, r.Err = FsckFailed, "stopped making progress"
+	}
+	return r, nil
+}
+
+func fsckReportKey(fsid []byte) []byte {
+	return []byte(fmt.Sprintf("/fs/%s", uuid.FromBytesOrNil(fsid)))
+}
+
+func (o *OortFS) newFsck(fsid []byte, repair bool, grace time.Duration, fl *flother.Flother) *fsck {
+	now := time.Now()
+	return &fsck{
+		o:      o,
+		fl:     fl,
+		fsid:   fsid,
+		repair: repair,
+		before: brimtime.TimeToUnixMicro(now.Add(-grace)),
+		seen:   make(map[string]bool),
+		report: &FsckReport{
+			FSID:     uuid.FromBytesOrNil(fsid).String(),
+			Repair:   repair,
+			State:    FsckRunning,
+			Start:    brimtime.TimeToUnixMicro(now),
+			Counts:   make(map[string]int),
+			Problems: []FsckProblem{},
+		},
+	}
+}
+
+func (f *fsck) run(ctx context.Context) error {
+	err := f.walk(ctx, fsckItem{id: formic.GetID(f.fsid, 1, 0), path: "/"})
+	if err != nil {
+		return err
+	}
+	if err = f.checkRecords(ctx); err != nil {
+		return err
+	}
+	f.report.State = FsckDone
+	f.report.Finish = brimtime.TimeToUnixMicro(time.Now())
+	return nil
+}
+
+func (f *fsck) storeReport(ctx context.Context) error {
+	f.stored = time.Now()
+	f.report.Updated = brimtime.TimeToUnixMicro(f.stored)
+	b, err := json.Marshal(f.report)
+	if err != nil {
+		return err
+	}
+	return f.o.comms.WriteGroup(ctx, fsckReportKey(f.fsid), []byte("fsck"), b)
+}
+
+// progress stops the fsck once ctx is done and, if the report is being
+// stored, stores it every so often so it can be seen how far the fsck has
+// got.
+func (f *fsck) progress(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !f.store || time.Since(f.stored) < fsckProgressInterval {
+		return nil
+	}
+	if err := f.storeReport(ctx); err != nil {
+		// Only the progress is lost; the report is stored again at the end
+		log.Printf("FSCK %s couldn't store its progress: %s", f.report.FSID, err)
+	}
+	return nil
+}
+
+func (f *fsck) problem(p FsckProblem, fix func() error) {
+	if f.repair {
+		if err := fix(); err != nil {
+			p.Err = err.Error()
+		} else {
+			p.Repaired = true
+		}
+	}
+	log.Printf("FSCK %s: %s %s inode %d block %d repaired %t %s", f.report.FSID, p.Kind, p.Path, p.Inode, p.Block, p.Repaired, p.Err)
+	f.report.Counts[p.Kind]++
+	if len(f.report.Problems) < maxFsckProblems {
+		f.report.Problems = append(f.report.Problems, p)
+	}
+}
+
+// walk checks everything that can be reached from start, which is looked up
+// through its parent unless it is the root.
+func (f *fsck) walk(ctx context.Context, start fsckItem) error {
+	todo := []fsckItem{start}
+	for len(todo) > 0 {
+		if err := f.progress(ctx); err != nil {
+			return err
+		}
+		item := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if f.seen[string(item.id)] {
+			continue
+		}
+		n, err := f.o.GetInode(ctx, item.id)
+		if err == ErrNotFound && item.parent != nil {
+			if item.tsm < f.before {
+				f.problem(FsckProblem{Kind: FsckDanglingEntry, Path: item.path}, func() error {
+					return f.deleteListing(ctx, item.parent, item.name, item.tsm+1)
+				})
+			}
+			continue
+		}
+		if err == ErrCorrupt {
+			// Left for the scrubber to report
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f.seen[string(item.id)] = true
+		f.report.Inodes++
+		if n.IsDir {
+			items, err := f.o.comms.ReadGroup(ctx, item.id)
+			if err != nil && !store.IsNotFound(err) {
+				return err
+			}
+			for _, e := range items {
+				d := &pb.DirEntry{}
+				if formic.Unmarshal(e.Value, d) != nil {
+					continue
+				}
+				p := path.Join(item.path, d.Name)
+				if d.Tombstone != nil {
+					if e.TimestampMicro < f.before {
+						parent, name := item.id, d.Name
+						f.problem(FsckProblem{Kind: FsckStaleTombstone, Path: p, Inode: d.Tombstone.Inode}, func() error {
+							f.o.deletes.queue(ctx, &DeleteItem{fsid: f.fsid, parent: parent, name: name})
+							return nil
+						})
+					}
+					continue
+				}
+				todo = append(todo, fsckItem{parent: item.id, name: d.Name, id: d.Id, path: p, tsm: e.TimestampMicro})
+			}
+		} else if !n.IsLink {
+			if err = f.checkBlocks(ctx, n, item.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkBlocks looks for blocks past the end of the file that were never
+// deleted.
+func (f *fsck) checkBlocks(ctx context.Context, n *pb.InodeEntry, p string) error {
+	for b, missing := n.Blocks, 0; missing < fsckBlockProbe; b++ {
+		id := formic.GetID(f.fsid, n.Inode, b+1) // block 0 is for inode data
+		tsm, err := f.o.comms.LookupValue(ctx, id)
+		if store.IsNotFound(err) {
+			missing++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		missing = 0
+		if tsm >= f.before {
+			continue
+		}
+		f.problem(FsckProblem{Kind: FsckLeakedBlock, Path: p, Inode: n.Inode, Block: b}, func() error {
+			err := f.o.DeleteChunk(ctx, id, tsm+1)
+			if err == ErrStoreHasNewerValue {
+				return nil
+			}
+			return err
+		})
+	}
+	return nil
+}
+
+// checkRecords finds the recorded inodes that weren't reached by the walk,
+// reading the records a group at a time. Directories are dealt with first,
+// along with everything in them, so that only the top of an unreachable tree
+// is linked into lost+found.
+func (f *fsck) checkRecords(ctx context.Context) error {
+	var dirs, files []*pb.InodeEntry
+	inside := make(map[string]bool)
+	for shard := uint64(0); shard < inodeRecordShards; shard++ {
+		if err := f.progress(ctx); err != nil {
+			return err
+		}
+		key := inodeRecordsKey(f.fsid, shard)
+		items, err := f.o.comms.ReadGroup(ctx, key)
+		if store.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			inode, err := strconv.ParseUint(string(item.Value), 10, 64)
+			if err != nil {
+				continue
+			}
+			id := formic.GetID(f.fsid, inode, 0)
+			if f.seen[string(id)] || item.TimestampMicro >= f.before {
+				continue
+			}
+			n, err := f.o.GetInode(ctx, id)
+			if err == ErrNotFound {
+				name, tsm := inodeRecordName(inode), item.TimestampMicro
+				f.problem(FsckProblem{Kind: FsckStaleRecord, Inode: inode}, func() error {
+					return f.deleteListing(ctx, key, name, tsm+1)
+				})
+				continue
+			}
+			if err == ErrCorrupt {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if n.IsDir {
+				dirs = append(dirs, n)
+				if err = f.descendants(ctx, id, inside); err != nil {
+					return err
+				}
+			} else {
+				files = append(files, n)
+			}
+		}
+	}
+	for _, n := range append(dirs, files...) {
+		id := formic.GetID(f.fsid, n.Inode, 0)
+		if inside[string(id)] || f.seen[string(id)] {
+			continue
+		}
+		p := fmt.Sprintf("<inode %d>", n.Inode)
+		f.problem(FsckProblem{Kind: FsckUnreachableInode, Inode: n.Inode}, func() error {
+			err := f.adopt(ctx, n, id)
+			if err == nil {
+				p = path.Join("/", f.lfName, inodeRecordName(n.Inode))
+			}
+			return err
+		})
+		// Anything wrong inside the unreachable tree is still worth reporting
+		if err := f.walk(ctx, fsckItem{id: id, path: p}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// descendants adds the ids of everything under the directory id to inside.
+func (f *fsck) descendants(ctx context.Context, id []byte, inside map[string]bool) error {
+	items, err := f.o.comms.ReadGroup(ctx, id)
+	if store.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		d := &pb.DirEntry{}
+		if formic.Unmarshal(item.Value, d) != nil || d.Tombstone != nil || inside[string(d.Id)] {
+			continue
+		}
+		inside[string(d.Id)] = true
+		if d.Type == uint32(fuse.DT_Dir) {
+			if err = f.descendants(ctx, d.Id, inside); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// adopt links an unreachable inode into lost+found, named by its inode
+// number.
+func (f *fsck) adopt(ctx context.Context, n *pb.InodeEntry, id []byte) error {
+	lf, err := f.lostFound(ctx)
+	if err != nil {
+		return err
+	}
+	d := &pb.DirEntry{
+		Version: DirEntryVersion,
+		Name:    inodeRecordName(n.Inode),
+		Id:      id,
+		Type:    uint32(fuse.DT_File),
+		Inode:   n.Inode,
+	}
+	if n.IsDir {
+		d.Type = uint32(fuse.DT_Dir)
+	}
+	err = f.o.writeDirent(ctx, lf, d, brimtime.TimeToUnixMicro(time.Now()))
+	if err != nil {
+		return err
+	}
+	return f.o.setParent(ctx, id, f.lfInode)
+}
+
+// lostFound returns the id of lost+found, creating it if needed. A name that
+// is already used by anything but a directory only root can get into is
+// skipped, so nothing is ever linked where a user could reach it.
+func (f *fsck) lostFound(ctx context.Context) ([]byte, error) {
+	if f.lf != nil {
+		return f.lf, nil
+	}
+	root := formic.GetID(f.fsid, 1, 0)
+	for i, raced := 0, false; i < maxLostFoundNames; {
+		name := LostFoundName
+		if i > 0 {
+			name = fmt.Sprintf("%s.%d", LostFoundName, i)
+		}
+		d, err := f.o.GetDirent(ctx, root, name)
+		if err != nil {
+			return nil, err
+		}
+		if d.Name == "" || d.Tombstone != nil {
+			id, inode, err := f.makeLostFound(ctx, root, name)
+			if err == nil {
+				f.lf, f.lfInode, f.lfName = id, inode, name
+				return id, nil
+			}
+			if err != ErrExists {
+				return nil, err
+			}
+			if !raced {
+				// Someone else took the name first, so see what it is
+				raced = true
+				continue
+			}
+		} else if n, err := f.o.GetInode(ctx, d.Id); err == nil {
+			if n.IsDir && n.Attr.Uid == 0 && n.Attr.Mode&0077 == 0 {
+				f.lf, f.lfInode, f.lfName = d.Id, n.Inode, name
+				return d.Id, nil
+			}
+		} else if err != ErrNotFound && err != ErrCorrupt {
+			return nil, err
+		}
+		i, raced = i+1, false
+	}
+	return nil, fmt.Errorf("no free name for %s", LostFoundName)
+}
+
+// makeLostFound creates a lost+found directory called name in the root.
+func (f *fsck) makeLostFound(ctx context.Context, root []byte, name string) ([]byte, uint64, error) {
+	inode := f.fl.GetID()
+	id := formic.GetID(f.fsid, inode, 0)
+	ts := time.Now().Unix()
+	attr := &pb.Attr{
+		Inode:  inode,
+		Atime:  ts,
+		Mtime:  ts,
+		Ctime:  ts,
+		Crtime: ts,
+		Mode:   uint32(os.ModeDir | 0700),
+		Nlink:  1,
+	}
+	_, _, err := f.o.Create(ctx, f.fsid, root, id, inode, name, attr, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	// It is reachable now, so it won't show up as unreachable itself
+	f.seen[string(id)] = true
+	return id, inode, nil
+}
+
+func (f *fsck) deleteListing(ctx context.Context, parent []byte, name string, tsm int64) error {
+	err := f.o.DeleteListing(ctx, parent, name, tsm)
+	if err == ErrStoreHasNewerValue || store.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
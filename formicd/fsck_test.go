@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+	"github.com/gholt/store"
+	"golang.org/x/net/context"
+)
+
+func TestOortFS_Fsck(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	root := formic.GetID(fsid.Bytes(), 1, 0)
+	write := func(name string, parent uint64, size int) *pb.Attr {
+		c := createFile(t, api, ctx, parent, name)
+		payload := make([]byte, size)
+		if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: payload}); err != nil {
+			t.Fatal("Write failed: ", err)
+		}
+		waitForSize(t, api, ctx, c.Inode, uint64(size))
+		return c
+	}
+	write("good", 1, 19)
+	// A block left past the end of a file, as from a truncate that never
+	// finished deleting it
+	short := write("short", 1, 30)
+	if err := o.WriteChunk(ctx, formic.GetID(fsid.Bytes(), short.Inode, 6), []byte("leaked")); err != nil {
+		t.Fatal(err)
+	}
+	// An entry whose inode is gone
+	gone := write("gone", 1, 5)
+	if err := api.comms.DeleteValue(ctx, formic.GetID(fsid.Bytes(), gone.Inode, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// A removal that was never carried out
+	tsm := brimtime.TimeToUnixMicro(time.Now())
+	b, _ := formic.Marshal(&pb.DirEntry{Version: DirEntryVersion, Name: "dead", Id: formic.GetID(fsid.Bytes(), 12345, 0), Tombstone: &pb.Tombstone{Dtime: tsm, Qtime: tsm, FsId: fsid.Bytes(), Inode: 12345}})
+	if err := api.comms.WriteGroupTS(ctx, root, []byte("dead"), b, tsm-1); err != nil {
+		t.Fatal(err)
+	}
+	// A directory, with a file in it, and a file that have both lost their
+	// entries
+	m, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "orphan", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	write("child", m.Attr.Inode, 5)
+	lonely := write("lonely", 1, 5)
+	for _, name := range []string{"orphan", "lonely"} {
+		if err = api.comms.DeleteGroupItem(ctx, root, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A user's own file that has the name lost+found would otherwise have
+	createFile(t, api, ctx, 1, LostFoundName)
+	// A record of an inode that was never written
+	if err = o.addInodeRecord(ctx, fsid.Bytes(), 999); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{
+		FsckLeakedBlock:      1,
+		FsckDanglingEntry:    1,
+		FsckStaleTombstone:   1,
+		FsckUnreachableInode: 2,
+		FsckStaleRecord:      2, // gone's record, and 999
+	}
+	for _, repair := range []bool{false, true} {
+		r, err := o.Fsck(ctx, fsid.Bytes(), repair, 0, api.fl)
+		if err != nil {
+			t.Fatal("Fsck failed: ", err)
+		}
+		if len(r.Counts) != len(expected) {
+			t.Errorf("Expected %v, found %v", expected, r.Counts)
+		}
+		for kind, count := range expected {
+			if r.Counts[kind] != count {
+				t.Errorf("Expected %d %s, found %d", count, kind, r.Counts[kind])
+			}
+		}
+		for _, p := range r.Problems {
+			if p.Repaired != repair || p.Err != "" {
+				t.Errorf("Unexpected repair: %v", p)
+			}
+			if p.Kind == FsckLeakedBlock && (p.Inode != short.Inode || p.Block != 5) {
+				t.Errorf("Unexpected leaked block: %v", p)
+			}
+		}
+	}
+
+	// The unreachable inodes are linked into lost+found.1 by number. It is
+	// only open to root, so it is looked at directly.
+	_, attr, err := o.Lookup(ctx, root, LostFoundName+".1")
+	if err != nil {
+		t.Fatal("Lookup of lost+found.1 failed: ", err)
+	}
+	lf := formic.GetID(fsid.Bytes(), attr.Inode, 0)
+	for _, inode := range []uint64{m.Attr.Inode, lonely.Inode} {
+		_, attr, err := o.Lookup(ctx, lf, inodeRecordName(inode))
+		if err != nil || attr.Inode != inode {
+			t.Fatalf("Lookup in lost+found of %d failed: %v", inode, err)
+		}
+	}
+	if _, err = api.Lookup(ctx, &pb.LookupRequest{Parent: m.Attr.Inode, Name: "child"}); err != nil {
+		t.Fatal("Lookup of child failed: ", err)
+	}
+	// The stale tombstone is cleaned up by the Deletinator
+	for i := 0; ; i++ {
+		_, err = api.comms.ReadGroupItem(ctx, root, []byte("dead"))
+		if store.IsNotFound(err) {
+			break
+		}
+		if i > 100 {
+			t.Fatal("Tombstone wasn't cleaned up: ", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r, err := o.Fsck(ctx, fsid.Bytes(), false, 0, api.fl)
+	if err != nil {
+		t.Fatal("Fsck failed: ", err)
+	}
+	if len(r.Problems) != 0 {
+		t.Errorf("Problems left after repair: %v", r.Problems)
+	}
+	// The next repair links into the same lost+found.1
+	again := write("again", 1, 5)
+	if err = api.comms.DeleteGroupItem(ctx, root, []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = o.Fsck(ctx, fsid.Bytes(), true, 0, api.fl); err != nil {
+		t.Fatal("Fsck failed: ", err)
+	}
+	if _, attr, err = o.Lookup(ctx, lf, inodeRecordName(again.Inode)); err != nil || attr.Inode != again.Inode {
+		t.Fatalf("Lookup in lost+found.1 of %d failed: %v", again.Inode, err)
+	}
+}
+
+func TestOortFS_FsckGrace(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	c := createFile(t, api, ctx, 1, "new")
+	if err := api.comms.DeleteGroupItem(ctx, formic.GetID(fsid.Bytes(), 1, 0), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	// Too recent to tell from a create that is still in progress
+	r, err := o.Fsck(ctx, fsid.Bytes(), true, time.Minute, api.fl)
+	if err != nil {
+		t.Fatal("Fsck failed: ", err)
+	}
+	if len(r.Problems) != 0 {
+		t.Errorf("Unexpected problems for inode %d: %v", c.Inode, r.Problems)
+	}
+}
+
+func TestOortFS_FsckBackground(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	if _, err := o.FsckStatus(ctx, fsid.Bytes()); err != ErrNotFound {
+		t.Fatal("Expected ErrNotFound before any fsck, got: ", err)
+	}
+	c := createFile(t, api, ctx, 1, "gone")
+	if err := api.comms.DeleteValue(ctx, formic.GetID(fsid.Bytes(), c.Inode, 0)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := o.StartFsck(ctx, fsid.Bytes(), false, 0, api.fl)
+	if err != nil {
+		t.Fatal("StartFsck failed: ", err)
+	}
+	if r.State != FsckRunning {
+		t.Errorf("Expected the started fsck to be running, got %q", r.State)
+	}
+	for i := 0; r.State == FsckRunning; i++ {
+		if i > 100 {
+			t.Fatal("Fsck didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+		if r, err = o.FsckStatus(ctx, fsid.Bytes()); err != nil {
+			t.Fatal("FsckStatus failed: ", err)
+		}
+	}
+	if r.State != FsckDone || r.Finish == 0 || r.Counts[FsckDanglingEntry] != 1 {
+		t.Errorf("Unexpected report: %+v", r)
+	}
+
+	// Only one fsck of a file system runs at a time, unless the one running
+	// has stopped storing its progress
+	f := o.newFsck(fsid.Bytes(), false, 0, api.fl)
+	if err = f.storeReport(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = o.StartFsck(ctx, fsid.Bytes(), false, 0, api.fl); err != ErrExists {
+		t.Fatal("Expected ErrExists while an fsck is running, got: ", err)
+	}
+	f.report.Updated = brimtime.TimeToUnixMicro(time.Now().Add(-2 * fsckStale))
+	b, _ := json.Marshal(f.report)
+	if err = api.comms.WriteGroup(ctx, fsckReportKey(fsid.Bytes()), []byte("fsck"), b); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = o.FsckStatus(ctx, fsid.Bytes()); err != nil || r.State != FsckFailed {
+		t.Fatalf("Expected the stalled fsck to have failed, got %+v %v", r, err)
+	}
+	if _, err = o.StartFsck(ctx, fsid.Bytes(), false, 0, api.fl); err != nil {
+		t.Fatal("StartFsck after a stalled fsck failed: ", err)
+	}
+}
+
+func TestOortFS_FsckCancel(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := o.Fsck(cctx, fsid.Bytes(), false, 0, api.fl); err != context.Canceled {
+		t.Fatal("Expected the cancelled fsck to stop, got: ", err)
+	}
+}
@@ -6,14 +6,18 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/grpclog"
 
+	"github.com/creiht/formic"
 	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/store"
 	"github.com/pandemicsyn/ftls"
 	"github.com/pandemicsyn/oort/api"
 
@@ -47,41 +51,12 @@ func setupMetrics(listenAddr, enabledCollectors string) {
 		log.Fatalf("Couldn't load collectors: %s", err)
 	}
 	nodeCollector := sysmetrics.New(collectors)
-	prometheus.MustRegister(nodeCollector)
+	prometheus.MustRegister(nodeCollector, corruptChunks, inodeConflicts, scrubInodes, scrubBlocks, scrubProblems, deleteQueueDepth, deleteQueueOldest, deleteAge, updatePending, updateWrites, updateApplied)
 	http.Handle("/metrics", prometheus.Handler())
 	go http.ListenAndServe(listenAddr, nil)
 }
 
-func main() {
-	flag.Parse()
-	if *printVersionInfo {
-		fmt.Println("formicd version:", formicdVersion)
-		fmt.Println("build date:", buildDate)
-		fmt.Println("go version:", goVersion)
-		return
-	}
-
-	cfg := resolveConfig(nil)
-	var logDebug func(formt string, args ...interface{})
-	if cfg.debug {
-		logDebug = func(formt string, args ...interface{}) {
-			if formt != "" && formt[len(formt)-1] == '\n' {
-				formt = "DEBUG: " + formt
-			} else {
-				formt = "DEBUG: " + formt + "\n"
-			}
-			fmt.Printf(formt, args...)
-		}
-	}
-
-	setupMetrics(cfg.metricsAddr, cfg.metricsCollectors)
-
-	var opts []grpc.ServerOption
-	creds, err := credentials.NewServerTLSFromFile(path.Join(cfg.path, "server.crt"), path.Join(cfg.path, "server.key"))
-	FatalIf(err, "Couldn't load cert from file")
-	opts = []grpc.ServerOption{grpc.Creds(creds)}
-	s := grpc.NewServer(opts...)
-
+func newOortStores(cfg *config, logDebug func(string, ...interface{})) (store.ValueStore, store.GroupStore) {
 	var vcOpts []grpc.DialOption
 	vtlsConfig := &ftls.Config{
 		MutualTLS:          !cfg.skipMutualTLS,
@@ -150,6 +125,65 @@ func main() {
 	if gerr := gstore.Startup(context.Background()); gerr != nil {
 		grpclog.Fatalln("Cannot start groupstore connector:", gerr)
 	}
+	return vstore, gstore
+}
+
+func main() {
+	flag.Parse()
+	if *printVersionInfo {
+		fmt.Println("formicd version:", formicdVersion)
+		fmt.Println("build date:", buildDate)
+		fmt.Println("go version:", goVersion)
+		return
+	}
+
+	cfg := resolveConfig(nil)
+	var logDebug func(formt string, args ...interface{})
+	if cfg.debug {
+		logDebug = func(formt string, args ...interface{}) {
+			if formt != "" && formt[len(formt)-1] == '\n' {
+				formt = "DEBUG: " + formt
+			} else {
+				formt = "DEBUG: " + formt + "\n"
+			}
+			fmt.Printf(formt, args...)
+		}
+	}
+
+	setupMetrics(cfg.metricsAddr, cfg.metricsCollectors)
+
+	var opts []grpc.ServerOption
+	creds, err := credentials.NewServerTLSFromFile(path.Join(cfg.path, "server.crt"), path.Join(cfg.path, "server.key"))
+	FatalIf(err, "Couldn't load cert from file")
+	opts = []grpc.ServerOption{
+		grpc.Creds(creds),
+		// Sends the errnos of errors from the Api service in trailers
+		grpc.UnaryInterceptor(formic.UnaryServerInterceptor),
+		grpc.StreamInterceptor(formic.StreamServerInterceptor),
+	}
+	s := grpc.NewServer(opts...)
+
+	var vstore store.ValueStore
+	var gstore store.GroupStore
+	switch cfg.backend {
+	case "memory":
+		grpclog.Println("Using in memory backend, nothing will be persisted")
+		vstore = NewMemValueStore()
+		gstore = NewMemGroupStore()
+	case "disk":
+		vstore = NewDiskValueStore(path.Join(cfg.path, "data/valuestore.log"))
+		if verr := vstore.Startup(context.Background()); verr != nil {
+			grpclog.Fatalln("Cannot start disk valuestore:", verr)
+		}
+		gstore = NewDiskGroupStore(path.Join(cfg.path, "data/groupstore.log"))
+		if gerr := gstore.Startup(context.Background()); gerr != nil {
+			grpclog.Fatalln("Cannot start disk groupstore:", gerr)
+		}
+	case "oort":
+		vstore, gstore = newOortStores(cfg, logDebug)
+	default:
+		grpclog.Fatalln("Unknown backend:", cfg.backend)
+	}
 
 	// starting up formicd
 	comms, err := NewStoreComms(vstore, gstore)
@@ -157,10 +191,42 @@ func main() {
 		grpclog.Fatalln(err)
 	}
 	fs := NewOortFS(comms)
+	if err = fs.RecoverCreates(context.Background()); err != nil {
+		grpclog.Println("Couldn't finish interrupted creates:", err)
+	}
+	if err = fs.RecoverRenames(context.Background()); err != nil {
+		grpclog.Println("Couldn't finish interrupted renames:", err)
+	}
+	if cfg.scrubInterval > 0 {
+		scrubber := newScrubber(fs, comms, cfg.scrubInterval, cfg.nodeId)
+		go scrubber.run()
+	}
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.port))
 	FatalIf(err, "Failed to bind formicd to port")
-	pb.RegisterFileSystemAPIServer(s, NewFileSystemAPIServer(gstore))
-	pb.RegisterApiServer(s, NewApiServer(fs, cfg.nodeId, comms))
+	server := NewApiServer(fs, cfg.nodeId, comms)
+	pb.RegisterFileSystemAPIServer(s, NewFileSystemAPIServer(gstore, fs, server.fl))
+	if cfg.concurrentRequestsPerStore > 0 {
+		server.blockConcurrency = cfg.concurrentRequestsPerStore
+	}
+	pb.RegisterApiServer(s, server)
+	go shutdownOnSignal(s, vstore, gstore)
 	grpclog.Printf("Starting up formic and the file system api on %d...\n", cfg.port)
 	s.Serve(l)
 }
+
+// shutdownOnSignal stops serving and shuts the stores down, so that everything
+// they hold is on disk, when formicd is asked to stop.
+func shutdownOnSignal(s *grpc.Server, vstore store.ValueStore, gstore store.GroupStore) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	grpclog.Printf("Shutting down on %s", sig)
+	s.Stop()
+	if err := vstore.Shutdown(context.Background()); err != nil {
+		grpclog.Println("Couldn't shut down the value store:", err)
+	}
+	if err := gstore.Shutdown(context.Background()); err != nil {
+		grpclog.Println("Couldn't shut down the group store:", err)
+	}
+	os.Exit(0)
+}
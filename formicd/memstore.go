@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/gholt/store"
+	"golang.org/x/net/context"
+)
+
+// In process implementations of store.ValueStore and store.GroupStore.
+// These follow the same timestamp rules as oort: a write or delete only takes
+// effect if its timestamp is newer than what is already stored, and deletes
+// leave a tombstone behind so that older writes can't resurrect the value.
+
+type errMemNotFound struct{}
+
+func (e *errMemNotFound) Error() string {
+	return "not found"
+}
+
+func (e *errMemNotFound) ErrNotFound() string {
+	return "not found"
+}
+
+var errMemNotFoundValue = &errMemNotFound{}
+
+type memKey struct {
+	keyA uint64
+	keyB uint64
+}
+
+type memItem struct {
+	timestampMicro int64
+	value          []byte
+	deleted        bool
+}
+
+type memStats struct {
+	values     int
+	tombstones int
+}
+
+func (s *memStats) String() string {
+	return fmt.Sprintf("values: %d tombstones: %d", s.values, s.tombstones)
+}
+
+// memValues holds the items for a single key space and applies the
+// newer-timestamp-wins rules. Callers must hold the owning store's lock.
+type memValues map[memKey]*memItem
+
+func (m memValues) lookup(k memKey) (int64, uint32, error) {
+	item, ok := m[k]
+	if !ok {
+		return 0, 0, errMemNotFoundValue
+	}
+	if item.deleted {
+		return item.timestampMicro, 0, errMemNotFoundValue
+	}
+	return item.timestampMicro, uint32(len(item.value)), nil
+}
+
+func (m memValues) read(k memKey, value []byte) (int64, []byte, error) {
+	item, ok := m[k]
+	if !ok {
+		return 0, value, errMemNotFoundValue
+	}
+	if item.deleted {
+		return item.timestampMicro, value, errMemNotFoundValue
+	}
+	return item.timestampMicro, append(value, item.value...), nil
+}
+
+func (m memValues) write(k memKey, timestampMicro int64, value []byte, deleted bool) int64 {
+	item, ok := m[k]
+	if ok {
+		if item.timestampMicro >= timestampMicro {
+			return item.timestampMicro
+		}
+	} else {
+		item = &memItem{}
+		m[k] = item
+	}
+	old := item.timestampMicro
+	item.timestampMicro = timestampMicro
+	item.deleted = deleted
+	if deleted {
+		item.value = nil
+	} else {
+		item.value = append([]byte(nil), value...)
+	}
+	return old
+}
+
+func (m memValues) stats() *memStats {
+	s := &memStats{}
+	for _, item := range m {
+		if item.deleted {
+			s.tombstones++
+		} else {
+			s.values++
+		}
+	}
+	return s
+}
+
+type memValueStore struct {
+	sync.RWMutex
+	values memValues
+}
+
+func NewMemValueStore() *memValueStore {
+	return &memValueStore{
+		values: make(memValues),
+	}
+}
+
+func (s *memValueStore) Startup(ctx context.Context) error {
+	return nil
+}
+
+func (s *memValueStore) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (s *memValueStore) EnableWrites(ctx context.Context) error {
+	return nil
+}
+
+func (s *memValueStore) DisableWrites(ctx context.Context) error {
+	return nil
+}
+
+func (s *memValueStore) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *memValueStore) AuditPass(ctx context.Context) error {
+	return nil
+}
+
+func (s *memValueStore) Stats(ctx context.Context, debug bool) (fmt.Stringer, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.values.stats(), nil
+}
+
+func (s *memValueStore) ValueCap(ctx context.Context) (uint32, error) {
+	return math.MaxUint32, nil
+}
+
+func (s *memValueStore) Lookup(ctx context.Context, keyA, keyB uint64) (int64, uint32, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.values.lookup(memKey{keyA, keyB})
+}
+
+func (s *memValueStore) Read(ctx context.Context, keyA, keyB uint64, value []byte) (int64, []byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.values.read(memKey{keyA, keyB}, value)
+}
+
+func (s *memValueStore) Write(ctx context.Context, keyA, keyB uint64, timestampMicro int64, value []byte) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.values.write(memKey{keyA, keyB}, timestampMicro, value, false), nil
+}
+
+func (s *memValueStore) Delete(ctx context.Context, keyA, keyB uint64, timestampMicro int64) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.values.write(memKey{keyA, keyB}, timestampMicro, nil, true), nil
+}
+
+type memGroupStore struct {
+	sync.RWMutex
+	groups map[memKey]memValues
+}
+
+func NewMemGroupStore() *memGroupStore {
+	return &memGroupStore{
+		groups: make(map[memKey]memValues),
+	}
+}
+
+func (s *memGroupStore) Startup(ctx context.Context) error {
+	return nil
+}
+
+func (s *memGroupStore) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (s *memGroupStore) EnableWrites(ctx context.Context) error {
+	return nil
+}
+
+func (s *memGroupStore) DisableWrites(ctx context.Context) error {
+	return nil
+}
+
+func (s *memGroupStore) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *memGroupStore) AuditPass(ctx context.Context) error {
+	return nil
+}
+
+func (s *memGroupStore) Stats(ctx context.Context, debug bool) (fmt.Stringer, error) {
+	s.RLock()
+	defer s.RUnlock()
+	total := &memStats{}
+	for _, group := range s.groups {
+		gs := group.stats()
+		total.values += gs.values
+		total.tombstones += gs.tombstones
+	}
+	return total, nil
+}
+
+func (s *memGroupStore) ValueCap(ctx context.Context) (uint32, error) {
+	return math.MaxUint32, nil
+}
+
+func (s *memGroupStore) group(parentKeyA, parentKeyB uint64, create bool) memValues {
+	k := memKey{parentKeyA, parentKeyB}
+	g, ok := s.groups[k]
+	if !ok && create {
+		g = make(memValues)
+		s.groups[k] = g
+	}
+	return g
+}
+
+func (s *memGroupStore) Lookup(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64) (int64, uint32, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.group(parentKeyA, parentKeyB, false).lookup(memKey{childKeyA, childKeyB})
+}
+
+func (s *memGroupStore) Read(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64, value []byte) (int64, []byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.group(parentKeyA, parentKeyB, false).read(memKey{childKeyA, childKeyB}, value)
+}
+
+func (s *memGroupStore) Write(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64, timestampMicro int64, value []byte) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.group(parentKeyA, parentKeyB, true).write(memKey{childKeyA, childKeyB}, timestampMicro, value, false), nil
+}
+
+func (s *memGroupStore) Delete(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64, timestampMicro int64) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.group(parentKeyA, parentKeyB, true).write(memKey{childKeyA, childKeyB}, timestampMicro, nil, true), nil
+}
+
+func (s *memGroupStore) LookupGroup(ctx context.Context, parentKeyA, parentKeyB uint64) ([]store.LookupGroupItem, error) {
+	s.RLock()
+	defer s.RUnlock()
+	g := s.group(parentKeyA, parentKeyB, false)
+	items := make([]store.LookupGroupItem, 0, len(g))
+	for k, item := range g {
+		if item.deleted {
+			continue
+		}
+		items = append(items, store.LookupGroupItem{
+			ChildKeyA:      k.keyA,
+			ChildKeyB:      k.keyB,
+			TimestampMicro: item.timestampMicro,
+			Length:         uint32(len(item.value)),
+		})
+	}
+	return items, nil
+}
+
+func (s *memGroupStore) ReadGroup(ctx context.Context, parentKeyA, parentKeyB uint64) ([]store.ReadGroupItem, error) {
+	s.RLock()
+	defer s.RUnlock()
+	g := s.group(parentKeyA, parentKeyB, false)
+	items := make([]store.ReadGroupItem, 0, len(g))
+	for k, item := range g {
+		if item.deleted {
+			continue
+		}
+		items = append(items, store.ReadGroupItem{
+			ChildKeyA:      k.keyA,
+			ChildKeyB:      k.keyB,
+			TimestampMicro: item.timestampMicro,
+			Value:          append([]byte(nil), item.value...),
+		})
+	}
+	return items, nil
+}
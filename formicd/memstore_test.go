@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"golang.org/x/net/context"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/getcfs/fuse"
+	"github.com/gholt/store"
+	"github.com/satori/go.uuid"
+	"github.com/spaolacci/murmur3"
+)
+
+func TestMemValueStore_Timestamps(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemValueStore()
+	_, _, err := s.Read(ctx, 1, 2, nil)
+	if !store.IsNotFound(err) {
+		t.Fatal("Expected not found, got: ", err)
+	}
+	old, err := s.Write(ctx, 1, 2, 10, []byte("first"))
+	if err != nil || old != 0 {
+		t.Fatalf("Write returned %d, %v", old, err)
+	}
+	old, err = s.Write(ctx, 1, 2, 5, []byte("older"))
+	if err != nil || old != 10 {
+		t.Fatalf("Older write returned %d, %v", old, err)
+	}
+	ts, v, err := s.Read(ctx, 1, 2, nil)
+	if err != nil || ts != 10 || !bytes.Equal(v, []byte("first")) {
+		t.Fatalf("Read returned %d, %q, %v", ts, v, err)
+	}
+	old, err = s.Delete(ctx, 1, 2, 20)
+	if err != nil || old != 10 {
+		t.Fatalf("Delete returned %d, %v", old, err)
+	}
+	ts, _, err = s.Read(ctx, 1, 2, nil)
+	if !store.IsNotFound(err) || ts != 20 {
+		t.Fatalf("Read after delete returned %d, %v", ts, err)
+	}
+	// The tombstone should keep older writes from resurrecting the value
+	old, _ = s.Write(ctx, 1, 2, 15, []byte("resurrect"))
+	if old != 20 {
+		t.Fatal("Expected tombstone timestamp, got: ", old)
+	}
+	if _, _, err = s.Read(ctx, 1, 2, nil); !store.IsNotFound(err) {
+		t.Fatal("Expected not found, got: ", err)
+	}
+}
+
+func TestMemGroupStore_ReadGroup(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemGroupStore()
+	s.Write(ctx, 1, 1, 1, 1, 10, []byte("a"))
+	s.Write(ctx, 1, 1, 2, 2, 10, []byte("b"))
+	s.Write(ctx, 2, 2, 1, 1, 10, []byte("other"))
+	s.Delete(ctx, 1, 1, 2, 2, 20)
+	items, err := s.ReadGroup(ctx, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !bytes.Equal(items[0].Value, []byte("a")) {
+		t.Fatalf("Unexpected group items: %v", items)
+	}
+	lookups, err := s.LookupGroup(ctx, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lookups) != 1 || lookups[0].Length != 1 || lookups[0].TimestampMicro != 10 {
+		t.Fatalf("Unexpected lookup items: %v", lookups)
+	}
+	if _, _, err = s.Read(ctx, 1, 1, 2, 2, nil); !store.IsNotFound(err) {
+		t.Fatal("Expected not found, got: ", err)
+	}
+}
+
+// newMemApiServer returns an apiServer backed by OortFS on the in memory
+// stores along with a context for a filesystem that the peer can access.
+func newMemApiServer(t *testing.T) (*apiServer, context.Context) {
+	comms, err := NewStoreComms(NewMemValueStore(), NewMemGroupStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsid := uuid.NewV4()
+	c, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	// The root directory is owned by 1001
+	c = metadata.NewContext(c, metadata.Pairs("fsid", fsid.String(), "uid", "1001", "gid", "1001"))
+	c = peer.NewContext(c, &peer.Peer{Addr: fakePeerAddr{}})
+	ip := "127.0.0.1"
+	err = comms.WriteGroup(c, []byte(fmt.Sprintf("/fs/%s/addr", fsid.String())), []byte(ip), []byte(ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Listed as CreateFS would, for the tasks that go through every fs
+	ref, _ := json.Marshal(&FileSysRef{FSID: fsid.String()})
+	if err = comms.WriteGroup(c, []byte("/fs"), []byte(fsid.String()), ref); err != nil {
+		t.Fatal(err)
+	}
+	api := NewApiServer(NewOortFS(comms), 1, comms)
+	if _, err = api.InitFs(c, &pb.InitFsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	return api, c
+}
+
+func TestOortFS_CreateWriteRead(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	c, err := api.Create(ctx, &pb.CreateRequest{Parent: 1, Name: "test", Attr: &pb.Attr{Mode: 0644}})
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	l, err := api.Lookup(ctx, &pb.LookupRequest{Parent: 1, Name: "test"})
+	if err != nil {
+		t.Fatal("Lookup failed: ", err)
+	}
+	if l.Name != "test" || l.Attr.Inode != c.Attr.Inode {
+		t.Fatalf("Lookup returned %s %d", l.Name, l.Attr.Inode)
+	}
+	payload := []byte("hello world")
+	_, err = api.Write(ctx, &pb.WriteRequest{Inode: c.Attr.Inode, Offset: 0, Payload: payload})
+	if err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Attr.Inode, Offset: 0, Size: int64(len(payload))})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	if !bytes.Equal(r.Payload, payload) {
+		t.Errorf("Expected read: '%s' received: '%s'", payload, r.Payload)
+	}
+	d, err := api.ReadDirAll(ctx, &pb.ReadDirAllRequest{Inode: 1})
+	if err != nil {
+		t.Fatal("ReadDirAll failed: ", err)
+	}
+	if len(d.DirEntries) != 1 || d.DirEntries[0].Name != "test" {
+		t.Errorf("Unexpected dir entries: %v", d.DirEntries)
+	}
+}
+
+func TestOortFS_Link(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	c, err := api.Create(ctx, &pb.CreateRequest{Parent: 1, Name: "orig", Attr: &pb.Attr{Mode: 0644}})
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	payload := []byte("linked data")
+	if _, err = api.Write(ctx, &pb.WriteRequest{Inode: c.Attr.Inode, Offset: 0, Payload: payload}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	// Wait for the updatinator so that it can't clobber the link count
+	for i := 0; i < 100; i++ {
+		a, _ := api.GetAttr(ctx, &pb.GetAttrRequest{Inode: c.Attr.Inode})
+		if a.Attr.Size == uint64(len(payload)) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	l, err := api.Link(ctx, &pb.LinkRequest{Parent: 1, Name: "link", Inode: c.Attr.Inode})
+	if err != nil {
+		t.Fatal("Link failed: ", err)
+	}
+	if l.Name != "link" || l.Attr.Inode != c.Attr.Inode || l.Attr.Nlink != 2 {
+		t.Fatalf("Link returned %s %d nlink %d", l.Name, l.Attr.Inode, l.Attr.Nlink)
+	}
+	_, err = api.Link(ctx, &pb.LinkRequest{Parent: 1, Name: "orig", Inode: c.Attr.Inode})
+	if formic.Errno(err) != syscall.EEXIST {
+		t.Fatal("Expected EEXIST linking over an existing name, got: ", err)
+	}
+	if _, err = api.Remove(ctx, &pb.RemoveRequest{Parent: 1, Name: "orig"}); err != nil {
+		t.Fatal("Remove failed: ", err)
+	}
+	_, err = api.Lookup(ctx, &pb.LookupRequest{Parent: 1, Name: "orig"})
+	if formic.Errno(err) != syscall.ENOENT {
+		t.Fatal("Expected ENOENT for removed name, got: ", err)
+	}
+	lookup, err := api.Lookup(ctx, &pb.LookupRequest{Parent: 1, Name: "link"})
+	if err != nil {
+		t.Fatal("Lookup failed: ", err)
+	}
+	if lookup.Attr.Inode != c.Attr.Inode || lookup.Attr.Nlink != 1 {
+		t.Fatalf("Lookup returned inode %d nlink %d", lookup.Attr.Inode, lookup.Attr.Nlink)
+	}
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Attr.Inode, Offset: 0, Size: int64(len(payload))})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	if !bytes.Equal(r.Payload, payload) {
+		t.Errorf("Expected read: '%s' received: '%s'", payload, r.Payload)
+	}
+	d, err := api.ReadDirAll(ctx, &pb.ReadDirAllRequest{Inode: 1})
+	if err != nil {
+		t.Fatal("ReadDirAll failed: ", err)
+	}
+	if len(d.DirEntries) != 1 || d.DirEntries[0].Name != "link" {
+		t.Errorf("Unexpected dir entries: %v", d.DirEntries)
+	}
+}
+
+func TestOortFS_RemoveDir(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	m, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "dir", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	_, err = api.Create(ctx, &pb.CreateRequest{Parent: m.Attr.Inode, Name: "child", Attr: &pb.Attr{Mode: 0644}})
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	_, err = api.Remove(ctx, &pb.RemoveRequest{Parent: 1, Name: "dir", Dir: true})
+	if formic.Errno(err) != syscall.ENOTEMPTY {
+		t.Fatal("Expected ENOTEMPTY, got: ", err)
+	}
+	_, err = api.Remove(ctx, &pb.RemoveRequest{Parent: 1, Name: "dir"})
+	if formic.Errno(err) != syscall.EISDIR {
+		t.Fatal("Expected EISDIR, got: ", err)
+	}
+	_, err = api.Remove(ctx, &pb.RemoveRequest{Parent: m.Attr.Inode, Name: "child"})
+	if err != nil {
+		t.Fatal("Remove of child failed: ", err)
+	}
+	_, err = api.Remove(ctx, &pb.RemoveRequest{Parent: 1, Name: "dir", Dir: true})
+	if err != nil {
+		t.Fatal("Remove of empty dir failed: ", err)
+	}
+	_, err = api.Lookup(ctx, &pb.LookupRequest{Parent: 1, Name: "dir"})
+	if formic.Errno(err) != syscall.ENOENT {
+		t.Fatal("Expected ENOENT for removed dir, got: ", err)
+	}
+}
+
+func TestApiServer_Errnos(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	name := string(bytes.Repeat([]byte("a"), 256))
+	_, err := api.Create(ctx, &pb.CreateRequest{Parent: 1, Name: name, Attr: &pb.Attr{Mode: 0644}})
+	if formic.Errno(err) != syscall.ENAMETOOLONG {
+		t.Fatal("Expected ENAMETOOLONG, got: ", err)
+	}
+	if _, err = api.Create(ctx, &pb.CreateRequest{Parent: 1, Name: name[:255], Attr: &pb.Attr{Mode: 0644}}); err != nil {
+		t.Fatal("Expected a name of NAME_MAX to be allowed, got: ", err)
+	}
+	_, err = api.Getxattr(ctx, &pb.GetxattrRequest{Inode: 1, Name: "user.missing"})
+	if formic.Errno(err) != syscall.ENODATA {
+		t.Fatal("Expected ENODATA, got: ", err)
+	}
+	_, err = api.GetAttr(ctx, &pb.GetAttrRequest{Inode: 12345})
+	if formic.Errno(err) != syscall.ENOENT {
+		t.Fatal("Expected ENOENT, got: ", err)
+	}
+	// Other failed preconditions aren't taken for ENOTEMPTY
+	err = grpc.Errorf(codes.FailedPrecondition, "Account Mismatch")
+	if formic.Errno(err) != syscall.EIO {
+		t.Fatal("Expected EIO, got: ", formic.Errno(err))
+	}
+}
+
+// corruptValueStore flips a bit in the values it reads for a key, for as many
+// reads as it has been told to.
+type corruptValueStore struct {
+	*memValueStore
+	sync.Mutex
+	bad map[memKey]int
+}
+
+func (s *corruptValueStore) corrupt(id []byte, reads int) {
+	keyA, keyB := murmur3.Sum128(id)
+	s.Lock()
+	s.bad[memKey{keyA, keyB}] = reads
+	s.Unlock()
+}
+
+func (s *corruptValueStore) Read(ctx context.Context, keyA, keyB uint64, value []byte) (int64, []byte, error) {
+	ts, v, err := s.memValueStore.Read(ctx, keyA, keyB, value)
+	s.Lock()
+	defer s.Unlock()
+	if err == nil && s.bad[memKey{keyA, keyB}] > 0 {
+		s.bad[memKey{keyA, keyB}]--
+		v[len(v)-1] ^= 1
+	}
+	return ts, v, err
+}
+
+func TestOortFS_Checksum(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	vstore := &corruptValueStore{memValueStore: api.comms.vstore.(*memValueStore), bad: make(map[memKey]int)}
+	api.comms.vstore = vstore
+	c := createFile(t, api, ctx, 1, "file")
+	payload := []byte("hello world")
+	if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: payload}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	waitForSize(t, api, ctx, c.Inode, uint64(len(payload)))
+	fsid, _ := GetFsId(ctx)
+	id := formic.GetID(fsid.Bytes(), c.Inode, 1)
+	// A single bad read is retried
+	vstore.corrupt(id, 1)
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: int64(len(payload))})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	if !bytes.Equal(r.Payload, payload) {
+		t.Errorf("Expected read: '%s' received: '%s'", payload, r.Payload)
+	}
+	vstore.corrupt(id, 1+corruptRereads)
+	if _, err = api.fs.GetChunk(ctx, id); err != ErrCorrupt {
+		t.Fatal("Expected ErrCorrupt, got: ", err)
+	}
+	vstore.corrupt(id, 1+corruptRereads)
+	_, err = api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: int64(len(payload))})
+	if formic.Errno(err) != syscall.EIO {
+		t.Fatal("Expected EIO, got: ", err)
+	}
+}
+
+// downGroupStore fails every write, as a store that can't be reached would.
+type downGroupStore struct {
+	*memGroupStore
+}
+
+func (s *downGroupStore) Write(ctx context.Context, parentKeyA, parentKeyB, childKeyA, childKeyB uint64, timestampMicro int64, value []byte) (int64, error) {
+	return 0, errors.New("store is down")
+}
+
+func TestStoreComms_WriteGroupError(t *testing.T) {
+	comms, err := NewStoreComms(NewMemValueStore(), &downGroupStore{memGroupStore: NewMemGroupStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = comms.WriteGroup(context.Background(), []byte("group"), []byte("child"), []byte("value")); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+}
+
+// slowFS counts how many block operations are running at once and fails the
+// ones it is told to.
+type slowFS struct {
+	FileService
+	sync.Mutex
+	running int
+	peak    int
+	fail    map[string]bool
+}
+
+var errSlowFS = errors.New("injected failure")
+
+func (fs *slowFS) start(id []byte) error {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.running++
+	if fs.running > fs.peak {
+		fs.peak = fs.running
+	}
+	if fs.fail[string(id)] {
+		return errSlowFS
+	}
+	return nil
+}
+
+func (fs *slowFS) done() {
+	time.Sleep(5 * time.Millisecond)
+	fs.Lock()
+	fs.running--
+	fs.Unlock()
+}
+
+func (fs *slowFS) GetChunk(ctx context.Context, id []byte) ([]byte, error) {
+	defer fs.done()
+	if err := fs.start(id); err != nil {
+		return nil, err
+	}
+	return fs.FileService.GetChunk(ctx, id)
+}
+
+func (fs *slowFS) WriteChunk(ctx context.Context, id, data []byte) error {
+	defer fs.done()
+	if err := fs.start(id); err != nil {
+		return err
+	}
+	return fs.FileService.WriteChunk(ctx, id, data)
+}
+
+func TestApiServer_ParallelBlocks(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	fs := &slowFS{FileService: api.fs, fail: make(map[string]bool)}
+	api.fs = fs
+	api.blocksize = 10
+	api.blockConcurrency = 4
+	c := createFile(t, api, ctx, 1, "parallel")
+	fsid, _ := GetFsId(ctx)
+	blockID := func(block uint64) []byte {
+		return formic.GetID(fsid.Bytes(), c.Inode, block+1)
+	}
+	data := make([]byte, 95)
+	for i := range data {
+		data[i] = byte('a' + i%26)
+	}
+	if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Offset: 3, Payload: data}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	if fs.peak < 2 || fs.peak > 4 {
+		t.Fatalf("Expected between 2 and 4 blocks at once, got %d", fs.peak)
+	}
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Offset: 3, Size: 200})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	if !bytes.Equal(r.Payload, data) {
+		t.Fatalf("Expected read: '%s' received: '%s'", data, r.Payload)
+	}
+	// A failure part way through is a short read
+	fs.fail[string(blockID(4))] = true
+	r, err = api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Offset: 3, Size: 95})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	if !bytes.Equal(r.Payload, data[:37]) {
+		t.Fatalf("Expected a short read of '%s' received: '%s'", data[:37], r.Payload)
+	}
+	// but a failure of the first block is an error
+	fs.fail[string(blockID(0))] = true
+	if _, err = api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: 95}); formic.Errno(err) != syscall.EIO {
+		t.Fatal("Expected EIO, got: ", err)
+	}
+	// The blocks that can be written still are when others fail
+	fs.fail = map[string]bool{string(blockID(2)): true}
+	w, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Offset: 0, Payload: bytes.Repeat([]byte("z"), 40)})
+	if formic.Errno(err) != syscall.EIO || w.Status != 1 {
+		t.Fatal("Expected EIO and a status of 1, got: ", w, err)
+	}
+	fs.fail = map[string]bool{}
+	r, err = api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Offset: 0, Size: 40})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	want := append(bytes.Repeat([]byte("z"), 20), data[17:27]...)
+	want = append(want, bytes.Repeat([]byte("z"), 10)...)
+	if !bytes.Equal(r.Payload, want) {
+		t.Fatalf("Expected read: '%s' received: '%s'", want, r.Payload)
+	}
+}
+
+func TestApiServer_BlockSize(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	fsid, _ := GetFsId(ctx)
+	b, _ := json.Marshal(&FileSysAttr{Attr: "blocksize", Value: "16", FSID: fsid.String()})
+	if err := api.comms.WriteGroup(ctx, []byte(fmt.Sprintf("/fs/%s", fsid)), []byte("blocksize"), b); err != nil {
+		t.Fatal(err)
+	}
+	// Written with the file system's block size
+	c := createFile(t, api, ctx, 1, "sixteen")
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCD")
+	if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: data}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	chunk, err := api.fs.GetChunk(ctx, formic.GetID(fsid.Bytes(), c.Inode, 1))
+	if err != nil || !bytes.Equal(chunk, data[:16]) {
+		t.Fatalf("Expected the first block to be '%s', got '%s': %v", data[:16], chunk, err)
+	}
+	waitForBlockSize(t, api, ctx, c.Inode, 16)
+
+	// Files keep the block size that they were written with
+	api, ctx = newMemApiServer(t)
+	api.blocksize = 10
+	c = createFile(t, api, ctx, 1, "ten")
+	if _, err = api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: data}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	waitForBlockSize(t, api, ctx, c.Inode, 10)
+	api.blocksize = 1024 * 64
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: 100})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	if !bytes.Equal(r.Payload, data) {
+		t.Fatalf("Expected read: '%s' received: '%s'", data, r.Payload)
+	}
+	// but new files get the new default
+	c = createFile(t, api, ctx, 1, "default")
+	if _, err = api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: data}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	waitForBlockSize(t, api, ctx, c.Inode, 1024*64)
+}
+
+// waitForBlockSize waits for the updatinator to record the block size of the
+// inode.
+func waitForBlockSize(t *testing.T, api *apiServer, ctx context.Context, inode, blocksize uint64) {
+	fsid, _ := GetFsId(ctx)
+	var n *pb.InodeEntry
+	for i := 0; i < 100; i++ {
+		n, _ = api.fs.GetInode(ctx, formic.GetID(fsid.Bytes(), inode, 0))
+		if n != nil && n.BlockSize != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n == nil || n.BlockSize != blocksize {
+		t.Fatalf("Expected block size %d, got: %v", blocksize, n)
+	}
+}
+
+func TestOortFS_Truncate(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	fsid, _ := GetFsId(ctx)
+	c := createFile(t, api, ctx, 1, "truncate")
+	id := formic.GetID(fsid.Bytes(), c.Inode, 0)
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxy")
+	if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: data}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	waitForSize(t, api, ctx, c.Inode, uint64(len(data)))
+	_, err := api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: c.Inode, Size: 12}, Valid: uint32(fuse.SetattrSize)})
+	if err != nil {
+		t.Fatal("SetAttr failed: ", err)
+	}
+	n, _ := api.fs.GetInode(ctx, id)
+	if n.Attr.Size != 12 || n.Blocks != 2 || n.LastBlock != 2 {
+		t.Fatalf("Expected size 12 in 2 blocks with 2 in the last, got: %v", n)
+	}
+	chunk, err := api.fs.GetChunk(ctx, formic.GetID(fsid.Bytes(), c.Inode, 2))
+	if err != nil || !bytes.Equal(chunk, data[10:12]) {
+		t.Fatalf("Expected the last block to be cut to '%s', got '%s': %v", data[10:12], chunk, err)
+	}
+	// The Deletinator frees the rest
+	for _, block := range []uint64{3, 4} {
+		for i := 0; i < 100; i++ {
+			if _, err = api.fs.GetChunk(ctx, formic.GetID(fsid.Bytes(), c.Inode, block)); err == ErrNotFound {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != ErrNotFound {
+			t.Fatalf("Expected block %d to be deleted, got: %v", block-1, err)
+		}
+	}
+	// Extending doesn't bring back the old data
+	_, err = api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: c.Inode, Size: 30}, Valid: uint32(fuse.SetattrSize)})
+	if err != nil {
+		t.Fatal("SetAttr failed: ", err)
+	}
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: 100})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	want := append(append([]byte{}, data[:12]...), make([]byte, 18)...)
+	if !bytes.Equal(r.Payload, want) {
+		t.Fatalf("Expected read: %v received: %v", want, r.Payload)
+	}
+	// and writing inside the file doesn't shrink it
+	if _, err = api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Offset: 21, Payload: []byte("z")}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	n, _ = api.fs.GetInode(ctx, id)
+	if n.Attr.Size != 30 || n.Blocks != 3 || n.LastBlock != 10 {
+		t.Fatalf("Expected size 30 in 3 blocks, got: %v", n)
+	}
+}
+
+func TestOortFS_TruncateBeforeDelete(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	o := api.fs.(*OortFS)
+	// A Deletinator that never gets to the truncated blocks
+	o.deletes = newDeletinator(o, api.comms, 1)
+	c := createFile(t, api, ctx, 1, "truncate")
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxy")
+	if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: data}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	waitForSize(t, api, ctx, c.Inode, uint64(len(data)))
+	for _, size := range []uint64{5, 35} {
+		_, err := api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: c.Inode, Size: size}, Valid: uint32(fuse.SetattrSize)})
+		if err != nil {
+			t.Fatal("SetAttr failed: ", err)
+		}
+	}
+	// Writing part of a block that was cut off doesn't bring the rest back
+	if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Offset: 22, Payload: []byte("z")}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	want := append(append([]byte{}, data[:5]...), make([]byte, 30)...)
+	want[22] = 'z'
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: 100})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	if !bytes.Equal(r.Payload, want) {
+		t.Errorf("Expected read: %v received: %v", want, r.Payload)
+	}
+	rs := &fakeReadStream{ctx: ctx}
+	if err = api.ReadStream(&pb.ReadRequest{Inode: c.Inode, Size: 100}, rs); err != nil {
+		t.Fatal("ReadStream failed: ", err)
+	}
+	var got []byte
+	for _, resp := range rs.resps {
+		got = append(got, resp.Payload...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected stream read: %v received: %v", want, got)
+	}
+}
+
+func TestOortFS_UpdateInodeConflict(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	c := createFile(t, api, ctx, 1, "file")
+	fsid, _ := GetFsId(ctx)
+	id := formic.GetID(fsid.Bytes(), c.Inode, 0)
+	calls := 0
+	n, err := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+		calls++
+		if calls == 1 {
+			// Someone else gets their update in first
+			if _, err := o.Setxattr(ctx, id, "user.other", []byte("x")); err != nil {
+				t.Fatal("Setxattr failed: ", err)
+			}
+		}
+		n.Attr.Mode = 0600
+		return nil
+	})
+	if err != nil {
+		t.Fatal("UpdateInode failed: ", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the update to be made twice, got %d", calls)
+	}
+	if n.Attr.Mode != 0600 || string(n.Xattr["user.other"]) != "x" {
+		t.Errorf("Expected both updates, got: %v", n)
+	}
+	n, _ = o.GetInode(ctx, id)
+	if n.Attr.Mode != 0600 || string(n.Xattr["user.other"]) != "x" {
+		t.Errorf("Expected both updates to be stored, got: %v", n)
+	}
+}
+
+func TestOortFS_ConcurrentInodeUpdates(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	c := createFile(t, api, ctx, 1, "file")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := api.Setxattr(ctx, &pb.SetxattrRequest{Inode: c.Inode, Name: fmt.Sprintf("user.%d", i), Value: []byte("v")})
+			if err != nil {
+				t.Error("Setxattr failed: ", err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			_, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Offset: int64(i) * 10, Payload: []byte("0123456789")})
+			if err != nil {
+				t.Error("Write failed: ", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	fsid, _ := GetFsId(ctx)
+	n, err := api.fs.GetInode(ctx, formic.GetID(fsid.Bytes(), c.Inode, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Xattr) != 10 {
+		t.Errorf("Expected 10 xattrs, got %v", n.Xattr)
+	}
+	if n.Attr.Size != 100 {
+		t.Errorf("Expected size 100, got %d", n.Attr.Size)
+	}
+}
+
+// waitForSize waits for the updatinator to catch up with the size of inode.
+func waitForSize(t *testing.T, api *apiServer, ctx context.Context, inode, size uint64) {
+	var a *pb.GetAttrResponse
+	for i := 0; i < 100; i++ {
+		a, _ = api.GetAttr(ctx, &pb.GetAttrRequest{Inode: inode})
+		if a != nil && a.Attr.Size == size {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected size %d, got: %v", size, a)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/creiht/formic/proto"
+	"golang.org/x/net/context"
+)
+
+var ErrAccess = errors.New("Permission denied")
+var ErrPerm = errors.New("Operation not permitted")
+
+// Permission bits to check for, these match the values used by access(2)
+const (
+	MayExec  = 1
+	MayWrite = 2
+	MayRead  = 4
+)
+
+// Requests that don't say who they are on behalf of are treated as coming
+// from nobody
+const (
+	NobodyUid = 65534
+	NobodyGid = 65534
+)
+
+// Creds are the user and groups of the process making a request.
+type Creds struct {
+	Uid  uint32
+	Gid  uint32
+	Gids []uint32
+}
+
+// GetCreds returns the credentials sent in the uid, gid and gids metadata.
+func GetCreds(ctx context.Context) *Creds {
+	c := &Creds{Uid: NobodyUid, Gid: NobodyGid}
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return c
+	}
+	if v, ok := md["uid"]; ok {
+		if uid, err := strconv.ParseUint(v[0], 10, 32); err == nil {
+			c.Uid = uint32(uid)
+		}
+	}
+	if v, ok := md["gid"]; ok {
+		if gid, err := strconv.ParseUint(v[0], 10, 32); err == nil {
+			c.Gid = uint32(gid)
+		}
+	}
+	for _, v := range md["gids"] {
+		if gid, err := strconv.ParseUint(v, 10, 32); err == nil {
+			c.Gids = append(c.Gids, uint32(gid))
+		}
+	}
+	return c
+}
+
+func (c *Creds) inGroup(gid uint32) bool {
+	if c.Gid == gid {
+		return true
+	}
+	for _, g := range c.Gids {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// owns returns true if the caller is allowed to change attr, which is only the
+// owner and root.
+func (c *Creds) owns(attr *pb.Attr) bool {
+	return c.Uid == 0 || c.Uid == attr.Uid
+}
+
+// canAccess returns true if the caller has all of the permissions in mask.
+// Root can do anything, except execute a file that nobody can execute.
+func (c *Creds) canAccess(attr *pb.Attr, mask uint32) bool {
+	return c.canAccessACL(attr, nil, mask)
+}
+
+// canAccessACL is canAccess for an inode that has an access ACL. The owner's
+// permissions are always the same as the mode bits.
+func (c *Creds) canAccessACL(attr *pb.Attr, acl ACL, mask uint32) bool {
+	if c.Uid == 0 {
+		if mask&MayExec == 0 || os.FileMode(attr.Mode).IsDir() {
+			return true
+		}
+		return attr.Mode&0111 != 0
+	}
+	if c.Uid != attr.Uid && acl != nil {
+		return acl.allows(c, attr, mask)
+	}
+	var perm uint32
+	switch {
+	case c.Uid == attr.Uid:
+		perm = (attr.Mode >> 6) & 7
+	case c.inGroup(attr.Gid):
+		perm = (attr.Mode >> 3) & 7
+	default:
+		perm = attr.Mode & 7
+	}
+	return perm&mask == mask
+}
+
+// inodeACL returns the access ACL for the inode, or nil if it doesn't have
+// one or it can't be parsed.
+func inodeACL(n *pb.InodeEntry) ACL {
+	b, ok := n.Xattr[ACLAccessXattr]
+	if !ok {
+		return nil
+	}
+	acl, err := ParseACL(b)
+	if err != nil {
+		return nil
+	}
+	return acl
+}
+
+// canUnlink returns true if the caller may remove or rename the entry for attr
+// in dir. In a sticky directory only the owners of the entry or the directory
+// can do that.
+func (c *Creds) canUnlink(dir, attr *pb.Attr) bool {
+	if dir.Mode&uint32(os.ModeSticky) == 0 {
+		return true
+	}
+	return c.owns(dir) || c.owns(attr)
+}
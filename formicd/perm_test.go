@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+
+	"golang.org/x/net/context"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/getcfs/fuse"
+)
+
+// asUser returns a copy of ctx for requests made by uid and gid.
+func asUser(ctx context.Context, uid, gid uint32) context.Context {
+	fsid, _ := GetFsId(ctx)
+	return metadata.NewContext(ctx, metadata.Pairs("fsid", fsid.String(), "uid", fmt.Sprint(uid), "gid", fmt.Sprint(gid)))
+}
+
+func TestCreds_CanAccess(t *testing.T) {
+	attr := &pb.Attr{Mode: 0640, Uid: 10, Gid: 20}
+	for _, test := range []struct {
+		c    *Creds
+		mask uint32
+		ok   bool
+	}{
+		{&Creds{Uid: 10, Gid: 99}, MayRead | MayWrite, true},
+		{&Creds{Uid: 10, Gid: 99}, MayExec, false},
+		{&Creds{Uid: 11, Gid: 20}, MayRead, true},
+		{&Creds{Uid: 11, Gid: 20}, MayWrite, false},
+		{&Creds{Uid: 11, Gid: 99, Gids: []uint32{20}}, MayRead, true},
+		{&Creds{Uid: 11, Gid: 99}, MayRead, false},
+		{&Creds{Uid: 0, Gid: 0}, MayRead | MayWrite, true},
+		{&Creds{Uid: 0, Gid: 0}, MayExec, false},
+	} {
+		if ok := test.c.canAccess(attr, test.mask); ok != test.ok {
+			t.Errorf("canAccess(%v, %d) returned %v", test.c, test.mask, ok)
+		}
+	}
+}
+
+func TestApiServer_Permissions(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	other := asUser(ctx, 2000, 2000)
+	c := createFile(t, api, ctx, 1, "private")
+	if c.Uid != 1001 || c.Gid != 1001 {
+		t.Fatalf("Expected the file to be owned by the caller, got %d:%d", c.Uid, c.Gid)
+	}
+	_, err := api.Create(other, &pb.CreateRequest{Parent: 1, Name: "intruder", Attr: &pb.Attr{Mode: 0644}})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES creating in another user's directory, got: ", err)
+	}
+	_, err = api.Open(other, &pb.OpenRequest{Inode: c.Inode, Flags: syscall.O_WRONLY})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES opening another user's file for writing, got: ", err)
+	}
+	_, err = api.Open(other, &pb.OpenRequest{Inode: c.Inode, Flags: syscall.O_RDONLY | syscall.O_TRUNC})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES truncating another user's file, got: ", err)
+	}
+	if _, err = api.Open(other, &pb.OpenRequest{Inode: c.Inode, Flags: syscall.O_RDONLY}); err != nil {
+		t.Fatal("Open of a world readable file failed: ", err)
+	}
+	if _, err = api.Read(other, &pb.ReadRequest{Inode: c.Inode, Size: 1}); err != nil {
+		t.Fatal("Read of a world readable file failed: ", err)
+	}
+	_, err = api.Remove(other, &pb.RemoveRequest{Parent: 1, Name: "private"})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES removing from another user's directory, got: ", err)
+	}
+	_, err = api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: c.Inode, Uid: 2000}, Valid: uint32(fuse.SetattrUid)})
+	if formic.Errno(err) != syscall.EPERM {
+		t.Fatal("Expected EPERM giving a file away, got: ", err)
+	}
+	_, err = api.SetAttr(other, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: c.Inode, Mode: 0666}, Valid: uint32(fuse.SetattrMode)})
+	if formic.Errno(err) != syscall.EPERM {
+		t.Fatal("Expected EPERM changing the mode of another user's file, got: ", err)
+	}
+	m, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "closed", Attr: &pb.Attr{Mode: 0700}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	createFile(t, api, ctx, m.Attr.Inode, "secret")
+	_, err = api.Lookup(other, &pb.LookupRequest{Parent: m.Attr.Inode, Name: "secret"})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES looking up in a closed directory, got: ", err)
+	}
+	if _, err = api.Lookup(asUser(ctx, 0, 0), &pb.LookupRequest{Parent: m.Attr.Inode, Name: "secret"}); err != nil {
+		t.Fatal("Lookup as root failed: ", err)
+	}
+}
+
+func TestApiServer_StickyDir(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	m, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "tmp", Attr: &pb.Attr{Mode: uint32(os.ModeSticky | 0777)}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	alice := asUser(ctx, 2000, 2000)
+	bob := asUser(ctx, 3000, 3000)
+	createFile(t, api, alice, m.Attr.Inode, "alice")
+	createFile(t, api, bob, m.Attr.Inode, "bob")
+	_, err = api.Remove(bob, &pb.RemoveRequest{Parent: m.Attr.Inode, Name: "alice"})
+	if formic.Errno(err) != syscall.EPERM {
+		t.Fatal("Expected EPERM removing another user's file, got: ", err)
+	}
+	_, err = api.Rename(bob, &pb.RenameRequest{OldParent: m.Attr.Inode, NewParent: m.Attr.Inode, OldName: "bob", NewName: "alice"})
+	if formic.Errno(err) != syscall.EPERM {
+		t.Fatal("Expected EPERM replacing another user's file, got: ", err)
+	}
+	if _, err = api.Remove(bob, &pb.RemoveRequest{Parent: m.Attr.Inode, Name: "bob"}); err != nil {
+		t.Fatal("Remove of own file failed: ", err)
+	}
+	// The owner of the directory can remove anything in it
+	if _, err = api.Remove(ctx, &pb.RemoveRequest{Parent: m.Attr.Inode, Name: "alice"}); err != nil {
+		t.Fatal("Remove by directory owner failed: ", err)
+	}
+}
+
+func TestApiServer_WriteReadOnlyCreate(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	// As with open(O_CREAT|O_WRONLY, 0444), the new file can be written
+	// through the handle it was created with
+	c, err := api.Create(ctx, &pb.CreateRequest{Parent: 1, Name: "ro", Attr: &pb.Attr{Mode: 0444}})
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	if _, err = api.Write(ctx, &pb.WriteRequest{Inode: c.Attr.Inode, Payload: []byte("data")}); err != nil {
+		t.Fatal("Write to a read only file just created failed: ", err)
+	}
+	// Opening it again for writing is refused
+	_, err = api.Open(ctx, &pb.OpenRequest{Inode: c.Attr.Inode, Flags: syscall.O_WRONLY})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES opening a read only file for writing, got: ", err)
+	}
+}
+
+func TestApiServer_HandlePermissions(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	other := asUser(ctx, 2000, 2000)
+	c, err := api.Create(ctx, &pb.CreateRequest{Parent: 1, Name: "private", Attr: &pb.Attr{Mode: 0600}})
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	inode := c.Attr.Inode
+	// Requests made without having been able to open the file
+	_, err = api.Read(other, &pb.ReadRequest{Inode: inode, Size: 1})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Error("Expected EACCES reading, got: ", err)
+	}
+	_, err = api.Write(other, &pb.WriteRequest{Inode: inode, Payload: []byte("x")})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Error("Expected EACCES writing, got: ", err)
+	}
+	err = api.ReadStream(&pb.ReadRequest{Inode: inode, Size: 1}, &fakeReadStream{ctx: other})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Error("Expected EACCES reading a stream, got: ", err)
+	}
+	err = api.WriteStream(&fakeWriteStream{ctx: other, reqs: []*pb.WriteRequest{{Inode: inode, Payload: []byte("x")}}})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Error("Expected EACCES writing a stream, got: ", err)
+	}
+	_, err = api.Fallocate(other, &pb.FallocateRequest{Inode: inode, Length: 10})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Error("Expected EACCES preallocating, got: ", err)
+	}
+	_, err = api.Lseek(other, &pb.LseekRequest{Inode: inode, Whence: SeekData})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Error("Expected EACCES seeking, got: ", err)
+	}
+	// Only reading is let through once the file is world readable
+	_, err = api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: inode, Mode: 0644}, Valid: uint32(fuse.SetattrMode)})
+	if err != nil {
+		t.Fatal("SetAttr failed: ", err)
+	}
+	if _, err = api.Read(other, &pb.ReadRequest{Inode: inode, Size: 1}); err != nil {
+		t.Error("Read of a world readable file failed: ", err)
+	}
+	_, err = api.Write(other, &pb.WriteRequest{Inode: inode, Payload: []byte("x")})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Error("Expected EACCES writing, got: ", err)
+	}
+}
+
+func TestApiServer_XattrPermissions(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	other := asUser(ctx, 2000, 2000)
+	root := asUser(ctx, 0, 0)
+	c := createFile(t, api, ctx, 1, "file")
+	_, err := api.Setxattr(ctx, &pb.SetxattrRequest{Inode: c.Inode, Name: "user.mine", Value: []byte("a")})
+	if err != nil {
+		t.Fatal("Setxattr by the owner failed: ", err)
+	}
+	_, err = api.Setxattr(other, &pb.SetxattrRequest{Inode: c.Inode, Name: "user.theirs", Value: []byte("b")})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES setting an xattr without write permission, got: ", err)
+	}
+	_, err = api.Removexattr(other, &pb.RemovexattrRequest{Inode: c.Inode, Name: "user.mine"})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES removing an xattr without write permission, got: ", err)
+	}
+	if _, err = api.Getxattr(other, &pb.GetxattrRequest{Inode: c.Inode, Name: "user.mine"}); err != nil {
+		t.Fatal("Getxattr of a world readable file failed: ", err)
+	}
+	_, err = api.Setxattr(ctx, &pb.SetxattrRequest{Inode: c.Inode, Name: "trusted.hidden", Value: []byte("c")})
+	if formic.Errno(err) != syscall.EPERM {
+		t.Fatal("Expected EPERM setting a trusted xattr, got: ", err)
+	}
+	_, err = api.Setxattr(root, &pb.SetxattrRequest{Inode: c.Inode, Name: "trusted.hidden", Value: []byte("c")})
+	if err != nil {
+		t.Fatal("Setxattr of a trusted xattr by root failed: ", err)
+	}
+	l, err := api.Listxattr(ctx, &pb.ListxattrRequest{Inode: c.Inode})
+	if err != nil || string(l.Xattr) != "user.mine\x00" {
+		t.Fatalf("Expected only the user xattr to be listed, got %q: %v", l.Xattr, err)
+	}
+	// Once the file can't be read nothing is readable or listed
+	_, err = api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: c.Inode, Mode: 0600}, Valid: uint32(fuse.SetattrMode)})
+	if err != nil {
+		t.Fatal("SetAttr failed: ", err)
+	}
+	_, err = api.Getxattr(other, &pb.GetxattrRequest{Inode: c.Inode, Name: "user.mine"})
+	if formic.Errno(err) != syscall.EACCES {
+		t.Fatal("Expected EACCES getting an xattr without read permission, got: ", err)
+	}
+	l, err = api.Listxattr(other, &pb.ListxattrRequest{Inode: c.Inode})
+	if err != nil || len(l.Xattr) != 0 {
+		t.Fatalf("Expected nothing to be listed, got %q: %v", l.Xattr, err)
+	}
+}
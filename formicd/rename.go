@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+	"github.com/gholt/store"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+const RenameJournalVersion = 1
+
+// Rename flags, these match the values used by renameat2
+const (
+	RenameNoReplace = 1 << 0
+	RenameExchange  = 1 << 1
+)
+
+// Renames that are in progress are recorded in this group of their file
+// system so that any that were interrupted can be finished when formicd starts
+// back up.
+func renameJournalKey(fsid []byte) []byte {
+	return []byte(fmt.Sprintf("/fs/%s/renames", uuid.FromBytesOrNil(fsid)))
+}
+
+func renameJournalChild(j *pb.RenameJournal) []byte {
+	return []byte(fmt.Sprintf("%d/%s/%d", j.OldParent, j.OldName, j.Tsm))
+}
+
+// errNameChanged is returned when the new name has been changed since the
+// rename looked at it, so the rename can't be applied as journaled.
+var errNameChanged = errors.New("Name changed during rename")
+
+// Rename moves oldName in oldParent to newName in newParent, replacing
+// whatever newName was unless RenameNoReplace is set, or swapping the two if
+// RenameExchange is set. The rename is journaled before any directory entries
+// are changed and every change uses the journal's timestamp, so a rename that
+// was interrupted can simply be applied again. If newName is changed by
+// something else in the meantime the rename is started over with what newName
+// is now.
+func (o *OortFS) Rename(ctx context.Context, fsid []byte, oldParent, newParent uint64, oldName, newName string, flags uint32) (*pb.RenameResponse, error) {
+	for i := 0; ; i++ {
+		resp, err := o.rename(ctx, fsid, oldParent, newParent, oldName, newName, flags)
+		if err != errNameChanged {
+			return resp, err
+		}
+		if i >= maxInodeRetries {
+			log.Printf("Err: Gave up renaming %s to %s after %d conflicts", oldName, newName, i+1)
+			return resp, ErrConflict
+		}
+	}
+}
+
+func (o *OortFS) rename(ctx context.Context, fsid []byte, oldParent, newParent uint64, oldName, newName string, flags uint32) (*pb.RenameResponse, error) {
+	if flags&^(RenameNoReplace|RenameExchange) != 0 || flags == RenameNoReplace|RenameExchange {
+		return &pb.RenameResponse{}, ErrInvalid
+	}
+	if oldParent == newParent && oldName == newName {
+		return &pb.RenameResponse{}, nil
+	}
+	src, err := o.GetDirent(ctx, formic.GetID(fsid, oldParent, 0), oldName)
+	if err != nil {
+		return &pb.RenameResponse{}, err
+	}
+	if src.Id == nil || src.Tombstone != nil {
+		return &pb.RenameResponse{}, ErrNotFound
+	}
+	dst, err := o.GetDirent(ctx, formic.GetID(fsid, newParent, 0), newName)
+	if err != nil {
+		return &pb.RenameResponse{}, err
+	}
+	if dst.Id == nil || dst.Tombstone != nil {
+		dst = nil
+	}
+	if dst != nil && flags&RenameNoReplace != 0 {
+		return &pb.RenameResponse{}, ErrExists
+	}
+	if dst == nil && flags&RenameExchange != 0 {
+		return &pb.RenameResponse{}, ErrNotFound
+	}
+	if dst != nil && bytes.Equal(src.Id, dst.Id) {
+		// Both names are links to the same inode so there is nothing to do
+		return &pb.RenameResponse{}, nil
+	}
+	j := &pb.RenameJournal{
+		Version:   RenameJournalVersion,
+		FsId:      fsid,
+		OldParent: oldParent,
+		NewParent: newParent,
+		OldName:   oldName,
+		NewName:   newName,
+		Flags:     flags,
+		Tsm:       brimtime.TimeToUnixMicro(time.Now()),
+		Src:       src,
+		Dst:       dst,
+	}
+	srcInode, err := o.GetInode(ctx, src.Id)
+	if err != nil {
+		return &pb.RenameResponse{}, err
+	}
+	if srcInode.IsDir && oldParent != newParent {
+		inside, err := o.isAncestor(ctx, fsid, srcInode.Inode, newParent)
+		if err != nil {
+			return &pb.RenameResponse{}, err
+		}
+		if inside {
+			return &pb.RenameResponse{}, ErrInvalid
+		}
+	}
+	if dst != nil {
+		dstInode, err := o.GetInode(ctx, dst.Id)
+		if err != nil {
+			return &pb.RenameResponse{}, err
+		}
+		if flags&RenameExchange != 0 {
+			if dstInode.IsDir && oldParent != newParent {
+				inside, err := o.isAncestor(ctx, fsid, dstInode.Inode, oldParent)
+				if err != nil {
+					return &pb.RenameResponse{}, err
+				}
+				if inside {
+					return &pb.RenameResponse{}, ErrInvalid
+				}
+			}
+		} else {
+			if dstInode.IsDir && !srcInode.IsDir {
+				return &pb.RenameResponse{}, ErrIsDir
+			}
+			if !dstInode.IsDir && srcInode.IsDir {
+				return &pb.RenameResponse{}, ErrNotDir
+			}
+			if dstInode.IsDir {
+				empty, err := o.isEmpty(ctx, dst.Id)
+				if err != nil {
+					return &pb.RenameResponse{}, err
+				}
+				if !empty {
+					return &pb.RenameResponse{}, ErrNotEmpty
+				}
+			}
+			j.DstNlink = Nlink(dstInode.Attr)
+		}
+	}
+	b, err := formic.Marshal(j)
+	if err != nil {
+		return &pb.RenameResponse{}, err
+	}
+	err = o.comms.WriteGroupTS(ctx, renameJournalKey(fsid), renameJournalChild(j), b, j.Tsm)
+	if err != nil {
+		return &pb.RenameResponse{}, err
+	}
+	aerr := o.applyRename(ctx, j)
+	if aerr != nil && aerr != errNameChanged {
+		// The journal entry is left in place so the rename will be finished
+		// when formicd restarts
+		return &pb.RenameResponse{}, aerr
+	}
+	// Nothing has been changed if the new name was, so that rename is
+	// dropped as well
+	err = o.comms.DeleteGroupItemTS(ctx, renameJournalKey(fsid), renameJournalChild(j), j.Tsm+1)
+	if err != nil && err != ErrStoreHasNewerValue {
+		log.Println("Couldn't remove rename journal entry: ", err)
+	}
+	return &pb.RenameResponse{}, aerr
+}
+
+// RecoverRenames finishes any renames that were interrupted, for instance by
+// formicd dying between writing the new directory entry and removing the old
+// one. Applying a rename is idempotent, so this is safe to run even when
+// another formicd is still working on one of the renames. A rename that can't
+// be finished is left for the next time, and doesn't hold up the others; the
+// first such error is returned once they have all been tried.
+func (o *OortFS) RecoverRenames(ctx context.Context) error {
+	fsids, err := listFSIDs(ctx, o.comms)
+	if err != nil {
+		return err
+	}
+	var failed error
+	for _, fsid := range fsids {
+		if err = o.recoverRenames(ctx, fsid); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// recoverRenames finishes the interrupted renames of a file system.
+func (o *OortFS) recoverRenames(ctx context.Context, fsid []byte) error {
+	items, err := o.comms.ReadGroup(ctx, renameJournalKey(fsid))
+	if err != nil && !store.IsNotFound(err) {
+		return err
+	}
+	var failed error
+	for _, item := range items {
+		j := &pb.RenameJournal{}
+		err = formic.Unmarshal(item.Value, j)
+		if err != nil {
+			log.Println("Skipping bad rename journal entry: ", err)
+			continue
+		}
+		log.Printf("Finishing rename of %s to %s", j.OldName, j.NewName)
+		err = o.applyRename(ctx, j)
+		if err == errNameChanged {
+			// The rename never got as far as the old name, and whoever
+			// changed the new name since has the last word on it
+			log.Printf("Dropping rename of %s to %s as %s has changed since", j.OldName, j.NewName, j.NewName)
+			err = nil
+		}
+		if err == nil {
+			err = o.comms.DeleteGroupItemTS(ctx, renameJournalKey(fsid), renameJournalChild(j), j.Tsm+1)
+			if err == ErrStoreHasNewerValue {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Printf("Couldn't finish rename of %s to %s: %s", j.OldName, j.NewName, err)
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	return failed
+}
+
+// applyRename makes the directory changes recorded in the journal entry. The
+// new entry is written first, and if the store has kept a newer entry than it
+// for the new name errNameChanged is returned before anything else is
+// touched.
+func (o *OortFS) applyRename(ctx context.Context, j *pb.RenameJournal) error {
+	exchange := j.Flags&RenameExchange != 0
+	oldParentID := formic.GetID(j.FsId, j.OldParent, 0)
+	newParentID := formic.GetID(j.FsId, j.NewParent, 0)
+	src := &pb.DirEntry{
+		Version: j.Src.Version,
+		Name:    j.NewName,
+		Id:      j.Src.Id,
+		Type:    j.Src.Type,
+		Inode:   j.Src.Inode,
+	}
+	err := o.moveDirent(ctx, newParentID, src, j.Tsm)
+	if err != nil {
+		return err
+	}
+	if exchange {
+		dst := &pb.DirEntry{
+			Version: j.Dst.Version,
+			Name:    j.OldName,
+			Id:      j.Dst.Id,
+			Type:    j.Dst.Type,
+			Inode:   j.Dst.Inode,
+		}
+		err = o.writeDirent(ctx, oldParentID, dst, j.Tsm)
+	} else {
+		err = o.comms.DeleteGroupItemTS(ctx, oldParentID, []byte(j.OldName), j.Tsm)
+	}
+	if err != nil && err != ErrStoreHasNewerValue {
+		return err
+	}
+	if j.OldParent != j.NewParent {
+		err = o.setParent(ctx, j.Src.Id, j.NewParent)
+		if err != nil {
+			return err
+		}
+		if exchange {
+			err = o.setParent(ctx, j.Dst.Id, j.OldParent)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if j.Dst != nil && !exchange {
+		return o.dropReplaced(ctx, j)
+	}
+	return nil
+}
+
+func (o *OortFS) writeDirent(ctx context.Context, parent []byte, d *pb.DirEntry, tsm int64) error {
+	b, err := formic.Marshal(d)
+	if err != nil {
+		return err
+	}
+	err = o.comms.WriteGroupTS(ctx, parent, []byte(d.Name), b, tsm)
+	if err != nil && err != ErrStoreHasNewerValue {
+		return err
+	}
+	return nil
+}
+
+// moveDirent writes the entry d that a rename moves into parent. If the store
+// already has an entry at least as new, it is only fine to carry on if that is
+// this same move being applied again.
+func (o *OortFS) moveDirent(ctx context.Context, parent []byte, d *pb.DirEntry, tsm int64) error {
+	b, err := formic.Marshal(d)
+	if err != nil {
+		return err
+	}
+	err = o.comms.WriteGroupTS(ctx, parent, []byte(d.Name), b, tsm)
+	if err != ErrStoreHasNewerValue {
+		return err
+	}
+	kept, ts, err := o.readClaim(ctx, parent, d.Name)
+	if err != nil {
+		return err
+	}
+	if kept != nil && ts == tsm && bytes.Equal(kept.Id, d.Id) {
+		return nil
+	}
+	return errNameChanged
+}
+
+// setParent updates the parent of a directory that has been moved. A
+// directory that has been removed since has no parent to update.
+func (o *OortFS) setParent(ctx context.Context, id []byte, parent uint64) error {
+	_, err := o.UpdateInode(ctx, id, func(n *pb.InodeEntry) error {
+		if !n.IsDir || n.Parent == parent {
+			return errNoChange
+		}
+		n.Parent = parent
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// dropReplaced removes the link to the inode that was replaced by a rename,
+// queueing the inode to be deleted if that was its last link.
+func (o *OortFS) dropReplaced(ctx context.Context, j *pb.RenameJournal) error {
+	n, err := o.GetInode(ctx, j.Dst.Id)
+	if err == ErrNotFound {
+		// Already cleaned up by an earlier attempt
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if j.DstNlink > 1 {
+		// Set rather than decrement the count so that doing this again
+		// doesn't drop a link that belongs to some other name
+		_, err = o.UpdateInode(ctx, j.Dst.Id, func(n *pb.InodeEntry) error {
+			n.Attr.Nlink = j.DstNlink - 1
+			return nil
+		})
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	o.deletes.queue(ctx, &DeleteItem{
+		ts: &pb.Tombstone{
+			Dtime:  j.Tsm,
+			Qtime:  j.Tsm,
+			FsId:   j.FsId,
+			Inode:  n.Inode,
+			Blocks: n.Blocks,
+		},
+	})
+	return nil
+}
+
+// isAncestor returns true if dir is inode or one of inode's parents. If the
+// search reaches a directory created before parents were tracked there is no
+// telling, so ErrUnknownParent is returned rather than risk a loop.
+func (o *OortFS) isAncestor(ctx context.Context, fsid []byte, dir, inode uint64) (bool, error) {
+	for {
+		if inode == dir {
+			return true, nil
+		}
+		if inode == 1 {
+			return false, nil
+		}
+		n, err := o.GetInode(ctx, formic.GetID(fsid, inode, 0))
+		if err != nil {
+			return false, err
+		}
+		if n.Parent == 0 {
+			return false, ErrUnknownParent
+		}
+		inode = n.Parent
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+)
+
+func createFile(t *testing.T, api *apiServer, ctx context.Context, parent uint64, name string) *pb.Attr {
+	c, err := api.Create(ctx, &pb.CreateRequest{Parent: parent, Name: name, Attr: &pb.Attr{Mode: 0644}})
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	return c.Attr
+}
+
+func lookupInode(t *testing.T, api *apiServer, ctx context.Context, parent uint64, name string) uint64 {
+	l, err := api.Lookup(ctx, &pb.LookupRequest{Parent: parent, Name: name})
+	if formic.Errno(err) == syscall.ENOENT {
+		return 0
+	}
+	if err != nil {
+		t.Fatal("Lookup failed: ", err)
+	}
+	return l.Attr.Inode
+}
+
+func TestOortFS_RenameReplace(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	a := createFile(t, api, ctx, 1, "a")
+	b := createFile(t, api, ctx, 1, "b")
+	_, err := api.Rename(ctx, &pb.RenameRequest{OldParent: 1, NewParent: 1, OldName: "a", NewName: "b", Flags: RenameNoReplace})
+	if formic.Errno(err) != syscall.EEXIST {
+		t.Fatal("Expected EEXIST, got: ", err)
+	}
+	_, err = api.Rename(ctx, &pb.RenameRequest{OldParent: 1, NewParent: 1, OldName: "a", NewName: "b"})
+	if err != nil {
+		t.Fatal("Rename failed: ", err)
+	}
+	if i := lookupInode(t, api, ctx, 1, "b"); i != a.Inode {
+		t.Fatalf("Expected b to be inode %d, got %d", a.Inode, i)
+	}
+	if i := lookupInode(t, api, ctx, 1, "a"); i != 0 {
+		t.Fatal("Expected a to be gone, got inode: ", i)
+	}
+	// The replaced inode should be cleaned up by the deletinator
+	fsid, _ := GetFsId(ctx)
+	for i := 0; ; i++ {
+		_, err = api.fs.GetInode(ctx, formic.GetID(fsid.Bytes(), b.Inode, 0))
+		if err == ErrNotFound {
+			break
+		}
+		if i > 100 {
+			t.Fatal("Replaced inode was not deleted: ", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOortFS_RenameExchange(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	a := createFile(t, api, ctx, 1, "a")
+	d, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "d", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	b := createFile(t, api, ctx, d.Attr.Inode, "b")
+	_, err = api.Rename(ctx, &pb.RenameRequest{OldParent: 1, NewParent: d.Attr.Inode, OldName: "a", NewName: "b", Flags: RenameExchange})
+	if err != nil {
+		t.Fatal("Rename failed: ", err)
+	}
+	if i := lookupInode(t, api, ctx, d.Attr.Inode, "b"); i != a.Inode {
+		t.Fatalf("Expected d/b to be inode %d, got %d", a.Inode, i)
+	}
+	if i := lookupInode(t, api, ctx, 1, "a"); i != b.Inode {
+		t.Fatalf("Expected a to be inode %d, got %d", b.Inode, i)
+	}
+	_, err = api.Rename(ctx, &pb.RenameRequest{OldParent: 1, NewParent: 1, OldName: "a", NewName: "missing", Flags: RenameExchange})
+	if formic.Errno(err) != syscall.ENOENT {
+		t.Fatal("Expected ENOENT, got: ", err)
+	}
+}
+
+func TestOortFS_RenameIntoSelf(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	d1, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "d1", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	d2, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: d1.Attr.Inode, Name: "d2", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	_, err = api.Rename(ctx, &pb.RenameRequest{OldParent: 1, NewParent: d2.Attr.Inode, OldName: "d1", NewName: "x"})
+	if formic.Errno(err) != syscall.EINVAL {
+		t.Fatal("Expected EINVAL, got: ", err)
+	}
+	// Moving d2 up and then d1 into it is fine
+	_, err = api.Rename(ctx, &pb.RenameRequest{OldParent: d1.Attr.Inode, NewParent: 1, OldName: "d2", NewName: "d2"})
+	if err != nil {
+		t.Fatal("Rename failed: ", err)
+	}
+	_, err = api.Rename(ctx, &pb.RenameRequest{OldParent: 1, NewParent: d2.Attr.Inode, OldName: "d1", NewName: "d1"})
+	if err != nil {
+		t.Fatal("Rename failed: ", err)
+	}
+}
+
+func TestOortFS_RenameOverNewerEntry(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	root := formic.GetID(fsid.Bytes(), 1, 0)
+	a := createFile(t, api, ctx, 1, "a")
+	c := createFile(t, api, ctx, 1, "c")
+	// b is written by something whose clock is well ahead, so every rename
+	// over it loses
+	d, err := o.GetDirent(ctx, root, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Name = "b"
+	if err = o.writeDirent(ctx, root, d, brimtime.TimeToUnixMicro(time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	_, err = api.Rename(ctx, &pb.RenameRequest{OldParent: 1, NewParent: 1, OldName: "a", NewName: "b"})
+	if formic.Errno(err) != syscall.EAGAIN {
+		t.Fatal("Expected EAGAIN, got: ", err)
+	}
+	// Neither a nor the inode b links to have been touched
+	if i := lookupInode(t, api, ctx, 1, "a"); i != a.Inode {
+		t.Fatalf("Expected a to be inode %d, got %d", a.Inode, i)
+	}
+	if i := lookupInode(t, api, ctx, 1, "b"); i != c.Inode {
+		t.Fatalf("Expected b to be inode %d, got %d", c.Inode, i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err = o.GetInode(ctx, d.Id); err != nil {
+		t.Fatal("Expected the inode b links to to be kept, got: ", err)
+	}
+	items, err := o.comms.ReadGroup(ctx, renameJournalKey(fsid.Bytes()))
+	if err != nil || len(items) != 0 {
+		t.Fatalf("Expected the journal to be empty, got %d items: %v", len(items), err)
+	}
+}
+
+func TestOortFS_RecoverRenames(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	a := createFile(t, api, ctx, 1, "a")
+	src, err := o.GetDirent(ctx, formic.GetID(fsid.Bytes(), 1, 0), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate formicd dying after the new entry was written but before the
+	// old one was removed
+	j := &pb.RenameJournal{
+		Version:   RenameJournalVersion,
+		FsId:      fsid.Bytes(),
+		OldParent: 1,
+		NewParent: 1,
+		OldName:   "a",
+		NewName:   "b",
+		Tsm:       brimtime.TimeToUnixMicro(time.Now()),
+		Src:       src,
+	}
+	b, err := formic.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.comms.WriteGroupTS(ctx, renameJournalKey(fsid.Bytes()), renameJournalChild(j), b, j.Tsm); err != nil {
+		t.Fatal(err)
+	}
+	src.Name = "b"
+	if err = o.writeDirent(ctx, formic.GetID(fsid.Bytes(), 1, 0), src, j.Tsm); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.RecoverRenames(ctx); err != nil {
+		t.Fatal("RecoverRenames failed: ", err)
+	}
+	if i := lookupInode(t, api, ctx, 1, "b"); i != a.Inode {
+		t.Fatalf("Expected b to be inode %d, got %d", a.Inode, i)
+	}
+	if i := lookupInode(t, api, ctx, 1, "a"); i != 0 {
+		t.Fatal("Expected a to be gone, got inode: ", i)
+	}
+	items, err := o.comms.ReadGroup(ctx, renameJournalKey(fsid.Bytes()))
+	if err != nil || len(items) != 0 {
+		t.Fatalf("Expected the journal to be empty, got %d items: %v", len(items), err)
+	}
+}
+
+func TestOortFS_RecoverRenamesDeletedSource(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	d1, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "d1", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	d2, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "d2", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	src, err := o.GetDirent(ctx, formic.GetID(fsid.Bytes(), 1, 0), "d2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A rename of d2 into d1 that was interrupted, after which d2 was removed
+	j := &pb.RenameJournal{
+		Version:   RenameJournalVersion,
+		FsId:      fsid.Bytes(),
+		OldParent: 1,
+		NewParent: d1.Attr.Inode,
+		OldName:   "d2",
+		NewName:   "d2",
+		Tsm:       brimtime.TimeToUnixMicro(time.Now()),
+		Src:       src,
+	}
+	b, err := formic.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.comms.WriteGroupTS(ctx, renameJournalKey(fsid.Bytes()), renameJournalChild(j), b, j.Tsm); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.DeleteChunk(ctx, formic.GetID(fsid.Bytes(), d2.Attr.Inode, 0), j.Tsm+1); err != nil {
+		t.Fatal(err)
+	}
+	// Along with one that can still be finished
+	createFile(t, api, ctx, 1, "a")
+	src, err = o.GetDirent(ctx, formic.GetID(fsid.Bytes(), 1, 0), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j = &pb.RenameJournal{
+		Version:   RenameJournalVersion,
+		FsId:      fsid.Bytes(),
+		OldParent: 1,
+		NewParent: 1,
+		OldName:   "a",
+		NewName:   "b",
+		Tsm:       brimtime.TimeToUnixMicro(time.Now()),
+		Src:       src,
+	}
+	if b, err = formic.Marshal(j); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.comms.WriteGroupTS(ctx, renameJournalKey(fsid.Bytes()), renameJournalChild(j), b, j.Tsm); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.RecoverRenames(ctx); err != nil {
+		t.Fatal("RecoverRenames failed: ", err)
+	}
+	if i := lookupInode(t, api, ctx, 1, "a"); i != 0 {
+		t.Fatal("Expected a to be gone, got inode: ", i)
+	}
+	items, err := o.comms.ReadGroup(ctx, renameJournalKey(fsid.Bytes()))
+	if err != nil || len(items) != 0 {
+		t.Fatalf("Expected the journal to be empty, got %d items: %v", len(items), err)
+	}
+}
+
+func TestOortFS_RenameUnknownParent(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	o := api.fs.(*OortFS)
+	fsid, _ := GetFsId(ctx)
+	d1, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "d1", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	d2, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: d1.Attr.Inode, Name: "d2", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	// As if d2 had been created before parents were tracked
+	_, err = o.UpdateInode(ctx, formic.GetID(fsid.Bytes(), d2.Attr.Inode, 0), func(n *pb.InodeEntry) error {
+		n.Parent = 0
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = api.Rename(ctx, &pb.RenameRequest{OldParent: 1, NewParent: d2.Attr.Inode, OldName: "d1", NewName: "x"})
+	if formic.Errno(err) != syscall.EXDEV {
+		t.Fatal("Expected EXDEV, got: ", err)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+	"github.com/gholt/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+// Kinds of problems found by the scrubber
+const (
+	ScrubCorruptInode  = "corrupt-inode"
+	ScrubCorruptEntry  = "corrupt-entry"
+	ScrubDanglingEntry = "dangling-entry"
+	ScrubMissingBlock  = "missing-block"
+	ScrubCorruptBlock  = "corrupt-block"
+)
+
+// How many problems are listed in a report. The counts include all of them.
+const maxScrubProblems = 1000
+
+// How long a formicd holds the lease on scrubbing a file system before another
+// formicd may take it over. It is renewed as the walk goes on.
+const scrubLeaseTime = 10 * time.Minute
+
+var errScrubLeaseLost = errors.New("Scrub lease taken by another formicd")
+
+var (
+	scrubInodes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "formicd",
+		Subsystem: "scrub",
+		Name:      "inodes_total",
+		Help:      "Inodes checked by the scrubber.",
+	})
+	scrubBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "formicd",
+		Subsystem: "scrub",
+		Name:      "blocks_total",
+		Help:      "Blocks checked by the scrubber.",
+	})
+	scrubProblems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "formicd",
+		Subsystem: "scrub",
+		Name:      "problems_total",
+		Help:      "Problems found by the scrubber, by kind.",
+	}, []string{"kind"})
+)
+
+// ScrubProblem is something wrong that the scrubber found. Path is where it
+// was found from the root of the file system, so a file with several links
+// is only reported under the first one walked.
+type ScrubProblem struct {
+	Kind  string `json:"kind"`
+	Path  string `json:"path"`
+	Inode uint64 `json:"inode,omitempty"`
+	Block uint64 `json:"block"`
+	Err   string `json:"err,omitempty"`
+}
+
+// ScrubReport is the result of scrubbing a file system. It is stored as the
+// "scrub" item of /fs/FSID in the group store.
+type ScrubReport struct {
+	FSID     string         `json:"fsid"`
+	Start    int64          `json:"start"`
+	Finish   int64          `json:"finish"`
+	Inodes   uint64         `json:"inodes"`
+	Blocks   uint64         `json:"blocks"`
+	Counts   map[string]int `json:"counts"`
+	Problems []ScrubProblem `json:"problems"`
+}
+
+// ScrubLease is held by the formicd scrubbing a file system, so that each file
+// system is only scrubbed by one formicd at a time. It is stored as the
+// "scrub-lease" item of /fs/FSID in the group store.
+type ScrubLease struct {
+	Node    int   `json:"node"`
+	Taken   int64 `json:"taken"`
+	Expires int64 `json:"expires"`
+}
+
+// outranks returns whether l wins over o, which was taken by another formicd
+// in the same instant. Ties go to the higher node id, so every formicd agrees
+// on who holds the lease whichever of the writes the store kept.
+func (l *ScrubLease) outranks(o *ScrubLease) bool {
+	return l.Taken == o.Taken && l.Node > o.Node
+}
+
+func (r *ScrubReport) problem(p ScrubProblem) {
+	log.Printf("SCRUB %s: %s %s inode %d block %d %s", r.FSID, p.Kind, p.Path, p.Inode, p.Block, p.Err)
+	scrubProblems.WithLabelValues(p.Kind).Inc()
+	r.Counts[p.Kind]++
+	if len(r.Problems) < maxScrubProblems {
+		r.Problems = append(r.Problems, p)
+	}
+}
+
+// Scrubber periodically walks every file system from its root checking that
+// inodes, blocks and directory entries can all be read back intact. The file
+// systems are shared out between the formicds by leases, and one that was
+// scrubbed by any formicd within the interval is skipped.
+type Scrubber struct {
+	fs       FileService
+	comms    *StoreComms
+	interval time.Duration
+	node     int
+	renewAt  time.Time // When the lease being held should be renewed
+	now      func() time.Time
+}
+
+func newScrubber(fs FileService, comms *StoreComms, interval time.Duration, node int) *Scrubber {
+	return &Scrubber{
+		fs:       fs,
+		comms:    comms,
+		interval: interval,
+		node:     node,
+		now:      time.Now,
+	}
+}
+
+func (s *Scrubber) run() {
+	for {
+		time.Sleep(s.interval)
+		// TODO: Need better context
+		ctx := context.Background()
+		items, err := s.comms.ReadGroup(ctx, []byte("/fs"))
+		if err != nil {
+			log.Println("Scrub failed to list file systems: ", err)
+			continue
+		}
+		for _, item := range items {
+			var ref FileSysRef
+			if err = json.Unmarshal(item.Value, &ref); err != nil {
+				log.Println("Scrub failed to read file system: ", err)
+				continue
+			}
+			if !s.due(ctx, ref.FSID) {
+				continue
+			}
+			ok, err := s.lease(ctx, ref.FSID)
+			if err != nil {
+				log.Printf("Scrub of %s couldn't take the lease: %s", ref.FSID, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if _, err = s.scrub(ctx, ref.FSID); err != nil {
+				log.Printf("Scrub of %s failed: %s", ref.FSID, err)
+			}
+			s.release(ctx, ref.FSID)
+		}
+	}
+}
+
+// due returns whether the file system hasn't been scrubbed, by any formicd,
+// within the interval.
+func (s *Scrubber) due(ctx context.Context, fsid string) bool {
+	b, err := s.comms.ReadGroupItem(ctx, []byte(fmt.Sprintf("/fs/%s", fsid)), []byte("scrub"))
+	if err != nil {
+		return true
+	}
+	var r ScrubReport
+	if err = json.Unmarshal(b, &r); err != nil {
+		return true
+	}
+	return r.Finish <= brimtime.TimeToUnixMicro(time.Now().Add(-s.interval))
+}
+
+// lease takes or renews the lease on scrubbing the file system, returning
+// false if another formicd holds it.
+func (s *Scrubber) lease(ctx context.Context, fsid string) (bool, error) {
+	key := []byte(fmt.Sprintf("/fs/%s", fsid))
+	now := s.now()
+	mine := &ScrubLease{Node: s.node, Taken: brimtime.TimeToUnixMicro(now), Expires: brimtime.TimeToUnixMicro(now.Add(scrubLeaseTime))}
+	b, err := json.Marshal(mine)
+	if err != nil {
+		return false, err
+	}
+	// Of the formicds that took the lease at once, the one with the newest
+	// write holds it. Which of two writes with the same timestamp the store
+	// keeps depends on the order they arrive in, so a lease taken in the same
+	// instant by a higher node id is written again over the top. Any that
+	// read back before a later write landed will scrub until they next renew,
+	// which only costs the extra work.
+	tsm := mine.Taken
+	for written := false; ; written = true {
+		v, ts, err := s.comms.ReadGroupItemTS(ctx, key, []byte("scrub-lease"))
+		if err != nil && !store.IsNotFound(err) {
+			return false, err
+		}
+		if err == nil {
+			var l ScrubLease
+			if json.Unmarshal(v, &l) == nil {
+				if l.Node != s.node && l.Expires > mine.Taken && !mine.outranks(&l) {
+					return false, nil
+				}
+				if l.Node == s.node && written {
+					s.renewAt = now.Add(scrubLeaseTime / 2)
+					return true, nil
+				}
+			}
+		} else if written {
+			// Released in the meantime
+			return false, nil
+		}
+		if ts >= tsm {
+			tsm = ts + 1
+		}
+		err = s.comms.WriteGroupTS(ctx, key, []byte("scrub-lease"), b, tsm)
+		if err != nil && err != ErrStoreHasNewerValue {
+			return false, err
+		}
+	}
+}
+
+// renew renews the lease being held once it is halfway through.
+func (s *Scrubber) renew(ctx context.Context, fsid string) error {
+	if s.renewAt.IsZero() || s.now().Before(s.renewAt) {
+		return nil
+	}
+	ok, err := s.lease(ctx, fsid)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errScrubLeaseLost
+	}
+	return nil
+}
+
+// release gives up the lease on scrubbing the file system.
+func (s *Scrubber) release(ctx context.Context, fsid string) {
+	s.renewAt = time.Time{}
+	err := s.comms.DeleteGroupItem(ctx, []byte(fmt.Sprintf("/fs/%s", fsid)), []byte("scrub-lease"))
+	if err != nil && !store.IsNotFound(err) {
+		log.Printf("Scrub of %s couldn't release the lease: %s", fsid, err)
+	}
+}
+
+// scrub walks the file system and stores the report of what was found.
+func (s *Scrubber) scrub(ctx context.Context, fsid string) (*ScrubReport, error) {
+	id, err := uuid.FromString(fsid)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Scrubbing: ", fsid)
+	r := &ScrubReport{
+		FSID:     fsid,
+		Start:    brimtime.TimeToUnixMicro(time.Now()),
+		Counts:   make(map[string]int),
+		Problems: []ScrubProblem{},
+	}
+	if err = s.walk(ctx, id.Bytes(), r); err != nil {
+		return nil, err
+	}
+	r.Finish = brimtime.TimeToUnixMicro(time.Now())
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	err = s.comms.WriteGroup(ctx, []byte(fmt.Sprintf("/fs/%s", fsid)), []byte("scrub"), b)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type scrubItem struct {
+	id   []byte
+	path string
+}
+
+// walk checks each inode reachable from the root once, however many links it
+// has. Errors from the store, other than the ones being looked for, stop the
+// walk so that a bad connection isn't reported as a damaged file system.
+func (s *Scrubber) walk(ctx context.Context, fsid []byte, r *ScrubReport) error {
+	seen := make(map[string]bool)
+	todo := []scrubItem{{id: formic.GetID(fsid, 1, 0), path: "/"}}
+	for len(todo) > 0 {
+		item := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if seen[string(item.id)] {
+			continue
+		}
+		seen[string(item.id)] = true
+		if err := s.renew(ctx, r.FSID); err != nil {
+			return err
+		}
+		n, err := s.fs.GetInode(ctx, item.id)
+		if err == ErrNotFound {
+			r.problem(ScrubProblem{Kind: ScrubDanglingEntry, Path: item.path})
+			continue
+		}
+		if err == ErrCorrupt {
+			r.problem(ScrubProblem{Kind: ScrubCorruptInode, Path: item.path})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.Inodes++
+		scrubInodes.Inc()
+		if n.IsDir {
+			entries, err := s.comms.ReadGroup(ctx, item.id)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				d := &pb.DirEntry{}
+				if err = formic.Unmarshal(e.Value, d); err != nil {
+					r.problem(ScrubProblem{Kind: ScrubCorruptEntry, Path: item.path, Inode: n.Inode, Err: err.Error()})
+					continue
+				}
+				if d.Tombstone != nil {
+					continue
+				}
+				todo = append(todo, scrubItem{id: d.Id, path: path.Join(item.path, d.Name)})
+			}
+			continue
+		}
+		for _, e := range dataExtents(n) {
+			for b := e.Start; b < e.Start+e.Count; b++ {
+				// block 0 is for inode data
+				_, err = s.fs.GetChunk(ctx, formic.GetID(fsid, n.Inode, b+1))
+				switch err {
+				case nil:
+				case ErrNotFound:
+					r.problem(ScrubProblem{Kind: ScrubMissingBlock, Path: item.path, Inode: n.Inode, Block: b})
+				case ErrCorrupt:
+					r.problem(ScrubProblem{Kind: ScrubCorruptBlock, Path: item.path, Inode: n.Inode, Block: b})
+				default:
+					return err
+				}
+				r.Blocks++
+				scrubBlocks.Inc()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+)
+
+func TestScrubber(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	vstore := &corruptValueStore{memValueStore: api.comms.vstore.(*memValueStore), bad: make(map[memKey]int)}
+	api.comms.vstore = vstore
+	m, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "dir", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	payload := []byte("The quick brown fox")
+	var files []*pb.Attr
+	for _, name := range []string{"good", "bad", "gone"} {
+		c := createFile(t, api, ctx, m.Attr.Inode, name)
+		if _, err = api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Payload: payload}); err != nil {
+			t.Fatal("Write failed: ", err)
+		}
+		waitForSize(t, api, ctx, c.Inode, uint64(len(payload)))
+		files = append(files, c)
+	}
+	fsid, _ := GetFsId(ctx)
+	bad, gone := files[1].Inode, files[2].Inode
+	// block 0 is for inode data
+	if err = api.comms.DeleteValue(ctx, formic.GetID(fsid.Bytes(), bad, 1)); err != nil {
+		t.Fatal(err)
+	}
+	vstore.corrupt(formic.GetID(fsid.Bytes(), bad, 2), 1000)
+	if err = api.comms.DeleteValue(ctx, formic.GetID(fsid.Bytes(), gone, 0)); err != nil {
+		t.Fatal(err)
+	}
+	s := newScrubber(api.fs, api.comms, 0, 1)
+	r, err := s.scrub(ctx, fsid.String())
+	if err != nil {
+		t.Fatal("Scrub failed: ", err)
+	}
+	// The root, dir, good and bad
+	if r.Inodes != 4 || r.Blocks != 4 {
+		t.Errorf("Expected 4 inodes and 4 blocks, got %d and %d", r.Inodes, r.Blocks)
+	}
+	expected := map[string]ScrubProblem{
+		ScrubMissingBlock:  {Kind: ScrubMissingBlock, Path: "/dir/bad", Inode: bad, Block: 0},
+		ScrubCorruptBlock:  {Kind: ScrubCorruptBlock, Path: "/dir/bad", Inode: bad, Block: 1},
+		ScrubDanglingEntry: {Kind: ScrubDanglingEntry, Path: "/dir/gone"},
+	}
+	if len(r.Problems) != len(expected) {
+		t.Fatalf("Unexpected problems: %v", r.Problems)
+	}
+	for _, p := range r.Problems {
+		if expected[p.Kind] != p || r.Counts[p.Kind] != 1 {
+			t.Errorf("Unexpected problem: %v", p)
+		}
+	}
+	b, err := api.comms.ReadGroupItem(ctx, []byte(fmt.Sprintf("/fs/%s", fsid)), []byte("scrub"))
+	if err != nil {
+		t.Fatal("Report wasn't stored: ", err)
+	}
+	var stored ScrubReport
+	if err = json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Finish != r.Finish || len(stored.Problems) != len(r.Problems) {
+		t.Errorf("Stored report differs: %v", stored)
+	}
+}
+
+func TestScrubber_Lease(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	fsid, _ := GetFsId(ctx)
+	a := newScrubber(api.fs, api.comms, time.Hour, 1)
+	b := newScrubber(api.fs, api.comms, time.Hour, 2)
+	if !a.due(ctx, fsid.String()) {
+		t.Fatal("Expected a file system that was never scrubbed to be due")
+	}
+	if ok, err := a.lease(ctx, fsid.String()); !ok || err != nil {
+		t.Fatalf("Expected to take the lease, got %t %v", ok, err)
+	}
+	if ok, err := b.lease(ctx, fsid.String()); ok || err != nil {
+		t.Fatalf("Expected the lease to be held, got %t %v", ok, err)
+	}
+	// Renewing keeps it
+	a.renewAt = time.Now().Add(-time.Second)
+	if err := a.renew(ctx, fsid.String()); err != nil {
+		t.Fatal("Renew failed: ", err)
+	}
+	if _, err := a.scrub(ctx, fsid.String()); err != nil {
+		t.Fatal("Scrub failed: ", err)
+	}
+	a.release(ctx, fsid.String())
+	// Scrubbed within the interval, so no one needs to again
+	if b.due(ctx, fsid.String()) {
+		t.Error("Expected a file system just scrubbed not to be due")
+	}
+	if ok, err := b.lease(ctx, fsid.String()); !ok || err != nil {
+		t.Fatalf("Expected to take the released lease, got %t %v", ok, err)
+	}
+	// The lease can no longer be renewed by a formicd that gave it up
+	a.renewAt = time.Now().Add(-time.Second)
+	if err := a.renew(ctx, fsid.String()); err != errScrubLeaseLost {
+		t.Errorf("Expected the lease to be lost, got %v", err)
+	}
+}
+
+func TestScrubber_LeaseTie(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	fsid, _ := GetFsId(ctx)
+	// Every formicd takes the lease in the same instant, so only the node ids
+	// tell the writes apart
+	now := time.Now()
+	var scrubbers []*Scrubber
+	for node := 1; node <= 8; node++ {
+		s := newScrubber(api.fs, api.comms, time.Hour, node)
+		s.now = func() time.Time { return now }
+		scrubbers = append(scrubbers, s)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(scrubbers))
+	for _, s := range scrubbers {
+		wg.Add(1)
+		go func(s *Scrubber) {
+			defer wg.Done()
+			if _, err := s.lease(ctx, fsid.String()); err != nil {
+				errs <- err
+			}
+		}(s)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal("Lease failed: ", err)
+	}
+	// Any that took the lease before a later write landed find out when they
+	// renew, leaving only the highest node id holding it
+	for _, s := range scrubbers {
+		s.renewAt = now.Add(-time.Second)
+		err := s.renew(ctx, fsid.String())
+		if s.node == 8 && err != nil {
+			t.Errorf("Expected node 8 to keep the lease, got %v", err)
+		}
+		if s.node != 8 && err != errScrubLeaseLost {
+			t.Errorf("Expected node %d to lose the lease, got %v", s.node, err)
+		}
+	}
+	b, err := api.comms.ReadGroupItem(ctx, []byte(fmt.Sprintf("/fs/%s", fsid)), []byte("scrub-lease"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l ScrubLease
+	if err = json.Unmarshal(b, &l); err != nil || l.Node != 8 {
+		t.Errorf("Expected node 8 to hold the lease, got %d %v", l.Node, err)
+	}
+}
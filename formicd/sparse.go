@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"golang.org/x/net/context"
+)
+
+// Values of whence for Lseek, as used by Linux
+const (
+	SeekData = 3
+	SeekHole = 4
+)
+
+var ErrNoData = errors.New("No data past offset")
+
+// addExtent records that block has been written, merging it with the extents
+// on either side of it.
+func addExtent(n *pb.InodeEntry, block uint64) {
+	e := n.Extents
+	// The first extent that ends after block
+	i := sort.Search(len(e), func(i int) bool { return e[i].Start+e[i].Count > block })
+	if i < len(e) && e[i].Start <= block {
+		return
+	}
+	if i > 0 && e[i-1].Start+e[i-1].Count == block {
+		e[i-1].Count++
+		if i < len(e) && e[i].Start == block+1 {
+			e[i-1].Count += e[i].Count
+			n.Extents = append(e[:i], e[i+1:]...)
+		}
+		return
+	}
+	if i < len(e) && e[i].Start == block+1 {
+		e[i].Start--
+		e[i].Count++
+		return
+	}
+	e = append(e, nil)
+	copy(e[i+1:], e[i:])
+	e[i] = &pb.Extent{Start: block, Count: 1}
+	n.Extents = e
+}
+
+// clipExtents drops any blocks from blocks on.
+func clipExtents(n *pb.InodeEntry, blocks uint64) {
+	e := n.Extents
+	for len(e) > 0 && e[len(e)-1].Start >= blocks {
+		e = e[:len(e)-1]
+	}
+	if len(e) > 0 && e[len(e)-1].Start+e[len(e)-1].Count > blocks {
+		e[len(e)-1].Count = blocks - e[len(e)-1].Start
+	}
+	n.Extents = e
+}
+
+// removeExtents drops the blocks from start up to end.
+func removeExtents(n *pb.InodeEntry, start, end uint64) {
+	var e []*pb.Extent
+	for _, x := range n.Extents {
+		xend := x.Start + x.Count
+		if xend <= start || x.Start >= end {
+			e = append(e, x)
+			continue
+		}
+		if x.Start < start {
+			e = append(e, &pb.Extent{Start: x.Start, Count: start - x.Start})
+		}
+		if xend > end {
+			e = append(e, &pb.Extent{Start: end, Count: xend - end})
+		}
+	}
+	n.Extents = e
+}
+
+// dataExtents returns the extents of blocks that have data. Inodes from before
+// the extents were tracked are treated as having no holes.
+func dataExtents(n *pb.InodeEntry) []*pb.Extent {
+	if n.Version < 2 {
+		if n.Blocks == 0 {
+			return nil
+		}
+		return []*pb.Extent{{Start: 0, Count: n.Blocks}}
+	}
+	return n.Extents
+}
+
+// hasData returns whether block of n has been written, and not cut off by a
+// truncate since. The blocks that a truncate leaves for the Deletinator are
+// still in the store until it gets to them, so they have to be treated as
+// holes rather than read.
+func hasData(n *pb.InodeEntry, block uint64) bool {
+	for _, e := range dataExtents(n) {
+		if block >= e.Start && block < e.Start+e.Count {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeExtents starts tracking the extents of an inode from before they
+// were tracked, assuming that all of its blocks were written.
+func upgradeExtents(n *pb.InodeEntry) {
+	if n.Version < 2 {
+		n.Extents = dataExtents(n)
+		n.Version = 2
+	}
+}
+
+// seek finds the next data or hole at or after offset, in the same way as
+// lseek with SEEK_DATA or SEEK_HOLE. The end of the file counts as a hole.
+func seek(n *pb.InodeEntry, offset int64, whence uint32) (int64, error) {
+	size := int64(n.Attr.Size)
+	if offset < 0 {
+		return 0, ErrInvalid
+	}
+	if offset >= size {
+		return 0, ErrNoData
+	}
+	bs := int64(n.BlockSize)
+	var extents []*pb.Extent
+	if bs > 0 {
+		extents = dataExtents(n)
+	}
+	switch whence {
+	case SeekData:
+		for _, e := range extents {
+			start, end := int64(e.Start)*bs, int64(e.Start+e.Count)*bs
+			if end <= offset {
+				continue
+			}
+			if start >= size {
+				break
+			}
+			if start > offset {
+				return start, nil
+			}
+			return offset, nil
+		}
+		return 0, ErrNoData
+	case SeekHole:
+		for _, e := range extents {
+			start, end := int64(e.Start)*bs, int64(e.Start+e.Count)*bs
+			if start > offset {
+				break
+			}
+			if end > offset {
+				offset = end
+			}
+		}
+		if offset > size {
+			offset = size
+		}
+		return offset, nil
+	}
+	return 0, ErrInvalid
+}
+
+// Lseek answers SEEK_DATA and SEEK_HOLE from the blocks that have been
+// written. Blocks of writes that are still in progress may show up as holes.
+func (s *apiServer) Lseek(ctx context.Context, r *pb.LseekRequest) (*pb.LseekResponse, error) {
+	err := s.validateIP(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	fsid, err := GetFsId(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	// Like lseek itself, this works on a file open for either reading or
+	// writing
+	id := formic.GetID(fsid.Bytes(), r.Inode, 0)
+	n, err := s.accessHandle(ctx, id, MayRead)
+	if err == ErrAccess {
+		n, err = s.accessHandle(ctx, id, MayWrite)
+	}
+	if err != nil {
+		return nil, apiError(err)
+	}
+	log.Printf("LSEEK: Inode: %d Offset: %d Whence: %d", r.Inode, r.Offset, r.Whence)
+	offset, err := seek(n, r.Offset, r.Whence)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return &pb.LseekResponse{Offset: offset}, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+)
+
+func TestExtents(t *testing.T) {
+	n := &pb.InodeEntry{Version: InodeEntryVersion}
+	for _, block := range []uint64{5, 1, 2, 9, 0, 7, 8, 2} {
+		addExtent(n, block)
+	}
+	want := []pb.Extent{{Start: 0, Count: 3}, {Start: 5, Count: 1}, {Start: 7, Count: 3}}
+	if len(n.Extents) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, n.Extents)
+	}
+	for i, e := range n.Extents {
+		if *e != want[i] {
+			t.Fatalf("Expected %v, got %v", want, n.Extents)
+		}
+	}
+	addExtent(n, 6)
+	if len(n.Extents) != 2 || n.Extents[1].Start != 5 || n.Extents[1].Count != 5 {
+		t.Fatalf("Expected the extents on both sides to merge, got %v", n.Extents)
+	}
+	clipExtents(n, 8)
+	if len(n.Extents) != 2 || n.Extents[1].Count != 3 {
+		t.Fatalf("Expected the last extent to be cut, got %v", n.Extents)
+	}
+	clipExtents(n, 2)
+	if len(n.Extents) != 1 || n.Extents[0].Count != 2 {
+		t.Fatalf("Expected one extent of 2 blocks, got %v", n.Extents)
+	}
+}
+
+func TestSeek_Legacy(t *testing.T) {
+	// Files from before the extents were tracked have no holes
+	n := &pb.InodeEntry{Version: 1, Attr: &pb.Attr{Size: 25}, Blocks: 3, BlockSize: 10}
+	if off, err := seek(n, 12, SeekData); err != nil || off != 12 {
+		t.Fatalf("Expected data at 12, got %d: %v", off, err)
+	}
+	if off, err := seek(n, 12, SeekHole); err != nil || off != 25 {
+		t.Fatalf("Expected a hole at 25, got %d: %v", off, err)
+	}
+}
+
+func TestApiServer_Lseek(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	c := createFile(t, api, ctx, 1, "sparse")
+	// Data in blocks 1 and 4 to 5, with holes around it
+	for _, w := range []struct {
+		offset int64
+		data   string
+	}{{15, "hello"}, {40, "0123456789ab"}} {
+		if _, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Offset: w.offset, Payload: []byte(w.data)}); err != nil {
+			t.Fatal("Write failed: ", err)
+		}
+	}
+	waitForSize(t, api, ctx, c.Inode, 52)
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: 100})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	want := make([]byte, 52)
+	copy(want[15:], "hello")
+	copy(want[40:], "0123456789ab")
+	if !bytes.Equal(r.Payload, want) {
+		t.Fatalf("Expected read: %v received: %v", want, r.Payload)
+	}
+	for _, test := range []struct {
+		offset int64
+		whence uint32
+		want   int64
+		errno  syscall.Errno
+	}{
+		{0, SeekData, 10, 0},
+		{17, SeekData, 17, 0},
+		{20, SeekData, 40, 0},
+		{0, SeekHole, 0, 0},
+		{12, SeekHole, 20, 0},
+		{45, SeekHole, 52, 0},
+		{52, SeekData, 0, syscall.ENXIO},
+		{60, SeekHole, 0, syscall.ENXIO},
+		{0, 1, 0, syscall.EINVAL},
+	} {
+		l, err := api.Lseek(ctx, &pb.LseekRequest{Inode: c.Inode, Offset: test.offset, Whence: test.whence})
+		if test.errno != 0 {
+			if formic.Errno(err) != test.errno {
+				t.Errorf("Lseek(%d, %d) expected %v, got %v", test.offset, test.whence, test.errno, err)
+			}
+			continue
+		}
+		if err != nil || l.Offset != test.want {
+			t.Errorf("Lseek(%d, %d) expected %d, got %v: %v", test.offset, test.whence, test.want, l, err)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"golang.org/x/net/context"
+)
+
+// ReadStream sends the requested range a block at a time, fetching up to
+// blockConcurrency blocks ahead of what is being sent. The stream ends early
+// at the end of the file.
+func (s *apiServer) ReadStream(r *pb.ReadRequest, stream pb.Api_ReadStreamServer) error {
+	ctx := stream.Context()
+	err := s.validateIP(ctx)
+	if err != nil {
+		return apiError(err)
+	}
+	fsid, err := GetFsId(ctx)
+	if err != nil {
+		return apiError(err)
+	}
+	n, err := s.accessHandle(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0), MayRead)
+	if err != nil {
+		return apiError(err)
+	}
+	bs, err := s.blockSize(ctx, fsid, n)
+	if err != nil {
+		return apiError(err)
+	}
+	log.Printf("READSTREAM: Inode: %d Offset: %d Size: %d", r.Inode, r.Offset, r.Size)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		chunk []byte
+		err   error
+	}
+	end := r.Offset + r.Size
+	// The fetches are queued in order so that they can be sent in order as
+	// they finish
+	pending := make(chan chan result, s.blockConcurrency)
+	go func() {
+		defer close(pending)
+		for block := uint64(r.Offset / bs); int64(block)*bs < end; block++ {
+			c := make(chan result, 1)
+			select {
+			case pending <- c:
+			case <-ctx.Done():
+				return
+			}
+			if !hasData(n, block) {
+				c <- result{}
+				continue
+			}
+			go func(block uint64) {
+				chunk, err := s.fs.GetChunk(ctx, formic.GetID(fsid.Bytes(), r.Inode, block+1)) // block 0 is for inode data
+				c <- result{chunk: chunk, err: err}
+			}(block)
+		}
+	}()
+	offset := r.Offset
+	for c := range pending {
+		res := <-c
+		if res.err != nil && res.err != ErrNotFound {
+			return apiError(res.err)
+		}
+		first := offset % bs
+		chunk := fillHole(res.chunk, offset-first, bs, n.Attr.Size)
+		if first >= int64(len(chunk)) {
+			// Past the end of the file
+			break
+		}
+		payload := chunk[first:]
+		if int64(len(payload)) > end-offset {
+			payload = payload[:end-offset]
+		}
+		err = stream.Send(&pb.ReadResponse{Inode: r.Inode, Offset: offset, Payload: payload})
+		if err != nil {
+			return err
+		}
+		offset += int64(len(payload))
+		if int64(len(chunk)) < bs {
+			// A short block is the end of the file
+			break
+		}
+	}
+	return nil
+}
+
+// How many entries are sent in each ReadDirResponse
+const readDirBatch = 256
+
+// errDirLimit stops a listing once a ReadDir has sent as many entries as were
+// asked for.
+var errDirLimit = errors.New("Directory listing limit reached")
+
+// ReadDir sends the entries of a directory after the cookie in the request, a
+// batch at a time. The listing is read from the store as it is sent, so a slow
+// client holds up the listing rather than it piling up in memory.
+func (s *apiServer) ReadDir(r *pb.ReadDirRequest, stream pb.Api_ReadDirServer) error {
+	return s.readDir(r, stream, false)
+}
+
+// ReadDirPlus is ReadDir with the attributes of each entry, so that a listing
+// that goes on to look at every entry doesn't need a call for each one. The
+// attributes are only sent if the caller could look the entries up.
+func (s *apiServer) ReadDirPlus(r *pb.ReadDirRequest, stream pb.Api_ReadDirPlusServer) error {
+	return s.readDir(r, stream, true)
+}
+
+type readDirStream interface {
+	Context() context.Context
+	Send(*pb.ReadDirResponse) error
+}
+
+func (s *apiServer) readDir(r *pb.ReadDirRequest, stream readDirStream, plus bool) error {
+	ctx := stream.Context()
+	err := s.validateIP(ctx)
+	if err != nil {
+		return apiError(err)
+	}
+	fsid, err := GetFsId(ctx)
+	if err != nil {
+		return apiError(err)
+	}
+	c := GetCreds(ctx)
+	id := formic.GetID(fsid.Bytes(), r.Inode, 0)
+	n, err := s.accessInode(ctx, c, id, MayRead)
+	if err != nil {
+		return apiError(err)
+	}
+	plus = plus && c.canAccessACL(n.Attr, inodeACL(n), MayExec)
+	send := func(batch []*pb.DirEnt) error {
+		if plus {
+			batch, err = s.addAttrs(ctx, fsid.Bytes(), batch)
+			if err != nil || len(batch) == 0 {
+				return err
+			}
+		}
+		return stream.Send(&pb.ReadDirResponse{DirEntries: batch})
+	}
+	batch := make([]*pb.DirEnt, 0, readDirBatch)
+	sent := uint32(0)
+	err = s.fs.ReadDir(ctx, id, r.Cookie, r.CookieB, func(d *pb.DirEnt) error {
+		batch = append(batch, d)
+		sent++
+		limited := r.Limit > 0 && sent >= r.Limit
+		if len(batch) == readDirBatch || limited {
+			err := send(batch)
+			if err != nil {
+				return err
+			}
+			batch = make([]*pb.DirEnt, 0, readDirBatch)
+		}
+		if limited {
+			return errDirLimit
+		}
+		return nil
+	})
+	if err == errDirLimit {
+		return nil
+	}
+	if err == nil && len(batch) > 0 {
+		err = send(batch)
+	}
+	return apiError(err)
+}
+
+// addAttrs reads the attributes of the entries in batch, up to
+// blockConcurrency at once, leaving out any entries whose inodes have gone
+// since they were listed.
+func (s *apiServer) addAttrs(ctx context.Context, fsid []byte, batch []*pb.DirEnt) ([]*pb.DirEnt, error) {
+	errs := s.forBlocks(len(batch), func(i int) error {
+		attr, err := s.fs.GetAttr(ctx, formic.GetID(fsid, batch[i].Inode, 0))
+		batch[i].Attr = attr
+		return err
+	})
+	found := batch[:0]
+	for i, err := range errs {
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, batch[i])
+	}
+	return found, nil
+}
+
+// WriteStream stores the data from each request as it arrives, with up to
+// blockConcurrency blocks being stored at once. Any error ends the stream.
+func (s *apiServer) WriteStream(stream pb.Api_WriteStreamServer) error {
+	ctx := stream.Context()
+	err := s.validateIP(ctx)
+	if err != nil {
+		return apiError(err)
+	}
+	fsid, err := GetFsId(ctx)
+	if err != nil {
+		return apiError(err)
+	}
+	w := &blockWriter{
+		s:    s,
+		ctx:  ctx,
+		fsid: fsid.Bytes(),
+		sem:  make(chan struct{}, s.blockConcurrency),
+	}
+	checked := uint64(0)
+	bs := int64(0)
+	// Other clients are told once the writes have been stored, which is by
+	// the time this returns
+	written := make(map[uint64]bool)
+	defer func() {
+		for inode := range written {
+			s.changed(ctx, fsid, &pb.Invalidation{Inode: inode, Data: true})
+		}
+	}()
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.flush()
+			return err
+		}
+		if r.Inode != checked {
+			n, err := s.accessHandle(ctx, formic.GetID(fsid.Bytes(), r.Inode, 0), MayWrite)
+			if err == nil {
+				bs, err = s.blockSize(ctx, fsid, n)
+			}
+			if err != nil {
+				w.flush()
+				return apiError(err)
+			}
+			checked = r.Inode
+		}
+		written[r.Inode] = true
+		err = w.write(r, bs)
+		if err != nil {
+			w.flush()
+			return apiError(err)
+		}
+	}
+	err = w.flush()
+	if err != nil {
+		return apiError(err)
+	}
+	return stream.SendAndClose(&pb.WriteResponse{Status: 0})
+}
+
+// blockWriter stores the data from a WriteStream. Data is held until it fills
+// a block, or the stream moves somewhere else or ends, so that each block is
+// only written once and the blocks can be stored in parallel. The blocks may
+// be stored in any order, but the inode is only updated with the blocks up to
+// the first one still being stored, so the file never grows over data that
+// isn't there yet.
+type blockWriter struct {
+	sync.Mutex
+	s         *apiServer
+	ctx       context.Context
+	fsid      []byte
+	inode     uint64
+	blocksize int64
+	offset    int64 // Where buf starts in the file
+	buf       []byte
+	sem       chan struct{}
+	wg        sync.WaitGroup
+	stored    []*UpdateItem // Of the blocks being stored in order, nil until stored
+	next      int           // The first of stored not yet taken for updating
+	err       error
+}
+
+// write buffers and stores the request for a file with the given block size.
+func (w *blockWriter) write(r *pb.WriteRequest, blocksize int64) error {
+	if r.Inode != w.inode || r.Offset != w.offset+int64(len(w.buf)) {
+		// Wait for everything so far so that none of the stores overlap
+		err := w.flush()
+		if err != nil {
+			return err
+		}
+		w.inode = r.Inode
+		w.blocksize = blocksize
+		w.offset = r.Offset
+	}
+	w.buf = append(w.buf, r.Payload...)
+	for {
+		n := w.blocksize - w.offset%w.blocksize
+		if int64(len(w.buf)) < n {
+			break
+		}
+		w.store(w.buf[:n])
+		w.buf = w.buf[n:]
+	}
+	w.Lock()
+	defer w.Unlock()
+	return w.err
+}
+
+// store starts storing payload at the current offset, which must not cross a
+// block boundary.
+func (w *blockWriter) store(payload []byte) {
+	block := uint64(w.offset / w.blocksize)
+	first := w.offset % w.blocksize
+	inode := w.inode
+	blocksize := w.blocksize
+	w.offset += int64(len(payload))
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+	w.Lock()
+	i := len(w.stored)
+	w.stored = append(w.stored, nil)
+	w.Unlock()
+	go func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		size, err := w.s.storeBlock(w.ctx, w.fsid, inode, blocksize, block, first, payload)
+		if err == nil {
+			err = w.update(i, blockUpdate(w.fsid, inode, blocksize, block, size))
+		}
+		if err != nil {
+			w.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.Unlock()
+		}
+	}()
+}
+
+// update records that the ith block being stored has been, and updates the
+// inode with it and any stored blocks after it if the blocks before it have
+// all been stored too. A block that fails stops the ones after it from being
+// updated.
+func (w *blockWriter) update(i int, item *UpdateItem) error {
+	w.Lock()
+	w.stored[i] = item
+	if i != w.next || w.err != nil {
+		// Whichever block before this is stored last takes this with it
+		w.Unlock()
+		return nil
+	}
+	var ready []*UpdateItem
+	for w.next < len(w.stored) && w.stored[w.next] != nil {
+		ready = append(ready, w.stored[w.next])
+		w.next++
+	}
+	w.Unlock()
+	merged := ready[0]
+	for _, r := range ready[1:] {
+		merged.blocks = append(merged.blocks, r.blocks...)
+		if r.size > merged.size {
+			merged.size = r.size
+		}
+		merged.mtime = r.mtime
+	}
+	return w.s.updates.update(w.ctx, merged)
+}
+
+// flush stores whatever is left of a partial block and waits for all of the
+// stores to finish.
+func (w *blockWriter) flush() error {
+	if len(w.buf) > 0 {
+		w.store(w.buf)
+		w.buf = nil
+	}
+	w.wg.Wait()
+	w.Lock()
+	defer w.Unlock()
+	w.stored = nil
+	w.next = 0
+	return w.err
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"golang.org/x/net/context"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/getcfs/fuse"
+	"github.com/gholt/brimtime"
+	"github.com/gholt/store"
+)
+
+type fakeReadStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	resps []*pb.ReadResponse
+}
+
+func (s *fakeReadStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeReadStream) Send(r *pb.ReadResponse) error {
+	s.resps = append(s.resps, r)
+	return nil
+}
+
+type fakeWriteStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	reqs []*pb.WriteRequest
+	resp *pb.WriteResponse
+}
+
+func (s *fakeWriteStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeWriteStream) Recv() (*pb.WriteRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	r := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return r, nil
+}
+
+func (s *fakeWriteStream) SendAndClose(r *pb.WriteResponse) error {
+	s.resp = r
+	return nil
+}
+
+func TestApiServer_Streams(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	c := createFile(t, api, ctx, 1, "big")
+	data := []byte("The quick brown fox jumps over the lazy dog, again and again.")
+	// Uneven pieces so that they don't line up with the blocks, and a rewrite
+	// of part of the middle that has to be merged with what was stored
+	w := &fakeWriteStream{ctx: ctx}
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		w.reqs = append(w.reqs, &pb.WriteRequest{Inode: c.Inode, Offset: int64(i), Payload: data[i:end]})
+	}
+	w.reqs = append(w.reqs, &pb.WriteRequest{Inode: c.Inode, Offset: 16, Payload: []byte("BROWN")})
+	copy(data[16:], "BROWN")
+	if err := api.WriteStream(w); err != nil {
+		t.Fatal("WriteStream failed: ", err)
+	}
+	if w.resp == nil || w.resp.Status != 0 {
+		t.Fatal("Unexpected WriteStream response: ", w.resp)
+	}
+	waitForSize(t, api, ctx, c.Inode, uint64(len(data)))
+	r, err := api.Read(ctx, &pb.ReadRequest{Inode: c.Inode, Size: int64(len(data))})
+	if err != nil {
+		t.Fatal("Read failed: ", err)
+	}
+	if !bytes.Equal(r.Payload, data) {
+		t.Fatalf("Expected read: '%s' received: '%s'", data, r.Payload)
+	}
+	rs := &fakeReadStream{ctx: ctx}
+	// Ask for more than there is to make sure the stream stops at the end
+	if err = api.ReadStream(&pb.ReadRequest{Inode: c.Inode, Offset: 3, Size: 1000}, rs); err != nil {
+		t.Fatal("ReadStream failed: ", err)
+	}
+	var got []byte
+	for _, resp := range rs.resps {
+		if resp.Offset != int64(3+len(got)) {
+			t.Fatalf("Expected offset %d, got %d", 3+len(got), resp.Offset)
+		}
+		got = append(got, resp.Payload...)
+	}
+	if !bytes.Equal(got, data[3:]) {
+		t.Fatalf("Expected stream: '%s' received: '%s'", data[3:], got)
+	}
+	rs = &fakeReadStream{ctx: ctx}
+	if err = api.ReadStream(&pb.ReadRequest{Inode: c.Inode, Offset: 12, Size: 5}, rs); err != nil {
+		t.Fatal("ReadStream failed: ", err)
+	}
+	if len(rs.resps) != 1 || !bytes.Equal(rs.resps[0].Payload, data[12:17]) {
+		t.Fatalf("Unexpected stream for a partial range: %v", rs.resps)
+	}
+}
+
+type fakeReadDirStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	resps []*pb.ReadDirResponse
+}
+
+func (s *fakeReadDirStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeReadDirStream) Send(r *pb.ReadDirResponse) error {
+	s.resps = append(s.resps, r)
+	return nil
+}
+
+// readDir lists the directory from cookie with a ReadDir, checking that the
+// batches aren't too big and that the entries are in cookie order.
+func readDir(t *testing.T, api *apiServer, ctx context.Context, inode, cookie, cookieB uint64, limit uint32) []*pb.DirEnt {
+	s := &fakeReadDirStream{ctx: ctx}
+	if err := api.ReadDir(&pb.ReadDirRequest{Inode: inode, Cookie: cookie, CookieB: cookieB, Limit: limit}, s); err != nil {
+		t.Fatal("ReadDir failed: ", err)
+	}
+	var ents []*pb.DirEnt
+	for _, r := range s.resps {
+		if len(r.DirEntries) == 0 || len(r.DirEntries) > readDirBatch {
+			t.Fatalf("Unexpected batch of %d entries", len(r.DirEntries))
+		}
+		for _, d := range r.DirEntries {
+			if !keyAfter(d.Cookie, d.CookieB, cookie, cookieB) {
+				t.Fatalf("Entry %s has cookie %d/%d, not after %d/%d", d.Name, d.Cookie, d.CookieB, cookie, cookieB)
+			}
+			cookie, cookieB = d.Cookie, d.CookieB
+			ents = append(ents, d)
+		}
+	}
+	return ents
+}
+
+func TestApiServer_ReadDir(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	inodes := map[string]uint64{}
+	for i := 0; i < 600; i++ {
+		name := fmt.Sprintf("file%d", i)
+		inodes[name] = createFile(t, api, ctx, 1, name).Inode
+	}
+	m, err := api.MkDir(ctx, &pb.MkDirRequest{Parent: 1, Name: "dir", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	inodes["dir"] = m.Attr.Inode
+	l, err := api.Symlink(ctx, &pb.SymlinkRequest{Parent: 1, Name: "link", Target: "dir"})
+	if err != nil {
+		t.Fatal("Symlink failed: ", err)
+	}
+	inodes["link"] = l.Attr.Inode
+
+	all := readDir(t, api, ctx, 1, 0, 0, 0)
+	if len(all) != len(inodes) {
+		t.Fatalf("Expected %d entries, got %d", len(inodes), len(all))
+	}
+	for _, d := range all {
+		if d.Inode != inodes[d.Name] {
+			t.Errorf("Expected %s to be inode %d, got %d", d.Name, inodes[d.Name], d.Inode)
+		}
+		expected := fuse.DT_File
+		switch d.Name {
+		case "dir":
+			expected = fuse.DT_Dir
+		case "link":
+			expected = fuse.DT_Link
+		}
+		if fuse.DirentType(d.Type) != expected {
+			t.Errorf("Expected %s to have type %v, got %v", d.Name, expected, fuse.DirentType(d.Type))
+		}
+	}
+
+	// A page at a time, carrying on from the last cookie, removing an entry
+	// that hasn't been listed yet part of the way through
+	removed := all[len(all)-1].Name
+	var paged []*pb.DirEnt
+	var cookie, cookieB uint64
+	for {
+		page := readDir(t, api, ctx, 1, cookie, cookieB, 100)
+		if len(page) > 100 {
+			t.Fatalf("Expected at most 100 entries, got %d", len(page))
+		}
+		if len(page) == 0 {
+			break
+		}
+		if len(paged) == 0 {
+			if _, err = api.Remove(ctx, &pb.RemoveRequest{Parent: 1, Name: removed}); err != nil {
+				t.Fatal("Remove failed: ", err)
+			}
+		}
+		paged = append(paged, page...)
+		cookie, cookieB = page[len(page)-1].Cookie, page[len(page)-1].CookieB
+	}
+	if len(paged) != len(all)-1 {
+		t.Fatalf("Expected %d entries, got %d", len(all)-1, len(paged))
+	}
+	for i, d := range paged {
+		if d.Name != all[i].Name || d.Cookie != all[i].Cookie {
+			t.Fatalf("Expected entry %d to be %s, got %s", i, all[i].Name, d.Name)
+		}
+	}
+
+	// Both halves of the cookie count, so an entry whose first half matches
+	// but which comes after in the second is still listed
+	d := all[10]
+	page := readDir(t, api, ctx, 1, d.Cookie, d.CookieB-1, 1)
+	if d.CookieB > 0 && (len(page) != 1 || page[0].Name != d.Name) {
+		t.Fatalf("Expected %s to be listed, got %v", d.Name, page)
+	}
+}
+
+// countingGroupStore counts how many times the keys of a group are listed.
+type countingGroupStore struct {
+	*memGroupStore
+	sync.Mutex
+	lookups int
+}
+
+func (s *countingGroupStore) LookupGroup(ctx context.Context, parentKeyA, parentKeyB uint64) ([]store.LookupGroupItem, error) {
+	s.Lock()
+	s.lookups++
+	s.Unlock()
+	return s.memGroupStore.LookupGroup(ctx, parentKeyA, parentKeyB)
+}
+
+func TestApiServer_ReadDirPages(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	for i := 0; i < 100; i++ {
+		createFile(t, api, ctx, 1, fmt.Sprintf("file%d", i))
+	}
+	gstore := &countingGroupStore{memGroupStore: api.comms.gstore.(*memGroupStore)}
+	api.comms.gstore = gstore
+	var listed int
+	var cookie, cookieB uint64
+	for {
+		page := readDir(t, api, ctx, 1, cookie, cookieB, 7)
+		if len(page) == 0 {
+			break
+		}
+		listed += len(page)
+		cookie, cookieB = page[len(page)-1].Cookie, page[len(page)-1].CookieB
+	}
+	if listed != 100 {
+		t.Fatalf("Expected 100 entries, got %d", listed)
+	}
+	// Only the first page read the keys of the directory
+	if gstore.lookups != 1 {
+		t.Errorf("Expected the directory's keys to be read once, got %d", gstore.lookups)
+	}
+}
+
+func TestApiServer_ReadDirOldEntries(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	c := createFile(t, api, ctx, 1, "old")
+	fsid, _ := GetFsId(ctx)
+	// An entry from before entries recorded the inode number
+	d := &pb.DirEntry{Version: 1, Name: "old", Id: formic.GetID(fsid.Bytes(), c.Inode, 0), Type: uint32(fuse.DT_File)}
+	err := api.fs.(*OortFS).writeDirent(ctx, formic.GetID(fsid.Bytes(), 1, 0), d, brimtime.TimeToUnixMicro(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := readDir(t, api, ctx, 1, 0, 0, 0)
+	if len(ents) != 1 || ents[0].Inode != c.Inode {
+		t.Errorf("Expected old to be inode %d, got %v", c.Inode, ents)
+	}
+}
+
+func TestApiServer_ReadDirPlus(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blockConcurrency = 4
+	for i := 0; i < 300; i++ {
+		createFile(t, api, ctx, 1, fmt.Sprintf("file%d", i))
+	}
+	fsid, _ := GetFsId(ctx)
+	gone := createFile(t, api, ctx, 1, "gone")
+	if err := api.comms.DeleteValue(ctx, formic.GetID(fsid.Bytes(), gone.Inode, 0)); err != nil {
+		t.Fatal(err)
+	}
+	list := func(ctx context.Context) []*pb.DirEnt {
+		s := &fakeReadDirStream{ctx: ctx}
+		if err := api.ReadDirPlus(&pb.ReadDirRequest{Inode: 1}, s); err != nil {
+			t.Fatal("ReadDirPlus failed: ", err)
+		}
+		var ents []*pb.DirEnt
+		for _, r := range s.resps {
+			ents = append(ents, r.DirEntries...)
+		}
+		return ents
+	}
+	ents := list(ctx)
+	if len(ents) != 300 {
+		t.Fatalf("Expected 300 entries, got %d", len(ents))
+	}
+	for _, d := range ents {
+		a, err := api.GetAttr(ctx, &pb.GetAttrRequest{Inode: d.Inode})
+		if err != nil {
+			t.Fatal("GetAttr failed: ", err)
+		}
+		if d.Attr == nil || d.Attr.Inode != d.Inode || d.Attr.Mode != a.Attr.Mode || d.Attr.Ctime != a.Attr.Ctime {
+			t.Fatalf("Expected %s to have attributes %v, got %v", d.Name, a.Attr, d.Attr)
+		}
+	}
+
+	// Without search permission the names can still be listed, but nothing
+	// that a lookup wouldn't allow
+	_, err := api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: 1, Mode: uint32(os.ModeDir | 0644)}, Valid: uint32(fuse.SetattrMode)})
+	if err != nil {
+		t.Fatal("SetAttr failed: ", err)
+	}
+	ents = list(ctx)
+	if len(ents) != 300 {
+		t.Fatalf("Expected 300 entries, got %d", len(ents))
+	}
+	for _, d := range ents {
+		if d.Attr != nil {
+			t.Fatalf("Expected no attributes for %s, got %v", d.Name, d.Attr)
+		}
+	}
+}
@@ -1,120 +1,865 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
 	"github.com/gholt/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/satori/go.uuid"
 
 	"golang.org/x/net/context"
 )
 
+const UpdateJournalVersion = 1
+
+// Writes whose inodes haven't been updated yet are journaled in this group of
+// their file system, an entry for each inode update, so that the sizes aren't
+// lost if formicd dies. They are applied when formicd starts again, or by
+// another formicd once they are overdue.
+func updateJournalKey(fsid []byte) []byte {
+	return []byte(fmt.Sprintf("/fs/%s/updates", uuid.FromBytesOrNil(fsid)))
+}
+
+func updateJournalChild(inode uint64, qtime int64) []byte {
+	return []byte(fmt.Sprintf("%d/%d", inode, qtime))
+}
+
+const (
+	DefaultUpdateWorkers = 8
+	minUpdateBackoff     = time.Second
+	maxUpdateBackoff     = time.Minute
+	// How many times journaled writes are retried here before they are left
+	// for the rescan of the journal, about a minute with the backoff
+	maxUpdateRetries = 6
+	// How often the journal is checked for updates that were left behind
+	updateRescanInterval = time.Minute
+	// How old a journaled update has to be before a formicd that didn't
+	// journal it will apply it
+	updateOverdue = 5 * time.Minute
+)
+
+var (
+	updatePending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "formicd",
+		Subsystem: "update",
+		Name:      "pending",
+		Help:      "Inodes with writes waiting to be applied.",
+	})
+	updateWrites = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "formicd",
+		Subsystem: "update",
+		Name:      "writes_total",
+		Help:      "Block writes queued to be applied to their inodes.",
+	})
+	updateApplied = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "formicd",
+		Subsystem: "update",
+		Name:      "applied_total",
+		Help:      "Inode updates applied, each covering one or more writes.",
+	})
+)
+
 type UpdateItem struct {
-	id        []byte
-	block     uint64
+	fsid      []byte
+	inode     uint64
+	blocks    []uint64
 	blocksize uint64
-	size      uint64
+	size      uint64 // What the writes grow the file to
 	mtime     int64
+	qtime     int64 // When the writes were journaled, if they have been
+	retries   uint32
+}
+
+func (item *UpdateItem) id() []byte {
+	return formic.GetID(item.fsid, item.inode, 0)
+}
+
+// pendingUpdate is the writes to an inode that are waiting to be applied
+// together.
+type pendingUpdate struct {
+	items   []*UpdateItem
+	waiters []chan error
 }
 
+type updateShard struct {
+	sync.Mutex
+	pending map[string]*pendingUpdate // By inode id
+	wake    chan struct{}
+}
+
+// Updatinator records writes in their inodes. Each inode belongs to one of
+// the workers, so the updates to it are never applied concurrently, and the
+// writes that come in while an update is being applied are coalesced into the
+// next one.
 type Updatinator struct {
-	in chan *UpdateItem
-	fs FileService
+	fs     FileService
+	comms  *StoreComms
+	shards []*updateShard
 }
 
-func newUpdatinator(in chan *UpdateItem, fs FileService) *Updatinator {
-	return &Updatinator{
-		in: in,
-		fs: fs,
+func newUpdatinator(fs FileService, comms *StoreComms, workers int) *Updatinator {
+	u := &Updatinator{
+		fs:     fs,
+		comms:  comms,
+		shards: make([]*updateShard, workers),
+	}
+	for i := range u.shards {
+		u.shards[i] = &updateShard{
+			pending: make(map[string]*pendingUpdate),
+			wake:    make(chan struct{}, 1),
+		}
 	}
+	return u
 }
 
-func (u *Updatinator) run() {
-	// TODO: Add fan-out based on the id of the update
+// start starts the workers, and replays the journal in case formicd died with
+// updates outstanding.
+func (u *Updatinator) start() {
+	for _, s := range u.shards {
+		go u.work(s)
+	}
+	if u.comms != nil {
+		go u.rescan()
+	}
+}
+
+// update waits until the inode has been updated with the write, so that the
+// new size is seen by anything that comes after.
+func (u *Updatinator) update(ctx context.Context, item *UpdateItem) error {
+	updateWrites.Inc()
+	done := make(chan error, 1)
+	u.add(item, done)
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeJournal journals the writes that haven't been yet as a single entry,
+// along with how many times the inode has been truncated so that they aren't
+// replayed over a later truncate.
+func (u *Updatinator) writeJournal(ctx context.Context, items []*UpdateItem) error {
+	if u.comms == nil {
+		// TODO: Fix abstraction so that we don't have to do this for tests
+		return nil
+	}
+	var fresh []*UpdateItem
+	for _, item := range items {
+		if item.qtime == 0 {
+			fresh = append(fresh, item)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	n, err := u.fs.GetInode(ctx, fresh[0].id())
+	if err != nil {
+		return err
+	}
+	j := &pb.UpdateJournal{
+		Version:   UpdateJournalVersion,
+		Inode:     n.Inode,
+		Qtime:     brimtime.TimeToUnixMicro(time.Now()),
+		Truncates: n.Truncates,
+	}
+	for _, item := range fresh {
+		j.Blocks = append(j.Blocks, item.blocks...)
+		j.Blocksize = item.blocksize
+		if item.size > j.Size {
+			j.Size = item.size
+		}
+		if item.mtime > j.Mtime {
+			j.Mtime = item.mtime
+		}
+	}
+	b, err := formic.Marshal(j)
+	if err != nil {
+		return err
+	}
+	err = u.comms.WriteGroupTS(ctx, updateJournalKey(fresh[0].fsid), updateJournalChild(j.Inode, j.Qtime), b, j.Qtime)
+	if err != nil {
+		return err
+	}
+	for _, item := range fresh {
+		item.qtime = j.Qtime
+	}
+	return nil
+}
+
+// add hands the write to the worker for its inode. done, if given, is sent
+// the result of applying it.
+func (u *Updatinator) add(item *UpdateItem, done chan error) {
+	id := item.id()
+	s := u.shards[crc32.ChecksumIEEE(id)%uint32(len(u.shards))]
+	s.Lock()
+	p := s.pending[string(id)]
+	if p == nil {
+		p = &pendingUpdate{}
+		s.pending[string(id)] = p
+		updatePending.Inc()
+	}
+	p.items = append(p.items, item)
+	if done != nil {
+		p.waiters = append(p.waiters, done)
+	}
+	s.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (u *Updatinator) work(s *updateShard) {
+	for range s.wake {
+		s.Lock()
+		pending := s.pending
+		s.pending = make(map[string]*pendingUpdate)
+		s.Unlock()
+		for id, p := range pending {
+			updatePending.Dec()
+			// TODO: Need better context
+			u.apply(context.Background(), []byte(id), p)
+		}
+	}
+}
+
+// apply journals the pending writes and updates the inode with all of them
+// at once. If that fails the writers are told, and as the blocks have already
+// been stored the writes are applied again later, here with a backoff for a
+// while and then by the rescan of the journal. Writes that couldn't be
+// journaled either are kept being retried here as there is nothing else to
+// apply them.
+func (u *Updatinator) apply(ctx context.Context, id []byte, p *pendingUpdate) {
+	var blocks []uint64
+	var blocksize, size uint64
+	var mtime int64
+	for _, item := range p.items {
+		blocks = append(blocks, item.blocks...)
+		blocksize = item.blocksize
+		if item.size > size {
+			size = item.size
+		}
+		if item.mtime > mtime {
+			mtime = item.mtime
+		}
+	}
+	err := u.writeJournal(ctx, p.items)
+	if err != nil && err != ErrNotFound {
+		// They are still applied, but are lost if formicd dies first
+		log.Println("Couldn't journal update: ", err)
+	}
+	err = u.fs.Update(ctx, id, blocks, blocksize, size, mtime)
+	for _, done := range p.waiters {
+		done <- err
+	}
+	if err != nil && err != ErrNotFound {
+		u.retry(p.items, err)
+		return
+	}
+	// A file that is gone has nothing left to update
+	if err == nil {
+		updateApplied.Inc()
+	}
+	u.clearJournal(ctx, p.items)
+}
+
+// retry applies the writes again after a backoff, and leaves the journaled
+// ones that have been tried maxUpdateRetries times to the rescan.
+func (u *Updatinator) retry(items []*UpdateItem, err error) {
+	var again []*UpdateItem
+	var retries uint32
+	for _, item := range items {
+		if item.retries >= maxUpdateRetries && item.qtime != 0 {
+			continue
+		}
+		item.retries++
+		if item.retries > retries {
+			retries = item.retries
+		}
+		again = append(again, item)
+	}
+	if len(again) < len(items) {
+		log.Printf("Update failed, leaving %d writes to the journal: %s", len(items)-len(again), err)
+	}
+	if len(again) == 0 {
+		return
+	}
+	backoff := updateBackoff(retries)
+	if retries == 1 || backoff == maxUpdateBackoff {
+		log.Printf("Update failed, retrying in %s: %s", backoff, err)
+	}
+	time.AfterFunc(backoff, func() {
+		for _, item := range again {
+			u.add(item, nil)
+		}
+	})
+}
+
+// updateBackoff returns how long to wait before applying writes again after
+// they have failed retries times.
+func updateBackoff(retries uint32) time.Duration {
+	if retries == 0 {
+		return 0
+	}
+	if retries > 20 {
+		return maxUpdateBackoff
+	}
+	b := minUpdateBackoff << (retries - 1)
+	if b > maxUpdateBackoff {
+		return maxUpdateBackoff
+	}
+	return b
+}
+
+// clearJournal removes the journal entries of writes that have been applied.
+func (u *Updatinator) clearJournal(ctx context.Context, items []*UpdateItem) {
+	if u.comms == nil {
+		return
+	}
+	cleared := make(map[int64]bool)
+	for _, item := range items {
+		if item.qtime == 0 || cleared[item.qtime] {
+			continue
+		}
+		cleared[item.qtime] = true
+		err := u.comms.DeleteGroupItemTS(ctx, updateJournalKey(item.fsid), updateJournalChild(item.inode, item.qtime), item.qtime+1)
+		if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+			// It will be applied again, which is harmless
+			log.Println("Couldn't remove update journal entry: ", err)
+		}
+	}
+}
+
+func (u *Updatinator) rescan() {
+	// Everything is replayed when starting up, and only overdue updates
+	// after that as the rest may still be waiting on their workers.
+	var overdue time.Duration
 	for {
-		toupdate := <-u.in
-		log.Println("Updating: ", toupdate)
 		// TODO: Need better context
 		ctx := context.Background()
-		err := u.fs.Update(ctx, toupdate.id, toupdate.block, toupdate.blocksize, toupdate.size, toupdate.mtime)
+		fsids, err := listFSIDs(ctx, u.comms)
 		if err != nil {
-			log.Println("Update failed, requeing: ", err)
-			u.in <- toupdate
+			log.Println("Couldn't list file systems to replay updates: ", err)
+		}
+		for _, fsid := range fsids {
+			u.replay(ctx, fsid, overdue)
+		}
+		overdue = updateOverdue
+		time.Sleep(updateRescanInterval)
+	}
+}
+
+// replay applies the journaled writes to the file system that are more than
+// overdue old. Applying writes again is harmless, as writes only ever grow
+// files, but writes from before the file was last truncated are dropped as
+// they would grow it back.
+func (u *Updatinator) replay(ctx context.Context, fsid []byte, overdue time.Duration) {
+	key := updateJournalKey(fsid)
+	items, err := u.comms.ReadGroup(ctx, key)
+	if err != nil && !store.IsNotFound(err) {
+		log.Println("Couldn't read update journal: ", err)
+		return
+	}
+	due := brimtime.TimeToUnixMicro(time.Now().Add(-overdue))
+	for _, item := range items {
+		j := &pb.UpdateJournal{}
+		if err = formic.Unmarshal(item.Value, j); err != nil {
+			log.Println("Skipping bad update journal entry: ", err)
+			continue
+		}
+		if j.Qtime > due {
+			continue
+		}
+		n, err := u.fs.GetInode(ctx, formic.GetID(fsid, j.Inode, 0))
+		if err == nil && n.Truncates == j.Truncates {
+			u.add(&UpdateItem{
+				fsid:      fsid,
+				inode:     j.Inode,
+				blocks:    j.Blocks,
+				blocksize: j.Blocksize,
+				size:      j.Size,
+				mtime:     j.Mtime,
+				qtime:     j.Qtime,
+			}, nil)
+			continue
+		}
+		if err != nil && err != ErrNotFound {
+			log.Println("Couldn't replay update: ", err)
+			continue
+		}
+		// The file is gone or has been truncated since
+		err = u.comms.DeleteGroupItemTS(ctx, key, updateJournalChild(j.Inode, j.Qtime), j.Qtime+1)
+		if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+			log.Println("Couldn't remove update journal entry: ", err)
+		}
+	}
+}
+
+// listFSIDs returns the ids of all of the file systems.
+func listFSIDs(ctx context.Context, comms *StoreComms) ([][]byte, error) {
+	items, err := comms.ReadGroup(ctx, []byte("/fs"))
+	if err != nil && !store.IsNotFound(err) {
+		return nil, err
+	}
+	var fsids [][]byte
+	for _, item := range items {
+		var ref FileSysRef
+		if err = json.Unmarshal(item.Value, &ref); err != nil {
+			log.Println("Skipping bad file system reference: ", err)
+			continue
+		}
+		fsid, err := uuid.FromString(ref.FSID)
+		if err != nil {
+			log.Println("Skipping bad file system reference: ", err)
+			continue
 		}
+		fsids = append(fsids, fsid.Bytes())
 	}
+	return fsids, nil
 }
 
+const DeleteJournalVersion = 1
+
+// Deletes that haven't been carried out yet are journaled in this group of
+// their file system so that they are picked back up if formicd dies, either
+// when it starts again or by another formicd once they are overdue.
+func deleteJournalKey(fsid []byte) []byte {
+	return []byte(fmt.Sprintf("/fs/%s/deletes", uuid.FromBytesOrNil(fsid)))
+}
+
+const (
+	DefaultDeleteWorkers = 4
+	deleteQueueSize      = 1000
+	minDeleteBackoff     = time.Second
+	maxDeleteBackoff     = 10 * time.Minute
+	// How often the journal is checked for deletes that aren't queued here
+	deleteRescanInterval = time.Minute
+	// How long past its next attempt a delete has to be before a formicd
+	// that didn't queue it will take it over
+	deleteOverdue = 5 * time.Minute
+	// How many times the delete of a directory waits for the deletes of its
+	// entries to clear them from its group, about 17 minutes with the
+	// backoff, before it goes ahead and leaves them to finish on their own
+	maxDirDeleteWaits = 10
+)
+
+var (
+	deleteQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "formicd",
+		Subsystem: "delete",
+		Name:      "queue_depth",
+		Help:      "Deletes waiting to be carried out.",
+	})
+	deleteQueueOldest = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "formicd",
+		Subsystem: "delete",
+		Name:      "queue_oldest_seconds",
+		Help:      "How long the oldest journaled delete has been waiting, as of the last rescan.",
+	})
+	deleteAge = prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace: "formicd",
+		Subsystem: "delete",
+		Name:      "age_seconds",
+		Help:      "How long deletes waited from being queued until they were done.",
+	})
+)
+
 type DeleteItem struct {
+	fsid   []byte
 	parent []byte
 	name   string
+	ts     *pb.Tombstone // Set instead of parent and name for inodes that no longer have a listing
+	// Set along with ts when a truncate only frees the blocks from firstBlock
+	// on, and the inode stays
+	firstBlock uint64
+	truncate   bool
+	qtime      int64 // When the delete was first queued
+	retries    uint32
+	next       int64 // When the delete should next be tried
+}
+
+func (item *DeleteItem) journalChild() []byte {
+	if item.ts != nil {
+		return []byte(fmt.Sprintf("%d/%d/%d", item.ts.Inode, item.firstBlock, item.qtime))
+	}
+	return []byte(fmt.Sprintf("%x/%s/%d", item.parent, item.name, item.qtime))
+}
+
+func (item *DeleteItem) journal() *pb.DeleteJournal {
+	return &pb.DeleteJournal{
+		Version:    DeleteJournalVersion,
+		FsId:       item.fsid,
+		Parent:     item.parent,
+		Name:       item.name,
+		Ts:         item.ts,
+		FirstBlock: item.firstBlock,
+		Truncate:   item.truncate,
+		Qtime:      item.qtime,
+		Retries:    item.retries,
+		Next:       item.next,
+	}
 }
 
+func deleteItemFromJournal(j *pb.DeleteJournal) *DeleteItem {
+	return &DeleteItem{
+		fsid:       j.FsId,
+		parent:     j.Parent,
+		name:       j.Name,
+		ts:         j.Ts,
+		firstBlock: j.FirstBlock,
+		truncate:   j.Truncate,
+		qtime:      j.Qtime,
+		retries:    j.Retries,
+		next:       j.Next,
+	}
+}
+
+// deleteBackoff returns how long to wait before trying a delete again after
+// it has failed retries times.
+func deleteBackoff(retries uint32) time.Duration {
+	if retries == 0 {
+		return 0
+	}
+	if retries > 20 {
+		return maxDeleteBackoff
+	}
+	b := minDeleteBackoff << (retries - 1)
+	if b > maxDeleteBackoff {
+		return maxDeleteBackoff
+	}
+	return b
+}
+
+// Deletinator carries out deletes with a pool of workers. Deletes are
+// journaled before they are queued, so queueing never blocks, and anything
+// that doesn't fit in the queue is picked up from the journal later.
 type Deletinator struct {
-	in chan *DeleteItem
-	fs FileService
+	sync.Mutex
+	in      chan *DeleteItem
+	fs      FileService
+	comms   *StoreComms
+	workers int
+	queued  map[string]bool // Deletes that are queued here, or waiting to be retried
+	// Deletes of the same inode are serialized so that link counts aren't
+	// lost
+	inodeLocks [64]sync.Mutex
 }
 
-func newDeletinator(in chan *DeleteItem, fs FileService) *Deletinator {
+func newDeletinator(fs FileService, comms *StoreComms, workers int) *Deletinator {
 	return &Deletinator{
-		in: in,
-		fs: fs,
+		in:      make(chan *DeleteItem, deleteQueueSize),
+		fs:      fs,
+		comms:   comms,
+		workers: workers,
+		queued:  make(map[string]bool),
+	}
+}
+
+// start starts the workers, and replays the journal in case formicd died with
+// deletes outstanding.
+func (d *Deletinator) start() {
+	for i := 0; i < d.workers; i++ {
+		go d.work()
+	}
+	go d.rescan()
+}
+
+// queue journals the delete and hands it to the workers. If the delete can't
+// be journaled it is still carried out, but is lost if formicd dies first.
+func (d *Deletinator) queue(ctx context.Context, item *DeleteItem) {
+	if item.ts != nil {
+		item.fsid = item.ts.FsId
+	}
+	item.qtime = brimtime.TimeToUnixMicro(time.Now())
+	item.next = item.qtime
+	if err := d.writeJournal(ctx, item); err != nil {
+		log.Println("Couldn't journal delete: ", err)
+	}
+	deleteQueueDepth.Inc()
+	d.send(item)
+}
+
+func (d *Deletinator) writeJournal(ctx context.Context, item *DeleteItem) error {
+	b, err := formic.Marshal(item.journal())
+	if err != nil {
+		return err
+	}
+	return d.comms.WriteGroup(ctx, deleteJournalKey(item.fsid), item.journalChild(), b)
+}
+
+// queueKey is how the delete is known in queued.
+func (item *DeleteItem) queueKey() string {
+	return fmt.Sprintf("%x/%s", item.fsid, item.journalChild())
+}
+
+// send queues the delete unless it is already queued here.
+func (d *Deletinator) send(item *DeleteItem) {
+	key := item.queueKey()
+	d.Lock()
+	if d.queued[key] {
+		d.Unlock()
+		return
+	}
+	d.queued[key] = true
+	d.Unlock()
+	d.dispatch(item)
+}
+
+// dispatch hands a delete that is marked as queued to the workers, leaving it
+// for the next rescan of the journal if they are too far behind.
+func (d *Deletinator) dispatch(item *DeleteItem) {
+	select {
+	case d.in <- item:
+	default:
+		d.Lock()
+		delete(d.queued, item.queueKey())
+		d.Unlock()
+	}
+}
+
+func (d *Deletinator) work() {
+	for item := range d.in {
+		log.Println("Deleting: ", item)
+		// TODO: Need better context
+		ctx := context.Background()
+		if d.delete(ctx, item) {
+			d.finish(ctx, item)
+		} else {
+			d.retry(ctx, item)
+		}
+	}
+}
+
+// finish removes a delete that is done from the journal.
+func (d *Deletinator) finish(ctx context.Context, item *DeleteItem) {
+	err := d.comms.DeleteGroupItem(ctx, deleteJournalKey(item.fsid), item.journalChild())
+	if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+		// It will be done again, which is harmless
+		log.Println("Couldn't remove delete journal entry: ", err)
+	}
+	d.Lock()
+	delete(d.queued, item.queueKey())
+	d.Unlock()
+	deleteQueueDepth.Dec()
+	deleteAge.Observe(time.Since(brimtime.UnixMicroToTime(item.qtime)).Seconds())
+}
+
+// retry queues a delete that failed again once it has backed off.
+func (d *Deletinator) retry(ctx context.Context, item *DeleteItem) {
+	item.retries++
+	backoff := deleteBackoff(item.retries)
+	item.next = brimtime.TimeToUnixMicro(time.Now().Add(backoff))
+	if err := d.writeJournal(ctx, item); err != nil {
+		log.Println("Couldn't journal delete retry: ", err)
 	}
+	time.AfterFunc(backoff, func() { d.dispatch(item) })
 }
 
-func (d *Deletinator) run() {
-	// TODO: Parallelize this thing?
+func (d *Deletinator) rescan() {
+	// Everything is replayed when starting up, and only overdue deletes
+	// after that as another formicd may be working on the rest.
+	// TODO: A restarted formicd will redo deletes that another formicd is
+	// working on, which is harmless but wasteful.
+	var overdue time.Duration
 	for {
-		todelete := <-d.in
-		log.Println("Deleting: ", todelete)
 		// TODO: Need better context
 		ctx := context.Background()
+		fsids, err := listFSIDs(ctx, d.comms)
+		if err != nil {
+			log.Println("Couldn't list file systems to replay deletes: ", err)
+		}
+		now := time.Now()
+		oldest := brimtime.TimeToUnixMicro(now)
+		depth := 0
+		for _, fsid := range fsids {
+			n, o := d.replay(ctx, fsid, overdue)
+			depth += n
+			if o < oldest {
+				oldest = o
+			}
+		}
+		deleteQueueDepth.Set(float64(depth))
+		deleteQueueOldest.Set(now.Sub(brimtime.UnixMicroToTime(oldest)).Seconds())
+		overdue = deleteOverdue
+		time.Sleep(deleteRescanInterval)
+	}
+}
+
+// replay queues the journaled deletes of the file system that aren't queued
+// here and are more than overdue past their next attempt. It returns how many
+// deletes are journaled and when the oldest was queued.
+func (d *Deletinator) replay(ctx context.Context, fsid []byte, overdue time.Duration) (int, int64) {
+	now := time.Now()
+	oldest := brimtime.TimeToUnixMicro(now)
+	items, err := d.comms.ReadGroup(ctx, deleteJournalKey(fsid))
+	if err != nil && !store.IsNotFound(err) {
+		log.Println("Couldn't read delete journal: ", err)
+		return 0, oldest
+	}
+	due := brimtime.TimeToUnixMicro(now.Add(-overdue))
+	for _, item := range items {
+		j := &pb.DeleteJournal{}
+		if err = formic.Unmarshal(item.Value, j); err != nil {
+			log.Println("Skipping bad delete journal entry: ", err)
+			continue
+		}
+		if j.Qtime < oldest {
+			oldest = j.Qtime
+		}
+		if j.Next > due {
+			continue
+		}
+		j.FsId = fsid
+		d.send(deleteItemFromJournal(j))
+	}
+	return len(items), oldest
+}
+
+// delete carries out the delete, and returns false if it needs to be tried
+// again.
+func (d *Deletinator) delete(ctx context.Context, todelete *DeleteItem) bool {
+	ts := todelete.ts
+	if todelete.truncate {
+		return d.deleteBlocks(ctx, ts, todelete.firstBlock)
+	}
+	if ts == nil {
 		// Get the dir entry info
 		dirent, err := d.fs.GetDirent(ctx, todelete.parent, todelete.name)
 		if store.IsNotFound(err) {
 			// NOTE: If it isn't found then it is likely deleted.
 			//       Do we need to do more to ensure this?
 			//       Skip for now
-			continue
+			return true
 		}
 		if err != nil {
-			// TODO Better error handling?
-			// re-q the id, to try again later
 			log.Print("Delete error getting dirent: ", err)
-			d.in <- todelete
-			continue
+			return false
 		}
-		ts := dirent.Tombstone
+		ts = dirent.Tombstone
 		if ts == nil {
 			// TODO: probably an overwrite. just remove old file
-			continue
+			return true
 		}
-		deleted := uint64(0)
-		for b := uint64(0); b < ts.Blocks; b++ {
-			// Delete each block
-			id := formic.GetID(ts.FsId, ts.Inode, b+1)
-			err := d.fs.DeleteChunk(ctx, id, ts.Dtime)
-			if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
-				continue
-			}
-			deleted++
+	}
+	lock := &d.inodeLocks[ts.Inode%uint64(len(d.inodeLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+	// A link may have been added since the remove was queued, in which
+	// case the blocks are still in use and only the listing goes away.
+	inodeID := formic.GetID(ts.FsId, ts.Inode, 0)
+	linked := false
+	inode, err := d.fs.UpdateInode(ctx, inodeID, func(n *pb.InodeEntry) error {
+		linked = Nlink(n.Attr) > 1
+		if !linked {
+			return errNoChange
 		}
-		if deleted == ts.Blocks {
-			// Everything is deleted so delete the entry
-			err := d.fs.DeleteChunk(ctx, formic.GetID(ts.FsId, ts.Inode, 0), ts.Dtime)
-			if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
-				// Couldn't delete the inode entry so try again later
-				d.in <- todelete
-				continue
-			}
+		n.Attr.Nlink = Nlink(n.Attr) - 1
+		return nil
+	})
+	if err != nil && err != ErrNotFound {
+		log.Print("Delete error updating link count: ", err)
+		return false
+	}
+	if linked {
+		if todelete.ts == nil {
 			err = d.fs.DeleteListing(ctx, todelete.parent, todelete.name, ts.Dtime)
 			if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
 				log.Println("  Err: ", err)
-				// TODO: Better error handling
-				// Ignore for now to be picked up later?
 			}
-		} else {
-			// If all artifacts are not deleted requeue for later
-			d.in <- todelete
+		}
+		return true
+	}
+	if inode != nil && inode.IsDir && !d.emptyDir(ctx, ts.FsId, inodeID, todelete.retries) {
+		return false
+	}
+	if !d.deleteBlocks(ctx, ts, 0) {
+		// If all artifacts are not deleted try again later
+		return false
+	}
+	// Everything is deleted so delete the entry
+	err = d.fs.DeleteChunk(ctx, inodeID, ts.Dtime)
+	if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+		// Couldn't delete the inode entry so try again later
+		return false
+	}
+	// fsck no longer needs to know about the inode
+	err = d.fs.DeleteListing(ctx, inodeRecordsKey(ts.FsId, ts.Inode), inodeRecordName(ts.Inode), ts.Dtime)
+	if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+		log.Println("  Err: ", err)
+	}
+	if todelete.ts != nil {
+		// No listing to clean up
+		return true
+	}
+	err = d.fs.DeleteListing(ctx, todelete.parent, todelete.name, ts.Dtime)
+	if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+		log.Println("  Err: ", err)
+		// TODO: Better error handling
+		// Ignore for now to be picked up later?
+	}
+	return true
+}
+
+// emptyDir returns true once the group of the directory being deleted has
+// nothing left in it. Anything created in the directory after Remove checked
+// that it was empty can no longer be reached, so it is removed as the directory
+// is, and the delete waits on the deletes of the children to clear their
+// entries from the group. Those deletes don't need the directory, so after
+// the delete has waited maxDirDeleteWaits times it stops waiting for them.
+func (d *Deletinator) emptyDir(ctx context.Context, fsid, id []byte, waits uint32) bool {
+	items, err := d.comms.ReadGroup(ctx, id)
+	if err != nil {
+		log.Print("Delete error reading directory: ", err)
+		return false
+	}
+	live := 0
+	for _, item := range items {
+		de := &pb.DirEntry{}
+		if err = formic.Unmarshal(item.Value, de); err != nil {
+			log.Print("Delete error reading directory entry: ", err)
+			continue
+		}
+		if de.Tombstone != nil {
+			continue
+		}
+		err = d.fs.Unlink(ctx, fsid, id, de.Name)
+		if err != nil && err != ErrNotFound {
+			log.Printf("Delete error removing %s created in a removed directory: %s", de.Name, err)
+			live++
+		}
+	}
+	if len(items) == 0 {
+		return true
+	}
+	if live == 0 && waits >= maxDirDeleteWaits {
+		log.Printf("Delete leaving %d directory entries to their own deletes", len(items))
+		return true
+	}
+	log.Printf("Delete waiting on %d directory entries", len(items))
+	return false
+}
+
+// deleteBlocks deletes the blocks of the tombstoned inode from first on, and
+// returns true if they are all gone. Blocks written after the tombstone are
+// left alone.
+func (d *Deletinator) deleteBlocks(ctx context.Context, ts *pb.Tombstone, first uint64) bool {
+	deleted := true
+	for b := first; b < ts.Blocks; b++ {
+		id := formic.GetID(ts.FsId, ts.Inode, b+1) // block 0 is for inode data
+		err := d.fs.DeleteChunk(ctx, id, ts.Dtime)
+		if err != nil && !store.IsNotFound(err) && err != ErrStoreHasNewerValue {
+			deleted = false
 		}
 	}
+	return deleted
 }
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/getcfs/fuse"
+)
+
+func TestDeleteBackoff(t *testing.T) {
+	for retries, expected := range map[uint32]time.Duration{
+		0:   0,
+		1:   time.Second,
+		2:   2 * time.Second,
+		4:   8 * time.Second,
+		11:  maxDeleteBackoff,
+		100: maxDeleteBackoff,
+	} {
+		if b := deleteBackoff(retries); b != expected {
+			t.Errorf("Expected backoff %s after %d retries, got %s", expected, retries, b)
+		}
+	}
+}
+
+func TestDeletinator_Replay(t *testing.T) {
+	comms, err := NewStoreComms(NewMemValueStore(), NewMemGroupStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fsid := uuid.NewV4().Bytes()
+	// An OortFS whose Deletinator never runs, as if formicd died
+	o := &OortFS{hasher: crc32.NewIEEE, comms: comms}
+	o.deletes = newDeletinator(o, comms, 1)
+	if err = o.InitFs(ctx, fsid); err != nil {
+		t.Fatal(err)
+	}
+	root := formic.GetID(fsid, 1, 0)
+	id := formic.GetID(fsid, 100, 0)
+	block := formic.GetID(fsid, 100, 1)
+	attr := &pb.Attr{Inode: 100, Mode: 0644, Nlink: 1}
+	if _, _, err = o.Create(ctx, fsid, root, id, 100, "doomed", attr, false); err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	if err = o.WriteChunk(ctx, block, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Update(ctx, id, []uint64{0}, 10, 4, time.Now().Unix()); err != nil {
+		t.Fatal("Update failed: ", err)
+	}
+	if _, err = o.Remove(ctx, fsid, root, "doomed", false); err != nil {
+		t.Fatal("Remove failed: ", err)
+	}
+	items, err := comms.ReadGroup(ctx, deleteJournalKey(fsid))
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Expected 1 journaled delete, got %d: %v", len(items), err)
+	}
+	// Starting back up carries out the delete of the listed file system
+	ref, _ := json.Marshal(&FileSysRef{FSID: uuid.FromBytesOrNil(fsid).String()})
+	if err = comms.WriteGroup(ctx, []byte("/fs"), []byte(uuid.FromBytesOrNil(fsid).String()), ref); err != nil {
+		t.Fatal(err)
+	}
+	NewOortFS(comms)
+	for i := 0; ; i++ {
+		items, err = comms.ReadGroup(ctx, deleteJournalKey(fsid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("Delete wasn't replayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err = o.GetChunk(ctx, block); err != ErrNotFound {
+		t.Error("Expected block to be deleted, got: ", err)
+	}
+	if _, err = o.GetInode(ctx, id); err != ErrNotFound {
+		t.Error("Expected inode to be deleted, got: ", err)
+	}
+	if d, _ := o.GetDirent(ctx, root, "doomed"); d.Name != "" {
+		t.Errorf("Expected listing to be deleted, got: %v", d)
+	}
+}
+
+func TestUpdatinator_Concurrent(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	api.blocksize = 10
+	c := createFile(t, api, ctx, 1, "racy")
+	// Writers to every other block, all racing to grow the file
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := []byte(fmt.Sprintf("block%05d", i))
+			_, err := api.Write(ctx, &pb.WriteRequest{Inode: c.Inode, Offset: int64(i) * 20, Payload: payload})
+			if err != nil {
+				t.Error("Write failed: ", err)
+				return
+			}
+			// The size is up to date as soon as the write is done
+			a, err := api.GetAttr(ctx, &pb.GetAttrRequest{Inode: c.Inode})
+			if err != nil {
+				t.Error("GetAttr failed: ", err)
+				return
+			}
+			if end := uint64(i)*20 + 10; a.Attr.Size < end {
+				t.Errorf("Expected size of at least %d, got %d", end, a.Attr.Size)
+			}
+		}(i)
+	}
+	wg.Wait()
+	fsid, _ := GetFsId(ctx)
+	n, err := api.fs.GetInode(ctx, formic.GetID(fsid.Bytes(), c.Inode, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Attr.Size != 390 {
+		t.Errorf("Expected size 390, got %d", n.Attr.Size)
+	}
+	if len(n.Extents) != 20 {
+		t.Errorf("Expected 20 extents, got %v", n.Extents)
+	}
+	items, err := api.comms.ReadGroup(ctx, updateJournalKey(fsid.Bytes()))
+	if err != nil || len(items) != 0 {
+		t.Errorf("Expected the update journal to be empty, got %d: %v", len(items), err)
+	}
+}
+
+func TestUpdateBackoff(t *testing.T) {
+	for retries, expected := range map[uint32]time.Duration{
+		0:   0,
+		1:   time.Second,
+		3:   4 * time.Second,
+		7:   maxUpdateBackoff,
+		100: maxUpdateBackoff,
+	} {
+		if b := updateBackoff(retries); b != expected {
+			t.Errorf("Expected backoff %s after %d retries, got %s", expected, retries, b)
+		}
+	}
+}
+
+// failingUpdateFS fails every inode update, as if the store were down.
+type failingUpdateFS struct {
+	FileService
+	sync.Mutex
+	updates int
+}
+
+func (fs *failingUpdateFS) Update(ctx context.Context, id []byte, blocks []uint64, blocksize, size uint64, mtime int64) error {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.updates++
+	return ErrConflict
+}
+
+func TestUpdatinator_RetryLeavesJournal(t *testing.T) {
+	comms, err := NewStoreComms(NewMemValueStore(), NewMemGroupStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fsid := uuid.NewV4().Bytes()
+	o := &OortFS{hasher: crc32.NewIEEE, comms: comms}
+	o.deletes = newDeletinator(o, comms, 1)
+	if err = o.InitFs(ctx, fsid); err != nil {
+		t.Fatal(err)
+	}
+	root := formic.GetID(fsid, 1, 0)
+	if _, _, err = o.Create(ctx, fsid, root, formic.GetID(fsid, 100, 0), 100, "stuck", &pb.Attr{Inode: 100, Mode: 0644}, false); err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	fs := &failingUpdateFS{FileService: o}
+	u := newUpdatinator(fs, comms, 1)
+	// Just the workers, without the rescan
+	go u.work(u.shards[0])
+	// Writes that have already been retried as many times as they are here
+	item := &UpdateItem{fsid: fsid, inode: 100, blocks: []uint64{0}, blocksize: 10, size: 10, mtime: time.Now().Unix(), retries: maxUpdateRetries}
+	if err = u.update(ctx, item); err != ErrConflict {
+		t.Fatal("Expected the update to fail, got: ", err)
+	}
+	time.Sleep(2 * minUpdateBackoff)
+	fs.Lock()
+	updates := fs.updates
+	fs.Unlock()
+	if updates != 1 {
+		t.Errorf("Expected the write to be left to the journal, got %d updates", updates)
+	}
+	items, err := comms.ReadGroup(ctx, updateJournalKey(fsid))
+	if err != nil || len(items) != 1 {
+		t.Errorf("Expected the write to stay journaled, got %d: %v", len(items), err)
+	}
+}
+
+func TestUpdatinator_Replay(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	c := createFile(t, api, ctx, 1, "lost")
+	fsid, _ := GetFsId(ctx)
+	if err := api.fs.WriteChunk(ctx, formic.GetID(fsid.Bytes(), c.Inode, 3), []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	// A write that formicd died before applying
+	u := newUpdatinator(api.fs, api.comms, 1)
+	item := &UpdateItem{fsid: fsid.Bytes(), inode: c.Inode, blocks: []uint64{2}, blocksize: 10, size: 24, mtime: time.Now().Unix()}
+	if err := u.writeJournal(ctx, []*UpdateItem{item}); err != nil {
+		t.Fatal(err)
+	}
+	// And one that was applied, but whose file has been truncated since
+	cut := createFile(t, api, ctx, 1, "cut")
+	if _, err := api.Write(ctx, &pb.WriteRequest{Inode: cut.Inode, Payload: make([]byte, 24)}); err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	item = &UpdateItem{fsid: fsid.Bytes(), inode: cut.Inode, blocks: []uint64{0}, blocksize: 65536, size: 24, mtime: time.Now().Unix()}
+	if err := u.writeJournal(ctx, []*UpdateItem{item}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := api.SetAttr(ctx, &pb.SetAttrRequest{Attr: &pb.Attr{Inode: cut.Inode, Size: 5}, Valid: uint32(fuse.SetattrSize)})
+	if err != nil {
+		t.Fatal("SetAttr failed: ", err)
+	}
+	u.start()
+	for i := 0; ; i++ {
+		a, err := api.GetAttr(ctx, &pb.GetAttrRequest{Inode: c.Inode})
+		if err != nil {
+			t.Fatal("GetAttr failed: ", err)
+		}
+		if a.Attr.Size == 24 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("Update wasn't replayed, size is ", a.Attr.Size)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; ; i++ {
+		items, err := api.comms.ReadGroup(ctx, updateJournalKey(fsid.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("Update journal wasn't cleared")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a, err := api.GetAttr(ctx, &pb.GetAttrRequest{Inode: cut.Inode})
+	if err != nil || a.Attr.Size != 5 {
+		t.Fatalf("Expected the truncated file to stay at 5 bytes, got %v: %v", a, err)
+	}
+}
+
+func TestBlockWriter_UpdatesInOrder(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	c := createFile(t, api, ctx, 1, "streamed")
+	fsid, _ := GetFsId(ctx)
+	w := &blockWriter{s: api, ctx: ctx, fsid: fsid.Bytes(), stored: make([]*UpdateItem, 2)}
+	size := func() uint64 {
+		a, err := api.GetAttr(ctx, &pb.GetAttrRequest{Inode: c.Inode})
+		if err != nil {
+			t.Fatal("GetAttr failed: ", err)
+		}
+		return a.Attr.Size
+	}
+	// The second block is stored before the first
+	if err := w.update(1, blockUpdate(fsid.Bytes(), c.Inode, 10, 1, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if s := size(); s != 0 {
+		t.Fatalf("Expected the file not to grow before the first block is stored, got %d", s)
+	}
+	if err := w.update(0, blockUpdate(fsid.Bytes(), c.Inode, 10, 0, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if s := size(); s != 20 {
+		t.Fatalf("Expected the file to grow over both blocks, got %d", s)
+	}
+}
+
+func TestDeletinator_CreateInRemovedDir(t *testing.T) {
+	comms, err := NewStoreComms(NewMemValueStore(), NewMemGroupStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fsid := uuid.NewV4().Bytes()
+	// An OortFS whose Deletinator isn't running yet
+	o := &OortFS{hasher: crc32.NewIEEE, comms: comms}
+	o.deletes = newDeletinator(o, comms, 1)
+	if err = o.InitFs(ctx, fsid); err != nil {
+		t.Fatal(err)
+	}
+	root := formic.GetID(fsid, 1, 0)
+	dir := formic.GetID(fsid, 100, 0)
+	file := formic.GetID(fsid, 101, 0)
+	if _, _, err = o.Create(ctx, fsid, root, dir, 100, "dir", &pb.Attr{Inode: 100, Mode: 0755}, true); err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	if _, err = o.Remove(ctx, fsid, root, "dir", true); err != nil {
+		t.Fatal("Remove failed: ", err)
+	}
+	// A create that checked the directory just before it was removed
+	if _, _, err = o.Create(ctx, fsid, dir, file, 101, "late", &pb.Attr{Inode: 101, Mode: 0644}, false); err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	o.deletes.start()
+	for i := 0; ; i++ {
+		items, err := comms.ReadGroup(ctx, deleteJournalKey(fsid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		if i > 500 {
+			t.Fatalf("Deletes weren't done, %d left", len(items))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err = o.GetInode(ctx, file); err != ErrNotFound {
+		t.Error("Expected the late file to be deleted, got: ", err)
+	}
+	if _, err = o.GetInode(ctx, dir); err != ErrNotFound {
+		t.Error("Expected the directory to be deleted, got: ", err)
+	}
+	if items, _ := comms.ReadGroup(ctx, dir); len(items) != 0 {
+		t.Errorf("Expected the directory's group to be empty, got %d items", len(items))
+	}
+}
+
+func TestDeletinator_DirWaitBounded(t *testing.T) {
+	comms, err := NewStoreComms(NewMemValueStore(), NewMemGroupStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fsid := uuid.NewV4().Bytes()
+	// An OortFS whose Deletinator never runs, so the listing of the removed
+	// file is never cleared
+	o := &OortFS{hasher: crc32.NewIEEE, comms: comms}
+	o.deletes = newDeletinator(o, comms, 1)
+	if err = o.InitFs(ctx, fsid); err != nil {
+		t.Fatal(err)
+	}
+	root := formic.GetID(fsid, 1, 0)
+	dir := formic.GetID(fsid, 100, 0)
+	file := formic.GetID(fsid, 101, 0)
+	if _, _, err = o.Create(ctx, fsid, root, dir, 100, "dir", &pb.Attr{Inode: 100, Mode: 0755}, true); err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	if _, _, err = o.Create(ctx, fsid, dir, file, 101, "file", &pb.Attr{Inode: 101, Mode: 0644}, false); err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+	if _, err = o.Remove(ctx, fsid, dir, "file", false); err != nil {
+		t.Fatal("Remove failed: ", err)
+	}
+	if o.deletes.emptyDir(ctx, fsid, dir, 0) {
+		t.Error("Expected the delete to wait on the removed file")
+	}
+	if !o.deletes.emptyDir(ctx, fsid, dir, maxDirDeleteWaits) {
+		t.Error("Expected the delete to stop waiting")
+	}
+}
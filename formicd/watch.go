@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/creiht/formic"
+	pb "github.com/creiht/formic/proto"
+	"github.com/gholt/brimtime"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+const ChangeBatchVersion = 1
+
+const (
+	// How often the changes made through a formicd are passed on to the
+	// others. They look for them more often than that, so that they don't
+	// miss any batches.
+	changeInterval = time.Second
+	changePoll     = changeInterval / 4
+	// Most changes passed on at once, beyond which the clients are told that
+	// they have missed some
+	maxBatchChanges = 10000
+	// Most invalidations held for a client that isn't keeping up
+	maxWatchPending = 10000
+)
+
+// The latest changes made through each formicd are kept in a group for each
+// file system, with one item for each formicd that each replaces the last.
+func changesKey(fsid string) []byte {
+	return []byte(fmt.Sprintf("/formicd/changes/%s", fsid))
+}
+
+// GetClient returns the id sent in the client metadata, which a client uses so
+// that it isn't told about its own changes.
+func GetClient(ctx context.Context) string {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v, ok := md["client"]; ok && len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// change is an invalidation along with the client that caused it.
+type change struct {
+	client string
+	inode  uint64
+	data   bool
+	parent uint64
+	name   string
+}
+
+func (c change) invalidation() *pb.Invalidation {
+	return &pb.Invalidation{Inode: c.inode, Data: c.data, Parent: c.parent, Name: c.name}
+}
+
+// watch is a client that is waiting on invalidations.
+type watch struct {
+	sync.Mutex
+	client  string
+	pending []*pb.Invalidation
+	missed  bool
+	wake    chan struct{}
+}
+
+// add queues c for the client, unless it made the change.
+func (w *watch) add(c change) {
+	if w.client != "" && w.client == c.client {
+		return
+	}
+	w.Lock()
+	if len(w.pending) >= maxWatchPending {
+		w.pending = nil
+		w.missed = true
+	} else if !w.missed {
+		w.pending = append(w.pending, c.invalidation())
+	}
+	w.Unlock()
+	w.poke()
+}
+
+// miss tells the client that it has missed some changes.
+func (w *watch) miss() {
+	w.Lock()
+	w.pending = nil
+	w.missed = true
+	w.Unlock()
+	w.poke()
+}
+
+func (w *watch) poke() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns what is queued for the client.
+func (w *watch) take() ([]*pb.Invalidation, bool) {
+	w.Lock()
+	defer w.Unlock()
+	pending, missed := w.pending, w.missed
+	w.pending = nil
+	w.missed = false
+	return pending, missed
+}
+
+// Invalidator tells clients about changes made by other clients, so that they
+// can drop what they have cached. Changes made through this formicd are passed
+// straight on to its own clients, and to the other formicds through the group
+// store in batches.
+type Invalidator struct {
+	sync.Mutex
+	comms    *StoreComms
+	node     string
+	watches  map[string]map[*watch]bool // By fsid
+	pending  map[string]map[change]bool // Not passed on yet, by fsid
+	overflow map[string]bool
+	seqs     map[string]uint64 // Of the last batch passed on, by fsid
+	seen     map[string]*seenBatches
+}
+
+// seenBatches tracks the batches taken from the other formicds for a file
+// system that is being watched.
+type seenBatches struct {
+	since int64             // Timestamp micro the file system was first watched
+	seqs  map[string]uint64 // Of the last batch from each formicd
+}
+
+func newInvalidator(comms *StoreComms, node string) *Invalidator {
+	return &Invalidator{
+		comms:    comms,
+		node:     node,
+		watches:  make(map[string]map[*watch]bool),
+		pending:  make(map[string]map[change]bool),
+		overflow: make(map[string]bool),
+		seqs:     make(map[string]uint64),
+		seen:     make(map[string]*seenBatches),
+	}
+}
+
+// start starts passing changes to and from the other formicds.
+func (v *Invalidator) start() {
+	if v.comms == nil {
+		return
+	}
+	go func() {
+		publish := time.NewTicker(changeInterval)
+		poll := time.NewTicker(changePoll)
+		for {
+			select {
+			case <-publish.C:
+				v.publish(context.Background())
+			case <-poll.C:
+				v.poll(context.Background())
+			}
+		}
+	}()
+}
+
+// watch adds a client watching the file system.
+func (v *Invalidator) watch(fsid, client string) *watch {
+	w := &watch{client: client, wake: make(chan struct{}, 1)}
+	v.Lock()
+	defer v.Unlock()
+	if v.watches[fsid] == nil {
+		v.watches[fsid] = make(map[*watch]bool)
+		v.seen[fsid] = &seenBatches{
+			since: brimtime.TimeToUnixMicro(time.Now()),
+			seqs:  make(map[string]uint64),
+		}
+	}
+	v.watches[fsid][w] = true
+	return w
+}
+
+func (v *Invalidator) unwatch(fsid string, w *watch) {
+	v.Lock()
+	defer v.Unlock()
+	delete(v.watches[fsid], w)
+	if len(v.watches[fsid]) == 0 {
+		delete(v.watches, fsid)
+		delete(v.seen, fsid)
+	}
+}
+
+// changed records that client has changed what invs say.
+func (v *Invalidator) changed(fsid, client string, invs ...*pb.Invalidation) {
+	v.Lock()
+	defer v.Unlock()
+	for _, inv := range invs {
+		c := change{client: client, inode: inv.Inode, data: inv.Data, parent: inv.Parent, name: inv.Name}
+		for w := range v.watches[fsid] {
+			w.add(c)
+		}
+		if v.overflow[fsid] {
+			continue
+		}
+		if v.pending[fsid] == nil {
+			v.pending[fsid] = make(map[change]bool)
+		}
+		v.pending[fsid][c] = true
+		if len(v.pending[fsid]) > maxBatchChanges {
+			delete(v.pending, fsid)
+			v.overflow[fsid] = true
+		}
+	}
+}
+
+// publish passes on the changes made since the last time.
+func (v *Invalidator) publish(ctx context.Context) {
+	v.Lock()
+	batches := make(map[string]*pb.ChangeBatch)
+	for fsid, changes := range v.pending {
+		batches[fsid] = v.batch(fsid, changes, false)
+	}
+	for fsid := range v.overflow {
+		batches[fsid] = v.batch(fsid, nil, true)
+	}
+	v.pending = make(map[string]map[change]bool)
+	v.overflow = make(map[string]bool)
+	v.Unlock()
+	for fsid, b := range batches {
+		data, err := formic.Marshal(b)
+		if err == nil {
+			err = v.comms.WriteGroup(ctx, changesKey(fsid), []byte(v.node), data)
+		}
+		if err != nil {
+			// The other formicds will see the gap when the next batch
+			// goes through, and their clients will drop everything
+			log.Printf("Couldn't pass on changes to %s: %s", fsid, err)
+		}
+	}
+}
+
+// batch numbers the next batch of changes to the file system. It must be
+// called with the Invalidator locked.
+func (v *Invalidator) batch(fsid string, changes map[change]bool, overflow bool) *pb.ChangeBatch {
+	seq, ok := v.seqs[fsid]
+	if !ok {
+		// Batches from before a restart may have been lost, so the numbers
+		// carry on from well past them
+		seq = uint64(brimtime.TimeToUnixMicro(time.Now()))
+	}
+	seq++
+	v.seqs[fsid] = seq
+	b := &pb.ChangeBatch{Version: ChangeBatchVersion, Node: v.node, Seq: seq, Overflow: overflow}
+	for c := range changes {
+		b.Changes = append(b.Changes, &pb.Change{Client: c.client, Invalidation: c.invalidation()})
+	}
+	return b
+}
+
+// poll passes the changes made through the other formicds on to the clients
+// watching the file systems they were made in.
+func (v *Invalidator) poll(ctx context.Context) {
+	v.Lock()
+	fsids := make([]string, 0, len(v.watches))
+	for fsid := range v.watches {
+		fsids = append(fsids, fsid)
+	}
+	v.Unlock()
+	for _, fsid := range fsids {
+		items, err := v.comms.ReadGroup(ctx, changesKey(fsid))
+		if err != nil {
+			log.Printf("Couldn't read changes to %s: %s", fsid, err)
+			continue
+		}
+		v.Lock()
+		seen := v.seen[fsid]
+		for _, item := range items {
+			if seen == nil {
+				// No longer watched
+				break
+			}
+			b := &pb.ChangeBatch{}
+			err = formic.Unmarshal(item.Value, b)
+			if err != nil || b.Node == v.node {
+				continue
+			}
+			last, ok := seen.seqs[b.Node]
+			seen.seqs[b.Node] = b.Seq
+			switch {
+			case !ok && item.TimestampMicro < seen.since:
+				// From before anything was cached
+			case ok && b.Seq <= last:
+				// Already passed on
+			case (ok && b.Seq > last+1) || b.Overflow:
+				for w := range v.watches[fsid] {
+					w.miss()
+				}
+			default:
+				for _, c := range b.Changes {
+					if c.Invalidation == nil {
+						continue
+					}
+					i := c.Invalidation
+					ch := change{client: c.Client, inode: i.Inode, data: i.Data, parent: i.Parent, name: i.Name}
+					for w := range v.watches[fsid] {
+						w.add(ch)
+					}
+				}
+			}
+		}
+		v.Unlock()
+	}
+}
+
+// Watch sends the client invalidations for what other clients change in the
+// file system, until the client goes away. Clients only see the names of the
+// entries that have changed, and the client of a file system is trusted with
+// all of it anyway.
+func (s *apiServer) Watch(r *pb.WatchRequest, stream pb.Api_WatchServer) error {
+	ctx := stream.Context()
+	err := s.validateIP(ctx)
+	if err != nil {
+		return apiError(err)
+	}
+	fsid, err := GetFsId(ctx)
+	if err != nil {
+		return apiError(err)
+	}
+	w := s.invalidator.watch(fsid.String(), GetClient(ctx))
+	defer s.invalidator.unwatch(fsid.String(), w)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.wake:
+		}
+		invs, missed := w.take()
+		if len(invs) == 0 && !missed {
+			continue
+		}
+		err = stream.Send(&pb.WatchResponse{Invalidations: invs, Missed: missed})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// changed tells the clients watching the file system, other than the one that
+// made the request, what has changed.
+func (s *apiServer) changed(ctx context.Context, fsid uuid.UUID, invs ...*pb.Invalidation) {
+	s.invalidator.changed(fsid.String(), GetClient(ctx), invs...)
+}
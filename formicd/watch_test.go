@@ -0,0 +1,178 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"golang.org/x/net/context"
+
+	pb "github.com/creiht/formic/proto"
+)
+
+// withClient returns ctx with the client metadata set.
+func withClient(ctx context.Context, client string) context.Context {
+	md, _ := metadata.FromContext(ctx)
+	md = md.Copy()
+	md["client"] = []string{client}
+	return metadata.NewContext(ctx, md)
+}
+
+// hasEntry reports whether invs includes the entry name in parent.
+func hasEntry(invs []*pb.Invalidation, parent uint64, name string) bool {
+	for _, inv := range invs {
+		if inv.Parent == parent && inv.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestApiServer_WatchSkipsOrigin(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	fsid, _ := GetFsId(ctx)
+	a := api.invalidator.watch(fsid.String(), "a")
+	defer api.invalidator.unwatch(fsid.String(), a)
+	b := api.invalidator.watch(fsid.String(), "b")
+	defer api.invalidator.unwatch(fsid.String(), b)
+	attr := createFile(t, api, withClient(ctx, "a"), 1, "file")
+	if invs, missed := a.take(); len(invs) != 0 || missed {
+		t.Errorf("Expected nothing for the client that made the change, got %v %v", invs, missed)
+	}
+	invs, missed := b.take()
+	if missed || !hasEntry(invs, 1, "file") {
+		t.Errorf("Expected file to be invalidated, got %v %v", invs, missed)
+	}
+	_, err := api.Write(withClient(ctx, "b"), &pb.WriteRequest{Inode: attr.Inode, Payload: []byte("data")})
+	if err != nil {
+		t.Fatal("Write failed: ", err)
+	}
+	if invs, _ := b.take(); len(invs) != 0 {
+		t.Errorf("Expected nothing for the client that made the change, got %v", invs)
+	}
+	invs, _ = a.take()
+	if len(invs) != 1 || invs[0].Inode != attr.Inode || !invs[0].Data {
+		t.Errorf("Expected the data of %d to be invalidated, got %v", attr.Inode, invs)
+	}
+}
+
+func TestApiServer_WatchOtherNode(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	// A second formicd on the same store
+	other := NewApiServer(NewOortFS(api.comms), 2, api.comms)
+	fsid, _ := GetFsId(ctx)
+	w := other.invalidator.watch(fsid.String(), "b")
+	defer other.invalidator.unwatch(fsid.String(), w)
+	createFile(t, api, withClient(ctx, "a"), 1, "file")
+	api.invalidator.publish(ctx)
+	other.invalidator.poll(ctx)
+	invs, missed := w.take()
+	if missed || !hasEntry(invs, 1, "file") {
+		t.Fatalf("Expected file to be invalidated, got %v %v", invs, missed)
+	}
+	// The same batch isn't passed on twice
+	other.invalidator.poll(ctx)
+	if invs, missed := w.take(); len(invs) != 0 || missed {
+		t.Errorf("Expected nothing new, got %v %v", invs, missed)
+	}
+	// Changes made by the watching client itself on the other formicd are
+	// skipped here too
+	createFile(t, api, withClient(ctx, "b"), 1, "mine")
+	api.invalidator.publish(ctx)
+	other.invalidator.poll(ctx)
+	if invs, missed := w.take(); len(invs) != 0 || missed {
+		t.Errorf("Expected nothing for the client that made the change, got %v %v", invs, missed)
+	}
+}
+
+func TestApiServer_WatchMissed(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	other := NewApiServer(NewOortFS(api.comms), 2, api.comms)
+	fsid, _ := GetFsId(ctx)
+	w := other.invalidator.watch(fsid.String(), "b")
+	defer other.invalidator.unwatch(fsid.String(), w)
+	createFile(t, api, ctx, 1, "first")
+	api.invalidator.publish(ctx)
+	other.invalidator.poll(ctx)
+	w.take()
+	// A batch that never made it
+	api.invalidator.Lock()
+	api.invalidator.seqs[fsid.String()]++
+	api.invalidator.Unlock()
+	createFile(t, api, ctx, 1, "second")
+	api.invalidator.publish(ctx)
+	other.invalidator.poll(ctx)
+	if _, missed := w.take(); !missed {
+		t.Error("Expected a gap in the batches to be missed")
+	}
+	// More changes than a batch holds
+	invs := make([]*pb.Invalidation, maxBatchChanges+1)
+	for i := range invs {
+		invs[i] = &pb.Invalidation{Inode: uint64(i + 100)}
+	}
+	api.changed(ctx, fsid, invs...)
+	api.invalidator.publish(ctx)
+	other.invalidator.poll(ctx)
+	if invs, missed := w.take(); len(invs) != 0 || !missed {
+		t.Errorf("Expected an overflowed batch to be missed, got %d invalidations", len(invs))
+	}
+}
+
+type fakeWatchStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	resps chan *pb.WatchResponse
+}
+
+func (s *fakeWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeWatchStream) Send(r *pb.WatchResponse) error {
+	s.resps <- r
+	return nil
+}
+
+func TestApiServer_Watch(t *testing.T) {
+	api, ctx := newMemApiServer(t)
+	wctx, cancel := context.WithCancel(withClient(ctx, "b"))
+	stream := &fakeWatchStream{ctx: wctx, resps: make(chan *pb.WatchResponse, 16)}
+	done := make(chan error)
+	go func() {
+		done <- api.Watch(&pb.WatchRequest{}, stream)
+	}()
+	// Wait for the watch to start
+	fsid, _ := GetFsId(ctx)
+	for {
+		api.invalidator.Lock()
+		n := len(api.invalidator.watches[fsid.String()])
+		api.invalidator.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, err := api.MkDir(withClient(ctx, "a"), &pb.MkDirRequest{Parent: 1, Name: "dir", Attr: &pb.Attr{Mode: 0755}})
+	if err != nil {
+		t.Fatal("MkDir failed: ", err)
+	}
+	select {
+	case r := <-stream.resps:
+		if r.Missed || !hasEntry(r.Invalidations, 1, "dir") {
+			t.Errorf("Expected dir to be invalidated, got %v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for invalidations")
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Error("Watch failed: ", err)
+	}
+	api.invalidator.Lock()
+	defer api.invalidator.Unlock()
+	if len(api.invalidator.watches) != 0 {
+		t.Error("Expected the watch to be gone")
+	}
+}
@@ -30,6 +30,8 @@ It has these top-level messages:
 	LookupResponse
 	ReadDirAllRequest
 	ReadDirAllResponse
+	ReadDirRequest
+	ReadDirResponse
 	SymlinkRequest
 	SymlinkResponse
 	ReadlinkRequest
@@ -48,10 +50,28 @@ It has these top-level messages:
 	StatfsResponse
 	InitFsRequest
 	InitFsResponse
+	LinkRequest
+	LinkResponse
+	OpenRequest
+	OpenResponse
+	LseekRequest
+	LseekResponse
+	FallocateRequest
+	FallocateResponse
+	Invalidation
+	WatchRequest
+	WatchResponse
 	InodeEntry
+	Extent
 	Tombstone
 	DirEntry
 	FileBlock
+	RenameJournal
+	CreateJournal
+	DeleteJournal
+	UpdateJournal
+	Change
+	ChangeBatch
 	ModFS
 	CreateFSRequest
 	CreateFSResponse
@@ -67,6 +87,10 @@ It has these top-level messages:
 	GrantAddrFSResponse
 	RevokeAddrFSRequest
 	RevokeAddrFSResponse
+	ScrubReportFSRequest
+	ScrubReportFSResponse
+	FsckFSRequest
+	FsckFSResponse
 */
 package proto
 
@@ -90,9 +114,13 @@ const _ = proto1.ProtoPackageIsVersion1
 
 // DirEnt is a directory entry
 type DirEnt struct {
-	Name   string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
-	Parent uint64 `protobuf:"varint,2,opt,name=parent" json:"parent,omitempty"`
-	Type   uint32 `protobuf:"varint,3,opt,name=type" json:"type,omitempty"`
+	Name    string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Parent  uint64 `protobuf:"varint,2,opt,name=parent" json:"parent,omitempty"`
+	Type    uint32 `protobuf:"varint,3,opt,name=type" json:"type,omitempty"`
+	Inode   uint64 `protobuf:"varint,4,opt,name=inode" json:"inode,omitempty"`
+	Cookie  uint64 `protobuf:"varint,5,opt,name=cookie" json:"cookie,omitempty"`
+	Attr    *Attr  `protobuf:"bytes,6,opt,name=attr" json:"attr,omitempty"`
+	CookieB uint64 `protobuf:"varint,7,opt,name=cookieB" json:"cookieB,omitempty"`
 }
 
 func (m *DirEnt) Reset()                    { *m = DirEnt{} }
@@ -100,6 +128,13 @@ func (m *DirEnt) String() string            { return proto1.CompactTextString(m)
 func (*DirEnt) ProtoMessage()               {}
 func (*DirEnt) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
+func (m *DirEnt) GetAttr() *Attr {
+	if m != nil {
+		return m.Attr
+	}
+	return nil
+}
+
 // DirEntries just contains a list of directory entries
 type DirEntries struct {
 	DirEntries []*DirEnt `protobuf:"bytes,1,rep,name=DirEntries" json:"DirEntries,omitempty"`
@@ -129,6 +164,7 @@ type Attr struct {
 	Size   uint64 `protobuf:"varint,8,opt,name=size" json:"size,omitempty"`
 	Uid    uint32 `protobuf:"varint,9,opt,name=uid" json:"uid,omitempty"`
 	Gid    uint32 `protobuf:"varint,10,opt,name=gid" json:"gid,omitempty"`
+	Nlink  uint32 `protobuf:"varint,11,opt,name=nlink" json:"nlink,omitempty"`
 }
 
 func (m *Attr) Reset()                    { *m = Attr{} }
@@ -210,10 +246,12 @@ func (m *ReadRequest) String() string            { return proto1.CompactTextStri
 func (*ReadRequest) ProtoMessage()               {}
 func (*ReadRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
 
-// ReadResponse
+// ReadResponse, ReadStream sends one per block with the offset the payload
+// starts at
 type ReadResponse struct {
 	Inode   uint64 `protobuf:"varint,1,opt,name=inode" json:"inode,omitempty"`
 	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	Offset  int64  `protobuf:"varint,3,opt,name=offset" json:"offset,omitempty"`
 }
 
 func (m *ReadResponse) Reset()                    { *m = ReadResponse{} }
@@ -221,7 +259,8 @@ func (m *ReadResponse) String() string            { return proto1.CompactTextStr
 func (*ReadResponse) ProtoMessage()               {}
 func (*ReadResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
 
-// WriteRequest
+// WriteRequest, the requests sent on a WriteStream must be for contiguous
+// ranges of the same inode to be stored as they arrive
 type WriteRequest struct {
 	Inode   uint64 `protobuf:"varint,1,opt,name=inode" json:"inode,omitempty"`
 	Offset  int64  `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
@@ -322,6 +361,7 @@ func (m *CreateResponse) GetAttr() *Attr {
 type RemoveRequest struct {
 	Parent uint64 `protobuf:"varint,1,opt,name=parent" json:"parent,omitempty"`
 	Name   string `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Dir    bool   `protobuf:"varint,3,opt,name=dir" json:"dir,omitempty"`
 }
 
 func (m *RemoveRequest) Reset()                    { *m = RemoveRequest{} }
@@ -395,6 +435,36 @@ func (m *ReadDirAllResponse) GetDirEntries() []*DirEnt {
 	return nil
 }
 
+// ReadDirRequest
+type ReadDirRequest struct {
+	Inode   uint64 `protobuf:"varint,1,opt,name=inode" json:"inode,omitempty"`
+	Cookie  uint64 `protobuf:"varint,2,opt,name=cookie" json:"cookie,omitempty"`
+	Limit   uint32 `protobuf:"varint,3,opt,name=limit" json:"limit,omitempty"`
+	CookieB uint64 `protobuf:"varint,4,opt,name=cookieB" json:"cookieB,omitempty"`
+}
+
+func (m *ReadDirRequest) Reset()                    { *m = ReadDirRequest{} }
+func (m *ReadDirRequest) String() string            { return proto1.CompactTextString(m) }
+func (*ReadDirRequest) ProtoMessage()               {}
+func (*ReadDirRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+// ReadDirResponse
+type ReadDirResponse struct {
+	DirEntries []*DirEnt `protobuf:"bytes,1,rep,name=DirEntries" json:"DirEntries,omitempty"`
+}
+
+func (m *ReadDirResponse) Reset()                    { *m = ReadDirResponse{} }
+func (m *ReadDirResponse) String() string            { return proto1.CompactTextString(m) }
+func (*ReadDirResponse) ProtoMessage()               {}
+func (*ReadDirResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+func (m *ReadDirResponse) GetDirEntries() []*DirEnt {
+	if m != nil {
+		return m.DirEntries
+	}
+	return nil
+}
+
 // SymlinkRequest
 type SymlinkRequest struct {
 	Parent uint64 `protobuf:"varint,1,opt,name=parent" json:"parent,omitempty"`
@@ -407,7 +477,7 @@ type SymlinkRequest struct {
 func (m *SymlinkRequest) Reset()                    { *m = SymlinkRequest{} }
 func (m *SymlinkRequest) String() string            { return proto1.CompactTextString(m) }
 func (*SymlinkRequest) ProtoMessage()               {}
-func (*SymlinkRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+func (*SymlinkRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
 
 // SymlinkResponse
 type SymlinkResponse struct {
@@ -419,7 +489,7 @@ type SymlinkResponse struct {
 func (m *SymlinkResponse) Reset()                    { *m = SymlinkResponse{} }
 func (m *SymlinkResponse) String() string            { return proto1.CompactTextString(m) }
 func (*SymlinkResponse) ProtoMessage()               {}
-func (*SymlinkResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+func (*SymlinkResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
 
 func (m *SymlinkResponse) GetAttr() *Attr {
 	if m != nil {
@@ -436,7 +506,7 @@ type ReadlinkRequest struct {
 func (m *ReadlinkRequest) Reset()                    { *m = ReadlinkRequest{} }
 func (m *ReadlinkRequest) String() string            { return proto1.CompactTextString(m) }
 func (*ReadlinkRequest) ProtoMessage()               {}
-func (*ReadlinkRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
+func (*ReadlinkRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
 
 // ReadlinkResponse
 type ReadlinkResponse struct {
@@ -446,7 +516,7 @@ type ReadlinkResponse struct {
 func (m *ReadlinkResponse) Reset()                    { *m = ReadlinkResponse{} }
 func (m *ReadlinkResponse) String() string            { return proto1.CompactTextString(m) }
 func (*ReadlinkResponse) ProtoMessage()               {}
-func (*ReadlinkResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
+func (*ReadlinkResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
 
 // Getxattr
 type GetxattrRequest struct {
@@ -459,7 +529,7 @@ type GetxattrRequest struct {
 func (m *GetxattrRequest) Reset()                    { *m = GetxattrRequest{} }
 func (m *GetxattrRequest) String() string            { return proto1.CompactTextString(m) }
 func (*GetxattrRequest) ProtoMessage()               {}
-func (*GetxattrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
+func (*GetxattrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
 
 type GetxattrResponse struct {
 	Xattr []byte `protobuf:"bytes,1,opt,name=xattr,proto3" json:"xattr,omitempty"`
@@ -468,7 +538,7 @@ type GetxattrResponse struct {
 func (m *GetxattrResponse) Reset()                    { *m = GetxattrResponse{} }
 func (m *GetxattrResponse) String() string            { return proto1.CompactTextString(m) }
 func (*GetxattrResponse) ProtoMessage()               {}
-func (*GetxattrResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
+func (*GetxattrResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
 
 // Setxattr
 type SetxattrRequest struct {
@@ -482,7 +552,7 @@ type SetxattrRequest struct {
 func (m *SetxattrRequest) Reset()                    { *m = SetxattrRequest{} }
 func (m *SetxattrRequest) String() string            { return proto1.CompactTextString(m) }
 func (*SetxattrRequest) ProtoMessage()               {}
-func (*SetxattrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
+func (*SetxattrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
 
 type SetxattrResponse struct {
 }
@@ -490,7 +560,7 @@ type SetxattrResponse struct {
 func (m *SetxattrResponse) Reset()                    { *m = SetxattrResponse{} }
 func (m *SetxattrResponse) String() string            { return proto1.CompactTextString(m) }
 func (*SetxattrResponse) ProtoMessage()               {}
-func (*SetxattrResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
+func (*SetxattrResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{30} }
 
 // Listxattr
 type ListxattrRequest struct {
@@ -502,7 +572,7 @@ type ListxattrRequest struct {
 func (m *ListxattrRequest) Reset()                    { *m = ListxattrRequest{} }
 func (m *ListxattrRequest) String() string            { return proto1.CompactTextString(m) }
 func (*ListxattrRequest) ProtoMessage()               {}
-func (*ListxattrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
+func (*ListxattrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{31} }
 
 type ListxattrResponse struct {
 	Xattr []byte `protobuf:"bytes,1,opt,name=xattr,proto3" json:"xattr,omitempty"`
@@ -511,7 +581,7 @@ type ListxattrResponse struct {
 func (m *ListxattrResponse) Reset()                    { *m = ListxattrResponse{} }
 func (m *ListxattrResponse) String() string            { return proto1.CompactTextString(m) }
 func (*ListxattrResponse) ProtoMessage()               {}
-func (*ListxattrResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{30} }
+func (*ListxattrResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{32} }
 
 // Removexattr
 type RemovexattrRequest struct {
@@ -522,7 +592,7 @@ type RemovexattrRequest struct {
 func (m *RemovexattrRequest) Reset()                    { *m = RemovexattrRequest{} }
 func (m *RemovexattrRequest) String() string            { return proto1.CompactTextString(m) }
 func (*RemovexattrRequest) ProtoMessage()               {}
-func (*RemovexattrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{31} }
+func (*RemovexattrRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{33} }
 
 type RemovexattrResponse struct {
 }
@@ -530,7 +600,7 @@ type RemovexattrResponse struct {
 func (m *RemovexattrResponse) Reset()                    { *m = RemovexattrResponse{} }
 func (m *RemovexattrResponse) String() string            { return proto1.CompactTextString(m) }
 func (*RemovexattrResponse) ProtoMessage()               {}
-func (*RemovexattrResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{32} }
+func (*RemovexattrResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{34} }
 
 // Rename
 type RenameRequest struct {
@@ -538,12 +608,13 @@ type RenameRequest struct {
 	NewParent uint64 `protobuf:"varint,2,opt,name=newParent" json:"newParent,omitempty"`
 	OldName   string `protobuf:"bytes,3,opt,name=oldName" json:"oldName,omitempty"`
 	NewName   string `protobuf:"bytes,4,opt,name=newName" json:"newName,omitempty"`
+	Flags     uint32 `protobuf:"varint,5,opt,name=flags" json:"flags,omitempty"`
 }
 
 func (m *RenameRequest) Reset()                    { *m = RenameRequest{} }
 func (m *RenameRequest) String() string            { return proto1.CompactTextString(m) }
 func (*RenameRequest) ProtoMessage()               {}
-func (*RenameRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{33} }
+func (*RenameRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{35} }
 
 type RenameResponse struct {
 }
@@ -551,7 +622,7 @@ type RenameResponse struct {
 func (m *RenameResponse) Reset()                    { *m = RenameResponse{} }
 func (m *RenameResponse) String() string            { return proto1.CompactTextString(m) }
 func (*RenameResponse) ProtoMessage()               {}
-func (*RenameResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{34} }
+func (*RenameResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{36} }
 
 // Statfs
 type StatfsRequest struct {
@@ -560,7 +631,7 @@ type StatfsRequest struct {
 func (m *StatfsRequest) Reset()                    { *m = StatfsRequest{} }
 func (m *StatfsRequest) String() string            { return proto1.CompactTextString(m) }
 func (*StatfsRequest) ProtoMessage()               {}
-func (*StatfsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{35} }
+func (*StatfsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{37} }
 
 type StatfsResponse struct {
 	Blocks  uint64 `protobuf:"varint,1,opt,name=blocks" json:"blocks,omitempty"`
@@ -576,7 +647,7 @@ type StatfsResponse struct {
 func (m *StatfsResponse) Reset()                    { *m = StatfsResponse{} }
 func (m *StatfsResponse) String() string            { return proto1.CompactTextString(m) }
 func (*StatfsResponse) ProtoMessage()               {}
-func (*StatfsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{36} }
+func (*StatfsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{38} }
 
 // InitFs
 type InitFsRequest struct {
@@ -585,7 +656,7 @@ type InitFsRequest struct {
 func (m *InitFsRequest) Reset()                    { *m = InitFsRequest{} }
 func (m *InitFsRequest) String() string            { return proto1.CompactTextString(m) }
 func (*InitFsRequest) ProtoMessage()               {}
-func (*InitFsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{37} }
+func (*InitFsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{39} }
 
 type InitFsResponse struct {
 }
@@ -593,7 +664,146 @@ type InitFsResponse struct {
 func (m *InitFsResponse) Reset()                    { *m = InitFsResponse{} }
 func (m *InitFsResponse) String() string            { return proto1.CompactTextString(m) }
 func (*InitFsResponse) ProtoMessage()               {}
-func (*InitFsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{38} }
+func (*InitFsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{40} }
+
+// LinkRequest
+type LinkRequest struct {
+	Parent uint64 `protobuf:"varint,1,opt,name=parent" json:"parent,omitempty"`
+	Name   string `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Inode  uint64 `protobuf:"varint,3,opt,name=inode" json:"inode,omitempty"`
+}
+
+func (m *LinkRequest) Reset()                    { *m = LinkRequest{} }
+func (m *LinkRequest) String() string            { return proto1.CompactTextString(m) }
+func (*LinkRequest) ProtoMessage()               {}
+func (*LinkRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{41} }
+
+// LinkResponse
+type LinkResponse struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Attr *Attr  `protobuf:"bytes,2,opt,name=attr" json:"attr,omitempty"`
+}
+
+func (m *LinkResponse) Reset()                    { *m = LinkResponse{} }
+func (m *LinkResponse) String() string            { return proto1.CompactTextString(m) }
+func (*LinkResponse) ProtoMessage()               {}
+func (*LinkResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{42} }
+
+func (m *LinkResponse) GetAttr() *Attr {
+	if m != nil {
+		return m.Attr
+	}
+	return nil
+}
+
+// Open
+type OpenRequest struct {
+	Inode uint64 `protobuf:"varint,1,opt,name=inode" json:"inode,omitempty"`
+	Flags uint32 `protobuf:"varint,2,opt,name=flags" json:"flags,omitempty"`
+}
+
+func (m *OpenRequest) Reset()                    { *m = OpenRequest{} }
+func (m *OpenRequest) String() string            { return proto1.CompactTextString(m) }
+func (*OpenRequest) ProtoMessage()               {}
+func (*OpenRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{43} }
+
+type OpenResponse struct {
+}
+
+func (m *OpenResponse) Reset()                    { *m = OpenResponse{} }
+func (m *OpenResponse) String() string            { return proto1.CompactTextString(m) }
+func (*OpenResponse) ProtoMessage()               {}
+func (*OpenResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{44} }
+
+// Lseek
+type LseekRequest struct {
+	Inode  uint64 `protobuf:"varint,1,opt,name=inode" json:"inode,omitempty"`
+	Offset int64  `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+	Whence uint32 `protobuf:"varint,3,opt,name=whence" json:"whence,omitempty"`
+}
+
+func (m *LseekRequest) Reset()                    { *m = LseekRequest{} }
+func (m *LseekRequest) String() string            { return proto1.CompactTextString(m) }
+func (*LseekRequest) ProtoMessage()               {}
+func (*LseekRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{45} }
+
+type LseekResponse struct {
+	Offset int64 `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
+}
+
+func (m *LseekResponse) Reset()                    { *m = LseekResponse{} }
+func (m *LseekResponse) String() string            { return proto1.CompactTextString(m) }
+func (*LseekResponse) ProtoMessage()               {}
+func (*LseekResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{46} }
+
+// Fallocate
+type FallocateRequest struct {
+	Inode  uint64 `protobuf:"varint,1,opt,name=inode" json:"inode,omitempty"`
+	Mode   uint32 `protobuf:"varint,2,opt,name=mode" json:"mode,omitempty"`
+	Offset int64  `protobuf:"varint,3,opt,name=offset" json:"offset,omitempty"`
+	Length int64  `protobuf:"varint,4,opt,name=length" json:"length,omitempty"`
+}
+
+func (m *FallocateRequest) Reset()                    { *m = FallocateRequest{} }
+func (m *FallocateRequest) String() string            { return proto1.CompactTextString(m) }
+func (*FallocateRequest) ProtoMessage()               {}
+func (*FallocateRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{47} }
+
+type FallocateResponse struct {
+	Attr *Attr `protobuf:"bytes,1,opt,name=attr" json:"attr,omitempty"`
+}
+
+func (m *FallocateResponse) Reset()                    { *m = FallocateResponse{} }
+func (m *FallocateResponse) String() string            { return proto1.CompactTextString(m) }
+func (*FallocateResponse) ProtoMessage()               {}
+func (*FallocateResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{48} }
+
+func (m *FallocateResponse) GetAttr() *Attr {
+	if m != nil {
+		return m.Attr
+	}
+	return nil
+}
+
+// Invalidation is something that a client may have cached that has changed
+type Invalidation struct {
+	Inode  uint64 `protobuf:"varint,1,opt,name=inode" json:"inode,omitempty"`
+	Data   bool   `protobuf:"varint,2,opt,name=data" json:"data,omitempty"`
+	Parent uint64 `protobuf:"varint,3,opt,name=parent" json:"parent,omitempty"`
+	Name   string `protobuf:"bytes,4,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *Invalidation) Reset()                    { *m = Invalidation{} }
+func (m *Invalidation) String() string            { return proto1.CompactTextString(m) }
+func (*Invalidation) ProtoMessage()               {}
+func (*Invalidation) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{49} }
+
+// WatchRequest
+type WatchRequest struct {
+}
+
+func (m *WatchRequest) Reset()                    { *m = WatchRequest{} }
+func (m *WatchRequest) String() string            { return proto1.CompactTextString(m) }
+func (*WatchRequest) ProtoMessage()               {}
+func (*WatchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{50} }
+
+// WatchResponse
+type WatchResponse struct {
+	Invalidations []*Invalidation `protobuf:"bytes,1,rep,name=invalidations" json:"invalidations,omitempty"`
+	Missed        bool            `protobuf:"varint,2,opt,name=missed" json:"missed,omitempty"`
+}
+
+func (m *WatchResponse) Reset()                    { *m = WatchResponse{} }
+func (m *WatchResponse) String() string            { return proto1.CompactTextString(m) }
+func (*WatchResponse) ProtoMessage()               {}
+func (*WatchResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{51} }
+
+func (m *WatchResponse) GetInvalidations() []*Invalidation {
+	if m != nil {
+		return m.Invalidations
+	}
+	return nil
+}
 
 // Inode
 // This is used for serialization of the inode metadata
@@ -612,12 +822,14 @@ type InodeEntry struct {
 	BlockSize uint64            `protobuf:"varint,11,opt,name=blockSize" json:"blockSize,omitempty"`
 	LastBlock uint64            `protobuf:"varint,12,opt,name=lastBlock" json:"lastBlock,omitempty"`
 	FsId      []byte            `protobuf:"bytes,13,opt,name=fsId,proto3" json:"fsId,omitempty"`
+	Extents   []*Extent         `protobuf:"bytes,14,rep,name=extents" json:"extents,omitempty"`
+	Truncates uint64            `protobuf:"varint,15,opt,name=truncates" json:"truncates,omitempty"`
 }
 
 func (m *InodeEntry) Reset()                    { *m = InodeEntry{} }
 func (m *InodeEntry) String() string            { return proto1.CompactTextString(m) }
 func (*InodeEntry) ProtoMessage()               {}
-func (*InodeEntry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{39} }
+func (*InodeEntry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{52} }
 
 func (m *InodeEntry) GetAttr() *Attr {
 	if m != nil {
@@ -633,6 +845,24 @@ func (m *InodeEntry) GetXattr() map[string][]byte {
 	return nil
 }
 
+func (m *InodeEntry) GetExtents() []*Extent {
+	if m != nil {
+		return m.Extents
+	}
+	return nil
+}
+
+// A run of blocks in a file
+type Extent struct {
+	Start uint64 `protobuf:"varint,1,opt,name=start" json:"start,omitempty"`
+	Count uint64 `protobuf:"varint,2,opt,name=count" json:"count,omitempty"`
+}
+
+func (m *Extent) Reset()                    { *m = Extent{} }
+func (m *Extent) String() string            { return proto1.CompactTextString(m) }
+func (*Extent) ProtoMessage()               {}
+func (*Extent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{53} }
+
 // Tombstone
 // Stores information needed to keep track of deleted items
 type Tombstone struct {
@@ -646,7 +876,7 @@ type Tombstone struct {
 func (m *Tombstone) Reset()                    { *m = Tombstone{} }
 func (m *Tombstone) String() string            { return proto1.CompactTextString(m) }
 func (*Tombstone) ProtoMessage()               {}
-func (*Tombstone) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{40} }
+func (*Tombstone) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{54} }
 
 // DirEntry
 // This is used for the serialization of dir info in the group score
@@ -657,12 +887,13 @@ type DirEntry struct {
 	Id        []byte     `protobuf:"bytes,3,opt,name=id,proto3" json:"id,omitempty"`
 	Tombstone *Tombstone `protobuf:"bytes,4,opt,name=tombstone" json:"tombstone,omitempty"`
 	Type      uint32     `protobuf:"varint,5,opt,name=type" json:"type,omitempty"`
+	Inode     uint64     `protobuf:"varint,6,opt,name=inode" json:"inode,omitempty"`
 }
 
 func (m *DirEntry) Reset()                    { *m = DirEntry{} }
 func (m *DirEntry) String() string            { return proto1.CompactTextString(m) }
 func (*DirEntry) ProtoMessage()               {}
-func (*DirEntry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{41} }
+func (*DirEntry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{55} }
 
 func (m *DirEntry) GetTombstone() *Tombstone {
 	if m != nil {
@@ -683,7 +914,165 @@ type FileBlock struct {
 func (m *FileBlock) Reset()                    { *m = FileBlock{} }
 func (m *FileBlock) String() string            { return proto1.CompactTextString(m) }
 func (*FileBlock) ProtoMessage()               {}
-func (*FileBlock) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{42} }
+func (*FileBlock) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{56} }
+
+// RenameJournal
+// Records a rename that is in progress so that it can be finished if formicd
+// dies between updating the two directory entries
+// This is *not* used for api calls
+type RenameJournal struct {
+	Version   uint32    `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	FsId      []byte    `protobuf:"bytes,2,opt,name=fsId,proto3" json:"fsId,omitempty"`
+	OldParent uint64    `protobuf:"varint,3,opt,name=oldParent" json:"oldParent,omitempty"`
+	NewParent uint64    `protobuf:"varint,4,opt,name=newParent" json:"newParent,omitempty"`
+	OldName   string    `protobuf:"bytes,5,opt,name=oldName" json:"oldName,omitempty"`
+	NewName   string    `protobuf:"bytes,6,opt,name=newName" json:"newName,omitempty"`
+	Flags     uint32    `protobuf:"varint,7,opt,name=flags" json:"flags,omitempty"`
+	Tsm       int64     `protobuf:"varint,8,opt,name=tsm" json:"tsm,omitempty"`
+	Src       *DirEntry `protobuf:"bytes,9,opt,name=src" json:"src,omitempty"`
+	Dst       *DirEntry `protobuf:"bytes,10,opt,name=dst" json:"dst,omitempty"`
+	DstNlink  uint32    `protobuf:"varint,11,opt,name=dstNlink" json:"dstNlink,omitempty"`
+}
+
+func (m *RenameJournal) Reset()                    { *m = RenameJournal{} }
+func (m *RenameJournal) String() string            { return proto1.CompactTextString(m) }
+func (*RenameJournal) ProtoMessage()               {}
+func (*RenameJournal) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{57} }
+
+func (m *RenameJournal) GetSrc() *DirEntry {
+	if m != nil {
+		return m.Src
+	}
+	return nil
+}
+
+func (m *RenameJournal) GetDst() *DirEntry {
+	if m != nil {
+		return m.Dst
+	}
+	return nil
+}
+
+// CreateJournal
+// Records a create that is in progress so that it can be finished if formicd
+// dies between writing the inode and the directory entry
+// This is *not* used for api calls
+type CreateJournal struct {
+	Version uint32      `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	FsId    []byte      `protobuf:"bytes,2,opt,name=fsId,proto3" json:"fsId,omitempty"`
+	Parent  []byte      `protobuf:"bytes,3,opt,name=parent,proto3" json:"parent,omitempty"`
+	Tsm     int64       `protobuf:"varint,4,opt,name=tsm" json:"tsm,omitempty"`
+	Inode   *InodeEntry `protobuf:"bytes,5,opt,name=inode" json:"inode,omitempty"`
+	Dirent  *DirEntry   `protobuf:"bytes,6,opt,name=dirent" json:"dirent,omitempty"`
+}
+
+func (m *CreateJournal) Reset()                    { *m = CreateJournal{} }
+func (m *CreateJournal) String() string            { return proto1.CompactTextString(m) }
+func (*CreateJournal) ProtoMessage()               {}
+func (*CreateJournal) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{58} }
+
+func (m *CreateJournal) GetInode() *InodeEntry {
+	if m != nil {
+		return m.Inode
+	}
+	return nil
+}
+
+func (m *CreateJournal) GetDirent() *DirEntry {
+	if m != nil {
+		return m.Dirent
+	}
+	return nil
+}
+
+// Records a delete that is waiting to be carried out so that it isn't lost
+// if formicd dies
+// This is *not* used for api calls
+type DeleteJournal struct {
+	Version    uint32     `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	Parent     []byte     `protobuf:"bytes,2,opt,name=parent,proto3" json:"parent,omitempty"`
+	Name       string     `protobuf:"bytes,3,opt,name=name" json:"name,omitempty"`
+	Ts         *Tombstone `protobuf:"bytes,4,opt,name=ts" json:"ts,omitempty"`
+	FirstBlock uint64     `protobuf:"varint,5,opt,name=firstBlock" json:"firstBlock,omitempty"`
+	Truncate   bool       `protobuf:"varint,6,opt,name=truncate" json:"truncate,omitempty"`
+	Qtime      int64      `protobuf:"varint,7,opt,name=qtime" json:"qtime,omitempty"`
+	Retries    uint32     `protobuf:"varint,8,opt,name=retries" json:"retries,omitempty"`
+	Next       int64      `protobuf:"varint,9,opt,name=next" json:"next,omitempty"`
+	FsId       []byte     `protobuf:"bytes,10,opt,name=fsId,proto3" json:"fsId,omitempty"`
+}
+
+func (m *DeleteJournal) Reset()                    { *m = DeleteJournal{} }
+func (m *DeleteJournal) String() string            { return proto1.CompactTextString(m) }
+func (*DeleteJournal) ProtoMessage()               {}
+func (*DeleteJournal) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{59} }
+
+func (m *DeleteJournal) GetTs() *Tombstone {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+// Records writes to a file whose inode hasn't been updated with them yet, so
+// that the size isn't lost if formicd dies
+// This is *not* used for api calls
+type UpdateJournal struct {
+	Version   uint32   `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	Inode     uint64   `protobuf:"varint,2,opt,name=inode" json:"inode,omitempty"`
+	Blocks    []uint64 `protobuf:"varint,3,rep,packed,name=blocks" json:"blocks,omitempty"`
+	Blocksize uint64   `protobuf:"varint,4,opt,name=blocksize" json:"blocksize,omitempty"`
+	Size      uint64   `protobuf:"varint,5,opt,name=size" json:"size,omitempty"`
+	Mtime     int64    `protobuf:"varint,6,opt,name=mtime" json:"mtime,omitempty"`
+	Qtime     int64    `protobuf:"varint,7,opt,name=qtime" json:"qtime,omitempty"`
+	Truncates uint64   `protobuf:"varint,8,opt,name=truncates" json:"truncates,omitempty"`
+}
+
+func (m *UpdateJournal) Reset()                    { *m = UpdateJournal{} }
+func (m *UpdateJournal) String() string            { return proto1.CompactTextString(m) }
+func (*UpdateJournal) ProtoMessage()               {}
+func (*UpdateJournal) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{60} }
+
+// Change is a change made by a client, as passed between formicds
+// This is *not* used for api calls
+type Change struct {
+	Client       string        `protobuf:"bytes,1,opt,name=client" json:"client,omitempty"`
+	Invalidation *Invalidation `protobuf:"bytes,2,opt,name=invalidation" json:"invalidation,omitempty"`
+}
+
+func (m *Change) Reset()                    { *m = Change{} }
+func (m *Change) String() string            { return proto1.CompactTextString(m) }
+func (*Change) ProtoMessage()               {}
+func (*Change) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{61} }
+
+func (m *Change) GetInvalidation() *Invalidation {
+	if m != nil {
+		return m.Invalidation
+	}
+	return nil
+}
+
+// Records the latest changes made through a formicd so that other formicds can
+// pass them on to their clients
+// This is *not* used for api calls
+type ChangeBatch struct {
+	Version  uint32    `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	Node     string    `protobuf:"bytes,2,opt,name=node" json:"node,omitempty"`
+	Seq      uint64    `protobuf:"varint,3,opt,name=seq" json:"seq,omitempty"`
+	Changes  []*Change `protobuf:"bytes,4,rep,name=changes" json:"changes,omitempty"`
+	Overflow bool      `protobuf:"varint,5,opt,name=overflow" json:"overflow,omitempty"`
+}
+
+func (m *ChangeBatch) Reset()                    { *m = ChangeBatch{} }
+func (m *ChangeBatch) String() string            { return proto1.CompactTextString(m) }
+func (*ChangeBatch) ProtoMessage()               {}
+func (*ChangeBatch) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{62} }
+
+func (m *ChangeBatch) GetChanges() []*Change {
+	if m != nil {
+		return m.Changes
+	}
+	return nil
+}
 
 // ModFS ...
 type ModFS struct {
@@ -694,18 +1083,19 @@ type ModFS struct {
 func (m *ModFS) Reset()                    { *m = ModFS{} }
 func (m *ModFS) String() string            { return proto1.CompactTextString(m) }
 func (*ModFS) ProtoMessage()               {}
-func (*ModFS) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{43} }
+func (*ModFS) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{63} }
 
 // Request to create a new filesystem
 type CreateFSRequest struct {
-	Token  string `protobuf:"bytes,1,opt,name=Token" json:"Token,omitempty"`
-	FSName string `protobuf:"bytes,2,opt,name=FSName" json:"FSName,omitempty"`
+	Token     string `protobuf:"bytes,1,opt,name=Token" json:"Token,omitempty"`
+	FSName    string `protobuf:"bytes,2,opt,name=FSName" json:"FSName,omitempty"`
+	BlockSize int64  `protobuf:"varint,3,opt,name=BlockSize" json:"BlockSize,omitempty"`
 }
 
 func (m *CreateFSRequest) Reset()                    { *m = CreateFSRequest{} }
 func (m *CreateFSRequest) String() string            { return proto1.CompactTextString(m) }
 func (*CreateFSRequest) ProtoMessage()               {}
-func (*CreateFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{44} }
+func (*CreateFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{64} }
 
 // Response from creating a new filesystem
 type CreateFSResponse struct {
@@ -715,7 +1105,7 @@ type CreateFSResponse struct {
 func (m *CreateFSResponse) Reset()                    { *m = CreateFSResponse{} }
 func (m *CreateFSResponse) String() string            { return proto1.CompactTextString(m) }
 func (*CreateFSResponse) ProtoMessage()               {}
-func (*CreateFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{45} }
+func (*CreateFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{65} }
 
 // Request a list of all file systems for a given account
 type ListFSRequest struct {
@@ -725,7 +1115,7 @@ type ListFSRequest struct {
 func (m *ListFSRequest) Reset()                    { *m = ListFSRequest{} }
 func (m *ListFSRequest) String() string            { return proto1.CompactTextString(m) }
 func (*ListFSRequest) ProtoMessage()               {}
-func (*ListFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{46} }
+func (*ListFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{66} }
 
 // Response for displaying a list of all an accounts file systems.
 type ListFSResponse struct {
@@ -735,7 +1125,7 @@ type ListFSResponse struct {
 func (m *ListFSResponse) Reset()                    { *m = ListFSResponse{} }
 func (m *ListFSResponse) String() string            { return proto1.CompactTextString(m) }
 func (*ListFSResponse) ProtoMessage()               {}
-func (*ListFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{47} }
+func (*ListFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{67} }
 
 // Request to show the specific details about a file system
 type ShowFSRequest struct {
@@ -746,7 +1136,7 @@ type ShowFSRequest struct {
 func (m *ShowFSRequest) Reset()                    { *m = ShowFSRequest{} }
 func (m *ShowFSRequest) String() string            { return proto1.CompactTextString(m) }
 func (*ShowFSRequest) ProtoMessage()               {}
-func (*ShowFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{48} }
+func (*ShowFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{68} }
 
 // Response for a specific file system for an account.
 type ShowFSResponse struct {
@@ -756,7 +1146,7 @@ type ShowFSResponse struct {
 func (m *ShowFSResponse) Reset()                    { *m = ShowFSResponse{} }
 func (m *ShowFSResponse) String() string            { return proto1.CompactTextString(m) }
 func (*ShowFSResponse) ProtoMessage()               {}
-func (*ShowFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{49} }
+func (*ShowFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{69} }
 
 // Request to delete a specific file system
 type DeleteFSRequest struct {
@@ -767,7 +1157,7 @@ type DeleteFSRequest struct {
 func (m *DeleteFSRequest) Reset()                    { *m = DeleteFSRequest{} }
 func (m *DeleteFSRequest) String() string            { return proto1.CompactTextString(m) }
 func (*DeleteFSRequest) ProtoMessage()               {}
-func (*DeleteFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{50} }
+func (*DeleteFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{70} }
 
 // Response from deleting a file system
 type DeleteFSResponse struct {
@@ -777,7 +1167,7 @@ type DeleteFSResponse struct {
 func (m *DeleteFSResponse) Reset()                    { *m = DeleteFSResponse{} }
 func (m *DeleteFSResponse) String() string            { return proto1.CompactTextString(m) }
 func (*DeleteFSResponse) ProtoMessage()               {}
-func (*DeleteFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{51} }
+func (*DeleteFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{71} }
 
 // Request to update a specific file system's information
 type UpdateFSRequest struct {
@@ -789,7 +1179,7 @@ type UpdateFSRequest struct {
 func (m *UpdateFSRequest) Reset()                    { *m = UpdateFSRequest{} }
 func (m *UpdateFSRequest) String() string            { return proto1.CompactTextString(m) }
 func (*UpdateFSRequest) ProtoMessage()               {}
-func (*UpdateFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{52} }
+func (*UpdateFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{72} }
 
 func (m *UpdateFSRequest) GetFilesys() *ModFS {
 	if m != nil {
@@ -806,7 +1196,7 @@ type UpdateFSResponse struct {
 func (m *UpdateFSResponse) Reset()                    { *m = UpdateFSResponse{} }
 func (m *UpdateFSResponse) String() string            { return proto1.CompactTextString(m) }
 func (*UpdateFSResponse) ProtoMessage()               {}
-func (*UpdateFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{53} }
+func (*UpdateFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{73} }
 
 // Request grant an ip address access to a file system
 type GrantAddrFSRequest struct {
@@ -818,7 +1208,7 @@ type GrantAddrFSRequest struct {
 func (m *GrantAddrFSRequest) Reset()                    { *m = GrantAddrFSRequest{} }
 func (m *GrantAddrFSRequest) String() string            { return proto1.CompactTextString(m) }
 func (*GrantAddrFSRequest) ProtoMessage()               {}
-func (*GrantAddrFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{54} }
+func (*GrantAddrFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{74} }
 
 // Response from granting ip address access to a file system
 type GrantAddrFSResponse struct {
@@ -828,7 +1218,7 @@ type GrantAddrFSResponse struct {
 func (m *GrantAddrFSResponse) Reset()                    { *m = GrantAddrFSResponse{} }
 func (m *GrantAddrFSResponse) String() string            { return proto1.CompactTextString(m) }
 func (*GrantAddrFSResponse) ProtoMessage()               {}
-func (*GrantAddrFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{55} }
+func (*GrantAddrFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{75} }
 
 // Request revoke an ip address access to a file system
 type RevokeAddrFSRequest struct {
@@ -840,7 +1230,7 @@ type RevokeAddrFSRequest struct {
 func (m *RevokeAddrFSRequest) Reset()                    { *m = RevokeAddrFSRequest{} }
 func (m *RevokeAddrFSRequest) String() string            { return proto1.CompactTextString(m) }
 func (*RevokeAddrFSRequest) ProtoMessage()               {}
-func (*RevokeAddrFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{56} }
+func (*RevokeAddrFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{76} }
 
 // Response from revoking ip address access to a file system
 type RevokeAddrFSResponse struct {
@@ -850,7 +1240,53 @@ type RevokeAddrFSResponse struct {
 func (m *RevokeAddrFSResponse) Reset()                    { *m = RevokeAddrFSResponse{} }
 func (m *RevokeAddrFSResponse) String() string            { return proto1.CompactTextString(m) }
 func (*RevokeAddrFSResponse) ProtoMessage()               {}
-func (*RevokeAddrFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{57} }
+func (*RevokeAddrFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{77} }
+
+// Request the report from the last scrub of a file system
+type ScrubReportFSRequest struct {
+	Token string `protobuf:"bytes,1,opt,name=Token" json:"Token,omitempty"`
+	FSid  string `protobuf:"bytes,2,opt,name=FSid" json:"FSid,omitempty"`
+}
+
+func (m *ScrubReportFSRequest) Reset()                    { *m = ScrubReportFSRequest{} }
+func (m *ScrubReportFSRequest) String() string            { return proto1.CompactTextString(m) }
+func (*ScrubReportFSRequest) ProtoMessage()               {}
+func (*ScrubReportFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{78} }
+
+// Response with the last scrub report for a file system
+type ScrubReportFSResponse struct {
+	Data string `protobuf:"bytes,1,opt,name=Data" json:"Data,omitempty"`
+}
+
+func (m *ScrubReportFSResponse) Reset()                    { *m = ScrubReportFSResponse{} }
+func (m *ScrubReportFSResponse) String() string            { return proto1.CompactTextString(m) }
+func (*ScrubReportFSResponse) ProtoMessage()               {}
+func (*ScrubReportFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{79} }
+
+// Request to start checking a file system for damage, and optionally repair
+// it, or with Status to ask how the running or last check went
+type FsckFSRequest struct {
+	Token  string `protobuf:"bytes,1,opt,name=Token" json:"Token,omitempty"`
+	FSid   string `protobuf:"bytes,2,opt,name=FSid" json:"FSid,omitempty"`
+	Repair bool   `protobuf:"varint,3,opt,name=Repair" json:"Repair,omitempty"`
+	Status bool   `protobuf:"varint,4,opt,name=Status" json:"Status,omitempty"`
+}
+
+func (m *FsckFSRequest) Reset()                    { *m = FsckFSRequest{} }
+func (m *FsckFSRequest) String() string            { return proto1.CompactTextString(m) }
+func (*FsckFSRequest) ProtoMessage()               {}
+func (*FsckFSRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{80} }
+
+// Response with the state of the check, and what has been found, and
+// repaired, in the file system so far
+type FsckFSResponse struct {
+	Data string `protobuf:"bytes,1,opt,name=Data" json:"Data,omitempty"`
+}
+
+func (m *FsckFSResponse) Reset()                    { *m = FsckFSResponse{} }
+func (m *FsckFSResponse) String() string            { return proto1.CompactTextString(m) }
+func (*FsckFSResponse) ProtoMessage()               {}
+func (*FsckFSResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{81} }
 
 func init() {
 	proto1.RegisterType((*DirEnt)(nil), "proto.DirEnt")
@@ -874,6 +1310,8 @@ func init() {
 	proto1.RegisterType((*LookupResponse)(nil), "proto.LookupResponse")
 	proto1.RegisterType((*ReadDirAllRequest)(nil), "proto.ReadDirAllRequest")
 	proto1.RegisterType((*ReadDirAllResponse)(nil), "proto.ReadDirAllResponse")
+	proto1.RegisterType((*ReadDirRequest)(nil), "proto.ReadDirRequest")
+	proto1.RegisterType((*ReadDirResponse)(nil), "proto.ReadDirResponse")
 	proto1.RegisterType((*SymlinkRequest)(nil), "proto.SymlinkRequest")
 	proto1.RegisterType((*SymlinkResponse)(nil), "proto.SymlinkResponse")
 	proto1.RegisterType((*ReadlinkRequest)(nil), "proto.ReadlinkRequest")
@@ -892,10 +1330,28 @@ func init() {
 	proto1.RegisterType((*StatfsResponse)(nil), "proto.StatfsResponse")
 	proto1.RegisterType((*InitFsRequest)(nil), "proto.InitFsRequest")
 	proto1.RegisterType((*InitFsResponse)(nil), "proto.InitFsResponse")
+	proto1.RegisterType((*LinkRequest)(nil), "proto.LinkRequest")
+	proto1.RegisterType((*LinkResponse)(nil), "proto.LinkResponse")
+	proto1.RegisterType((*OpenRequest)(nil), "proto.OpenRequest")
+	proto1.RegisterType((*OpenResponse)(nil), "proto.OpenResponse")
+	proto1.RegisterType((*LseekRequest)(nil), "proto.LseekRequest")
+	proto1.RegisterType((*LseekResponse)(nil), "proto.LseekResponse")
+	proto1.RegisterType((*FallocateRequest)(nil), "proto.FallocateRequest")
+	proto1.RegisterType((*FallocateResponse)(nil), "proto.FallocateResponse")
+	proto1.RegisterType((*Invalidation)(nil), "proto.Invalidation")
+	proto1.RegisterType((*WatchRequest)(nil), "proto.WatchRequest")
+	proto1.RegisterType((*WatchResponse)(nil), "proto.WatchResponse")
 	proto1.RegisterType((*InodeEntry)(nil), "proto.InodeEntry")
+	proto1.RegisterType((*Extent)(nil), "proto.Extent")
 	proto1.RegisterType((*Tombstone)(nil), "proto.Tombstone")
 	proto1.RegisterType((*DirEntry)(nil), "proto.DirEntry")
 	proto1.RegisterType((*FileBlock)(nil), "proto.FileBlock")
+	proto1.RegisterType((*RenameJournal)(nil), "proto.RenameJournal")
+	proto1.RegisterType((*CreateJournal)(nil), "proto.CreateJournal")
+	proto1.RegisterType((*DeleteJournal)(nil), "proto.DeleteJournal")
+	proto1.RegisterType((*UpdateJournal)(nil), "proto.UpdateJournal")
+	proto1.RegisterType((*Change)(nil), "proto.Change")
+	proto1.RegisterType((*ChangeBatch)(nil), "proto.ChangeBatch")
 	proto1.RegisterType((*ModFS)(nil), "proto.ModFS")
 	proto1.RegisterType((*CreateFSRequest)(nil), "proto.CreateFSRequest")
 	proto1.RegisterType((*CreateFSResponse)(nil), "proto.CreateFSResponse")
@@ -911,6 +1367,10 @@ func init() {
 	proto1.RegisterType((*GrantAddrFSResponse)(nil), "proto.GrantAddrFSResponse")
 	proto1.RegisterType((*RevokeAddrFSRequest)(nil), "proto.RevokeAddrFSRequest")
 	proto1.RegisterType((*RevokeAddrFSResponse)(nil), "proto.RevokeAddrFSResponse")
+	proto1.RegisterType((*ScrubReportFSRequest)(nil), "proto.ScrubReportFSRequest")
+	proto1.RegisterType((*ScrubReportFSResponse)(nil), "proto.ScrubReportFSResponse")
+	proto1.RegisterType((*FsckFSRequest)(nil), "proto.FsckFSRequest")
+	proto1.RegisterType((*FsckFSResponse)(nil), "proto.FsckFSResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -942,6 +1402,15 @@ type ApiClient interface {
 	Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error)
 	Statfs(ctx context.Context, in *StatfsRequest, opts ...grpc.CallOption) (*StatfsResponse, error)
 	InitFs(ctx context.Context, in *InitFsRequest, opts ...grpc.CallOption) (*InitFsResponse, error)
+	Link(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*LinkResponse, error)
+	ReadStream(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (Api_ReadStreamClient, error)
+	WriteStream(ctx context.Context, opts ...grpc.CallOption) (Api_WriteStreamClient, error)
+	Lseek(ctx context.Context, in *LseekRequest, opts ...grpc.CallOption) (*LseekResponse, error)
+	Fallocate(ctx context.Context, in *FallocateRequest, opts ...grpc.CallOption) (*FallocateResponse, error)
+	ReadDir(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (Api_ReadDirClient, error)
+	ReadDirPlus(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (Api_ReadDirPlusClient, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Api_WatchClient, error)
+	Open(ctx context.Context, in *OpenRequest, opts ...grpc.CallOption) (*OpenResponse, error)
 }
 
 type apiClient struct {
@@ -1114,6 +1583,204 @@ func (c *apiClient) InitFs(ctx context.Context, in *InitFsRequest, opts ...grpc.
 	return out, nil
 }
 
+func (c *apiClient) Link(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*LinkResponse, error) {
+	out := new(LinkResponse)
+	err := grpc.Invoke(ctx, "/proto.Api/Link", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) ReadStream(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (Api_ReadStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[0], c.cc, "/proto.Api/ReadStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiReadStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_ReadStreamClient interface {
+	Recv() (*ReadResponse, error)
+	grpc.ClientStream
+}
+
+type apiReadStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiReadStreamClient) Recv() (*ReadResponse, error) {
+	m := new(ReadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) WriteStream(ctx context.Context, opts ...grpc.CallOption) (Api_WriteStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[1], c.cc, "/proto.Api/WriteStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiWriteStreamClient{stream}
+	return x, nil
+}
+
+type Api_WriteStreamClient interface {
+	Send(*WriteRequest) error
+	CloseAndRecv() (*WriteResponse, error)
+	grpc.ClientStream
+}
+
+type apiWriteStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiWriteStreamClient) Send(m *WriteRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *apiWriteStreamClient) CloseAndRecv() (*WriteResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(WriteResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) Lseek(ctx context.Context, in *LseekRequest, opts ...grpc.CallOption) (*LseekResponse, error) {
+	out := new(LseekResponse)
+	err := grpc.Invoke(ctx, "/proto.Api/Lseek", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) Fallocate(ctx context.Context, in *FallocateRequest, opts ...grpc.CallOption) (*FallocateResponse, error) {
+	out := new(FallocateResponse)
+	err := grpc.Invoke(ctx, "/proto.Api/Fallocate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) ReadDir(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (Api_ReadDirClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[2], c.cc, "/proto.Api/ReadDir", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiReadDirClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_ReadDirClient interface {
+	Recv() (*ReadDirResponse, error)
+	grpc.ClientStream
+}
+
+type apiReadDirClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiReadDirClient) Recv() (*ReadDirResponse, error) {
+	m := new(ReadDirResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) ReadDirPlus(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (Api_ReadDirPlusClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[3], c.cc, "/proto.Api/ReadDirPlus", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiReadDirPlusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_ReadDirPlusClient interface {
+	Recv() (*ReadDirResponse, error)
+	grpc.ClientStream
+}
+
+type apiReadDirPlusClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiReadDirPlusClient) Recv() (*ReadDirResponse, error) {
+	m := new(ReadDirResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Api_WatchClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[4], c.cc, "/proto.Api/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_WatchClient interface {
+	Recv() (*WatchResponse, error)
+	grpc.ClientStream
+}
+
+type apiWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiWatchClient) Recv() (*WatchResponse, error) {
+	m := new(WatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) Open(ctx context.Context, in *OpenRequest, opts ...grpc.CallOption) (*OpenResponse, error) {
+	out := new(OpenResponse)
+	err := grpc.Invoke(ctx, "/proto.Api/Open", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Api service
 
 type ApiServer interface {
@@ -1135,6 +1802,15 @@ type ApiServer interface {
 	Rename(context.Context, *RenameRequest) (*RenameResponse, error)
 	Statfs(context.Context, *StatfsRequest) (*StatfsResponse, error)
 	InitFs(context.Context, *InitFsRequest) (*InitFsResponse, error)
+	Link(context.Context, *LinkRequest) (*LinkResponse, error)
+	ReadStream(*ReadRequest, Api_ReadStreamServer) error
+	WriteStream(Api_WriteStreamServer) error
+	Lseek(context.Context, *LseekRequest) (*LseekResponse, error)
+	Fallocate(context.Context, *FallocateRequest) (*FallocateResponse, error)
+	ReadDir(*ReadDirRequest, Api_ReadDirServer) error
+	ReadDirPlus(*ReadDirRequest, Api_ReadDirPlusServer) error
+	Watch(*WatchRequest, Api_WatchServer) error
+	Open(context.Context, *OpenRequest) (*OpenResponse, error)
 }
 
 func RegisterApiServer(s *grpc.Server, srv ApiServer) {
@@ -1465,6 +2141,188 @@ func _Api_InitFs_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Api_Link_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).Link(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Api/Link",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).Link(ctx, req.(*LinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_ReadStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ApiServer).ReadStream(m, &apiReadStreamServer{stream})
+}
+
+type Api_ReadStreamServer interface {
+	Send(*ReadResponse) error
+	grpc.ServerStream
+}
+
+type apiReadStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiReadStreamServer) Send(m *ReadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Api_WriteStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ApiServer).WriteStream(&apiWriteStreamServer{stream})
+}
+
+type Api_WriteStreamServer interface {
+	SendAndClose(*WriteResponse) error
+	Recv() (*WriteRequest, error)
+	grpc.ServerStream
+}
+
+type apiWriteStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiWriteStreamServer) SendAndClose(m *WriteResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *apiWriteStreamServer) Recv() (*WriteRequest, error) {
+	m := new(WriteRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Api_Lseek_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LseekRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).Lseek(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Api/Lseek",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).Lseek(ctx, req.(*LseekRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_Fallocate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FallocateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).Fallocate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Api/Fallocate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).Fallocate(ctx, req.(*FallocateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Api_ReadDir_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadDirRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ApiServer).ReadDir(m, &apiReadDirServer{stream})
+}
+
+type Api_ReadDirServer interface {
+	Send(*ReadDirResponse) error
+	grpc.ServerStream
+}
+
+type apiReadDirServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiReadDirServer) Send(m *ReadDirResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Api_ReadDirPlus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadDirRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ApiServer).ReadDirPlus(m, &apiReadDirPlusServer{stream})
+}
+
+type Api_ReadDirPlusServer interface {
+	Send(*ReadDirResponse) error
+	grpc.ServerStream
+}
+
+type apiReadDirPlusServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiReadDirPlusServer) Send(m *ReadDirResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Api_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ApiServer).Watch(m, &apiWatchServer{stream})
+}
+
+type Api_WatchServer interface {
+	Send(*WatchResponse) error
+	grpc.ServerStream
+}
+
+type apiWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiWatchServer) Send(m *WatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Api_Open_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OpenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServer).Open(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Api/Open",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServer).Open(ctx, req.(*OpenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Api_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proto.Api",
 	HandlerType: (*ApiServer)(nil),
@@ -1541,8 +2399,50 @@ var _Api_serviceDesc = grpc.ServiceDesc{
 			MethodName: "InitFs",
 			Handler:    _Api_InitFs_Handler,
 		},
+		{
+			MethodName: "Link",
+			Handler:    _Api_Link_Handler,
+		},
+		{
+			MethodName: "Lseek",
+			Handler:    _Api_Lseek_Handler,
+		},
+		{
+			MethodName: "Fallocate",
+			Handler:    _Api_Fallocate_Handler,
+		},
+		{
+			MethodName: "Open",
+			Handler:    _Api_Open_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReadStream",
+			Handler:       _Api_ReadStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WriteStream",
+			Handler:       _Api_WriteStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ReadDir",
+			Handler:       _Api_ReadDir_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReadDirPlus",
+			Handler:       _Api_ReadDirPlus_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _Api_Watch_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams: []grpc.StreamDesc{},
 }
 
 // Client API for FileSystemAPI service
@@ -1555,6 +2455,8 @@ type FileSystemAPIClient interface {
 	UpdateFS(ctx context.Context, in *UpdateFSRequest, opts ...grpc.CallOption) (*UpdateFSResponse, error)
 	GrantAddrFS(ctx context.Context, in *GrantAddrFSRequest, opts ...grpc.CallOption) (*GrantAddrFSResponse, error)
 	RevokeAddrFS(ctx context.Context, in *RevokeAddrFSRequest, opts ...grpc.CallOption) (*RevokeAddrFSResponse, error)
+	ScrubReportFS(ctx context.Context, in *ScrubReportFSRequest, opts ...grpc.CallOption) (*ScrubReportFSResponse, error)
+	FsckFS(ctx context.Context, in *FsckFSRequest, opts ...grpc.CallOption) (*FsckFSResponse, error)
 }
 
 type fileSystemAPIClient struct {
@@ -1628,6 +2530,24 @@ func (c *fileSystemAPIClient) RevokeAddrFS(ctx context.Context, in *RevokeAddrFS
 	return out, nil
 }
 
+func (c *fileSystemAPIClient) ScrubReportFS(ctx context.Context, in *ScrubReportFSRequest, opts ...grpc.CallOption) (*ScrubReportFSResponse, error) {
+	out := new(ScrubReportFSResponse)
+	err := grpc.Invoke(ctx, "/proto.FileSystemAPI/ScrubReportFS", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSystemAPIClient) FsckFS(ctx context.Context, in *FsckFSRequest, opts ...grpc.CallOption) (*FsckFSResponse, error) {
+	out := new(FsckFSResponse)
+	err := grpc.Invoke(ctx, "/proto.FileSystemAPI/FsckFS", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for FileSystemAPI service
 
 type FileSystemAPIServer interface {
@@ -1638,6 +2558,8 @@ type FileSystemAPIServer interface {
 	UpdateFS(context.Context, *UpdateFSRequest) (*UpdateFSResponse, error)
 	GrantAddrFS(context.Context, *GrantAddrFSRequest) (*GrantAddrFSResponse, error)
 	RevokeAddrFS(context.Context, *RevokeAddrFSRequest) (*RevokeAddrFSResponse, error)
+	ScrubReportFS(context.Context, *ScrubReportFSRequest) (*ScrubReportFSResponse, error)
+	FsckFS(context.Context, *FsckFSRequest) (*FsckFSResponse, error)
 }
 
 func RegisterFileSystemAPIServer(s *grpc.Server, srv FileSystemAPIServer) {
@@ -1770,6 +2692,42 @@ func _FileSystemAPI_RevokeAddrFS_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _FileSystemAPI_ScrubReportFS_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScrubReportFSRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSystemAPIServer).ScrubReportFS(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.FileSystemAPI/ScrubReportFS",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSystemAPIServer).ScrubReportFS(ctx, req.(*ScrubReportFSRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSystemAPI_FsckFS_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FsckFSRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSystemAPIServer).FsckFS(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.FileSystemAPI/FsckFS",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSystemAPIServer).FsckFS(ctx, req.(*FsckFSRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _FileSystemAPI_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proto.FileSystemAPI",
 	HandlerType: (*FileSystemAPIServer)(nil),
@@ -1802,106 +2760,162 @@ var _FileSystemAPI_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RevokeAddrFS",
 			Handler:    _FileSystemAPI_RevokeAddrFS_Handler,
 		},
+		{
+			MethodName: "ScrubReportFS",
+			Handler:    _FileSystemAPI_ScrubReportFS_Handler,
+		},
+		{
+			MethodName: "FsckFS",
+			Handler:    _FileSystemAPI_FsckFS_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
 
 var fileDescriptor0 = []byte{
-	// 1523 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x9c, 0x58, 0xeb, 0x72, 0xd3, 0xd6,
-	0x13, 0xc7, 0xb7, 0xc4, 0x5e, 0x5b, 0xb1, 0x11, 0x97, 0xbf, 0xf1, 0xbf, 0x2d, 0x20, 0xda, 0x19,
-	0x66, 0x4a, 0xd3, 0x92, 0x32, 0x03, 0xcd, 0xf4, 0x42, 0x0a, 0xb5, 0x9b, 0x0e, 0x64, 0x18, 0x44,
-	0x0b, 0x9f, 0xda, 0x51, 0x62, 0x19, 0x34, 0xb1, 0x25, 0x23, 0xc9, 0x01, 0xf7, 0x1d, 0xfa, 0xb9,
-	0xaf, 0xd2, 0xe9, 0x5b, 0xf4, 0x8d, 0xba, 0x67, 0xcf, 0x5d, 0x56, 0xa8, 0xc9, 0xa7, 0xcc, 0xea,
-	0xec, 0x6f, 0x6f, 0x67, 0xf7, 0xec, 0xcf, 0x81, 0xde, 0x24, 0x49, 0x67, 0xd1, 0xd1, 0x6f, 0xc1,
-	0x3c, 0xda, 0x9e, 0xa7, 0x49, 0x9e, 0xb8, 0x0d, 0xfa, 0xe3, 0xdd, 0x81, 0x8d, 0x87, 0x51, 0xfa,
-	0x43, 0x9c, 0xbb, 0x1d, 0xa8, 0xc7, 0xc1, 0x2c, 0xec, 0x57, 0xae, 0x55, 0x6e, 0xb6, 0xdc, 0x2d,
-	0xd8, 0x98, 0x07, 0x69, 0x18, 0xe7, 0xfd, 0x2a, 0xca, 0x75, 0x76, 0x9a, 0x2f, 0xe7, 0x61, 0xbf,
-	0x86, 0x92, 0xe3, 0x7d, 0x0e, 0xc0, 0x51, 0x69, 0x14, 0x66, 0xee, 0x75, 0x53, 0x42, 0x7c, 0xed,
-	0x66, 0x7b, 0xc7, 0xe1, 0x6e, 0xb6, 0xf9, 0x81, 0xf7, 0x67, 0x05, 0xea, 0x7b, 0x79, 0x9e, 0xba,
-	0x0e, 0x34, 0xa2, 0x38, 0x19, 0x73, 0x37, 0x75, 0x26, 0x06, 0x79, 0x84, 0x5e, 0x99, 0x97, 0x1a,
-	0x13, 0x67, 0x24, 0xd6, 0xa4, 0x78, 0x44, 0x62, 0x9d, 0x44, 0x8c, 0xe9, 0x28, 0x25, 0xb9, 0x41,
-	0x32, 0xc6, 0x34, 0x63, 0xa6, 0x36, 0x58, 0x4c, 0x4c, 0xf9, 0x24, 0x98, 0x46, 0xe3, 0xfe, 0x26,
-	0x8a, 0x0d, 0x76, 0x98, 0x45, 0xbf, 0x87, 0xfd, 0x26, 0xf9, 0x69, 0x43, 0x6d, 0x81, 0x47, 0x2d,
-	0xd2, 0x44, 0xe1, 0x25, 0x0a, 0x40, 0xa9, 0xec, 0xc2, 0x96, 0x1f, 0xe6, 0x2c, 0xb6, 0xa7, 0xe1,
-	0xeb, 0x45, 0x98, 0xe5, 0xee, 0x15, 0xa8, 0x07, 0x28, 0x52, 0x84, 0xed, 0x9d, 0xb6, 0x48, 0x44,
-	0x46, 0xcf, 0x7d, 0x54, 0x09, 0x7b, 0x0b, 0xba, 0x0a, 0x9b, 0xcd, 0x93, 0x38, 0x0b, 0xdf, 0x01,
-	0xf6, 0xae, 0xc2, 0xd6, 0xc8, 0xf6, 0x64, 0x17, 0x83, 0x99, 0x1b, 0xad, 0x6f, 0x6e, 0x17, 0xda,
-	0x4f, 0xc3, 0x60, 0x5c, 0x6e, 0x8b, 0xd5, 0x2a, 0x99, 0x4c, 0xb2, 0x30, 0x17, 0x95, 0x95, 0xe5,
-	0xa0, 0xc2, 0x7a, 0xdb, 0xd0, 0xe1, 0x58, 0xe1, 0xa6, 0x00, 0xee, 0xc2, 0xe6, 0x3c, 0x58, 0x4e,
-	0x93, 0x80, 0x27, 0xda, 0xf1, 0xbe, 0x85, 0xce, 0xf3, 0x34, 0xca, 0xc3, 0x35, 0x9d, 0x19, 0xf8,
-	0x1a, 0xe1, 0xaf, 0x82, 0x23, 0xf0, 0xc2, 0x21, 0x22, 0xb2, 0x3c, 0xc8, 0x17, 0x19, 0x59, 0x68,
-	0x78, 0x23, 0xe8, 0x3c, 0x3e, 0xc6, 0x5e, 0x91, 0x0e, 0x74, 0xfb, 0x55, 0x64, 0xfb, 0x51, 0x73,
-	0x56, 0xa9, 0x39, 0x65, 0x55, 0x6a, 0xab, 0x55, 0xb9, 0x07, 0x8e, 0x30, 0x24, 0x3c, 0xd9, 0x6d,
-	0x2d, 0x91, 0xd5, 0x55, 0xe4, 0x8f, 0xe0, 0x3c, 0x48, 0xc3, 0x40, 0x27, 0x79, 0xe6, 0x18, 0xbe,
-	0x82, 0x2d, 0x69, 0xe9, 0x7d, 0x83, 0xf8, 0x0c, 0x9c, 0xa7, 0xe1, 0x2c, 0x39, 0x59, 0x2f, 0x08,
-	0xef, 0x1a, 0x6c, 0x49, 0xf5, 0x53, 0x0a, 0x8b, 0x06, 0x1f, 0x25, 0xc9, 0xf1, 0x62, 0xbe, 0x9e,
-	0x41, 0x0c, 0x5d, 0xaa, 0xbf, 0x6f, 0xe8, 0x1e, 0x9c, 0x67, 0x3d, 0x85, 0xb5, 0xdf, 0x9b, 0x4e,
-	0x4f, 0xe9, 0xf0, 0xbb, 0xe0, 0x9a, 0x3a, 0xc2, 0xc5, 0x1a, 0xef, 0xc7, 0x0b, 0x9c, 0xd2, 0xe5,
-	0x6c, 0x1a, 0xc5, 0xc7, 0xeb, 0xdd, 0x0e, 0x9e, 0xe6, 0x41, 0xfa, 0x12, 0x3b, 0xb2, 0x46, 0xb2,
-	0x98, 0xff, 0xba, 0x39, 0xff, 0x0d, 0x9a, 0xe1, 0x9f, 0x70, 0x86, 0xa5, 0x65, 0x5d, 0xc3, 0xb3,
-	0x5d, 0xfc, 0x35, 0xe8, 0xb2, 0xf4, 0xcc, 0x30, 0x0b, 0x05, 0xf0, 0xa0, 0xa7, 0x35, 0xb4, 0x3b,
-	0x11, 0x2b, 0xd5, 0xd8, 0x3b, 0xa0, 0x67, 0xe0, 0x6d, 0x70, 0xea, 0x43, 0x51, 0x08, 0xc8, 0x1c,
-	0x6d, 0xc7, 0xed, 0x41, 0x73, 0x9e, 0x64, 0x51, 0x1e, 0x25, 0x31, 0x4f, 0xd7, 0xbb, 0x0e, 0x3d,
-	0x6d, 0x4f, 0x0f, 0xfc, 0x5b, 0xf5, 0xb0, 0x74, 0xbc, 0x5f, 0xe9, 0x21, 0x5b, 0xdf, 0x25, 0x7f,
-	0x07, 0x17, 0xdc, 0x67, 0x67, 0xd5, 0x27, 0x53, 0x98, 0x4c, 0x83, 0x97, 0x99, 0x28, 0xb2, 0x0b,
-	0x3d, 0xbf, 0x10, 0x82, 0xb7, 0x07, 0xbd, 0x47, 0x51, 0xf6, 0x5f, 0x4e, 0x29, 0xb3, 0xea, 0x4a,
-	0x66, 0x7c, 0x0d, 0x61, 0xcb, 0x19, 0x26, 0xca, 0x53, 0xbb, 0xcd, 0x5a, 0x8e, 0x8d, 0xc8, 0xda,
-	0xd9, 0x79, 0x97, 0xe0, 0x82, 0x05, 0x11, 0x01, 0x3f, 0x67, 0xb3, 0xc9, 0xd4, 0xa4, 0x91, 0xf3,
-	0xd0, 0x4a, 0xa6, 0xe3, 0x27, 0x66, 0xab, 0xe0, 0xa7, 0x38, 0x7c, 0xf3, 0xc4, 0xdc, 0x9c, 0xf8,
-	0x18, 0xa2, 0xd6, 0x41, 0x20, 0xb6, 0x5a, 0x8b, 0x7d, 0x40, 0x1d, 0xfa, 0x50, 0x27, 0x7f, 0x3d,
-	0x36, 0xc5, 0xdc, 0xb0, 0x70, 0xd5, 0x05, 0xc7, 0xc7, 0x29, 0x9e, 0x64, 0xc2, 0x95, 0xf7, 0x47,
-	0x05, 0x07, 0x40, 0x7c, 0xd1, 0x6d, 0x73, 0x38, 0x4d, 0x8e, 0x8e, 0x33, 0xbd, 0x4a, 0x0f, 0x27,
-	0x69, 0x18, 0x0a, 0xb7, 0xec, 0x38, 0x38, 0x09, 0xa2, 0x29, 0x79, 0xa5, 0xe3, 0x49, 0x34, 0xc5,
-	0xf9, 0xaa, 0x2b, 0x91, 0xb4, 0x1b, 0x0a, 0x4c, 0xa5, 0xe6, 0xbb, 0x94, 0x85, 0x88, 0xf1, 0x4c,
-	0xc3, 0x98, 0xb6, 0xa9, 0xc3, 0xac, 0x4d, 0x52, 0xb5, 0x4f, 0x1d, 0x16, 0xe0, 0x7e, 0x1c, 0xe5,
-	0x43, 0x15, 0x20, 0xe6, 0x20, 0x3f, 0x88, 0x1c, 0xfe, 0xaa, 0x02, 0xec, 0xb3, 0x1a, 0xb3, 0xc1,
-	0x5e, 0x32, 0x93, 0x27, 0x21, 0x9a, 0xc0, 0xcb, 0xab, 0xc8, 0x16, 0x89, 0x32, 0x1c, 0x6f, 0x8a,
-	0xb7, 0xf9, 0x8e, 0xb1, 0x32, 0xe6, 0x51, 0xc5, 0xce, 0x2f, 0xaf, 0xa1, 0x6a, 0x8e, 0xd2, 0x83,
-	0x64, 0x81, 0x1a, 0x1b, 0x32, 0xf9, 0x28, 0x7b, 0x84, 0x43, 0x46, 0xe1, 0x37, 0x8d, 0x11, 0x6b,
-	0xd2, 0x15, 0x7c, 0x2a, 0x7b, 0xa4, 0x45, 0x8f, 0xcd, 0x07, 0xc2, 0x9b, 0x0e, 0x77, 0xfb, 0x05,
-	0x3b, 0xe6, 0x91, 0xeb, 0x42, 0x83, 0xf4, 0x47, 0xb2, 0xcf, 0xca, 0xd1, 0x96, 0x9f, 0xa6, 0x41,
-	0x96, 0x7f, 0xcf, 0x3e, 0xf7, 0x3b, 0xb2, 0xa5, 0x26, 0xd9, 0xfe, 0xb8, 0xef, 0xb0, 0x2e, 0x1c,
-	0xdc, 0x02, 0x30, 0x2c, 0xe2, 0x03, 0x74, 0x1c, 0x2e, 0xc5, 0x93, 0xaa, 0x66, 0x89, 0x56, 0xed,
-	0x6e, 0xf5, 0x5e, 0xc5, 0xfb, 0x05, 0x5a, 0xcf, 0x92, 0xd9, 0x61, 0x96, 0x27, 0x31, 0xf5, 0xf3,
-	0x98, 0x48, 0x4f, 0x45, 0x72, 0xa2, 0xd7, 0x06, 0x63, 0x92, 0x6e, 0xf8, 0x20, 0xaa, 0xca, 0xd4,
-	0x55, 0x0f, 0xf0, 0xc8, 0xa9, 0x52, 0xde, 0x2b, 0x68, 0x8a, 0x87, 0xb6, 0xe4, 0x3e, 0xec, 0x09,
-	0x07, 0xa8, 0x46, 0xd2, 0xea, 0x0d, 0x68, 0xe5, 0x32, 0x1c, 0xb2, 0xdc, 0xde, 0xe9, 0x89, 0x8a,
-	0xe9, 0x30, 0x25, 0x41, 0xe4, 0x03, 0xff, 0x35, 0xb4, 0x86, 0xd8, 0x6d, 0x54, 0x90, 0x52, 0x57,
-	0xe3, 0x20, 0x0f, 0x78, 0xc6, 0x6c, 0xae, 0x8f, 0x5e, 0x85, 0x18, 0xe5, 0x62, 0x26, 0xe6, 0xfa,
-	0x13, 0x68, 0x3c, 0x4e, 0xc6, 0x43, 0x9f, 0x29, 0x1e, 0x58, 0x9c, 0xd4, 0xe7, 0xbb, 0x8d, 0xcf,
-	0xe9, 0x17, 0xd0, 0xe5, 0x7b, 0x76, 0xe8, 0x1b, 0x73, 0xfd, 0x2c, 0x39, 0x0e, 0x63, 0x8d, 0x18,
-	0xfa, 0x07, 0xe6, 0xbe, 0xec, 0x69, 0x84, 0x5e, 0x70, 0x0f, 0x59, 0x30, 0xfc, 0xf1, 0xfd, 0x08,
-	0xf7, 0x25, 0x3e, 0x29, 0xa7, 0x59, 0xc4, 0xf3, 0x2d, 0x79, 0x5e, 0x8a, 0xbf, 0x85, 0x93, 0xfb,
-	0x2a, 0x79, 0x73, 0x6a, 0x44, 0xa8, 0x3d, 0xf4, 0x05, 0x81, 0x24, 0x6b, 0x52, 0xbb, 0xd4, 0xda,
-	0x36, 0x74, 0x1f, 0xe2, 0x14, 0xbe, 0x23, 0x43, 0xdb, 0x1e, 0xe6, 0xa7, 0xf5, 0x4b, 0x2d, 0x3e,
-	0x86, 0xee, 0xcf, 0xf3, 0x71, 0xb0, 0xae, 0x45, 0xf7, 0x43, 0xd8, 0x64, 0x17, 0x99, 0x2d, 0x33,
-	0x71, 0xf3, 0x1d, 0x71, 0xf3, 0x74, 0x41, 0xcc, 0xa1, 0x36, 0x57, 0xea, 0xf0, 0x3b, 0x70, 0x47,
-	0x69, 0x10, 0xe7, 0x7b, 0xe3, 0x71, 0xba, 0xa6, 0x4f, 0x94, 0x98, 0x36, 0x7f, 0x2e, 0xbd, 0x1b,
-	0x70, 0xc1, 0x32, 0x50, 0xea, 0xe5, 0x3e, 0x7b, 0xb2, 0x4f, 0xd0, 0xe2, 0x99, 0xdd, 0x7c, 0x0c,
-	0x17, 0x6d, 0x0b, 0x65, 0x7e, 0x76, 0xfe, 0x6e, 0x42, 0x6d, 0x6f, 0x1e, 0xb9, 0xbb, 0xb0, 0x29,
-	0x98, 0xbf, 0x7b, 0x49, 0x14, 0xc4, 0xfe, 0x15, 0x31, 0xb8, 0x5c, 0xfc, 0x2c, 0x9e, 0xc5, 0x73,
-	0x0c, 0x3b, 0x2a, 0x60, 0x47, 0xe5, 0xd8, 0xd1, 0x0a, 0xf6, 0x36, 0xd4, 0x19, 0x7f, 0x70, 0x5d,
-	0xa1, 0x61, 0xfc, 0x02, 0x18, 0x5c, 0xb0, 0xbe, 0x29, 0xc8, 0x1d, 0x68, 0x10, 0xf7, 0x76, 0xe5,
-	0xb9, 0xc9, 0xe4, 0x07, 0x17, 0xed, 0x8f, 0x26, 0x8a, 0x78, 0xb4, 0x42, 0x99, 0xf4, 0x5c, 0xa1,
-	0x2c, 0xaa, 0x8d, 0xa8, 0xbb, 0xb0, 0xc1, 0xe7, 0xcb, 0x95, 0x1a, 0x16, 0xa5, 0x1e, 0x5c, 0x2a,
-	0x7c, 0x35, 0x81, 0x7c, 0xe5, 0x2a, 0xa0, 0x45, 0x83, 0x15, 0xd0, 0x66, 0xbb, 0x1c, 0xc8, 0x09,
-	0xab, 0x02, 0x5a, 0x74, 0x57, 0x01, 0x6d, 0x56, 0x8b, 0xc0, 0x07, 0x00, 0x9a, 0x8a, 0xba, 0x7d,
-	0xa3, 0x76, 0x16, 0x83, 0x1d, 0x5c, 0x29, 0x39, 0x31, 0xaf, 0x52, 0x90, 0x47, 0xdd, 0x06, 0x16,
-	0x4d, 0xd5, 0x6d, 0x60, 0x73, 0x4c, 0xc4, 0x7e, 0x03, 0x4d, 0x49, 0x05, 0xdd, 0xcb, 0x86, 0x13,
-	0x13, 0xfd, 0xbf, 0x95, 0xef, 0x26, 0x5c, 0xb2, 0x3a, 0xd7, 0xe8, 0x17, 0x93, 0xe5, 0x28, 0x78,
-	0x91, 0xfe, 0x71, 0xb8, 0x5f, 0x84, 0xfb, 0xa7, 0xc0, 0xfd, 0x55, 0xf8, 0x7d, 0x68, 0x29, 0xe6,
-	0xe5, 0x4a, 0xbd, 0x22, 0x9d, 0x1b, 0xf4, 0x57, 0x0f, 0x94, 0x85, 0x21, 0xfb, 0xf9, 0xaa, 0x48,
-	0x96, 0x7b, 0xc5, 0xba, 0x60, 0xcb, 0xca, 0xa0, 0xec, 0xc8, 0xee, 0x1c, 0xb6, 0xb8, 0x8c, 0xce,
-	0x31, 0x48, 0x9a, 0xd1, 0x39, 0x16, 0xc3, 0x22, 0x20, 0x67, 0x54, 0x0a, 0x68, 0x51, 0x2e, 0x05,
-	0xb4, 0x69, 0x17, 0x07, 0x72, 0xaa, 0xa3, 0x80, 0x16, 0x15, 0x52, 0xc0, 0x02, 0x1f, 0x3a, 0xb7,
-	0xf3, 0x4f, 0x0d, 0x1c, 0xf6, 0x98, 0xfa, 0xcb, 0x2c, 0x0f, 0x67, 0x7b, 0x4f, 0xf6, 0xd9, 0x2d,
-	0xc8, 0x7d, 0xa4, 0x6e, 0xa1, 0xb0, 0xd2, 0xd4, 0x2d, 0x14, 0x17, 0x97, 0x68, 0x7e, 0x5a, 0x46,
-	0xba, 0xf9, 0xcd, 0xdd, 0xa5, 0x9b, 0xdf, 0xda, 0x58, 0x22, 0x77, 0xda, 0x3b, 0x3a, 0x77, 0x73,
-	0x69, 0xe9, 0xdc, 0xad, 0xe5, 0xc4, 0xdb, 0x46, 0x2e, 0x18, 0x15, 0x70, 0x61, 0x43, 0xa9, 0x80,
-	0x8b, 0x9b, 0x88, 0xc3, 0xe5, 0xba, 0x50, 0xf0, 0xc2, 0x3a, 0x52, 0xf0, 0xe2, 0x5e, 0xe1, 0x3d,
-	0x63, 0xac, 0x02, 0xd5, 0x33, 0xab, 0xfb, 0x45, 0xf5, 0x4c, 0xc9, 0xe6, 0x40, 0x3b, 0xfb, 0xec,
-	0xdf, 0x1f, 0xfa, 0xad, 0x77, 0x75, 0x87, 0xad, 0xac, 0x90, 0xc1, 0xff, 0x4b, 0xcf, 0xa4, 0xa9,
-	0xc3, 0x0d, 0x3a, 0xfd, 0xf2, 0xdf, 0x00, 0x00, 0x00, 0xff, 0xff, 0x21, 0x01, 0x19, 0x6c, 0x61,
-	0x13, 0x00, 0x00,
+	// 2301 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xa4, 0x59, 0xdd, 0x72, 0xdb, 0xc6,
+	0x15, 0x2e, 0xf8, 0x03, 0x92, 0x87, 0x00, 0x48, 0x41, 0xa2, 0x0d, 0xa3, 0xae, 0xcd, 0x20, 0x4d,
+	0x47, 0x6d, 0x5c, 0xb7, 0x56, 0x32, 0x4d, 0xa2, 0x71, 0x5a, 0xcb, 0x52, 0xa4, 0x2a, 0x95, 0x7f,
+	0xc6, 0x4c, 0x9b, 0x5c, 0xb5, 0x03, 0x91, 0x4b, 0x09, 0x43, 0x10, 0xa0, 0x81, 0xa5, 0x64, 0xf5,
+	0xa6, 0x4f, 0xd0, 0xce, 0xf4, 0x25, 0xfa, 0x18, 0xbd, 0xef, 0x1b, 0xf4, 0xb2, 0xf7, 0x7d, 0x89,
+	0xce, 0xfe, 0x62, 0x17, 0x04, 0x1d, 0xda, 0xbd, 0xe2, 0xe0, 0xec, 0x7e, 0xe7, 0x9c, 0x3d, 0x7f,
+	0x7b, 0xf6, 0x10, 0xfa, 0xd3, 0x34, 0x9b, 0x47, 0xe3, 0x3f, 0x85, 0x8b, 0xe8, 0xe1, 0x22, 0x4b,
+	0x71, 0xea, 0x36, 0xe9, 0x4f, 0xf0, 0x17, 0x30, 0x8f, 0xa2, 0xec, 0xab, 0x04, 0xbb, 0x16, 0x34,
+	0x92, 0x70, 0x8e, 0x3c, 0x63, 0x68, 0xec, 0x76, 0x5c, 0x07, 0xcc, 0x45, 0x98, 0xa1, 0x04, 0x7b,
+	0xb5, 0xa1, 0xb1, 0xdb, 0x20, 0xab, 0xf8, 0x66, 0x81, 0xbc, 0xfa, 0xd0, 0xd8, 0xb5, 0x5d, 0x1b,
+	0x9a, 0x51, 0x92, 0x4e, 0x90, 0xd7, 0xa0, 0x8b, 0x0e, 0x98, 0xe3, 0x34, 0x9d, 0x45, 0xc8, 0x6b,
+	0xd2, 0xef, 0x3b, 0xd0, 0x08, 0x31, 0xce, 0x3c, 0x73, 0x68, 0xec, 0x76, 0xf7, 0xba, 0x4c, 0xe2,
+	0xc3, 0x03, 0x8c, 0x33, 0xb7, 0x07, 0x2d, 0xb6, 0xf5, 0xa9, 0xd7, 0x22, 0x7b, 0x83, 0x5f, 0x00,
+	0x30, 0x05, 0xb2, 0x08, 0xe5, 0xee, 0x07, 0xea, 0x97, 0x67, 0x0c, 0xeb, 0xbb, 0xdd, 0x3d, 0x9b,
+	0xe3, 0xd9, 0x42, 0xf0, 0x0f, 0x03, 0x1a, 0x94, 0x95, 0x54, 0xc2, 0xa0, 0x42, 0x6d, 0x68, 0x86,
+	0x38, 0x9a, 0x23, 0xaa, 0x70, 0x9d, 0x7c, 0xce, 0xe9, 0x67, 0x5d, 0x7c, 0x8e, 0xe9, 0x67, 0x83,
+	0x7e, 0x12, 0x8d, 0x33, 0xfa, 0xdd, 0xa4, 0xdf, 0x16, 0x34, 0xe6, 0x84, 0x95, 0x29, 0x8e, 0x77,
+	0x15, 0xc6, 0xd1, 0x84, 0xaa, 0xd8, 0x24, 0x8b, 0x79, 0xf4, 0x67, 0xe4, 0xb5, 0xa9, 0x9c, 0x2e,
+	0xd4, 0x97, 0xd1, 0xc4, 0xeb, 0xd0, 0x9d, 0x5d, 0xa8, 0x5f, 0x44, 0x13, 0x0f, 0x04, 0x2c, 0x89,
+	0xa3, 0x64, 0xe6, 0x75, 0xc9, 0x67, 0xb0, 0x0f, 0xce, 0x08, 0x61, 0xa2, 0xea, 0x2b, 0xf4, 0x7a,
+	0x89, 0x72, 0x2c, 0xed, 0x62, 0xac, 0xda, 0x45, 0x8a, 0xac, 0x51, 0xec, 0x03, 0xe8, 0x49, 0x6c,
+	0xbe, 0x48, 0x93, 0x1c, 0xbd, 0x05, 0x1c, 0xdc, 0x07, 0xe7, 0x44, 0x97, 0xa4, 0xdb, 0x86, 0xb0,
+	0x3b, 0xd9, 0x9c, 0xdd, 0x3e, 0x74, 0x5f, 0xa1, 0x70, 0x52, 0xcd, 0x8b, 0x98, 0x2e, 0x9d, 0x4e,
+	0x73, 0x84, 0xb9, 0xa1, 0x85, 0x75, 0xa8, 0x9d, 0x83, 0x5f, 0x83, 0xc5, 0xb0, 0x5c, 0x4c, 0x09,
+	0xdc, 0x83, 0xd6, 0x22, 0xbc, 0x89, 0xd3, 0x90, 0x1d, 0xd4, 0x52, 0xb8, 0x49, 0xfc, 0xb7, 0x59,
+	0x84, 0xd1, 0x86, 0xc2, 0x15, 0x7e, 0x04, 0x6f, 0x05, 0xf7, 0xc1, 0xe6, 0x78, 0xae, 0x80, 0x03,
+	0x66, 0x8e, 0x43, 0xbc, 0xcc, 0x29, 0x87, 0x66, 0x70, 0x02, 0xd6, 0xb3, 0xd9, 0x51, 0x24, 0x2d,
+	0x55, 0x04, 0xba, 0x21, 0x02, 0x9d, 0xa6, 0x41, 0x8d, 0xa6, 0x81, 0xb0, 0x52, 0x7d, 0xd5, 0x4a,
+	0x9f, 0x83, 0xcd, 0x19, 0x71, 0x49, 0x7a, 0x02, 0x09, 0x64, 0x6d, 0x15, 0xf9, 0x5b, 0xb0, 0x0f,
+	0x33, 0x14, 0x62, 0xf4, 0x7f, 0xeb, 0xf0, 0x05, 0x38, 0x82, 0xd3, 0xbb, 0x2a, 0xb1, 0x0f, 0xf6,
+	0x2b, 0x34, 0x4f, 0xaf, 0x36, 0x54, 0xa2, 0x0b, 0xf5, 0x49, 0xc4, 0x74, 0x68, 0x07, 0x43, 0x70,
+	0x04, 0x76, 0x8d, 0x95, 0x7f, 0x0e, 0xf6, 0x59, 0x9a, 0xce, 0x96, 0x8b, 0x8d, 0xb8, 0x93, 0x73,
+	0x88, 0xed, 0xef, 0x7a, 0x8e, 0x00, 0xb6, 0x48, 0xc0, 0x1d, 0x45, 0xd9, 0x41, 0x1c, 0xaf, 0x09,
+	0xff, 0xcf, 0xc0, 0x55, 0xf7, 0x70, 0x11, 0x1b, 0xd4, 0x9a, 0x17, 0xe0, 0x70, 0xe0, 0xfa, 0x78,
+	0xe4, 0x95, 0xaf, 0x26, 0x8a, 0x50, 0x1c, 0xcd, 0x23, 0xcc, 0xeb, 0xa4, 0x52, 0xed, 0x68, 0xa5,
+	0x0c, 0x3e, 0x85, 0x9e, 0x64, 0xb8, 0xb9, 0x1a, 0xdf, 0x81, 0x33, 0xba, 0x99, 0x93, 0xd2, 0xb2,
+	0x99, 0xb3, 0x1c, 0x30, 0x71, 0x98, 0x5d, 0xf0, 0xa4, 0xea, 0x88, 0x92, 0xd5, 0x50, 0x4b, 0x16,
+	0xa9, 0x7b, 0x76, 0xf0, 0x35, 0xf4, 0x24, 0xe7, 0xc2, 0x95, 0xef, 0x17, 0x8c, 0x43, 0x76, 0x36,
+	0x55, 0xcd, 0x92, 0x1f, 0x02, 0xe8, 0x17, 0x3b, 0x0a, 0x71, 0x5c, 0x57, 0xea, 0xea, 0xe0, 0x39,
+	0x2d, 0x55, 0x6f, 0xc2, 0xb5, 0xc5, 0xac, 0xa4, 0x90, 0x5a, 0x7e, 0x6c, 0xb7, 0x0f, 0xed, 0x45,
+	0x9a, 0x47, 0x38, 0x4a, 0x13, 0x76, 0xdc, 0xe0, 0x03, 0xe8, 0x17, 0xfc, 0x8a, 0xa2, 0xf4, 0x46,
+	0x16, 0x3f, 0x2b, 0xf8, 0x23, 0x2d, 0xb6, 0x9b, 0x8b, 0x64, 0xb5, 0x7a, 0xc9, 0x64, 0x5a, 0xab,
+	0x32, 0xc9, 0x86, 0x69, 0x1c, 0x5e, 0xe4, 0xdc, 0xc8, 0x2e, 0xf4, 0x47, 0x25, 0x15, 0x82, 0x03,
+	0xe8, 0x9f, 0x45, 0xf9, 0xf7, 0x09, 0xa5, 0x27, 0xab, 0xad, 0x9c, 0x8c, 0x9e, 0x95, 0x44, 0xbe,
+	0xc2, 0xa2, 0xfa, 0x68, 0x8f, 0xc0, 0x65, 0x99, 0xba, 0xf1, 0xe9, 0x82, 0x01, 0x6c, 0x6b, 0x10,
+	0xae, 0xf0, 0x94, 0xd4, 0x0b, 0xb2, 0x4d, 0x30, 0xd9, 0x82, 0x4e, 0x1a, 0x4f, 0x5e, 0xaa, 0xa1,
+	0xb2, 0x05, 0x9d, 0x04, 0x5d, 0xbf, 0x54, 0xfb, 0x86, 0x1e, 0xb4, 0xd2, 0x78, 0xf2, 0x3c, 0xe4,
+	0x17, 0x71, 0x87, 0x10, 0x12, 0x74, 0x4d, 0x09, 0x0d, 0x61, 0x4d, 0xd5, 0x58, 0x7d, 0x70, 0x84,
+	0x1c, 0x2e, 0xb9, 0x07, 0xf6, 0x08, 0x87, 0x78, 0x9a, 0x73, 0xc9, 0xc1, 0x5f, 0x0d, 0x70, 0x04,
+	0xa5, 0x88, 0xa2, 0xf3, 0x38, 0x1d, 0xcf, 0xf2, 0xa2, 0x19, 0x38, 0x9f, 0x66, 0x48, 0xa4, 0x25,
+	0x59, 0x0e, 0xaf, 0xc2, 0x28, 0xf6, 0xea, 0x62, 0x79, 0x1a, 0xc5, 0x28, 0xf7, 0x1a, 0xf2, 0x93,
+	0xee, 0x6e, 0x4a, 0x30, 0xb5, 0xbc, 0x29, 0x92, 0x98, 0xe8, 0x13, 0xa3, 0x84, 0xf6, 0x03, 0x36,
+	0xe1, 0x36, 0xcd, 0x64, 0x47, 0x60, 0x13, 0x05, 0x4f, 0x93, 0x08, 0x1f, 0x4b, 0x05, 0xfb, 0xe0,
+	0x08, 0x02, 0x3f, 0xc3, 0x3e, 0x74, 0xcf, 0x36, 0x4e, 0x5f, 0xe9, 0x9e, 0x3a, 0xaf, 0x5e, 0xd6,
+	0x99, 0x9a, 0x31, 0x1b, 0x97, 0xc6, 0x8f, 0xa1, 0xfb, 0x62, 0x81, 0x92, 0x35, 0x5e, 0x97, 0x76,
+	0x67, 0x1d, 0x87, 0x03, 0x16, 0xdb, 0xcc, 0x35, 0xfe, 0x12, 0xac, 0xb3, 0x1c, 0xa1, 0xd9, 0x86,
+	0x17, 0xb1, 0x03, 0xe6, 0xf5, 0x25, 0x4a, 0xc6, 0x3c, 0x11, 0xc9, 0x3d, 0xcc, 0xe1, 0x85, 0x87,
+	0x38, 0xc0, 0xa0, 0x17, 0xfd, 0x0b, 0xe8, 0x1f, 0x87, 0x71, 0x9c, 0x8e, 0xc3, 0xb5, 0x97, 0xbd,
+	0x68, 0xca, 0x6a, 0xc2, 0xea, 0x6a, 0xa7, 0x40, 0xbe, 0x63, 0x94, 0x5c, 0xe0, 0x4b, 0xd6, 0xd2,
+	0x05, 0x0f, 0x61, 0x4b, 0x61, 0xf8, 0xfd, 0x5d, 0xce, 0x29, 0x58, 0xa7, 0x09, 0xed, 0xb9, 0x42,
+	0x92, 0x54, 0x15, 0xc2, 0x27, 0x21, 0x0e, 0xa9, 0xf0, 0xb6, 0xe2, 0xb0, 0xba, 0xe6, 0x30, 0x1a,
+	0xc2, 0xc4, 0x76, 0xdf, 0x86, 0x78, 0x7c, 0x29, 0xfc, 0xff, 0x3b, 0xb0, 0xf9, 0x37, 0x57, 0xe3,
+	0x67, 0x60, 0x47, 0x8a, 0x2c, 0x51, 0xe6, 0xb7, 0xb9, 0x3e, 0x9a, 0x1e, 0x0e, 0x98, 0xf3, 0x28,
+	0xcf, 0x11, 0xeb, 0x90, 0xda, 0xc1, 0x7f, 0x6b, 0x00, 0xa7, 0x44, 0x31, 0x72, 0x45, 0xdc, 0x90,
+	0x68, 0xbc, 0x42, 0x59, 0x4e, 0xca, 0x80, 0x21, 0x7b, 0xf1, 0xfc, 0x28, 0xca, 0xb8, 0xa6, 0xeb,
+	0x0b, 0xb4, 0x72, 0x08, 0x19, 0xf6, 0xec, 0xc4, 0x4d, 0x99, 0xbd, 0xe9, 0x04, 0x1d, 0xa6, 0xcb,
+	0x04, 0x7b, 0xa6, 0xf0, 0x72, 0x94, 0x93, 0xe0, 0xf3, 0x5a, 0xc2, 0x0c, 0xbc, 0x58, 0xb7, 0x69,
+	0xf0, 0x7d, 0x2c, 0xaa, 0x4d, 0x87, 0x9e, 0xe7, 0xae, 0x3c, 0x8f, 0x50, 0xf7, 0xe1, 0x77, 0x64,
+	0x99, 0x69, 0x5e, 0xe4, 0x28, 0x08, 0x79, 0xf4, 0x7b, 0x44, 0x32, 0xa9, 0x2b, 0x48, 0x71, 0x98,
+	0xe3, 0xa7, 0x84, 0xec, 0x59, 0xc2, 0xd2, 0xd3, 0xfc, 0x74, 0xe2, 0xd9, 0xb4, 0xd6, 0xde, 0x83,
+	0x16, 0x7a, 0x83, 0x51, 0x82, 0x73, 0xcf, 0xd1, 0x6e, 0xca, 0xaf, 0x28, 0x95, 0x30, 0xc0, 0xd9,
+	0x32, 0x21, 0x31, 0x90, 0x7b, 0x3d, 0xc2, 0xc0, 0x7f, 0x00, 0xa0, 0x28, 0xd1, 0x85, 0xfa, 0x0c,
+	0xdd, 0x78, 0x86, 0x5e, 0xc8, 0x69, 0x2f, 0xba, 0x5f, 0xfb, 0xdc, 0x08, 0x7e, 0x02, 0x26, 0x67,
+	0x65, 0x43, 0x33, 0xc7, 0x61, 0x86, 0x8b, 0x74, 0x19, 0x53, 0xcb, 0xd0, 0x8a, 0x12, 0xfc, 0x01,
+	0x3a, 0xdf, 0xa4, 0xf3, 0xf3, 0x1c, 0xa7, 0x09, 0x2d, 0xba, 0x13, 0xfa, 0x98, 0x30, 0xc4, 0x5b,
+	0xe3, 0xb5, 0xf2, 0x12, 0x11, 0x27, 0x60, 0xb7, 0xc5, 0xea, 0xd3, 0x89, 0x1b, 0x85, 0x3a, 0x21,
+	0xb8, 0x86, 0x36, 0xef, 0x06, 0x2a, 0x5c, 0xad, 0x97, 0x09, 0x80, 0x5a, 0x24, 0xb8, 0x7e, 0x08,
+	0x1d, 0x2c, 0xd4, 0xa1, 0x9c, 0xbb, 0x7b, 0x7d, 0x6e, 0x99, 0x42, 0x4d, 0xf1, 0x86, 0x6b, 0xea,
+	0x6f, 0x38, 0xea, 0xea, 0xe0, 0x31, 0x74, 0x8e, 0xa3, 0x18, 0x51, 0xd3, 0x57, 0x4a, 0x96, 0xd9,
+	0x40, 0x6f, 0xbc, 0xf1, 0x25, 0x1a, 0xcf, 0xf2, 0xe5, 0x9c, 0xa7, 0xfb, 0x7f, 0x0c, 0x71, 0x3d,
+	0x7c, 0x9d, 0x2e, 0xb3, 0x24, 0x8c, 0x2b, 0x59, 0x50, 0x33, 0x30, 0x16, 0xda, 0xed, 0x51, 0x5f,
+	0xbd, 0x3d, 0x1a, 0xe5, 0xdb, 0xa3, 0x59, 0xbe, 0x3d, 0x4c, 0xfd, 0xf6, 0x68, 0x89, 0xe6, 0x06,
+	0xe7, 0x73, 0x1a, 0x9d, 0x75, 0xf7, 0x2e, 0xd4, 0xf3, 0x6c, 0x4c, 0x5f, 0x6a, 0xdd, 0xbd, 0x9e,
+	0xd6, 0x52, 0x65, 0x37, 0x64, 0x75, 0x92, 0x63, 0x0f, 0xaa, 0x57, 0xfb, 0xd0, 0x9e, 0xe4, 0xf8,
+	0xb9, 0xf2, 0x9c, 0xfb, 0x9b, 0x21, 0xda, 0xf6, 0x0d, 0x8f, 0xa8, 0xd7, 0x0c, 0x4b, 0xe8, 0xc6,
+	0x1e, 0xa0, 0x43, 0x35, 0xf7, 0xba, 0x7b, 0x5b, 0x2b, 0x99, 0xe3, 0xde, 0x07, 0x73, 0x12, 0x51,
+	0xb8, 0x59, 0xa9, 0x62, 0xf0, 0x4f, 0x03, 0xec, 0x23, 0x14, 0xa3, 0xb7, 0x28, 0xa4, 0xbf, 0xe2,
+	0x2d, 0x19, 0x40, 0xec, 0x2a, 0xbe, 0x0b, 0x35, 0x9c, 0xaf, 0x8d, 0x16, 0x17, 0x60, 0x1a, 0x65,
+	0x22, 0x19, 0x59, 0x89, 0xe8, 0x43, 0x5b, 0xa4, 0x17, 0xd5, 0xaa, 0x5d, 0xc4, 0x7a, 0x4b, 0xbc,
+	0xc7, 0x32, 0xc4, 0x3a, 0xd9, 0xb6, 0x0c, 0x59, 0xf4, 0x06, 0x7b, 0x1d, 0x2d, 0x15, 0x80, 0x36,
+	0x27, 0x7f, 0x37, 0xc0, 0xfe, 0xfd, 0x62, 0xf2, 0x36, 0x8b, 0xca, 0x20, 0xad, 0x95, 0xb2, 0xa5,
+	0x3e, 0xac, 0x2b, 0x25, 0x84, 0x5e, 0xc6, 0x0d, 0xad, 0x6b, 0x92, 0x57, 0x39, 0x9b, 0x02, 0x98,
+	0x7a, 0x66, 0x32, 0x6d, 0xb5, 0x6a, 0x41, 0x5f, 0xf7, 0xc1, 0x21, 0x98, 0x87, 0x97, 0x61, 0x72,
+	0x41, 0x2f, 0xac, 0x71, 0x1c, 0x89, 0x3b, 0xba, 0xe3, 0xfe, 0x14, 0x2c, 0xb5, 0x86, 0xf3, 0x0b,
+	0xb7, 0xaa, 0x84, 0x07, 0x33, 0xe8, 0x32, 0x26, 0x4f, 0xc9, 0x2d, 0x50, 0x9d, 0xc7, 0xe2, 0x50,
+	0xb4, 0x3b, 0xcf, 0xd1, 0x6b, 0x9e, 0x04, 0xf7, 0xa0, 0x35, 0xa6, 0x50, 0xe2, 0x18, 0xb5, 0xc0,
+	0x71, 0xad, 0xfa, 0xd0, 0x4e, 0xaf, 0x50, 0x36, 0x8d, 0xd3, 0x6b, 0x7a, 0xc4, 0x76, 0xf0, 0x11,
+	0x34, 0x9f, 0xa5, 0x93, 0xe3, 0x11, 0xe1, 0xfa, 0x5c, 0x1b, 0xe0, 0x8c, 0xd8, 0x8b, 0x8c, 0xb5,
+	0x75, 0x87, 0xd0, 0x63, 0xd1, 0x7b, 0x3c, 0x52, 0xae, 0xdb, 0x6f, 0xd2, 0x19, 0x4a, 0x0a, 0xc4,
+	0xf1, 0xe8, 0x79, 0x51, 0x5f, 0xb6, 0xa0, 0xf3, 0x54, 0xd6, 0x67, 0xf6, 0x3a, 0x1f, 0x42, 0xbf,
+	0x60, 0x52, 0xb4, 0x23, 0x47, 0xa4, 0x34, 0xb0, 0xf6, 0xfd, 0x1e, 0xd8, 0xa4, 0x29, 0x5d, 0x27,
+	0x24, 0xb8, 0x07, 0x8e, 0x58, 0xaf, 0xc4, 0x3f, 0x00, 0x7b, 0x74, 0x99, 0x5e, 0xaf, 0x55, 0xd2,
+	0x82, 0xc6, 0xf1, 0x88, 0x8f, 0x49, 0x28, 0x37, 0xb1, 0xbb, 0x92, 0xdb, 0x43, 0xe8, 0xb1, 0x0c,
+	0xd9, 0x90, 0xdf, 0x10, 0xfa, 0xc5, 0xfe, 0x4a, 0x8e, 0xcf, 0xa0, 0xc7, 0x42, 0x76, 0x33, 0x8e,
+	0xee, 0x8f, 0xa0, 0x45, 0xca, 0x6a, 0x7e, 0x23, 0x12, 0xcd, 0xe2, 0xfe, 0xa4, 0x3e, 0x23, 0x02,
+	0x0b, 0x76, 0x95, 0x02, 0x7f, 0x03, 0xee, 0x49, 0x16, 0x26, 0xf8, 0x60, 0x32, 0xc9, 0x36, 0x94,
+	0x69, 0x41, 0x83, 0xec, 0x66, 0x59, 0x1e, 0x7c, 0x08, 0xdb, 0x1a, 0x83, 0x4a, 0x29, 0x4f, 0x48,
+	0xd3, 0x7f, 0x95, 0xce, 0xd0, 0x7b, 0x8b, 0xf9, 0x31, 0xec, 0xe8, 0x1c, 0x2a, 0xe5, 0x7c, 0x02,
+	0x3b, 0xa3, 0x71, 0xb6, 0x3c, 0x7f, 0x85, 0x16, 0x69, 0x86, 0x37, 0xf4, 0xca, 0x47, 0x30, 0x28,
+	0x81, 0x2a, 0x79, 0x9f, 0x81, 0x7d, 0x9c, 0x8f, 0x67, 0x1b, 0x6a, 0xef, 0x80, 0xf9, 0x0a, 0x2d,
+	0x42, 0x31, 0xd3, 0x50, 0xf2, 0xa5, 0x41, 0xd3, 0xea, 0x1e, 0x38, 0x82, 0x5b, 0x95, 0xb4, 0xbd,
+	0x7f, 0x59, 0x50, 0x3f, 0x58, 0x44, 0xee, 0x3e, 0xb4, 0xf8, 0xa4, 0xce, 0x1d, 0x70, 0xd7, 0xea,
+	0x53, 0x3f, 0xff, 0x56, 0x99, 0xcc, 0x3b, 0xec, 0x1f, 0x10, 0xec, 0x49, 0x09, 0x7b, 0x52, 0x8d,
+	0x3d, 0x59, 0xc1, 0x3e, 0x82, 0x06, 0x79, 0x4b, 0xbb, 0x2e, 0xdf, 0xa1, 0x4c, 0xec, 0xfc, 0x6d,
+	0x8d, 0x26, 0x21, 0x9f, 0x42, 0x93, 0xce, 0xc6, 0x5c, 0xb1, 0xae, 0x4e, 0xda, 0xfc, 0x1d, 0x9d,
+	0xa8, 0xa2, 0xe8, 0x9c, 0x4b, 0xa2, 0xd4, 0xf1, 0x99, 0xbf, 0xa3, 0x13, 0x25, 0xea, 0x33, 0x30,
+	0x59, 0xa5, 0x70, 0xc5, 0x0e, 0x6d, 0xe4, 0xe5, 0x0f, 0x4a, 0x54, 0x15, 0xc8, 0x9e, 0x9f, 0x12,
+	0xa8, 0x8d, 0xa9, 0xfc, 0x41, 0x89, 0xaa, 0x02, 0xd9, 0x0c, 0x49, 0x02, 0xb5, 0x09, 0x94, 0x3f,
+	0x28, 0x51, 0x25, 0xf0, 0x10, 0xa0, 0x98, 0x0e, 0xb9, 0x9e, 0x62, 0x3b, 0x6d, 0xa8, 0xe4, 0xdf,
+	0xa9, 0x58, 0x51, 0x5d, 0xc9, 0x07, 0x29, 0x45, 0x18, 0x68, 0x23, 0x1b, 0xff, 0x56, 0x99, 0x2c,
+	0xb1, 0x5f, 0x42, 0x5b, 0x8c, 0x45, 0xdc, 0x5b, 0x8a, 0x10, 0x15, 0x7d, 0x7b, 0x85, 0xae, 0xc2,
+	0xc5, 0x84, 0xc3, 0x55, 0xe2, 0x45, 0x7d, 0xf1, 0xfb, 0xb7, 0x57, 0xe8, 0x2a, 0x7c, 0x54, 0x86,
+	0x8f, 0xd6, 0xc0, 0x47, 0xab, 0xf0, 0x27, 0xd0, 0x91, 0x53, 0x08, 0x57, 0xec, 0x2b, 0x8f, 0x36,
+	0x7c, 0x6f, 0x75, 0x41, 0x72, 0x38, 0x86, 0x2e, 0x73, 0x26, 0xe3, 0x71, 0x47, 0x73, 0xb0, 0xc6,
+	0xc5, 0xaf, 0x5a, 0xd2, 0x23, 0x87, 0xb4, 0x37, 0x4a, 0xe4, 0x28, 0x03, 0x0b, 0x7f, 0x50, 0xa2,
+	0xaa, 0x40, 0x36, 0x4e, 0x90, 0x40, 0x6d, 0xde, 0xe0, 0x0f, 0x4a, 0x54, 0x15, 0xc8, 0xde, 0xf9,
+	0x12, 0xa8, 0xcd, 0x01, 0xfc, 0x41, 0x89, 0xaa, 0x26, 0x2f, 0x79, 0x55, 0xc9, 0xe4, 0x55, 0x66,
+	0x03, 0xfe, 0xb6, 0x46, 0x93, 0x90, 0x2f, 0x58, 0x94, 0x8e, 0x70, 0x86, 0xc2, 0xf9, 0x3b, 0x64,
+	0xfd, 0x2f, 0x0d, 0xf7, 0x31, 0x74, 0x69, 0x52, 0x73, 0xec, 0xbb, 0x64, 0xff, 0xae, 0x41, 0xf2,
+	0x9f, 0xbe, 0xe4, 0x25, 0x4e, 0x1d, 0x0b, 0xf8, 0x3b, 0x3a, 0x51, 0x0d, 0x0b, 0xf9, 0x1a, 0x97,
+	0x61, 0x51, 0x7e, 0xf0, 0xfb, 0xde, 0xea, 0x82, 0xe4, 0xf0, 0x18, 0x5a, 0x3c, 0xd3, 0xdc, 0x81,
+	0x9e, 0x79, 0xe5, 0x8c, 0x2a, 0x4d, 0x54, 0xe9, 0x99, 0x9f, 0xb0, 0xff, 0x30, 0x8e, 0xa2, 0xec,
+	0x65, 0xbc, 0xcc, 0xdf, 0x87, 0xc3, 0xaf, 0xa0, 0x49, 0x1f, 0xf1, 0x85, 0xbd, 0x94, 0x27, 0xbe,
+	0xbf, 0xa3, 0x13, 0x15, 0xdc, 0x23, 0x68, 0x90, 0x41, 0x8a, 0x74, 0x91, 0x32, 0x82, 0xf1, 0xb7,
+	0x35, 0x9a, 0x00, 0xed, 0xfd, 0xbb, 0x01, 0x36, 0xe9, 0x12, 0x46, 0x37, 0x39, 0x46, 0xf3, 0x83,
+	0x97, 0xa7, 0x24, 0x29, 0x45, 0xa3, 0x25, 0x93, 0xb2, 0xd4, 0xbe, 0xf9, 0xb7, 0x57, 0xe8, 0x5a,
+	0x2d, 0xa4, 0x5d, 0x56, 0x51, 0x0b, 0xd5, 0xa6, 0xcc, 0x1f, 0x94, 0xa8, 0x5a, 0x2a, 0xd0, 0x86,
+	0xaa, 0x48, 0x05, 0xb5, 0x1b, 0xf3, 0x07, 0x25, 0xaa, 0x5a, 0x45, 0x44, 0xe7, 0x24, 0x15, 0x2e,
+	0xb5, 0x5e, 0xfe, 0xed, 0x15, 0xba, 0x0a, 0x17, 0x7d, 0x90, 0x84, 0x97, 0xfa, 0x2c, 0xff, 0xf6,
+	0x0a, 0x5d, 0x2d, 0x21, 0x4a, 0x8f, 0x23, 0x4b, 0xc8, 0x6a, 0xe3, 0xe4, 0xfb, 0x55, 0x4b, 0x92,
+	0xcf, 0x29, 0x58, 0x6a, 0x13, 0xe3, 0x16, 0x05, 0x67, 0xa5, 0x37, 0xf2, 0x7f, 0x58, 0xb9, 0x26,
+	0x59, 0x9d, 0x81, 0xad, 0x35, 0x2d, 0xae, 0xd8, 0x5f, 0xd5, 0xff, 0xf8, 0x77, 0xab, 0x17, 0x55,
+	0xbf, 0xb0, 0x6e, 0x44, 0xfa, 0x45, 0x6b, 0x75, 0xfc, 0x41, 0x89, 0x2a, 0x80, 0xe7, 0x26, 0xa5,
+	0x7f, 0xf2, 0xbf, 0x01, 0x00, 0x78, 0x20, 0x3d, 0x8b, 0x01, 0x1e, 0x00, 0x00,
 }